@@ -0,0 +1,191 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Access key hash schemes. Hashes of the legacy SHA1 scheme are stored as plain hexadecimal digests,
+// with no prefix. Hashes of any other scheme are stored prefixed by the scheme name, e.g. "hmac-sha256:<digest>".
+const (
+	AccessKeyHashScheme_SHA1       = "sha1"
+	AccessKeyHashScheme_HMACSHA256 = "hmac-sha256"
+)
+
+// The name of the file, within the storage directory, holding the server secret used for keyed access key hashes
+const AccessKeyHashSecretFileName = ".accessKeyHashSecret"
+
+// An object used to calculate and verify access key hashes
+type AccessKeyHasher struct {
+	secret []byte
+	scheme string
+}
+
+// Access key hasher object constructor function. The given scheme is used for newly calculated hashes.
+// Hashes of all supported schemes are accepted when verifying.
+func NewAccessKeyHasher(secret []byte, scheme string) (*AccessKeyHasher, error) {
+	switch scheme {
+	case AccessKeyHashScheme_SHA1:
+	case AccessKeyHashScheme_HMACSHA256:
+		if len(secret) == 0 {
+			return nil, errors.New("The '" + scheme + "' access key hash scheme requires a non-empty secret.")
+		}
+	default:
+		return nil, errors.New("Unsupported access key hash scheme '" + scheme + "'.")
+	}
+
+	return &AccessKeyHasher{
+		secret: secret,
+		scheme: scheme,
+	}, nil
+}
+
+// Gets the scheme used for newly calculated hashes
+func (this *AccessKeyHasher) Scheme() string {
+	return this.scheme
+}
+
+// Calculates the hash of the given access key, using the hasher's scheme. An empty key yields an empty hash.
+func (this *AccessKeyHasher) Hash(accessKey string) string {
+	return this.hashWithScheme(accessKey, this.scheme)
+}
+
+// Calculates the hashes of the given access key for all supported schemes, starting with the hasher's scheme.
+// This is used to look up keys that may have been stored with a different scheme.
+func (this *AccessKeyHasher) CandidateHashes(accessKey string) []string {
+	candidates := []string{this.Hash(accessKey)}
+
+	for _, scheme := range []string{AccessKeyHashScheme_HMACSHA256, AccessKeyHashScheme_SHA1} {
+		if scheme == this.scheme || (scheme == AccessKeyHashScheme_HMACSHA256 && len(this.secret) == 0) {
+			continue
+		}
+
+		candidates = append(candidates, this.hashWithScheme(accessKey, scheme))
+	}
+
+	return candidates
+}
+
+// Checks, in constant time, if the given access key matches the given stored hash. An empty key only matches
+// an empty hash.
+func (this *AccessKeyHasher) Matches(accessKey string, storedHash string) bool {
+	if accessKey == "" || storedHash == "" {
+		return accessKey == "" && storedHash == ""
+	}
+
+	scheme, _, err := ParseAccessKeyHash(storedHash)
+	if err != nil || (scheme == AccessKeyHashScheme_HMACSHA256 && len(this.secret) == 0) {
+		return false
+	}
+
+	calculatedHash := this.hashWithScheme(accessKey, scheme)
+
+	return subtle.ConstantTimeCompare([]byte(calculatedHash), []byte(storedHash)) == 1
+}
+
+// Converts a stored hash of the legacy SHA1 scheme to the hasher's scheme, without requiring the original key.
+// This is possible since the keyed scheme is applied over the binary SHA1 digest of the key. Returns false if
+// no conversion is needed.
+func (this *AccessKeyHasher) Upgrade(storedHash string) (upgradedHash string, upgraded bool, err error) {
+	scheme, digest, err := ParseAccessKeyHash(storedHash)
+	if err != nil {
+		return
+	}
+
+	if scheme == this.scheme || scheme != AccessKeyHashScheme_SHA1 {
+		return storedHash, false, nil
+	}
+
+	sha1Digest, err := hex.DecodeString(digest)
+	if err != nil {
+		return
+	}
+
+	return this.hashDigestWithScheme(sha1Digest, this.scheme), true, nil
+}
+
+// Calculates the hash of the given access key using the given scheme
+func (this *AccessKeyHasher) hashWithScheme(accessKey string, scheme string) string {
+	if accessKey == "" {
+		return ""
+	}
+
+	sha1Digest := sha1.Sum([]byte(accessKey))
+	return this.hashDigestWithScheme(sha1Digest[:], scheme)
+}
+
+// Calculates the hash for the given binary SHA1 digest of an access key, using the given scheme
+func (this *AccessKeyHasher) hashDigestWithScheme(sha1Digest []byte, scheme string) string {
+	switch scheme {
+	case AccessKeyHashScheme_HMACSHA256:
+		mac := hmac.New(sha256.New, this.secret)
+		mac.Write(sha1Digest)
+		return AccessKeyHashScheme_HMACSHA256 + ":" + hex.EncodeToString(mac.Sum(nil))
+	default:
+		return hex.EncodeToString(sha1Digest)
+	}
+}
+
+// Parses a stored access key hash to its scheme and hexadecimal digest
+func ParseAccessKeyHash(storedHash string) (scheme string, digest string, err error) {
+	separatorIndex := strings.IndexByte(storedHash, ':')
+
+	if separatorIndex == -1 {
+		scheme = AccessKeyHashScheme_SHA1
+		digest = storedHash
+	} else {
+		scheme = storedHash[:separatorIndex]
+		digest = storedHash[separatorIndex+1:]
+	}
+
+	var expectedDigestLength int
+
+	switch scheme {
+	case AccessKeyHashScheme_SHA1:
+		expectedDigestLength = 40
+	case AccessKeyHashScheme_HMACSHA256:
+		expectedDigestLength = 64
+	default:
+		return "", "", errors.New("Unsupported access key hash scheme '" + scheme + "'.")
+	}
+
+	if len(digest) != expectedDigestLength || !accessKeyRegexp.MatchString(digest) {
+		return "", "", errors.New("Invalid access key hash '" + storedHash + "'.")
+	}
+
+	return
+}
+
+// Loads the access key hash secret from the given storage directory. If it doesn't exist, a new random
+// secret is generated and stored.
+func LoadOrCreateAccessKeyHashSecret(storagePath string) ([]byte, error) {
+	secretFilePath := filepath.Join(storagePath, AccessKeyHashSecretFileName)
+
+	fileContent, err := ReadEntireFile(secretFilePath)
+
+	if err == nil {
+		return hex.DecodeString(strings.TrimSpace(string(fileContent)))
+	} else if _, ok := err.(*os.PathError); !ok {
+		return nil, err
+	}
+
+	// Generate a new 256 bit secret
+	secretHex := GenerateRandomAccessKey() + GenerateRandomAccessKey()
+	secret, _ := hex.DecodeString(secretHex)
+
+	// Write the secret to the file, making it only readable by the owner
+	err = ioutil.WriteFile(secretFilePath, []byte(secretHex), 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	return secret, nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("AccessKeyHasher", func() {
+	secret := RandomBytes(32)
+	accessKey := GenerateRandomAccessKey()
+
+	It("Hashes access keys using the keyed HMAC-SHA256 scheme", func() {
+		hasher, err := NewAccessKeyHasher(secret, AccessKeyHashScheme_HMACSHA256)
+		Expect(err).To(BeNil())
+
+		accessKeyHash := hasher.Hash(accessKey)
+		Expect(accessKeyHash).To(HavePrefix("hmac-sha256:"))
+		Expect(accessKeyHash).To(HaveLen(len("hmac-sha256:") + 64))
+		Expect(hasher.Hash("")).To(Equal(""))
+
+		otherHasher, _ := NewAccessKeyHasher(RandomBytes(32), AccessKeyHashScheme_HMACSHA256)
+		Expect(otherHasher.Hash(accessKey)).NotTo(Equal(accessKeyHash))
+
+		_, err = NewAccessKeyHasher(nil, AccessKeyHashScheme_HMACSHA256)
+		Expect(err).NotTo(BeNil())
+		_, err = NewAccessKeyHasher(secret, "md5")
+		Expect(err).NotTo(BeNil())
+	})
+
+	It("Matches access keys against hashes of all supported schemes", func() {
+		hasher, _ := NewAccessKeyHasher(secret, AccessKeyHashScheme_HMACSHA256)
+
+		Expect(hasher.Matches(accessKey, hasher.Hash(accessKey))).To(BeTrue())
+		Expect(hasher.Matches(accessKey, SHA1ToHex([]byte(accessKey)))).To(BeTrue())
+		Expect(hasher.Matches(GenerateRandomAccessKey(), hasher.Hash(accessKey))).To(BeFalse())
+		Expect(hasher.Matches(accessKey, "")).To(BeFalse())
+		Expect(hasher.Matches("", hasher.Hash(accessKey))).To(BeFalse())
+		Expect(hasher.Matches("", "")).To(BeTrue())
+		Expect(hasher.Matches(accessKey, "md5:"+SHA1ToHex([]byte(accessKey)))).To(BeFalse())
+
+		Expect(hasher.CandidateHashes(accessKey)).To(Equal([]string{hasher.Hash(accessKey), SHA1ToHex([]byte(accessKey))}))
+	})
+
+	It("Upgrades legacy SHA1 hashes without the original key", func() {
+		hasher, _ := NewAccessKeyHasher(secret, AccessKeyHashScheme_HMACSHA256)
+
+		upgradedHash, upgraded, err := hasher.Upgrade(SHA1ToHex([]byte(accessKey)))
+		Expect(err).To(BeNil())
+		Expect(upgraded).To(BeTrue())
+		Expect(upgradedHash).To(Equal(hasher.Hash(accessKey)))
+
+		_, upgraded, err = hasher.Upgrade(upgradedHash)
+		Expect(err).To(BeNil())
+		Expect(upgraded).To(BeFalse())
+
+		_, _, err = hasher.Upgrade("abc")
+		Expect(err).NotTo(BeNil())
+	})
+})
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Supported access log formats
+const (
+	AccessLogFormat_Common = "common"
+	AccessLogFormat_JSON   = "json"
+)
+
+// A handled request, as recorded in the access log
+type AccessLogRecord struct {
+	Time       time.Time
+	RemoteAddr string
+	Method     string
+	URI        string
+	Protocol   string
+	Status     int
+	Bytes      int64
+	Duration   time.Duration
+	Datastore  string
+	ClientID   string
+}
+
+// An access log, writing a line for each handled request in either the Common Log Format or as JSON
+type AccessLog struct {
+	output io.WriteCloser
+	format string
+}
+
+// Access log object constructor function. Each record is written to the output in a single call. An unknown
+// format is treated as the Common Log Format.
+func NewAccessLog(output io.WriteCloser, format string) *AccessLog {
+	if format != AccessLogFormat_JSON {
+		format = AccessLogFormat_Common
+	}
+
+	return &AccessLog{
+		output: output,
+		format: format,
+	}
+}
+
+// Writes a record to the log
+func (this *AccessLog) Write(record *AccessLogRecord) (err error) {
+	var line []byte
+
+	if this.format == AccessLogFormat_JSON {
+		line, err = formatJsonAccessLogLine(record)
+		if err != nil {
+			return
+		}
+	} else {
+		line = formatCommonAccessLogLine(record)
+	}
+
+	_, err = this.output.Write(line)
+
+	return
+}
+
+// Closes the log's output
+func (this *AccessLog) Close() error {
+	return this.output.Close()
+}
+
+// Formats a record in the Common Log Format, e.g.
+// '127.0.0.1 - hmac-sha256:5d1c.. [10/Oct/2017:13:55:36 +0000] "GET /datastore/MyDatastore HTTP/1.1" 200 2326'
+func formatCommonAccessLogLine(record *AccessLogRecord) []byte {
+	host := record.RemoteAddr
+
+	if splitHost, _, err := net.SplitHostPort(record.RemoteAddr); err == nil {
+		host = splitHost
+	}
+
+	bytesField := "-"
+
+	if record.Bytes > 0 {
+		bytesField = strconv.FormatInt(record.Bytes, 10)
+	}
+
+	return []byte(fmt.Sprintf("%s - %s [%s] \"%s %s %s\" %d %s\n",
+		commonLogField(host),
+		commonLogField(record.ClientID),
+		record.Time.Format("02/Jan/2006:15:04:05 -0700"),
+		escapeCommonLogString(record.Method),
+		escapeCommonLogString(record.URI),
+		escapeCommonLogString(record.Protocol),
+		record.Status,
+		bytesField))
+}
+
+// Formats a record as a JSON line
+func formatJsonAccessLogLine(record *AccessLogRecord) ([]byte, error) {
+	line, err := json.Marshal(map[string]interface{}{
+		"time":       record.Time.UTC().Format(time.RFC3339Nano),
+		"remoteAddr": record.RemoteAddr,
+		"method":     record.Method,
+		"uri":        record.URI,
+		"protocol":   record.Protocol,
+		"status":     record.Status,
+		"bytes":      record.Bytes,
+		"duration":   durationToMilliseconds(record.Duration),
+		"datastore":  record.Datastore,
+		"clientID":   record.ClientID,
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return append(line, '\n'), nil
+}
+
+// Formats a Common Log Format field, where an empty value is represented by '-'
+func commonLogField(value string) string {
+	if value == "" {
+		return "-"
+	}
+
+	return escapeCommonLogString(value)
+}
+
+// Escapes quotes, backslashes and control characters in a string included in a Common Log Format line
+func escapeCommonLogString(value string) string {
+	var result strings.Builder
+
+	for _, char := range value {
+		switch {
+		case char == '"' || char == '\\':
+			result.WriteByte('\\')
+			result.WriteRune(char)
+		case char < 0x20 || char == 0x7f:
+			result.WriteString(fmt.Sprintf("\\x%02x", char))
+		default:
+			result.WriteRune(char)
+		}
+	}
+
+	return result.String()
+}
+
+// Converts a duration to fractional milliseconds
+func durationToMilliseconds(duration time.Duration) float64 {
+	return float64(duration) / float64(time.Millisecond)
+}
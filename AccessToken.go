@@ -0,0 +1,352 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Supported access token signature algorithms, named as in the JWT 'alg' header field
+const (
+	AccessTokenAlgorithm_HS256 = "HS256"
+	AccessTokenAlgorithm_EdDSA = "EdDSA"
+)
+
+// The header of a signed access token
+type AccessTokenHeader struct {
+	Algorithm string `json:"alg"`
+	Type      string `json:"typ,omitempty"`
+	KeyID     string `json:"kid"`
+}
+
+// The claims of a signed access token
+type AccessTokenClaims struct {
+	Subject    string   `json:"sub,omitempty"`
+	Datastores []string `json:"datastores"`
+	Profile    string   `json:"profile"`
+	KeyPrefix  string   `json:"keyPrefix,omitempty"`
+	ExpiresAt  int64    `json:"exp"`
+	NotBefore  int64    `json:"nbf,omitempty"`
+	IssuedAt   int64    `json:"iat,omitempty"`
+}
+
+// A key used to verify (and optionally sign) access tokens
+type AccessTokenKey struct {
+	Algorithm  string
+	Secret     []byte
+	PublicKey  ed25519.PublicKey
+	PrivateKey ed25519.PrivateKey
+}
+
+// Checks if the given access key string has the form of a signed token, rather than a plain access key
+func IsAccessToken(accessKey string) bool {
+	return strings.Count(accessKey, ".") == 2
+}
+
+// Checks if the claims allow access to the given datastore. Datastore names in the claims may include
+// wildcards, using the syntax of 'path.Match' (e.g. "user_*").
+func (this *AccessTokenClaims) AllowsDatastore(datastoreName string) bool {
+	for _, pattern := range this.Datastores {
+		matched, err := path.Match(pattern, datastoreName)
+
+		if err == nil && matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Parses and verifies a signed access token. The key used for verification is retrieved through the given
+// function, using the key identifier included in the token's header. 'now' is given as UNIX epoch seconds.
+func ParseAndVerifyAccessToken(token string, getKey func(keyID string) (*AccessTokenKey, error), now int64) (*AccessTokenClaims, error) {
+	tokenParts := strings.Split(token, ".")
+
+	if len(tokenParts) != 3 {
+		return nil, errors.New("Malformed access token.")
+	}
+
+	// Decode and parse the header
+	headerBytes, err := base64.RawURLEncoding.DecodeString(tokenParts[0])
+	if err != nil {
+		return nil, errors.New("Malformed access token header.")
+	}
+
+	var header AccessTokenHeader
+	err = json.Unmarshal(headerBytes, &header)
+	if err != nil {
+		return nil, errors.New("Malformed access token header.")
+	}
+
+	// Get the key for the identifier given in the header
+	key, err := getKey(header.KeyID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Ensure the algorithm given in the header matches the one configured for the key. The header is never
+	// trusted to choose the algorithm.
+	if header.Algorithm != key.Algorithm {
+		return nil, errors.New("The access token's algorithm doesn't match the one configured for its key.")
+	}
+
+	// Decode the signature
+	signature, err := base64.RawURLEncoding.DecodeString(tokenParts[2])
+	if err != nil {
+		return nil, errors.New("Malformed access token signature.")
+	}
+
+	// Verify the signature
+	signedContent := []byte(tokenParts[0] + "." + tokenParts[1])
+
+	if !key.verify(signedContent, signature) {
+		return nil, errors.New("Invalid access token signature.")
+	}
+
+	// Decode and parse the claims
+	claimsBytes, err := base64.RawURLEncoding.DecodeString(tokenParts[1])
+	if err != nil {
+		return nil, errors.New("Malformed access token claims.")
+	}
+
+	var claims AccessTokenClaims
+	err = json.Unmarshal(claimsBytes, &claims)
+	if err != nil {
+		return nil, errors.New("Malformed access token claims.")
+	}
+
+	// Validate the claims
+	if claims.ExpiresAt == 0 {
+		return nil, errors.New("The access token doesn't specify an expiry time.")
+	}
+
+	if now >= claims.ExpiresAt {
+		return nil, errors.New("The access token has expired.")
+	}
+
+	if claims.NotBefore > 0 && now < claims.NotBefore {
+		return nil, errors.New("The access token is not yet valid.")
+	}
+
+	if claims.Profile == "" {
+		return nil, errors.New("The access token doesn't specify an access profile.")
+	}
+
+	// The subject identifies the client for rate limiting, connection limits and storage quotas, so
+	// tokens lacking one would all share the same limits
+	if claims.Subject == "" {
+		return nil, errors.New("The access token doesn't specify a subject.")
+	}
+
+	return &claims, nil
+}
+
+// Creates a signed access token with the given claims
+func CreateAccessToken(claims *AccessTokenClaims, keyID string, key *AccessTokenKey) (string, error) {
+	serializedHeader, err := json.Marshal(AccessTokenHeader{Algorithm: key.Algorithm, Type: "JWT", KeyID: keyID})
+	if err != nil {
+		return "", err
+	}
+
+	serializedClaims, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signedContent := base64.RawURLEncoding.EncodeToString(serializedHeader) + "." + base64.RawURLEncoding.EncodeToString(serializedClaims)
+
+	var signature []byte
+
+	switch key.Algorithm {
+	case AccessTokenAlgorithm_HS256:
+		mac := hmac.New(sha256.New, key.Secret)
+		mac.Write([]byte(signedContent))
+		signature = mac.Sum(nil)
+	case AccessTokenAlgorithm_EdDSA:
+		if len(key.PrivateKey) != ed25519.PrivateKeySize {
+			return "", errors.New("No private key available for signing.")
+		}
+
+		signature = ed25519.Sign(key.PrivateKey, []byte(signedContent))
+	default:
+		return "", errors.New("Unsupported access token algorithm '" + key.Algorithm + "'.")
+	}
+
+	return signedContent + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// Verifies the given signature for the given content
+func (this *AccessTokenKey) verify(content []byte, signature []byte) bool {
+	switch this.Algorithm {
+	case AccessTokenAlgorithm_HS256:
+		if len(this.Secret) == 0 {
+			return false
+		}
+
+		mac := hmac.New(sha256.New, this.Secret)
+		mac.Write(content)
+		return hmac.Equal(mac.Sum(nil), signature)
+	case AccessTokenAlgorithm_EdDSA:
+		if len(this.PublicKey) != ed25519.PublicKeySize {
+			return false
+		}
+
+		return ed25519.Verify(this.PublicKey, content, signature)
+	default:
+		return false
+	}
+}
+
+// Gets the access token key having the given identifier, as configured in the given configuration snapshot.
+// Keys are configured either as "['server']['token']['key'][<keyID>]['algorithm' | 'secret' | 'publicKey']"
+// entries, where secrets and public keys are base64 encoded, or in a local JWKS file specified by
+// "['server']['token']['jwksFile']".
+func GetConfiguredAccessTokenKey(config *DatastoreConfigSnapshot, keySetCache *AccessTokenKeySetCache, keyID string) (*AccessTokenKey, error) {
+	if keyID == "" || strings.ContainsAny(keyID, "'[]") {
+		return nil, errors.New("The access token doesn't specify a valid key identifier.")
+	}
+
+	keyPrefix := "['server']['token']['key']['" + keyID + "']"
+	algorithm, err := config.GetString_GlobalOnly(keyPrefix + "['algorithm']")
+
+	// If the key is defined in the configuration
+	if err == nil && algorithm != "" {
+		key := &AccessTokenKey{Algorithm: algorithm}
+
+		switch algorithm {
+		case AccessTokenAlgorithm_HS256:
+			encodedSecret, _ := config.GetString_GlobalOnly(keyPrefix + "['secret']")
+			key.Secret, err = base64.StdEncoding.DecodeString(encodedSecret)
+		case AccessTokenAlgorithm_EdDSA:
+			encodedPublicKey, _ := config.GetString_GlobalOnly(keyPrefix + "['publicKey']")
+			key.PublicKey, err = base64.StdEncoding.DecodeString(encodedPublicKey)
+		default:
+			return nil, errors.New("The access token key '" + keyID + "' has an unsupported algorithm configured.")
+		}
+
+		if err != nil {
+			return nil, errors.New("The access token key '" + keyID + "' is misconfigured.")
+		}
+
+		return key, nil
+	}
+
+	// Otherwise, look for it in the JWKS file, if configured
+	jwksFilePath, _ := config.GetString_GlobalOnly("['server']['token']['jwksFile']")
+
+	if jwksFilePath != "" {
+		keys, err := keySetCache.Get(jwksFilePath)
+		if err != nil {
+			return nil, err
+		}
+
+		if key, found := keys[keyID]; found {
+			return key, nil
+		}
+	}
+
+	return nil, errors.New("Unknown access token key '" + keyID + "'.")
+}
+
+// A JSON Web Key, as found in a JWKS file. Only symmetric ('oct') and Ed25519 ('OKP') keys are supported.
+type jsonWebKey struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Algorithm string `json:"alg"`
+	Curve     string `json:"crv"`
+	K         string `json:"k"`
+	X         string `json:"x"`
+}
+
+// Parses the given JWKS file content to a map of keys, indexed by their identifiers
+func ParseJWKS(jwksBytes []byte) (map[string]*AccessTokenKey, error) {
+	var keySet struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+
+	err := json.Unmarshal(jwksBytes, &keySet)
+	if err != nil {
+		return nil, errors.New("Malformed JWKS: " + err.Error())
+	}
+
+	keys := map[string]*AccessTokenKey{}
+
+	for _, webKey := range keySet.Keys {
+		switch {
+		case webKey.KeyType == "oct" && (webKey.Algorithm == "" || webKey.Algorithm == AccessTokenAlgorithm_HS256):
+			secret, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(webKey.K, "="))
+			if err != nil {
+				return nil, errors.New("Malformed JWKS key '" + webKey.KeyID + "'.")
+			}
+
+			keys[webKey.KeyID] = &AccessTokenKey{Algorithm: AccessTokenAlgorithm_HS256, Secret: secret}
+		case webKey.KeyType == "OKP" && webKey.Curve == "Ed25519":
+			publicKey, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(webKey.X, "="))
+			if err != nil {
+				return nil, errors.New("Malformed JWKS key '" + webKey.KeyID + "'.")
+			}
+
+			keys[webKey.KeyID] = &AccessTokenKey{Algorithm: AccessTokenAlgorithm_EdDSA, PublicKey: publicKey}
+		}
+	}
+
+	return keys, nil
+}
+
+// A cache for the content of a JWKS file. The file is reloaded whenever its modification time changes.
+type AccessTokenKeySetCache struct {
+	filePath string
+	modTime  time.Time
+	keys     map[string]*AccessTokenKey
+
+	lock *sync.Mutex
+}
+
+// Access token key set cache object constructor function
+func NewAccessTokenKeySetCache() *AccessTokenKeySetCache {
+	return &AccessTokenKeySetCache{
+		lock: &sync.Mutex{},
+	}
+}
+
+// Gets the keys contained in the given JWKS file, reloading it if needed
+func (this *AccessTokenKeySetCache) Get(filePath string) (map[string]*AccessTokenKey, error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	fileInfo, err := os.Stat(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	// If the cached keys are current, return them
+	if this.keys != nil && this.filePath == filePath && fileInfo.ModTime().Equal(this.modTime) {
+		return this.keys, nil
+	}
+
+	// Otherwise, reload the file
+	fileContent, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	keys, err := ParseJWKS(fileContent)
+	if err != nil {
+		return nil, err
+	}
+
+	this.filePath = filePath
+	this.modTime = fileInfo.ModTime()
+	this.keys = keys
+
+	return keys, nil
+}
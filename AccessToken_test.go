@@ -0,0 +1,124 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("AccessToken", func() {
+	hmacKey := &AccessTokenKey{Algorithm: AccessTokenAlgorithm_HS256, Secret: RandomBytes(32)}
+
+	getKeyFunc := func(keys map[string]*AccessTokenKey) func(string) (*AccessTokenKey, error) {
+		return func(keyID string) (*AccessTokenKey, error) {
+			key, found := keys[keyID]
+			if !found {
+				return nil, ErrNotFound
+			}
+
+			return key, nil
+		}
+	}
+
+	now := time.Now().Unix()
+	testClaims := &AccessTokenClaims{Subject: "user1", Datastores: []string{"app_*", "shared"}, Profile: "ReaderWriter", KeyPrefix: "['users']['user1']", ExpiresAt: now + 60}
+
+	It("Creates and verifies HMAC signed tokens", func() {
+		token, err := CreateAccessToken(testClaims, "key1", hmacKey)
+		Expect(err).To(BeNil())
+		Expect(IsAccessToken(token)).To(BeTrue())
+		Expect(IsAccessToken(GenerateRandomAccessKey())).To(BeFalse())
+
+		claims, err := ParseAndVerifyAccessToken(token, getKeyFunc(map[string]*AccessTokenKey{"key1": hmacKey}), now)
+		Expect(err).To(BeNil())
+		Expect(claims).To(Equal(testClaims))
+
+		// Verify with a different secret
+		otherKey := &AccessTokenKey{Algorithm: AccessTokenAlgorithm_HS256, Secret: RandomBytes(32)}
+		_, err = ParseAndVerifyAccessToken(token, getKeyFunc(map[string]*AccessTokenKey{"key1": otherKey}), now)
+		Expect(err).NotTo(BeNil())
+
+		// Verify with an unknown key identifier
+		_, err = ParseAndVerifyAccessToken(token, getKeyFunc(map[string]*AccessTokenKey{"key2": hmacKey}), now)
+		Expect(err).NotTo(BeNil())
+	})
+
+	It("Creates and verifies Ed25519 signed tokens", func() {
+		publicKey, privateKey, err := ed25519.GenerateKey(nil)
+		Expect(err).To(BeNil())
+
+		token, err := CreateAccessToken(testClaims, "key1", &AccessTokenKey{Algorithm: AccessTokenAlgorithm_EdDSA, PrivateKey: privateKey})
+		Expect(err).To(BeNil())
+
+		claims, err := ParseAndVerifyAccessToken(token, getKeyFunc(map[string]*AccessTokenKey{"key1": &AccessTokenKey{Algorithm: AccessTokenAlgorithm_EdDSA, PublicKey: publicKey}}), now)
+		Expect(err).To(BeNil())
+		Expect(claims.Subject).To(Equal("user1"))
+
+		// A token must not be accepted if its algorithm differs from the one configured for the key
+		_, err = ParseAndVerifyAccessToken(token, getKeyFunc(map[string]*AccessTokenKey{"key1": &AccessTokenKey{Algorithm: AccessTokenAlgorithm_HS256, Secret: publicKey}}), now)
+		Expect(err).NotTo(BeNil())
+	})
+
+	It("Rejects expired, not yet valid and tampered tokens", func() {
+		getKey := getKeyFunc(map[string]*AccessTokenKey{"key1": hmacKey})
+
+		token, _ := CreateAccessToken(testClaims, "key1", hmacKey)
+		_, err := ParseAndVerifyAccessToken(token, getKey, now+60)
+		Expect(err).NotTo(BeNil())
+
+		notYetValidToken, _ := CreateAccessToken(&AccessTokenClaims{Subject: "user1", Datastores: []string{"*"}, Profile: "Reader", ExpiresAt: now + 60, NotBefore: now + 30}, "key1", hmacKey)
+		_, err = ParseAndVerifyAccessToken(notYetValidToken, getKey, now)
+		Expect(err).NotTo(BeNil())
+
+		noExpiryToken, _ := CreateAccessToken(&AccessTokenClaims{Subject: "user1", Datastores: []string{"*"}, Profile: "Reader"}, "key1", hmacKey)
+		_, err = ParseAndVerifyAccessToken(noExpiryToken, getKey, now)
+		Expect(err).NotTo(BeNil())
+
+		noSubjectToken, _ := CreateAccessToken(&AccessTokenClaims{Datastores: []string{"*"}, Profile: "Reader", ExpiresAt: now + 60}, "key1", hmacKey)
+		_, err = ParseAndVerifyAccessToken(noSubjectToken, getKey, now)
+		Expect(err).NotTo(BeNil())
+
+		tamperedClaims := base64.RawURLEncoding.EncodeToString([]byte(`{"datastores":["*"],"profile":"ReaderWriter","exp":9999999999}`))
+		tokenParts := []byte(token)
+		firstDot, secondDot := -1, -1
+		for i, c := range tokenParts {
+			if c == '.' {
+				if firstDot == -1 {
+					firstDot = i
+				} else {
+					secondDot = i
+				}
+			}
+		}
+
+		tamperedToken := token[:firstDot+1] + tamperedClaims + token[secondDot:]
+		_, err = ParseAndVerifyAccessToken(tamperedToken, getKey, now)
+		Expect(err).NotTo(BeNil())
+	})
+
+	It("Matches datastore names against the patterns given in the claims", func() {
+		Expect(testClaims.AllowsDatastore("app_123")).To(BeTrue())
+		Expect(testClaims.AllowsDatastore("shared")).To(BeTrue())
+		Expect(testClaims.AllowsDatastore("shared2")).To(BeFalse())
+		Expect(testClaims.AllowsDatastore("other")).To(BeFalse())
+	})
+
+	It("Parses symmetric and Ed25519 keys from a JWKS", func() {
+		publicKey, _, _ := ed25519.GenerateKey(nil)
+
+		jwks := `{"keys":[` +
+			`{"kty":"oct","kid":"a","alg":"HS256","k":"` + base64.RawURLEncoding.EncodeToString(hmacKey.Secret) + `"},` +
+			`{"kty":"OKP","kid":"b","crv":"Ed25519","x":"` + base64.RawURLEncoding.EncodeToString(publicKey) + `"},` +
+			`{"kty":"RSA","kid":"c","n":"AQAB","e":"AQAB"}]}`
+
+		keys, err := ParseJWKS([]byte(jwks))
+		Expect(err).To(BeNil())
+		Expect(keys).To(HaveLen(2))
+		Expect(keys["a"]).To(Equal(hmacKey))
+		Expect(keys["b"].Algorithm).To(Equal(AccessTokenAlgorithm_EdDSA))
+		Expect(keys["b"].PublicKey).To(Equal(ed25519.PublicKey(publicKey)))
+	})
+})
@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -17,6 +18,11 @@ type Client struct {
 	hostURL       string
 	datastoreName string
 	accessKey     string
+
+	// The HTTP client used to send requests. If nil, a default one is used.
+	httpClient *http.Client
+	// A context for all requests sent. If nil, requests can't be cancelled.
+	requestContext context.Context
 }
 
 // The client object constructor
@@ -63,6 +69,26 @@ func (this *Client) GetWhenNonEmpty(updatedAfter int64) (results []Entry, err er
 	return
 }
 
+// Sends a GET request to the server with the given 'updatedAfter' minimum timestamp, requesting the results
+// as newline delimited JSON records
+func (this *Client) GetJsonRecords(updatedAfter int64) (results []Entry, err error) {
+	params := map[string]string{
+		"format": "ndjson",
+	}
+
+	if updatedAfter > 0 {
+		params["updatedAfter"] = fmt.Sprintf("%d", updatedAfter)
+	}
+	_, responseBody, err := this.Request("GET", params, nil)
+	if err != nil {
+		return
+	}
+
+	results, err = DeserializeJsonRecordStream(bytes.NewReader(responseBody))
+
+	return
+}
+
 // Sends a GET request to the server with the given 'updatedAfter' minimum timestamp, and compacts the results
 func (this *Client) GetAndCompact(updatedAfter int64) (results []Entry, err error) {
 	params := map[string]string{}
@@ -165,13 +191,26 @@ func (this *Client) Delete() (err error) {
 func (this *Client) Request(method string, queryArgs map[string]string, requestBody io.Reader) (response *http.Response, responseBody []byte, err error) {
 	url := this.BuildRequestURL(queryArgs)
 
-	request, err := http.NewRequest(method, url, requestBody)
+	requestContext := this.requestContext
+	if requestContext == nil {
+		requestContext = context.Background()
+	}
+
+	request, err := http.NewRequestWithContext(requestContext, method, url, requestBody)
 
 	if err != nil {
 		return
 	}
 
-	client := &http.Client{}
+	// Include the access key in the 'Authorization' header, if given
+	if this.accessKey != "" {
+		request.Header.Set("Authorization", "Bearer "+this.accessKey)
+	}
+
+	client := this.httpClient
+	if client == nil {
+		client = &http.Client{}
+	}
 
 	response, err = client.Do(request)
 	if err != nil {
@@ -192,16 +231,7 @@ func (this *Client) Request(method string, queryArgs map[string]string, requestB
 //type webSocketClientReadNextFunc func() ([]Entry, error)
 
 func (this *Client) OpenWebSocket(updatedAfter int64)  (func() ([]Entry, error), error) {
-	dialer := &websocket.Dialer{}
-	queryArgs := map[string]string{}
-
-	if updatedAfter > 0 {
-		queryArgs["updatedAfter"] = fmt.Sprintf("%d", updatedAfter)
-	}
-
-	requestURL := this.BuildRequestURL(queryArgs)
-	requestURL = "ws://" + requestURL[7:]
-	conn, _, err := dialer.Dial(requestURL, nil)
+	conn, err := this.OpenWebSocketConnection(updatedAfter)
 
 	if err != nil {
 		return nil, err
@@ -227,6 +257,35 @@ func (this *Client) OpenWebSocket(updatedAfter int64)  (func() ([]Entry, error),
 	}, nil
 }
 
+// Opens a WebSocket connection to the datastore, receiving entries updated after the given timestamp as
+// binary messages
+func (this *Client) OpenWebSocketConnection(updatedAfter int64) (*websocket.Conn, error) {
+	dialer := &websocket.Dialer{}
+
+	// Include the access key as a WebSocket subprotocol token, if given
+	if this.accessKey != "" {
+		dialer.Subprotocols = []string{AccessKeyWebSocketProtocolPrefix + this.accessKey}
+	}
+
+	queryArgs := map[string]string{}
+
+	if updatedAfter > 0 {
+		queryArgs["updatedAfter"] = fmt.Sprintf("%d", updatedAfter)
+	}
+
+	// Replace the 'http' or 'https' scheme with 'ws' or 'wss', respectively
+	requestURL := "ws" + strings.TrimPrefix(this.BuildRequestURL(queryArgs), "http")
+
+	requestContext := this.requestContext
+	if requestContext == nil {
+		requestContext = context.Background()
+	}
+
+	conn, _, err := dialer.DialContext(requestContext, requestURL, nil)
+
+	return conn, err
+}
+
 func (this *Client) BuildRequestURL(queryArgs map[string]string) string {
 	queryComponents := []string{}
 
@@ -236,10 +295,6 @@ func (this *Client) BuildRequestURL(queryArgs map[string]string) string {
 		}
 	}
 
-	if this.accessKey != "" {
-		queryComponents = append(queryComponents, fmt.Sprintf("accessKey=%s", this.accessKey))
-	}
-
 	queryString := "?" + strings.Join(queryComponents, "&")
 
 	if len(queryString) > 1 {
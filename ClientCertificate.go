@@ -0,0 +1,82 @@
+package main
+
+import (
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// Loads a bundle of PEM encoded CA certificates, used to verify client certificates
+func LoadClientCACertificatePool(caFile string) (*x509.CertPool, error) {
+	caFileContent, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+
+	if !pool.AppendCertsFromPEM(caFileContent) {
+		return nil, errors.New("No valid PEM encoded certificates were found in the client CA file '" + caFile + "'.")
+	}
+
+	return pool, nil
+}
+
+// Gets the client certificate sent with the request, if it was sent over TLS and verified against the
+// configured CA bundle. Otherwise returns nil.
+func GetVerifiedClientCertificate(r *http.Request) *x509.Certificate {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil
+	}
+
+	return r.TLS.VerifiedChains[0][0]
+}
+
+// Gets the identities a client certificate may be mapped to an access profile by, in the order they
+// are looked up: its subject alternative names, prefixed by 'dns:', 'email:' or 'uri:', followed by its
+// subject distinguished name, prefixed by 'subject:' (e.g. 'subject:CN=service-a,O=Example'). Identities
+// containing characters that can't be included in a configuration path are omitted.
+func GetClientCertificateIdentities(certificate *x509.Certificate) []string {
+	identities := []string{}
+
+	for _, name := range certificate.DNSNames {
+		identities = append(identities, "dns:"+name)
+	}
+
+	for _, address := range certificate.EmailAddresses {
+		identities = append(identities, "email:"+address)
+	}
+
+	for _, uri := range certificate.URIs {
+		identities = append(identities, "uri:"+uri.String())
+	}
+
+	identities = append(identities, "subject:"+certificate.Subject.String())
+
+	results := []string{}
+
+	for _, identity := range identities {
+		if !strings.ContainsAny(identity, `'[]"`) {
+			results = append(results, identity)
+		}
+	}
+
+	return results
+}
+
+// Finds the access profile associated with a client certificate in the given configuration, through the
+// setting ['datastore']['clientCertificate'][<identity>]. Returns the identity that matched, along with the
+// profile name, or ErrNotFound if no identity matched.
+func FindClientCertificateProfile(config *DatastoreConfigSnapshot, certificate *x509.Certificate) (identity string, profileName string, err error) {
+	for _, identity = range GetClientCertificateIdentities(certificate) {
+		profileName, err = config.GetString("['datastore']['clientCertificate']['" + identity + "']")
+
+		if err == nil {
+			return
+		}
+	}
+
+	return "", "", ErrNotFound
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// A certificate authority used to issue client certificates in tests
+type testCertificateAuthority struct {
+	certificate *x509.Certificate
+	privateKey  *ecdsa.PrivateKey
+}
+
+// Creates a self-signed certificate authority
+func newTestCertificateAuthority(commonName string) *testCertificateAuthority {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).To(BeNil())
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+
+	certificateBytes, err := x509.CreateCertificate(rand.Reader, template, template, &privateKey.PublicKey, privateKey)
+	Expect(err).To(BeNil())
+
+	certificate, err := x509.ParseCertificate(certificateBytes)
+	Expect(err).To(BeNil())
+
+	return &testCertificateAuthority{certificate, privateKey}
+}
+
+// Gets the PEM encoded certificate of the authority
+func (this *testCertificateAuthority) certificatePEM() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: this.certificate.Raw})
+}
+
+// Issues a client certificate with the given subject and DNS names
+func (this *testCertificateAuthority) issueClientCertificate(subject pkix.Name, dnsNames []string) tls.Certificate {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).To(BeNil())
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      subject,
+		DNSNames:     dnsNames,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+
+	certificateBytes, err := x509.CreateCertificate(rand.Reader, template, this.certificate, &privateKey.PublicKey, this.privateKey)
+	Expect(err).To(BeNil())
+
+	return tls.Certificate{Certificate: [][]byte{certificateBytes}, PrivateKey: privateKey}
+}
+
+var _ = Describe("Client certificates", func() {
+	It("Are identified by their subject alternative names and subject", func() {
+		certificate := &x509.Certificate{
+			Subject:        pkix.Name{CommonName: "service-a", Organization: []string{"Example"}},
+			DNSNames:       []string{"service-a.example.com", "invalid'.example.com"},
+			EmailAddresses: []string{"service-a@example.com"},
+		}
+
+		Expect(GetClientCertificateIdentities(certificate)).To(Equal([]string{
+			"dns:service-a.example.com",
+			"email:service-a@example.com",
+			"subject:CN=service-a,O=Example",
+		}))
+	})
+})
+
+var _ = Describe("Server", func() {
+	var context *ServerTestContext
+	var testDirectory string
+	var certificateAuthority *testCertificateAuthority
+
+	BeforeEach(func() {
+		var err error
+		testDirectory, err = ioutil.TempDir("", "ClientCertificate")
+		Expect(err).To(BeNil())
+
+		certificateAuthority = newTestCertificateAuthority("Test CA")
+
+		context = NewServerTestContext()
+		context.startupOptions.SecurePort = 12346
+		context.startupOptions.CertFile = filepath.Join(testDirectory, "server.cert.pem")
+		context.startupOptions.KeyFile = filepath.Join(testDirectory, "server.key.pem")
+		context.startupOptions.ClientCAFile = filepath.Join(testDirectory, "ca.pem")
+
+		writeTestCertificate(context.startupOptions.CertFile, context.startupOptions.KeyFile, []string{"localhost"})
+		Expect(ioutil.WriteFile(context.startupOptions.ClientCAFile, certificateAuthority.certificatePEM(), 0644)).To(BeNil())
+
+		context.Start()
+	})
+
+	AfterEach(func() {
+		context.Stop()
+		os.RemoveAll(testDirectory)
+	})
+
+	// Sends a request over the secure listener, with the given client certificate, if any
+	sendRequest := func(certificate *tls.Certificate, method string, path string, body []byte) (int, error) {
+		tlsConfig := &tls.Config{InsecureSkipVerify: true}
+
+		// Always send the given certificate, even if it wasn't issued by one of the CAs the server requested
+		if certificate != nil {
+			tlsConfig.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+				return certificate, nil
+			}
+		}
+
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+		defer client.CloseIdleConnections()
+
+		request, _ := http.NewRequest(method, "https://localhost:12346"+path, bytes.NewReader(body))
+
+		response, err := client.Do(request)
+		if err != nil {
+			return 0, err
+		}
+
+		response.Body.Close()
+
+		return response.StatusCode, nil
+	}
+
+	It("Maps verified client certificates to access profiles", func() {
+		datastoreName := RandomWordString(12)
+
+		err := context.PutDatastoreSetting(datastoreName, `"['datastore']['clientCertificate']['subject:CN=service-a']"`, `"ReaderWriter"`, "")
+		Expect(err).To(BeNil())
+
+		err = context.PutGlobalSetting(`"['datastore']['clientCertificate']['dns:service-b.example.com']"`, `"Reader"`, "")
+		Expect(err).To(BeNil())
+
+		// Set a master key, such that requests without an access key aren't considered master key requests
+		_, masterKeyHash := context.GetRandomAccessKey()
+		err = context.PutGlobalSetting(`"['server']['masterKeyHash']"`, `"`+masterKeyHash+`"`, "")
+		Expect(err).To(BeNil())
+
+		serviceACertificate := certificateAuthority.issueClientCertificate(pkix.Name{CommonName: "service-a"}, nil)
+		serviceBCertificate := certificateAuthority.issueClientCertificate(pkix.Name{CommonName: "service-b"}, []string{"service-b.example.com"})
+		unknownCertificate := certificateAuthority.issueClientCertificate(pkix.Name{CommonName: "unknown"}, nil)
+
+		serializedEntries := SerializeEntries(context.GetTestEntries())
+		datastorePath := "/datastore/" + datastoreName
+
+		// Ensure the methods allowed by each profile are enforced
+		Expect(sendRequest(&serviceACertificate, "PUT", datastorePath, serializedEntries)).To(Equal(http.StatusOK))
+		Expect(sendRequest(&serviceACertificate, "GET", datastorePath, nil)).To(Equal(http.StatusOK))
+		Expect(sendRequest(&serviceACertificate, "DELETE", datastorePath, nil)).To(Equal(http.StatusForbidden))
+
+		Expect(sendRequest(&serviceBCertificate, "GET", datastorePath, nil)).To(Equal(http.StatusOK))
+		Expect(sendRequest(&serviceBCertificate, "POST", datastorePath, serializedEntries)).To(Equal(http.StatusForbidden))
+
+		// Ensure a certificate that isn't mapped to a profile isn't authorized
+		Expect(sendRequest(&unknownCertificate, "GET", datastorePath, nil)).To(Equal(http.StatusUnauthorized))
+
+		// Ensure a certificate can't be used to access a configuration datastore
+		Expect(sendRequest(&serviceACertificate, "GET", "/datastore/"+datastoreName+".config", nil)).To(Equal(http.StatusUnauthorized))
+	})
+
+	It("Rejects client certificates not issued by the configured CA", func() {
+		otherCertificate := newTestCertificateAuthority("Other CA").issueClientCertificate(pkix.Name{CommonName: "service-a"}, nil)
+
+		_, err := sendRequest(&otherCertificate, "GET", "/datastore/"+RandomWordString(12), nil)
+		Expect(err).NotTo(BeNil())
+	})
+})
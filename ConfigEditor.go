@@ -0,0 +1,444 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// An object used to inspect and modify configuration datastores directly through the storage directory,
+// without a running server
+type ConfigEditor struct {
+	server        *Server
+	datastoreName string
+}
+
+var configKeyPathRegexp *regexp.Regexp
+
+func init() {
+	configKeyPathRegexp = regexp.MustCompile(`^(\['[^'\[\]]*'\])+$`)
+}
+
+// Constructs a new configuration editor for the given target datastore. An empty datastore name
+// targets the global configuration datastore.
+func NewConfigEditor(storagePath string, datastoreName string) (*ConfigEditor, error) {
+	storagePathExists, err := DirectoryExists(storagePath)
+	if err != nil {
+		return nil, err
+	} else if !storagePathExists {
+		return nil, errors.New("The specified storage path '" + storagePath + "' does not exist.")
+	}
+
+	// Resolve the name of the configuration datastore
+	configDatastoreName := ".config"
+
+	if datastoreName != "" && datastoreName != ".config" {
+		configDatastoreName = strings.TrimSuffix(datastoreName, ".config") + ".config"
+	}
+
+	if configDatastoreName != DatastoreOwnershipDatastoreName && !datastorePathRegexp.MatchString("/datastore/"+configDatastoreName) {
+		return nil, errors.New("Invalid datastore name '" + datastoreName + "'.")
+	}
+
+	// Create a server object for the storage path. The server isn't started, it is only used to
+	// perform datastore operations
+	startupOptions := DefaultServerStartupOptions()
+	startupOptions.StoragePath = storagePath
+	startupOptions.LogLevel = 0
+
+	return &ConfigEditor{
+		server:        NewServer(startupOptions),
+		datastoreName: configDatastoreName,
+	}, nil
+}
+
+// Gets the name of the target configuration datastore
+func (this *ConfigEditor) DatastoreName() string {
+	return this.datastoreName
+}
+
+// Gets all the keys in the configuration datastore, sorted, along with their JSON encoded values
+func (this *ConfigEditor) List() (keys []string, values map[string]string, err error) {
+	content, err := this.server.GetConfigDatastoreContent(this.datastoreName)
+	if err != nil {
+		return
+	}
+
+	keys = content.Keys()
+	sort.Strings(keys)
+
+	values = map[string]string{}
+
+	for _, key := range keys {
+		value, _ := content.GetAny(key)
+		serializedValue, _ := json.Marshal(value)
+		values[key] = string(serializedValue)
+	}
+
+	return
+}
+
+// Gets the JSON encoded value of the given key
+func (this *ConfigEditor) Get(keyPath string) (string, error) {
+	key, err := ParseConfigKeyPath(keyPath)
+	if err != nil {
+		return "", err
+	}
+
+	content, err := this.server.GetConfigDatastoreContent(this.datastoreName)
+	if err != nil {
+		return "", err
+	}
+
+	value, err := content.GetAny(key)
+	if err != nil {
+		return "", err
+	}
+
+	serializedValue, err := json.Marshal(value)
+	return string(serializedValue), err
+}
+
+// Sets the given key to the given JSON encoded value, which may be of any JSON type other than null
+func (this *ConfigEditor) Set(keyPath string, jsonValue string) error {
+	key, err := ParseConfigKeyPath(keyPath)
+	if err != nil {
+		return err
+	}
+
+	var value interface{}
+	err = json.Unmarshal([]byte(jsonValue), &value)
+
+	if err != nil {
+		return errors.New("The value '" + jsonValue + "' is not valid JSON. Note string values should be quoted.")
+	}
+
+	if value == nil {
+		return errors.New("Configuration values can't be null. Use 'delete' to remove a key.")
+	}
+
+	return this.commit(key, jsonValue)
+}
+
+// Deletes the given key
+func (this *ConfigEditor) Delete(keyPath string) error {
+	key, err := ParseConfigKeyPath(keyPath)
+	if err != nil {
+		return err
+	}
+
+	content, err := this.server.GetConfigDatastoreContent(this.datastoreName)
+	if err != nil {
+		return err
+	}
+
+	if !content.Has(key) {
+		return ErrNotFound
+	}
+
+	return this.commit(key, "")
+}
+
+// Generates a new access key and associates its hash with the given access profile. Returns the key and its hash.
+func (this *ConfigEditor) AddAccessKey(profileName string) (accessKey string, accessKeyHash string, err error) {
+	accessKey = GenerateRandomAccessKey()
+
+	accessKeyHash, err = this.server.HashAccessKey(accessKey)
+	if err != nil {
+		return
+	}
+
+	err = this.SetAccessKeyProfile(accessKeyHash, profileName)
+	return
+}
+
+// Associates the given access key hash with the given access profile
+func (this *ConfigEditor) SetAccessKeyProfile(accessKeyHash string, profileName string) error {
+	_, _, err := ParseAccessKeyHash(accessKeyHash)
+	if err != nil {
+		return err
+	}
+
+	err = this.verifyProfileExists(profileName)
+	if err != nil {
+		return err
+	}
+
+	serializedProfileName, _ := json.Marshal(profileName)
+
+	return this.commit("['datastore']['accessKeyHash']['"+accessKeyHash+"']", string(serializedProfileName))
+}
+
+// Generates a new master key and replaces the existing master key hash. Returns the key and its hash.
+func (this *ConfigEditor) RotateMasterKey() (masterKey string, masterKeyHash string, err error) {
+	if this.datastoreName != ".config" {
+		return "", "", errors.New("The master key can only be set in the global configuration datastore.")
+	}
+
+	masterKey = GenerateRandomAccessKey()
+
+	masterKeyHash, err = this.server.HashAccessKey(masterKey)
+	if err != nil {
+		return
+	}
+
+	err = this.commit("['server']['masterKeyHash']", `"`+masterKeyHash+`"`)
+	return
+}
+
+// Rewrites all access key hashes stored with the legacy SHA1 scheme, including the master key hash and the
+// hashes recorded as datastore owners, to the keyed HMAC-SHA256 scheme, as a single transaction. When the global configuration datastore is targeted, the
+// scheme used for newly created hashes is set as well. Returns the number of hashes rewritten.
+func (this *ConfigEditor) MigrateAccessKeyHashes() (migratedCount int, err error) {
+	secret, err := this.server.getAccessKeyHashSecret()
+	if err != nil {
+		return
+	}
+
+	accessKeyHasher, err := NewAccessKeyHasher(secret, AccessKeyHashScheme_HMACSHA256)
+	if err != nil {
+		return
+	}
+
+	content, err := this.server.GetConfigDatastoreContent(this.datastoreName)
+	if err != nil {
+		return
+	}
+
+	keys := content.Keys()
+	sort.Strings(keys)
+
+	entries := []JsonEntry{}
+
+	// Replace each access key hash binding with a binding of the upgraded hash to the same profile
+	for _, key := range keys {
+		keySubmatches := accessKeyHashConfigKeyRegexp.FindStringSubmatch(key)
+
+		if len(keySubmatches) == 0 {
+			continue
+		}
+
+		upgradedHash, upgraded, err := accessKeyHasher.Upgrade(keySubmatches[1])
+		if err != nil {
+			return 0, err
+		}
+
+		if !upgraded {
+			continue
+		}
+
+		profileName, _ := content.GetString(key)
+		serializedProfileName, _ := json.Marshal(profileName)
+		serializedKey, _ := json.Marshal(key)
+		serializedUpgradedKey, _ := json.Marshal("['datastore']['accessKeyHash']['" + upgradedHash + "']")
+
+		entries = append(entries, JsonEntry{string(serializedKey), ""})
+		entries = append(entries, JsonEntry{string(serializedUpgradedKey), string(serializedProfileName)})
+		migratedCount++
+	}
+
+	if this.datastoreName == DatastoreOwnershipDatastoreName {
+		// Upgrade the access key hashes recorded as datastore owners, such that the datastores would remain
+		// owned by the same keys. Owners that aren't access key hashes (e.g. token subjects) are left as is.
+		for _, key := range keys {
+			if !datastoreOwnerConfigKeyRegexp.MatchString(key) {
+				continue
+			}
+
+			owner, _ := content.GetString(key)
+
+			if _, _, err := ParseAccessKeyHash(owner); err != nil {
+				continue
+			}
+
+			upgradedHash, upgraded, err := accessKeyHasher.Upgrade(owner)
+			if err != nil {
+				return 0, err
+			}
+
+			if !upgraded {
+				continue
+			}
+
+			serializedKey, _ := json.Marshal(key)
+			serializedUpgradedHash, _ := json.Marshal(upgradedHash)
+
+			entries = append(entries, JsonEntry{string(serializedKey), string(serializedUpgradedHash)})
+			migratedCount++
+		}
+	}
+
+	if this.datastoreName == ".config" {
+		// Upgrade the master key hash, if set
+		masterKeyHash, _ := content.GetString("['server']['masterKeyHash']")
+
+		if masterKeyHash != "" {
+			upgradedHash, upgraded, err := accessKeyHasher.Upgrade(masterKeyHash)
+			if err != nil {
+				return 0, err
+			}
+
+			if upgraded {
+				entries = append(entries, JsonEntry{`"['server']['masterKeyHash']"`, `"` + upgradedHash + `"`})
+				migratedCount++
+			}
+		}
+
+		// Set the scheme for newly created hashes
+		scheme, _ := content.GetString("['server']['accessKeyHashScheme']")
+
+		if scheme != AccessKeyHashScheme_HMACSHA256 {
+			entries = append(entries, JsonEntry{`"['server']['accessKeyHashScheme']"`, `"` + AccessKeyHashScheme_HMACSHA256 + `"`})
+		}
+	}
+
+	if len(entries) == 0 {
+		return
+	}
+
+	err = this.commitEntries(entries)
+
+	return
+}
+
+// Releases any open datastore files
+func (this *ConfigEditor) Close() {
+	for _, datastore := range this.server.datastores {
+		datastore.Close()
+	}
+}
+
+// Writes a single key and value as a transaction. An empty value deletes the key.
+func (this *ConfigEditor) commit(key string, jsonValue string) error {
+	serializedKey, _ := json.Marshal(key)
+
+	return this.commitEntries([]JsonEntry{
+		JsonEntry{string(serializedKey), jsonValue},
+	})
+}
+
+// Writes the given entries as a single transaction
+func (this *ConfigEditor) commitEntries(entries []JsonEntry) error {
+	// The global configuration datastore is never created here, since it would lack the default
+	// configuration the server creates on its first start
+	if this.datastoreName == ".config" {
+		_, err := this.server.GetDatastoreOperations(".config").LoadIfNeeded(false)
+
+		if _, ok := err.(*os.PathError); ok {
+			return errors.New("The global configuration datastore doesn't exist. Please start the server once to create a default one.")
+		} else if err != nil {
+			return err
+		}
+	}
+
+	_, err := this.server.UpdateConfigDatastore(this.datastoreName, entries)
+
+	return err
+}
+
+// Ensures the given access profile is defined either in the target or global configuration datastore
+func (this *ConfigEditor) verifyProfileExists(profileName string) error {
+	if profileName == "" {
+		return errors.New("No access profile name given.")
+	}
+
+	profileExists, err := this.server.AccessProfileExists(this.datastoreName, profileName)
+	if err != nil {
+		return err
+	}
+
+	if !profileExists {
+		return errors.New(fmt.Sprintf("The access profile '%s' isn't defined in either '%s' or the global configuration.", profileName, this.datastoreName))
+	}
+
+	return nil
+}
+
+// Converts a configuration key path to the bracketed form used internally, e.g. "['datastore']['flush']['enabled']".
+// Both the bracketed form and a JSON array of identifiers (e.g. '["datastore","flush","enabled"]') are accepted.
+func ParseConfigKeyPath(keyPath string) (string, error) {
+	keyPath = strings.TrimSpace(keyPath)
+
+	if strings.HasPrefix(keyPath, `["`) {
+		var identifiers []string
+
+		err := json.Unmarshal([]byte(keyPath), &identifiers)
+		if err != nil {
+			return "", errors.New("Invalid key path '" + keyPath + "': " + err.Error())
+		}
+
+		keyPath = ""
+		for _, identifier := range identifiers {
+			keyPath += "['" + identifier + "']"
+		}
+	}
+
+	if !configKeyPathRegexp.MatchString(keyPath) {
+		return "", errors.New("Invalid key path '" + keyPath + "'. Should be of the form ['<identifier>']['<identifier>'].. or [\"<identifier>\",\"<identifier>\",..]")
+	}
+
+	return keyPath, nil
+}
+
+// Migrates the access key hashes of all the configuration datastores in the given storage directory to the
+// keyed HMAC-SHA256 scheme, including the hashes recorded as datastore owners. The global configuration
+// datastore is migrated last, so the scheme used for new hashes would only be changed once all existing
+// hashes have been rewritten. Returns the total number of hashes rewritten.
+func MigrateAllAccessKeyHashes(storagePath string) (migratedCount int, err error) {
+	globalConfigExists, err := FileExists(filepath.Join(storagePath, ".config"))
+	if err != nil {
+		return
+	} else if !globalConfigExists {
+		return 0, errors.New("The global configuration datastore doesn't exist. Please start the server once to create a default one.")
+	}
+
+	fileInfos, err := ioutil.ReadDir(storagePath)
+	if err != nil {
+		return
+	}
+
+	configDatastoreNames := []string{}
+
+	for _, fileInfo := range fileInfos {
+		fileName := fileInfo.Name()
+
+		if fileInfo.IsDir() || fileName == ".config" || !IsConfigDatastoreName(fileName) || !datastorePathRegexp.MatchString("/datastore/"+fileName) {
+			continue
+		}
+
+		configDatastoreNames = append(configDatastoreNames, fileName)
+	}
+
+	ownershipDatastoreExists, err := FileExists(filepath.Join(storagePath, DatastoreOwnershipDatastoreName))
+	if err != nil {
+		return
+	} else if ownershipDatastoreExists {
+		configDatastoreNames = append(configDatastoreNames, DatastoreOwnershipDatastoreName)
+	}
+
+	configDatastoreNames = append(configDatastoreNames, ".config")
+
+	for _, configDatastoreName := range configDatastoreNames {
+		editor, err := NewConfigEditor(storagePath, configDatastoreName)
+		if err != nil {
+			return migratedCount, err
+		}
+
+		datastoreMigratedCount, err := editor.MigrateAccessKeyHashes()
+		editor.Close()
+
+		if err != nil {
+			return migratedCount, errors.New("Failed migrating '" + configDatastoreName + "': " + err.Error())
+		}
+
+		migratedCount += datastoreMigratedCount
+	}
+
+	return
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"os"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ConfigEditor", func() {
+	const storagePath = "./tests_temp"
+
+	It("Parses key paths given in either bracketed or JSON array form", func() {
+		key, err := ParseConfigKeyPath(`['datastore']['flush']['enabled']`)
+		Expect(err).To(BeNil())
+		Expect(key).To(Equal(`['datastore']['flush']['enabled']`))
+
+		key, err = ParseConfigKeyPath(`["datastore","flush","enabled"]`)
+		Expect(err).To(BeNil())
+		Expect(key).To(Equal(`['datastore']['flush']['enabled']`))
+
+		_, err = ParseConfigKeyPath(`datastore.flush.enabled`)
+		Expect(err).NotTo(BeNil())
+	})
+
+	It("Sets, gets and deletes keys in a dedicated configuration datastore", func() {
+		datastoreName := RandomWordString(12)
+		defer os.Remove(storagePath + "/" + datastoreName + ".config")
+
+		editor, err := NewConfigEditor(storagePath, datastoreName)
+		Expect(err).To(BeNil())
+		Expect(editor.DatastoreName()).To(Equal(datastoreName + ".config"))
+
+		Expect(editor.Set(`['datastore']['limit']['maxSize']`, `3000`)).To(BeNil())
+		Expect(editor.Set(`['datastore']['flush']['enabled']`, `false`)).To(BeNil())
+		Expect(editor.Set(`['datastore']['schema']['Age']['definition']`, `{"type": "integer", "minimum": 0}`)).To(BeNil())
+		Expect(editor.Set(`['datastore']['flush']['maxDelay']`, `null`)).NotTo(BeNil())
+		Expect(editor.Set(`['datastore']['flush']['maxDelay']`, `Reader`)).NotTo(BeNil())
+		editor.Close()
+
+		// Reopen to ensure the changes were persisted
+		editor, err = NewConfigEditor(storagePath, datastoreName)
+		Expect(err).To(BeNil())
+		defer editor.Close()
+
+		value, err := editor.Get(`["datastore","schema","Age","definition"]`)
+		Expect(err).To(BeNil())
+		Expect(value).To(Equal(`{"minimum":0,"type":"integer"}`))
+
+		value, err = editor.Get(`["datastore","limit","maxSize"]`)
+		Expect(err).To(BeNil())
+		Expect(value).To(Equal(`3000`))
+
+		keys, values, err := editor.List()
+		Expect(err).To(BeNil())
+		Expect(keys).To(Equal([]string{`['datastore']['flush']['enabled']`, `['datastore']['limit']['maxSize']`, `['datastore']['schema']['Age']['definition']`}))
+		Expect(values[`['datastore']['flush']['enabled']`]).To(Equal(`false`))
+
+		Expect(editor.Delete(`['datastore']['limit']['maxSize']`)).To(BeNil())
+		Expect(editor.Delete(`['datastore']['limit']['maxSize']`)).To(Equal(ErrNotFound))
+
+		_, err = editor.Get(`['datastore']['limit']['maxSize']`)
+		Expect(err).To(Equal(ErrNotFound))
+	})
+
+	It("Adds access keys associated with an existing profile", func() {
+		datastoreName := RandomWordString(12)
+		defer os.Remove(storagePath + "/" + datastoreName + ".config")
+
+		editor, err := NewConfigEditor(storagePath, datastoreName)
+		Expect(err).To(BeNil())
+		defer editor.Close()
+
+		_, _, err = editor.AddAccessKey("NonexistingProfile")
+		Expect(err).NotTo(BeNil())
+
+		Expect(editor.Set(`['accessProfile']['Custom']['method']['GET']['allowed']`, `true`)).To(BeNil())
+
+		accessKey, accessKeyHash, err := editor.AddAccessKey("Custom")
+		Expect(err).To(BeNil())
+		Expect(accessKey).To(HaveLen(32))
+		expectedAccessKeyHash, err := editor.server.HashAccessKey(accessKey)
+		Expect(err).To(BeNil())
+		Expect(accessKeyHash).To(Equal(expectedAccessKeyHash))
+
+		value, err := editor.Get(`['datastore']['accessKeyHash']['` + accessKeyHash + `']`)
+		Expect(err).To(BeNil())
+		Expect(value).To(Equal(`"Custom"`))
+
+		Expect(editor.SetAccessKeyProfile("abc", "Custom")).NotTo(BeNil())
+	})
+
+	It("Rotates the master key only in the global configuration datastore", func() {
+		datastoreName := RandomWordString(12)
+		defer os.Remove(storagePath + "/" + datastoreName + ".config")
+		defer os.Remove(storagePath + "/.config")
+
+		editor, err := NewConfigEditor(storagePath, datastoreName)
+		Expect(err).To(BeNil())
+
+		_, _, err = editor.RotateMasterKey()
+		Expect(err).NotTo(BeNil())
+		editor.Close()
+
+		// Rotating fails if no global configuration datastore has been created yet
+		editor, err = NewConfigEditor(storagePath, "")
+		Expect(err).To(BeNil())
+
+		_, _, err = editor.RotateMasterKey()
+		Expect(err).NotTo(BeNil())
+		editor.Close()
+
+		defaultConfig := DefaultServerConfig("")
+		Expect(ValidateAndPrepareTransaction(defaultConfig, MonoUnixTimeMicro(), 0)).To(BeNil())
+		Expect(CreateOrRewriteFileSafe(storagePath+"/.config", CreateNewDatastoreReaderFromBytes(defaultConfig, 0))).To(BeNil())
+
+		editor, err = NewConfigEditor(storagePath, "")
+		Expect(err).To(BeNil())
+		defer editor.Close()
+
+		_, masterKeyHash, err := editor.RotateMasterKey()
+		Expect(err).To(BeNil())
+
+		value, err := editor.Get(`['server']['masterKeyHash']`)
+		Expect(err).To(BeNil())
+		Expect(value).To(Equal(`"` + masterKeyHash + `"`))
+	})
+
+	It("Migrates legacy SHA1 access key hashes in all configuration datastores", func() {
+		datastoreName := RandomWordString(12)
+		defer os.Remove(storagePath + "/" + datastoreName + ".config")
+		defer os.Remove(storagePath + "/.config")
+
+		accessKey := GenerateRandomAccessKey()
+		legacyAccessKeyHash := SHA1ToHex([]byte(accessKey))
+		masterKey := GenerateRandomAccessKey()
+
+		// Create a global configuration datastore in the legacy format, with no hash scheme setting
+		legacyConfig := SerializeJsonEntries([]JsonEntry{
+			JsonEntry{`"['server']['masterKeyHash']"`, `"` + SHA1ToHex([]byte(masterKey)) + `"`},
+			JsonEntry{`"['accessProfile']['Custom']['method']['GET']['allowed']"`, `true`},
+		})
+		Expect(ValidateAndPrepareTransaction(legacyConfig, MonoUnixTimeMicro(), 0)).To(BeNil())
+		Expect(CreateOrRewriteFileSafe(storagePath+"/.config", CreateNewDatastoreReaderFromBytes(legacyConfig, 0))).To(BeNil())
+
+		editor, err := NewConfigEditor(storagePath, datastoreName)
+		Expect(err).To(BeNil())
+		Expect(editor.SetAccessKeyProfile(legacyAccessKeyHash, "Custom")).To(BeNil())
+		editor.Close()
+
+		migratedCount, err := MigrateAllAccessKeyHashes(storagePath)
+		Expect(err).To(BeNil())
+		Expect(migratedCount).To(BeNumerically(">=", 2))
+
+		globalEditor, err := NewConfigEditor(storagePath, "")
+		Expect(err).To(BeNil())
+		defer globalEditor.Close()
+
+		scheme, err := globalEditor.Get(`['server']['accessKeyHashScheme']`)
+		Expect(err).To(BeNil())
+		Expect(scheme).To(Equal(`"hmac-sha256"`))
+
+		upgradedAccessKeyHash, err := globalEditor.server.HashAccessKey(accessKey)
+		Expect(err).To(BeNil())
+		upgradedMasterKeyHash, err := globalEditor.server.HashAccessKey(masterKey)
+		Expect(err).To(BeNil())
+
+		value, err := globalEditor.Get(`['server']['masterKeyHash']`)
+		Expect(err).To(BeNil())
+		Expect(value).To(Equal(`"` + upgradedMasterKeyHash + `"`))
+
+		editor, err = NewConfigEditor(storagePath, datastoreName)
+		Expect(err).To(BeNil())
+		defer editor.Close()
+
+		_, err = editor.Get(`['datastore']['accessKeyHash']['` + legacyAccessKeyHash + `']`)
+		Expect(err).To(Equal(ErrNotFound))
+
+		value, err = editor.Get(`['datastore']['accessKeyHash']['` + upgradedAccessKeyHash + `']`)
+		Expect(err).To(BeNil())
+		Expect(value).To(Equal(`"Custom"`))
+	})
+
+	It("Migrates legacy SHA1 access key hashes recorded as datastore owners", func() {
+		defer os.Remove(storagePath + "/" + DatastoreOwnershipDatastoreName)
+		defer os.Remove(storagePath + "/.config")
+
+		accessKey := GenerateRandomAccessKey()
+		legacyAccessKeyHash := SHA1ToHex([]byte(accessKey))
+		ownedDatastoreName := RandomWordString(12)
+		tokenOwnedDatastoreName := RandomWordString(12)
+
+		// Create a global configuration datastore in the legacy format, with no hash scheme setting
+		legacyConfig := SerializeJsonEntries([]JsonEntry{
+			JsonEntry{`"['accessProfile']['Custom']['method']['GET']['allowed']"`, `true`},
+		})
+		Expect(ValidateAndPrepareTransaction(legacyConfig, MonoUnixTimeMicro(), 0)).To(BeNil())
+		Expect(CreateOrRewriteFileSafe(storagePath+"/.config", CreateNewDatastoreReaderFromBytes(legacyConfig, 0))).To(BeNil())
+
+		// Record the owners of two datastores: one owned by the access key, and one by a token subject
+		editor, err := NewConfigEditor(storagePath, DatastoreOwnershipDatastoreName)
+		Expect(err).To(BeNil())
+		Expect(editor.Set(`['datastore']['`+ownedDatastoreName+`']['owner']`, `"`+legacyAccessKeyHash+`"`)).To(BeNil())
+		Expect(editor.Set(`['datastore']['`+tokenOwnedDatastoreName+`']['owner']`, `"token:alice"`)).To(BeNil())
+		editor.Close()
+
+		migratedCount, err := MigrateAllAccessKeyHashes(storagePath)
+		Expect(err).To(BeNil())
+		Expect(migratedCount).To(Equal(1))
+
+		editor, err = NewConfigEditor(storagePath, DatastoreOwnershipDatastoreName)
+		Expect(err).To(BeNil())
+		defer editor.Close()
+
+		// Ensure the datastore is still owned by the same access key, now identified by its upgraded hash
+		upgradedAccessKeyHash, err := editor.server.HashAccessKey(accessKey)
+		Expect(err).To(BeNil())
+		Expect(upgradedAccessKeyHash).NotTo(Equal(legacyAccessKeyHash))
+
+		owner, err := editor.server.GetDatastoreOwner(ownedDatastoreName)
+		Expect(err).To(BeNil())
+		Expect(owner).To(Equal(upgradedAccessKeyHash))
+
+		owner, err = editor.server.GetDatastoreOwner(tokenOwnedDatastoreName)
+		Expect(err).To(BeNil())
+		Expect(owner).To(Equal("token:alice"))
+	})
+})
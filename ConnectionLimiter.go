@@ -0,0 +1,74 @@
+package main
+
+import (
+	"sync"
+)
+
+// Connection limiter definition. Tracks the number of concurrently open long-lived connections
+// (e.g. WebSockets) for each client.
+type ConnectionLimiter struct {
+	// A lookup table taking a client id and giving its current number of open connections
+	connectionCounts map[string]int64
+
+	// Make this lockable
+	sync.Mutex
+}
+
+// Create a new connection limiter object
+func NewConnectionLimiter() *ConnectionLimiter {
+	return &ConnectionLimiter{
+		connectionCounts: map[string]int64{},
+	}
+}
+
+// Try to acquire a connection slot for the given client. If the client already has the given maximum
+// number of open connections, returns false. Otherwise, returns a function that releases the slot. The
+// release function may safely be called more than once, but would only release the slot the first time.
+func (this *ConnectionLimiter) TryAcquire(clientID string, maxConnections int64) (release func(), acquired bool) {
+	// Lock this object
+	this.Lock()
+
+	// Unlock when the function returns
+	defer this.Unlock()
+
+	// If the client has reached the limit, return false
+	if this.connectionCounts[clientID] >= maxConnections {
+		return nil, false
+	}
+
+	// Increment the client's connection count
+	this.connectionCounts[clientID] += 1
+
+	// Create a release function that is only effective once
+	releaseOnce := &sync.Once{}
+
+	release = func() {
+		releaseOnce.Do(func() {
+			this.release(clientID)
+		})
+	}
+
+	return release, true
+}
+
+// Get the number of connections currently open for the given client
+func (this *ConnectionLimiter) Count(clientID string) int64 {
+	this.Lock()
+	defer this.Unlock()
+
+	return this.connectionCounts[clientID]
+}
+
+// Release a connection slot for the given client
+func (this *ConnectionLimiter) release(clientID string) {
+	this.Lock()
+	defer this.Unlock()
+
+	// Decrement the connection count
+	this.connectionCounts[clientID] -= 1
+
+	// Remove the entry once the client has no open connections, so the table doesn't grow without bound
+	if this.connectionCounts[clientID] <= 0 {
+		delete(this.connectionCounts, clientID)
+	}
+}
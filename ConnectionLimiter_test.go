@@ -0,0 +1,41 @@
+package main
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ConnectionLimiter", func() {
+	It("Limits the number of parallel connections for a client", func() {
+		connectionLimiter := NewConnectionLimiter()
+
+		release1, acquired := connectionLimiter.TryAcquire("SomeUser", 2)
+		Expect(acquired).To(BeTrue())
+		_, acquired = connectionLimiter.TryAcquire("SomeUser", 2)
+		Expect(acquired).To(BeTrue())
+		_, acquired = connectionLimiter.TryAcquire("SomeUser", 2)
+		Expect(acquired).To(BeFalse())
+
+		_, acquired = connectionLimiter.TryAcquire("SomeOtherUser", 2)
+		Expect(acquired).To(BeTrue())
+
+		// Releasing more than once should only free a single slot
+		release1()
+		release1()
+		Expect(connectionLimiter.Count("SomeUser")).To(Equal(int64(1)))
+
+		_, acquired = connectionLimiter.TryAcquire("SomeUser", 2)
+		Expect(acquired).To(BeTrue())
+		_, acquired = connectionLimiter.TryAcquire("SomeUser", 2)
+		Expect(acquired).To(BeFalse())
+	})
+
+	It("Removes entries for clients without open connections", func() {
+		connectionLimiter := NewConnectionLimiter()
+
+		release, _ := connectionLimiter.TryAcquire("SomeUser", 1)
+		release()
+
+		Expect(connectionLimiter.connectionCounts).To(BeEmpty())
+	})
+})
@@ -70,6 +70,16 @@ func (this *DatastoreConfigSnapshot) GetBool(key string) (value bool, err error)
 	return this.GlobalConfig.GetBool(key)
 }
 
+// Gets a boolean typed configuration value from the global configuration only
+func (this *DatastoreConfigSnapshot) GetBool_GlobalOnly(key string) (value bool, err error) {
+	if this.GlobalConfig == nil {
+		return
+	}
+
+	// Otherwise, look up the key in the global configuration and return its value if found
+	return this.GlobalConfig.GetBool(key)
+}
+
 // Gets a 64-bit integer typed configuration value.
 func (this *DatastoreConfigSnapshot) GetInt64(key string) (value int64, err error) {
 	// If a datastore-specific configuration is available
@@ -92,6 +102,16 @@ func (this *DatastoreConfigSnapshot) GetInt64(key string) (value int64, err erro
 	return this.GlobalConfig.GetInt64(key)
 }
 
+// Gets a 64-bit integer typed configuration value from the global configuration only
+func (this *DatastoreConfigSnapshot) GetInt64_GlobalOnly(key string) (value int64, err error) {
+	if this.GlobalConfig == nil {
+		return
+	}
+
+	// Otherwise, look up the key in the global configuration and return its value if found
+	return this.GlobalConfig.GetInt64(key)
+}
+
 // Gets a 64-bit float typed configuration value.
 func (this *DatastoreConfigSnapshot) GetFloat64(key string) (value float64, err error) {
 	// If a datastore-specific configuration is available
@@ -113,3 +133,23 @@ func (this *DatastoreConfigSnapshot) GetFloat64(key string) (value float64, err
 	// Otherwise, look up the key in the global configuration and return its value if found
 	return this.GlobalConfig.GetFloat64(key)
 }
+
+// Finds all keys starting with the given prefix, in either the datastore-specific or global configuration.
+func (this *DatastoreConfigSnapshot) FindKeysStartingWith(prefix string) (results []string) {
+	foundKeys := map[string]bool{}
+
+	for _, config := range []*VarMap{this.DedicatedConfig, this.GlobalConfig} {
+		if config == nil {
+			continue
+		}
+
+		for _, key := range config.FindKeysStartingWith(prefix) {
+			if !foundKeys[key] {
+				foundKeys[key] = true
+				results = append(results, key)
+			}
+		}
+	}
+
+	return
+}
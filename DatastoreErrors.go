@@ -34,3 +34,19 @@ var ErrCorruptedEntry = errors.New("Invalid entry checksum detected. This may be
 var ErrInvalidHeadEntry = errors.New("Invalid head entry detected.")
 var ErrEmptyTransaction = errors.New("An empty transaction bytestream was given.")
 
+
+type ErrEntryKeyNotPermitted struct {
+	message string
+}
+
+func (this ErrEntryKeyNotPermitted) Error() string {
+	return this.message
+}
+
+type ErrStorageQuotaExceeded struct {
+	message string
+}
+
+func (this ErrStorageQuotaExceeded) Error() string {
+	return this.message
+}
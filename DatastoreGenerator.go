@@ -1,14 +1,125 @@
 package main
 
 import (
+	"errors"
+	"math/rand"
+	"time"
 )
 
+// The options of a generated datastore
+type DatastoreGeneratorOptions struct {
+	// The number of entries to generate. Ignored if a target size is given.
+	EntryCount int
+	// The maximum key and value sizes
+	KeySize   int
+	ValueSize int
+	// The type of entries generated, as accepted by 'GenerateRandomEntry'
+	EntryType string
+	// The number of transactions the entries are divided into. If a target size is given, this sets the
+	// number of entries per transaction (as 'EntryCount / TransactionCount') rather than the number of
+	// transactions.
+	TransactionCount int
+	// The time span the commit timestamps of the transactions are spread across, ending at the current time
+	HistoryDuration time.Duration
+	// The fraction of entries (0..1) that overwrite a previously written key
+	OverwriteRatio float64
+	// The fraction of entries (0..1) that delete a previously written key
+	DeletionRatio float64
+	// The distribution previously written keys are selected from for overwrites and deletions. Either
+	// 'uniform' or 'zipf' (earlier keys are selected far more often than later ones).
+	KeyDistribution string
+	// If greater than 0, entries are generated until the size of the datastore reaches this size (bytes)
+	TargetSize int64
+	// Append an incomplete transaction to the end of the datastore, as if a write was interrupted, so
+	// the datastore would need to be repaired when loaded
+	CorruptTail bool
+}
+
+func DefaultDatastoreGeneratorOptions() *DatastoreGeneratorOptions {
+	return &DatastoreGeneratorOptions{
+		EntryCount:       100,
+		KeySize:          20,
+		ValueSize:        100,
+		EntryType:        "randomPathEntry",
+		TransactionCount: 1,
+		HistoryDuration:  0,
+		OverwriteRatio:   0,
+		DeletionRatio:    0,
+		KeyDistribution:  "uniform",
+		TargetSize:       0,
+		CorruptTail:      false,
+	}
+}
+
+// Verifies the options are valid
+func (this *DatastoreGeneratorOptions) Validate() (err error) {
+	// Initialize the minimal allowed timestamp to 01/01/2017
+	const minAllowedTimestamp int64 = 1483221600 * 1000000
+
+	if this.EntryCount < 1 && this.TargetSize <= 0 {
+		return errors.New("Entry count must be at least 1.")
+	}
+
+	if this.KeySize < 2 || this.ValueSize < 1 {
+		return errors.New("Key size must be at least 2 and value size must be at least 1.")
+	}
+
+	if this.TransactionCount < 1 {
+		return errors.New("Transaction count must be at least 1.")
+	}
+
+	if this.HistoryDuration < 0 || MonoUnixTimeMicro()-int64(this.HistoryDuration/time.Microsecond) <= minAllowedTimestamp {
+		return errors.New("History duration must be positive, and must not start before January 1st 2017.")
+	}
+
+	if this.OverwriteRatio < 0 || this.DeletionRatio < 0 || this.OverwriteRatio+this.DeletionRatio > 1 {
+		return errors.New("Overwrite and deletion ratios must be positive, and must not sum to more than 1.")
+	}
+
+	if this.KeyDistribution != "uniform" && this.KeyDistribution != "zipf" {
+		return errors.New("Key distribution must be either 'uniform' or 'zipf'.")
+	}
+
+	// Ensure the entry type is a valid one ('GenerateRandomEntry' panics for an invalid one)
+	defer func() {
+		if recover() != nil {
+			err = errors.New("Invalid entry type '" + this.EntryType + "'.")
+		}
+	}()
+
+	GenerateRandomEntry(this.KeySize, this.ValueSize, this.EntryType)
+
+	return nil
+}
+
 func GenerateRandomDatastore(filePath string, entryCount int, keySize int, valueSize int, entryType string) (err error) {
+	options := DefaultDatastoreGeneratorOptions()
+	options.EntryCount = entryCount
+	options.KeySize = keySize
+	options.ValueSize = valueSize
+	options.EntryType = entryType
+
+	return GenerateDatastore(filePath, options)
+}
+
+// Generates a datastore file containing a history of transactions, as described by the given options
+func GenerateDatastore(filePath string, options *DatastoreGeneratorOptions) (err error) {
 	startTime := MonoUnixTimeMilliFloat()
-	creationTimestamp := MonoUnixTimeMicro()
 
-	entryStreamBuffer := GenerateRandomEntryStreamBytes(entryCount, keySize, valueSize, entryType)
-	ValidateAndPrepareTransaction(entryStreamBuffer, -1, -1)
+	err = options.Validate()
+	if err != nil {
+		Logf("Invalid options: %s\n", err.Error())
+		return
+	}
+
+	// The datastore is created at the start of the history, and every transaction is committed after it
+	creationTimestamp := MonoUnixTimeMicro() - int64(options.HistoryDuration/time.Microsecond)
+
+	entryStreamBuffer, err := GenerateRandomTransactionHistoryBytes(options, creationTimestamp)
+	if err != nil {
+		Logf("Failed generating '%s': %s\n", filePath, err.Error())
+		return
+	}
 
 	err = CreateOrRewriteFileSafe(filePath, CreateNewDatastoreReaderFromBytes(entryStreamBuffer, creationTimestamp))
 	if err != nil {
@@ -20,8 +131,129 @@ func GenerateRandomDatastore(filePath string, entryCount int, keySize int, value
 	return
 }
 
+// Generates a serialized stream of transactions, as described by the given options, having commit
+// timestamps evenly spread between the given start time and the current time
+func GenerateRandomTransactionHistoryBytes(options *DatastoreGeneratorOptions, startTimestamp int64) ([]byte, error) {
+	// Generate the entries, and divide them into transactions
+	entries := generateRandomEntryHistory(options)
+
+	entriesPerTransaction := (options.EntryCount + options.TransactionCount - 1) / options.TransactionCount
+	if entriesPerTransaction < 1 {
+		entriesPerTransaction = 1
+	}
+
+	transactionCount := (len(entries) + entriesPerTransaction - 1) / entriesPerTransaction
+	endTimestamp := MonoUnixTimeMicro()
+
+	serializedTransactions := [][]byte{}
+	commitTimestamp := startTimestamp
+
+	for i := 0; i < transactionCount; i++ {
+		transactionEntries := entries[i*entriesPerTransaction : MinInt((i+1)*entriesPerTransaction, len(entries))]
+
+		// Spread the commit timestamps evenly, while ensuring each one is greater than the previous one
+		commitTimestamp = MaxInt64(commitTimestamp+1, startTimestamp+(endTimestamp-startTimestamp)*int64(i+1)/int64(transactionCount))
+
+		for _, entry := range transactionEntries {
+			entry.Header.UpdateTime = commitTimestamp
+			entry.Header.Flags = 0
+		}
+
+		// Serialize the transaction, add a transaction end flag to its last entry, and add checksums
+		serializedTransaction := SerializeEntries(transactionEntries)
+
+		err := ValidateAndPrepareTransaction(serializedTransaction, commitTimestamp, -1)
+		if err != nil {
+			return nil, err
+		}
+
+		serializedTransactions = append(serializedTransactions, serializedTransaction)
+	}
+
+	// Append an incomplete transaction if requested: a transaction cut off at a random offset, such that
+	// its last entry is either truncated or lacking a transaction end flag
+	if options.CorruptTail {
+		tailEntries := GenerateRandomEntries(RandomIntInRange(2, 5), options.KeySize, options.ValueSize, options.EntryType)
+
+		for i := range tailEntries {
+			tailEntries[i].Header.Flags = 0
+		}
+
+		serializedTail := SerializeEntries(tailEntries)
+
+		err := ValidateAndPrepareTransaction(serializedTail, endTimestamp+1, -1)
+		if err != nil {
+			return nil, err
+		}
+
+		serializedTransactions = append(serializedTransactions, serializedTail[:RandomIntInRange(1, len(serializedTail))])
+	}
+
+	return ConcatSliceList(serializedTransactions), nil
+}
+
+// Generates a sequence of entries, where a part of them overwrite or delete keys written by earlier
+// entries
+func generateRandomEntryHistory(options *DatastoreGeneratorOptions) []Entry {
+	entries := []Entry{}
+	totalSize := int64(0)
+
+	// The keys written so far, in the order they were first written, and a lookup table of them
+	writtenKeys := [][]byte{}
+	writtenKeyLookup := map[string]bool{}
+
+	// Selects a previously written key, according to the key distribution
+	selectWrittenKey := func() []byte {
+		if options.KeyDistribution == "zipf" && len(writtenKeys) > 1 {
+			return writtenKeys[rand.NewZipf(globalRand, 1.1, 1, uint64(len(writtenKeys)-1)).Uint64()]
+		}
+
+		return writtenKeys[rand.Intn(len(writtenKeys))]
+	}
+
+	for {
+		// Stop once the target size, or, if not given, the entry count has been reached
+		if options.TargetSize > 0 {
+			if int64(HeaderSize)+totalSize >= options.TargetSize {
+				break
+			}
+		} else if len(entries) >= options.EntryCount {
+			break
+		}
+
+		entry := GenerateRandomEntry(RandomIntInRange(2, options.KeySize+1), RandomIntInRange(1, options.ValueSize+1), options.EntryType)
+
+		draw := rand.Float64()
+
+		if len(writtenKeys) > 0 && draw < options.DeletionRatio {
+			// Delete a previously written key, by writing an empty value to it
+			entry.Key = selectWrittenKey()
+			entry.Value = []byte{}
+		} else if len(writtenKeys) > 0 && draw < options.DeletionRatio+options.OverwriteRatio {
+			// Overwrite a previously written key
+			entry.Key = selectWrittenKey()
+		} else {
+			// Otherwise, the entry writes a new key. Short random keys may collide with previously written
+			// ones, so retry a limited number of times to get an unused one.
+			for attempt := 0; attempt < 10 && writtenKeyLookup[string(entry.Key)]; attempt++ {
+				entry.Key = GenerateRandomEntry(RandomIntInRange(2, options.KeySize+1), 0, options.EntryType).Key
+			}
+
+			if !writtenKeyLookup[string(entry.Key)] {
+				writtenKeys = append(writtenKeys, entry.Key)
+				writtenKeyLookup[string(entry.Key)] = true
+			}
+		}
+
+		entries = append(entries, *entry)
+		totalSize += int64(HeaderSize + len(entry.Key) + len(entry.Value))
+	}
+
+	return entries
+}
+
 func GenerateRandomEntryStreamBytes(entryCount int, keySize int, valueSize int, entryType string) []byte {
-	return SerializeEntries(GenerateRandomEntries(entryCount,keySize,valueSize, entryType))
+	return SerializeEntries(GenerateRandomEntries(entryCount, keySize, valueSize, entryType))
 }
 
 func GenerateRandomEntries(entryCount int, maxKeySize int, maxValueSize int, entryType string) []Entry {
@@ -43,7 +275,7 @@ func GenerateRandomEntry(keySize int, valueSize int, entryType string) *Entry {
 	case "randomUTF8Entry":
 		return getRandomUtf8Entry(keySize, valueSize)
 	case "randomBinaryEntry":
-		return  getRandomBinaryEntry(keySize, valueSize)
+		return getRandomBinaryEntry(keySize, valueSize)
 	case "randomAlphanumericEntry":
 		return getRandomAlphanumericEntry(keySize, valueSize)
 	case "randomJSONEntry":
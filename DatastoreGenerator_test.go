@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("DatastoreGenerator", func() {
+	readTransactionHistory := func(entryStream []byte) (entries []*EntryStreamIteratorResult, keys []string) {
+		next := NewEntryStreamIterator(bytes.NewReader(entryStream), 0, int64(len(entryStream)))
+
+		for {
+			iteratorResult, err := next()
+			Expect(err).To(BeNil())
+
+			if iteratorResult == nil {
+				return
+			}
+
+			Expect(iteratorResult.VerifyAllChecksums()).To(BeNil())
+
+			key, err := iteratorResult.ReadKey()
+			Expect(err).To(BeNil())
+
+			entries = append(entries, iteratorResult)
+			keys = append(keys, string(key))
+		}
+	}
+
+	It("Divides the entries into transactions with increasing commit timestamps spread across the history", func() {
+		options := DefaultDatastoreGeneratorOptions()
+		options.EntryCount = 100
+		options.TransactionCount = 10
+		options.HistoryDuration = 24 * time.Hour
+
+		startTimestamp := MonoUnixTimeMicro() - int64(options.HistoryDuration/time.Microsecond)
+
+		entryStream, err := GenerateRandomTransactionHistoryBytes(options, startTimestamp)
+		Expect(err).To(BeNil())
+
+		entries, _ := readTransactionHistory(entryStream)
+		Expect(entries).To(HaveLen(100))
+
+		previousCommitTimestamp := startTimestamp
+		transactionCount := 0
+
+		for i, entry := range entries {
+			Expect(entry.CommitTime()).To(Equal(entry.UpdateTime()))
+			Expect(entry.HasTransactionEndFlag()).To(Equal(i%10 == 9))
+
+			if entry.HasTransactionEndFlag() {
+				Expect(entry.CommitTime()).To(BeNumerically(">", previousCommitTimestamp))
+				previousCommitTimestamp = entry.CommitTime()
+				transactionCount++
+			} else {
+				Expect(entries[i+1].CommitTime()).To(Equal(entry.CommitTime()))
+			}
+		}
+
+		Expect(transactionCount).To(Equal(10))
+		Expect(entries[0].CommitTime()).To(BeNumerically("<", startTimestamp+int64(3*time.Hour/time.Microsecond)))
+		Expect(entries[99].CommitTime()).To(BeNumerically(">", MonoUnixTimeMicro()-int64(time.Hour/time.Microsecond)))
+	})
+
+	It("Overwrites and deletes previously written keys according to the given ratios", func() {
+		for _, keyDistribution := range []string{"uniform", "zipf"} {
+			options := DefaultDatastoreGeneratorOptions()
+			options.EntryCount = 1000
+			options.TransactionCount = 50
+			options.OverwriteRatio = 0.4
+			options.DeletionRatio = 0.2
+			options.KeyDistribution = keyDistribution
+
+			entryStream, err := GenerateRandomTransactionHistoryBytes(options, MonoUnixTimeMicro()-1000000)
+			Expect(err).To(BeNil())
+
+			entries, keys := readTransactionHistory(entryStream)
+			Expect(entries).To(HaveLen(1000))
+
+			writtenKeys := map[string]bool{}
+			overwriteCount := 0
+			deletionCount := 0
+
+			for i, entry := range entries {
+				if entry.ValueSize() == 0 {
+					Expect(writtenKeys[keys[i]]).To(BeTrue())
+					deletionCount++
+				} else if writtenKeys[keys[i]] {
+					overwriteCount++
+				}
+
+				writtenKeys[keys[i]] = true
+			}
+
+			Expect(deletionCount).To(BeNumerically("~", 200, 80))
+			Expect(overwriteCount).To(BeNumerically("~", 400, 100))
+		}
+	})
+
+	It("Generates entries until the target size is reached", func() {
+		options := DefaultDatastoreGeneratorOptions()
+		options.EntryCount = 20
+		options.TransactionCount = 4
+		options.TargetSize = 100000
+
+		entryStream, err := GenerateRandomTransactionHistoryBytes(options, MonoUnixTimeMicro()-1000000)
+		Expect(err).To(BeNil())
+
+		Expect(len(entryStream)).To(BeNumerically(">=", options.TargetSize-HeaderSize))
+		Expect(len(entryStream)).To(BeNumerically("<", options.TargetSize+HeaderSize+20+100))
+
+		entries, _ := readTransactionHistory(entryStream)
+
+		for i, entry := range entries {
+			Expect(entry.HasTransactionEndFlag()).To(Equal(i%5 == 4 || i == len(entries)-1))
+		}
+	})
+
+	It("Appends an incomplete transaction when a corrupted tail is requested", func() {
+		options := DefaultDatastoreGeneratorOptions()
+		options.EntryCount = 50
+		options.TransactionCount = 5
+		options.CorruptTail = true
+
+		entryStream, err := GenerateRandomTransactionHistoryBytes(options, MonoUnixTimeMicro()-1000000)
+		Expect(err).To(BeNil())
+
+		datastoreBytes, err := ioutil.ReadAll(CreateNewDatastoreReaderFromBytes(entryStream, MonoUnixTimeMicro()-1000000))
+		Expect(err).To(BeNil())
+
+		safeTruncationSize, err := FindSafeTruncationSize(bytes.NewReader(datastoreBytes), int64(len(datastoreBytes)))
+		Expect(err).To(BeNil())
+		Expect(safeTruncationSize).To(BeNumerically("<", len(datastoreBytes)))
+
+		headEntrySize := len(datastoreBytes) - len(entryStream)
+		entries, _ := readTransactionHistory(datastoreBytes[headEntrySize:safeTruncationSize])
+		Expect(entries).To(HaveLen(50))
+		Expect(entries[49].HasTransactionEndFlag()).To(BeTrue())
+	})
+
+	It("Rejects invalid options", func() {
+		options := DefaultDatastoreGeneratorOptions()
+		options.OverwriteRatio = 0.7
+		options.DeletionRatio = 0.5
+		Expect(options.Validate()).NotTo(BeNil())
+
+		options = DefaultDatastoreGeneratorOptions()
+		options.KeyDistribution = "normal"
+		Expect(options.Validate()).NotTo(BeNil())
+
+		options = DefaultDatastoreGeneratorOptions()
+		options.HistoryDuration = 100 * 365 * 24 * time.Hour
+		Expect(options.Validate()).NotTo(BeNil())
+
+		options = DefaultDatastoreGeneratorOptions()
+		options.EntryType = "randomEntry"
+		Expect(options.Validate()).NotTo(BeNil())
+
+		Expect(DefaultDatastoreGeneratorOptions().Validate()).To(BeNil())
+	})
+
+	Context("Served datastores", func() {
+		var context *ServerTestContext
+
+		BeforeEach(func() {
+			context = NewServerTestContext()
+			context.Start()
+		})
+
+		AfterEach(func() {
+			context.Stop()
+		})
+
+		It("Serves a generated history, and repairs its corrupted tail when loaded", func() {
+			datastoreName := "GeneratedDatastore_" + RandomWordString(8)
+			filePath := context.startupOptions.StoragePath + "/" + datastoreName
+
+			options := DefaultDatastoreGeneratorOptions()
+			options.EntryCount = 200
+			options.TransactionCount = 20
+			options.HistoryDuration = time.Hour
+			options.OverwriteRatio = 0.3
+			options.DeletionRatio = 0.1
+			options.KeyDistribution = "zipf"
+			options.CorruptTail = true
+
+			Expect(GenerateDatastore(filePath, options)).To(BeNil())
+
+			backupFilePaths := func() []string {
+				matches, _ := filepath.Glob(filePath + ".corrupted-*")
+				return matches
+			}
+
+			defer func() {
+				for _, backupFilePath := range backupFilePaths() {
+					os.Remove(backupFilePath)
+				}
+			}()
+
+			client := context.GetClient(datastoreName, "")
+
+			allResults, err := client.Get(0)
+			Expect(err).To(BeNil())
+			Expect(allResults).NotTo(BeEmpty())
+			Expect(backupFilePaths()).To(HaveLen(1))
+
+			// Read the entries committed during the last half of the history
+			recentResults, err := client.Get(MonoUnixTimeMicro() - int64(30*time.Minute/time.Microsecond))
+			Expect(err).To(BeNil())
+			Expect(recentResults).NotTo(BeEmpty())
+			Expect(len(recentResults)).To(BeNumerically("<", len(allResults)))
+		})
+	})
+})
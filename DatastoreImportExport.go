@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Exports the entries of a datastore file, having commit timestamp greater than 'updatedAfter', as newline
+// delimited JSON records. An incomplete or corrupted tail, if exists, is excluded from the output.
+func ExportDatastoreToJsonRecords(datastoreFilePath string, target io.Writer, updatedAfter int64) (err error) {
+	// Open the datastore file for reading
+	file, err := OpenFileWithDeleteSharing(datastoreFilePath, os.O_RDONLY, 0666)
+	if err != nil {
+		return
+	}
+
+	// Close the file once the function exits
+	defer file.Close()
+
+	// Get the file size
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return
+	}
+
+	// Find the range of the file that contains only complete and valid transactions
+	validSize, err := FindSafeTruncationSize(NewPrefetchingReaderAt(file), fileInfo.Size())
+	if err != nil {
+		return
+	}
+
+	// If the datastore doesn't contain a valid head entry
+	if validSize < HeadEntrySize {
+		// Return an invalid head entry error
+		return ErrInvalidHeadEntry
+	}
+
+	// Index the valid range to find the offset of the first matching entry
+	index := NewDatastoreIndex()
+	err = index.AppendFromEntryStream(NewPrefetchingReaderAt(file), 0, validSize, nil)
+	if err != nil {
+		return
+	}
+
+	startOffset := index.FindOffsetOfFirstEntryUpdatedAfter(updatedAfter)
+
+	// If no entry matches
+	if startOffset == -1 {
+		// Return without writing anything
+		return nil
+	}
+
+	// Write the matching entries as JSON records
+	return WriteEntryStreamAsJsonRecords(target, NewPrefetchingReaderAt(file), startOffset, validSize)
+}
+
+// Creates (or rewrites) a datastore file from a stream of newline delimited JSON records.
+// Consecutive records sharing the same commit timestamp are imported as a single transaction, and their
+// original update and commit timestamps are preserved. Records with no commit timestamp are assigned the
+// time of the import. Commit timestamps must be non-decreasing, and must not be later than the time of the
+// import. The datastore's creation timestamp is set to just before the first commit timestamp, such that
+// all imported transactions are indexed.
+func ImportDatastoreFromJsonRecords(source io.Reader, datastoreFilePath string) (entryCount int, err error) {
+	// Read and decode all records
+	entries, err := DeserializeJsonRecordStream(source)
+	if err != nil {
+		return
+	}
+
+	importTimestamp := MonoUnixTimeMicro()
+	transactionStream := NewMemoryWriter()
+
+	for i := 0; i < len(entries); i++ {
+		// Assign the import time to records with no commit timestamp
+		if entries[i].Header.CommitTime <= 0 {
+			entries[i].Header.CommitTime = importTimestamp
+		}
+
+		// Ensure the commit timestamp isn't in the future
+		if entries[i].Header.CommitTime > importTimestamp {
+			return 0, errors.New(fmt.Sprintf("Record %d has a commit timestamp (%d) later than the current time.", i+1, entries[i].Header.CommitTime))
+		}
+
+		// Ensure commit timestamps are non-decreasing, otherwise the datastore index would skip records
+		if i > 0 && entries[i].Header.CommitTime < entries[i-1].Header.CommitTime {
+			return 0, errors.New(fmt.Sprintf("Record %d has a commit timestamp (%d) earlier than the one of the preceding record (%d).", i+1, entries[i].Header.CommitTime, entries[i-1].Header.CommitTime))
+		}
+	}
+
+	// Set the creation timestamp to just before the first commit timestamp, since only commit
+	// timestamps greater than the creation timestamp are indexed
+	creationTimestamp := importTimestamp
+
+	if len(entries) > 0 {
+		creationTimestamp = entries[0].Header.CommitTime - 1
+	}
+
+	for i := 0; i < len(entries); i++ {
+		// Mark the last entry of every group of records sharing the same commit timestamp as a transaction end
+		if i == len(entries)-1 || entries[i+1].Header.CommitTime != entries[i].Header.CommitTime {
+			entries[i].Header.Flags = Flag_TransactionEnd
+		}
+
+		transactionStream.Write(SerializeEntry(&entries[i]))
+	}
+
+	transactionBytes := transactionStream.WrittenData()
+
+	// Validate the entries and add checksums, while preserving their commit timestamps
+	err = ValidateAndPrepareTransaction(transactionBytes, -1, 0)
+	if err != nil {
+		return
+	}
+
+	// Safely write the new datastore file
+	err = CreateOrRewriteFileSafe(datastoreFilePath, CreateNewDatastoreReader(bytes.NewReader(transactionBytes), creationTimestamp))
+	if err != nil {
+		return
+	}
+
+	return len(entries), nil
+}
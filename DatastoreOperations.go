@@ -276,6 +276,22 @@ func (this *DatastoreOperations) CreateReader(state *DatastoreState, updatedAfte
 	return
 }
 
+// Writes the entries of the given datastore state object to the given writer as newline delimited JSON
+// records, starting at the first entry having commit timestamp greater than the value given as argument.
+func (this *DatastoreOperations) WriteJsonRecords(target io.Writer, state *DatastoreState, updatedAfter int64) error {
+	// Use the index to find the offset of the first entry matching the condition
+	offset := state.Index.FindOffsetOfFirstEntryUpdatedAfter(updatedAfter)
+
+	// If no such entry was found
+	if offset == -1 {
+		// Return without writing anything
+		return nil
+	}
+
+	// Convert the range between the offset and the total size of the indexed entries
+	return WriteEntryStreamAsJsonRecords(target, NewPrefetchingReaderAt(state.File), offset, state.Size())
+}
+
 ///////////////////////////////////////////////////////////////////////////////////////////////////
 /// Write operations
 ///////////////////////////////////////////////////////////////////////////////////////////////////
@@ -314,6 +330,9 @@ func (this *DatastoreOperations) Append(transactionBytes []byte, state *Datastor
 		}
 	}
 
+	// Record the written bytes
+	this.ParentServer.metrics.bytesWritten.Add(float64(len(transactionBytes)))
+
 	// Schedule a flush, if needed.
 	if flushAfterWrite {
 		this.ScheduleFlushIfNeeded(newState, maxFlushDelay)
@@ -339,6 +358,9 @@ func (this *DatastoreOperations) Rewrite(transactionBytes []byte, commitTimestam
 		return
 	}
 
+	// Record the written bytes
+	this.ParentServer.metrics.bytesWritten.Add(float64(len(transactionBytes)))
+
 	// Reload the datastore
 	newState, err := this.Load()
 
@@ -375,8 +397,12 @@ func (this *DatastoreOperations) ScheduleFlushIfNeeded(state *DatastoreState, ma
 				return
 			}
 
+			// Record the flush latency
+			flushLatency := MonoUnixTimeMilli() - startTime
+			this.ParentServer.metrics.flushLatency.Observe(float64(flushLatency) / 1000)
+
 			// Log a success message
-			this.ParentServer.Logf(1, "Flushed datastore '%s' %dms after written", this.Name, MonoUnixTimeMilli()-startTime)
+			this.ParentServer.Logf(1, "Flushed datastore '%s' %dms after written", this.Name, flushLatency)
 		} else { // Otherwise,
 			// Log a failure message
 			this.ParentServer.Logf(1, "Error flushing datastore '%s'. %s", this.Name, err.Error())
@@ -403,6 +429,29 @@ func (this *DatastoreOperations) ScheduleFlushIfNeeded(state *DatastoreState, ma
 	}
 }
 
+// Synchronously flushes the datastore file, if the datastore is currently open. This doesn't affect
+// any pending scheduled flush. Should be called within the writer queue.
+func (this *DatastoreOperations) Flush() (err error) {
+	// Get the current state
+	state := this.State
+
+	// If the datastore isn't open, there is nothing to flush
+	if state == nil {
+		return
+	}
+
+	// Increment the reference count to ensure the file isn't closed while flushing
+	err = state.Increment()
+	if err != nil {
+		return
+	}
+
+	defer state.Decrement()
+
+	// Flush the file
+	return state.File.Sync()
+}
+
 ///////////////////////////////////////////////////////////////////////////////////////////////////
 /// Compaction operations
 ///////////////////////////////////////////////////////////////////////////////////////////////////
@@ -489,13 +538,74 @@ func (this *DatastoreOperations) CompactIfNeeded(state *DatastoreState, minSize
 	// Atomically replace the current state object with the new state object
 	this.ReplaceState(newState)
 
+	// Record compaction metrics
+	compactionDuration := MonoUnixTimeMilli() - startTime
+
+	this.ParentServer.metrics.compactions.Inc()
+	this.ParentServer.metrics.compactionDuration.Observe(float64(compactionDuration) / 1000)
+	this.ParentServer.metrics.compactionReclaimedBytes.Add(float64(currentSize - compactedSize))
+
 	// Log message
-	this.ParentServer.Logf(1, "Compacted datastore '%s' from %d to %d bytes in %dms", this.Name, currentSize, compactedSize, MonoUnixTimeMilli()-startTime)
+	this.ParentServer.Logf(1, "Compacted datastore '%s' from %d to %d bytes in %dms", this.Name, currentSize, compactedSize, compactionDuration)
 
 	// Return without error
 	return true, nil
 }
 
+///////////////////////////////////////////////////////////////////////////////////////////////////
+/// Retention operations
+///////////////////////////////////////////////////////////////////////////////////////////////////
+
+// Discards all entries having a commit timestamp less than or equal to the given one. The remaining entries
+// are preserved as-is, including their commit timestamps, so clients reading updates wouldn't receive them
+// again. Returns the number of bytes discarded. Should only be called within the writer queue.
+func (this *DatastoreOperations) DiscardEntriesUpdatedUntil(state *DatastoreState, timestamp int64) (int64, error) {
+	// Get the current size of the datastore
+	currentSize := state.Size()
+
+	// Use the index to find the offset of the first entry to keep. Since entries are ordered by their
+	// commit timestamps, all entries before it would be discarded.
+	retainedOffset := state.Index.FindOffsetOfFirstEntryUpdatedAfter(timestamp)
+
+	// If no such entry was found, all entries would be discarded
+	if retainedOffset == -1 {
+		retainedOffset = currentSize
+	}
+
+	// If there is nothing to discard (other than the head entry, which is always preserved), return
+	if retainedOffset <= HeadEntrySize {
+		return 0, nil
+	}
+
+	// Create a reader for the head entry, preserving the original creation time, followed by the retained entries
+	retainedDatastoreReader := io.MultiReader(
+		bytes.NewReader(CreateSerializedHeadEntry(state.HeadEntryValue, state.CreationTime)),
+		NewRangeReader(state.File, retainedOffset, currentSize))
+
+	// Rewrite the file with the retained entries
+	err := CreateOrRewriteFileSafe(this.FilePath, retainedDatastoreReader)
+
+	// If an error occurred while rewriting the file
+	if err != nil {
+		// Return the error
+		return 0, err
+	}
+
+	// Reload the datastore
+	newState, err := this.Load()
+
+	// If an error occurred while loading the rewritten file
+	if err != nil {
+		// Return the error
+		return 0, err
+	}
+
+	// Atomically replace the current state object with the new state object
+	this.ReplaceState(newState)
+
+	return retainedOffset - HeadEntrySize, nil
+}
+
 ///////////////////////////////////////////////////////////////////////////////////////////////////
 /// State related operations
 ///////////////////////////////////////////////////////////////////////////////////////////////////
@@ -617,6 +727,9 @@ func (this *DatastoreOperations) Repair(state *DatastoreState) (*DatastoreState,
 		// Log a failure message
 		this.ParentServer.Logf(1, "Failed to reload datastore '%s' after repair.", this.Name)
 	} else { // Otherwise
+		// Record the repair
+		this.ParentServer.metrics.repairs.Inc()
+
 		// Log a success message
 		this.ParentServer.Logf(1, "Repaired datastore '%s'. Original size %d bytes, Repaired size %d bytes. A backup of the corrupted datastore file has been saved to '%s'.", this.Name, originalSize, repairedSize, backupFilePath)
 	}
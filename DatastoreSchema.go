@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// A schema for the JSON values stored in a datastore. It is composed of rules, each associating a key
+// pattern with a JSON schema.
+type DatastoreSchema struct {
+	rules []DatastoreSchemaRule
+}
+
+// A single datastore schema rule
+type DatastoreSchemaRule struct {
+	Name        string
+	KeyPattern  *regexp.Regexp
+	ValueSchema *JsonSchema
+}
+
+var datastoreSchemaRuleConfigKeyRegexp *regexp.Regexp
+
+func init() {
+	datastoreSchemaRuleConfigKeyRegexp = regexp.MustCompile(`^\['datastore'\]\['schema'\]\['([^'\[\]]*)'\]\['keyPattern'\]$`)
+}
+
+// Gets the datastore schema defined in the given configuration snapshot. Schemas are only read from the
+// datastore's dedicated configuration, where each rule is given as a pair of entries:
+//
+// "['datastore']['schema'][<ruleName>]['keyPattern']": a key pattern, where '*' matches any sequence of
+// characters other than a single quote, e.g. "['users']['*']['profile']".
+//
+// "['datastore']['schema'][<ruleName>]['definition']": a JSON schema object.
+//
+// Rules are tried by ascending name order, and the first one with a matching pattern is applied. If no
+// rules are defined, nil is returned.
+func GetConfiguredDatastoreSchema(config *DatastoreConfigSnapshot) (*DatastoreSchema, error) {
+	if config.DedicatedConfig == nil {
+		return nil, nil
+	}
+
+	// Find the names of all rules having a key pattern
+	ruleNames := []string{}
+
+	for _, key := range config.DedicatedConfig.FindKeysStartingWith("['datastore']['schema']") {
+		keySubmatches := datastoreSchemaRuleConfigKeyRegexp.FindStringSubmatch(key)
+
+		if len(keySubmatches) > 0 {
+			ruleNames = append(ruleNames, keySubmatches[1])
+		}
+	}
+
+	// If no rules were found, return nil
+	if len(ruleNames) == 0 {
+		return nil, nil
+	}
+
+	sort.Strings(ruleNames)
+
+	// Compile the rules
+	schema := &DatastoreSchema{}
+
+	for _, ruleName := range ruleNames {
+		rulePrefix := "['datastore']['schema']['" + ruleName + "']"
+
+		keyPattern, err := config.DedicatedConfig.GetString(rulePrefix + "['keyPattern']")
+		if err != nil {
+			return nil, errors.New("The key pattern for schema rule '" + ruleName + "' must be a string.")
+		}
+
+		definition, err := config.DedicatedConfig.GetAny(rulePrefix + "['definition']")
+		if err != nil {
+			return nil, errors.New("No definition was found for schema rule '" + ruleName + "'.")
+		}
+
+		serializedDefinition, err := json.Marshal(definition)
+		if err != nil {
+			return nil, err
+		}
+
+		valueSchema, err := ParseJsonSchema(serializedDefinition)
+		if err != nil {
+			return nil, errors.New("The definition for schema rule '" + ruleName + "' is invalid: " + err.Error())
+		}
+
+		schema.rules = append(schema.rules, DatastoreSchemaRule{
+			Name:        ruleName,
+			KeyPattern:  compileDatastoreSchemaKeyPattern(keyPattern),
+			ValueSchema: valueSchema,
+		})
+	}
+
+	return schema, nil
+}
+
+// Finds the value schema applying to the given key. Returns nil if no rule matches the key.
+func (this *DatastoreSchema) FindValueSchema(key string) *JsonSchema {
+	for _, rule := range this.rules {
+		if rule.KeyPattern.MatchString(key) {
+			return rule.ValueSchema
+		}
+	}
+
+	return nil
+}
+
+// Compiles a key pattern to a regular expression matching entire keys
+func compileDatastoreSchemaKeyPattern(keyPattern string) *regexp.Regexp {
+	patternParts := strings.Split(keyPattern, "*")
+
+	for i := range patternParts {
+		patternParts[i] = regexp.QuoteMeta(patternParts[i])
+	}
+
+	return regexp.MustCompile("^" + strings.Join(patternParts, "[^']*") + "$")
+}
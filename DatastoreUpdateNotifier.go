@@ -2,6 +2,7 @@ package main
 
 import (
 	"sync"
+	"time"
 )
 
 // The subscriber object
@@ -46,6 +47,65 @@ func (this *DatastoreUpdateNotifier) CreateUpdateNotification(minTimestampThresh
 	return
 }
 
+// Cancel a notification created by CreateUpdateNotification, releasing any goroutine waiting on it.
+// Returns false if the notification has already been triggered by an update.
+func (this *DatastoreUpdateNotifier) CancelUpdateNotification(waitGroup *sync.WaitGroup) bool {
+	// Lock this object
+	this.Lock()
+
+	// Unlock it when the function finishes
+	defer this.Unlock()
+
+	// Find the subscriber for the given wait group
+	for index, subscriber := range this.subscribers {
+		if subscriber.waitGroup == waitGroup {
+			// Remove it from the subscriber list and release its waiters
+			this.subscribers = append(this.subscribers[:index:index], this.subscribers[index+1:]...)
+			subscriber.waitGroup.Done()
+
+			return true
+		}
+	}
+
+	return false
+}
+
+// Wait until an update with a timestamp greater than the given threshold occurs, the given timeout
+// elapses, or the given cancellation channel is closed. A timeout of 0 waits indefinitely, and a nil
+// cancellation channel is never closed. Returns true if an update occurred.
+func (this *DatastoreUpdateNotifier) WaitForUpdate(minTimestampThreshold int64, timeout time.Duration, cancel <-chan struct{}) bool {
+	// Create a notification
+	waitGroup := this.CreateUpdateNotification(minTimestampThreshold)
+
+	// Wait for the notification in a separate goroutine
+	updateOccurred := make(chan struct{})
+
+	go func() {
+		waitGroup.Wait()
+		close(updateOccurred)
+	}()
+
+	// A nil timer channel is never received from, so no timer is needed when waiting indefinitely
+	var timerChannel <-chan time.Time
+
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+
+		timerChannel = timer.C
+	}
+
+	select {
+	case <-updateOccurred:
+		return true
+	case <-timerChannel:
+	case <-cancel:
+	}
+
+	// Cancel the notification, unless an update occurred in the meantime
+	return !this.CancelUpdateNotification(waitGroup)
+}
+
 // Announce an update has occurred, with the given timestamp as its occurrence time
 func (this *DatastoreUpdateNotifier) AnnounceUpdate(timestamp int64) {
 	// Lock this object
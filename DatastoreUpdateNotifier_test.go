@@ -19,4 +19,34 @@ var _ = Describe("DatastoreUpdateNotifier", func() {
 		waitGroup.Wait()
 		Expect(MonoUnixTimeMilliFloat() - startTime >= 10)
 	})
+
+	It("Waits for an update until a timeout elapses", func() {
+		notifier := NewDatastoreUpdateNotifier()
+
+		go func() {
+			time.Sleep(10 * time.Millisecond)
+			notifier.AnnounceUpdate(52)
+		}()
+		Expect(notifier.WaitForUpdate(51, 10*time.Second, nil)).To(BeTrue())
+
+		startTime := time.Now()
+		Expect(notifier.WaitForUpdate(52, 50*time.Millisecond, nil)).To(BeFalse())
+		Expect(time.Since(startTime)).To(BeNumerically(">=", 50*time.Millisecond))
+
+		// Ensure the cancelled notification was removed
+		Expect(notifier.subscribers).To(BeEmpty())
+	})
+
+	It("Stops waiting for an update once the cancellation channel is closed", func() {
+		notifier := NewDatastoreUpdateNotifier()
+		cancel := make(chan struct{})
+
+		go func() {
+			time.Sleep(10 * time.Millisecond)
+			close(cancel)
+		}()
+
+		Expect(notifier.WaitForUpdate(51, 0, cancel)).To(BeFalse())
+		Expect(notifier.subscribers).To(BeEmpty())
+	})
 })
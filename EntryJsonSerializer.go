@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"unicode/utf8"
+)
+
+// A JSON representation of a single entry, used for newline delimited JSON (NDJSON) import and export.
+// Keys and values with a UTF-8 or JSON format are stored as plain strings. Any other payload
+// (binary, encrypted, or text that isn't valid UTF-8) is stored as a base64 string, and marked
+// with a 'base64' encoding.
+type EntryJsonRecord struct {
+	Key              string `json:"key"`
+	KeyFormat        uint8  `json:"keyFormat"`
+	KeyEncoding      string `json:"keyEncoding,omitempty"`
+	Value            string `json:"value"`
+	ValueFormat      uint8  `json:"valueFormat"`
+	ValueEncoding    string `json:"valueEncoding,omitempty"`
+	EncryptionMethod uint8  `json:"encryptionMethod,omitempty"`
+	UpdateTime       int64  `json:"updateTime"`
+	CommitTime       int64  `json:"commitTime"`
+}
+
+const JsonRecordEncoding_Base64 = "base64"
+
+////////////////////////////////////////////////////////////////////////////////
+// Serialization
+////////////////////////////////////////////////////////////////////////////////
+
+// Convert an entry to its JSON record representation
+func CreateJsonRecordFromEntry(entry *Entry) *EntryJsonRecord {
+	record := &EntryJsonRecord{
+		KeyFormat:        entry.Header.KeyFormat,
+		ValueFormat:      entry.Header.ValueFormat,
+		EncryptionMethod: entry.Header.EncryptionMethod,
+		UpdateTime:       entry.Header.UpdateTime,
+		CommitTime:       entry.Header.CommitTime,
+	}
+
+	record.Key, record.KeyEncoding = encodeJsonRecordPayload(entry.Key, entry.Header.KeyFormat, entry.Header.EncryptionMethod)
+	record.Value, record.ValueEncoding = encodeJsonRecordPayload(entry.Value, entry.Header.ValueFormat, entry.Header.EncryptionMethod)
+
+	return record
+}
+
+// Serialize a single entry to a JSON record, terminated by a newline character
+func SerializeEntryToJsonRecord(entry *Entry) ([]byte, error) {
+	serializedRecord, err := json.Marshal(CreateJsonRecordFromEntry(entry))
+	if err != nil {
+		return nil, err
+	}
+
+	return append(serializedRecord, '\n'), nil
+}
+
+// Write all the entries in the given range of an entry stream as newline delimited JSON records
+func WriteEntryStreamAsJsonRecords(target io.Writer, source io.ReaderAt, startOffset int64, endOffset int64) error {
+	// Create an iterator for the source stream
+	next := NewEntryStreamIterator(source, startOffset, endOffset)
+
+	// Buffer the output to avoid a write call for every record
+	bufferedWriter := bufio.NewWriter(target)
+
+	for {
+		// Iterate to the next entry
+		iteratorResult, err := next()
+
+		// If an error occurred while iterating
+		if err != nil {
+			// Return the error
+			return err
+		}
+
+		// If the iterator has completed
+		if iteratorResult == nil {
+			// Flush remaining buffered data and return
+			return bufferedWriter.Flush()
+		}
+
+		// Skip the head entry, if included in the range
+		if iteratorResult.IsHeadEntry() {
+			continue
+		}
+
+		// Read the key and value of the entry
+		key, value, err := iteratorResult.ReadKeyAndValue()
+		if err != nil {
+			return err
+		}
+
+		// Serialize the entry to a JSON record
+		serializedRecord, err := SerializeEntryToJsonRecord(&Entry{Header: iteratorResult.Header, Key: key, Value: value})
+		if err != nil {
+			return err
+		}
+
+		// Write the record
+		_, err = bufferedWriter.Write(serializedRecord)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// Deserialization
+////////////////////////////////////////////////////////////////////////////////
+
+// Convert a JSON record to an entry
+func CreateEntryFromJsonRecord(record *EntryJsonRecord) (entry *Entry, err error) {
+	key, err := decodeJsonRecordPayload(record.Key, record.KeyEncoding)
+	if err != nil {
+		return
+	}
+
+	value, err := decodeJsonRecordPayload(record.Value, record.ValueEncoding)
+	if err != nil {
+		return
+	}
+
+	entry = &Entry{
+		Header: &EntryHeader{
+			KeyFormat:        record.KeyFormat,
+			ValueFormat:      record.ValueFormat,
+			EncryptionMethod: record.EncryptionMethod,
+			UpdateTime:       record.UpdateTime,
+			CommitTime:       record.CommitTime,
+		},
+		Key:   key,
+		Value: value,
+	}
+
+	return
+}
+
+// Deserialize a single JSON record to an entry
+func DeserializeJsonRecordToEntry(serializedRecord []byte) (*Entry, error) {
+	record := &EntryJsonRecord{}
+
+	err := json.Unmarshal(serializedRecord, record)
+	if err != nil {
+		return nil, err
+	}
+
+	return CreateEntryFromJsonRecord(record)
+}
+
+// Read a stream of newline delimited JSON records and convert them to entries. Empty lines are ignored.
+func DeserializeJsonRecordStream(source io.Reader) (entries []Entry, err error) {
+	entries = []Entry{}
+
+	bufferedReader := bufio.NewReader(source)
+	lineNumber := 0
+
+	for {
+		// Read the next line
+		line, readErr := bufferedReader.ReadBytes('\n')
+		lineNumber++
+
+		// If the line isn't empty
+		if len(bytes.TrimSpace(line)) > 0 {
+			// Deserialize it
+			entry, err := DeserializeJsonRecordToEntry(line)
+			if err != nil {
+				return nil, errors.New(fmt.Sprintf("Invalid JSON record at line %d: %s", lineNumber, err.Error()))
+			}
+
+			entries = append(entries, *entry)
+		}
+
+		// If the stream has ended
+		if readErr == io.EOF {
+			// Return the results
+			return entries, nil
+		} else if readErr != nil { // Otherwise, if some other error occurred
+			// Return the error
+			return nil, readErr
+		}
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// Payload encoding
+////////////////////////////////////////////////////////////////////////////////
+func encodeJsonRecordPayload(payload []byte, format uint8, encryptionMethod uint8) (encodedPayload string, encoding string) {
+	isText := format == DataFormat_UTF8 || format == DataFormat_JSON
+
+	if isText && encryptionMethod == 0 && utf8.Valid(payload) {
+		return string(payload), ""
+	}
+
+	return base64.StdEncoding.EncodeToString(payload), JsonRecordEncoding_Base64
+}
+
+func decodeJsonRecordPayload(encodedPayload string, encoding string) ([]byte, error) {
+	switch encoding {
+	case "":
+		return []byte(encodedPayload), nil
+	case JsonRecordEncoding_Base64:
+		return base64.StdEncoding.DecodeString(encodedPayload)
+	default:
+		return nil, errors.New("Unsupported payload encoding '" + encoding + "'")
+	}
+}
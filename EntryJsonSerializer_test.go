@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("EntryJsonSerializer", func() {
+	It("Serializes and deserializes entries of various formats as JSON records", func() {
+		testEntries := []Entry{
+			*getRandomJsonEntry(20, 50),
+			*getRandomUtf8Entry(20, 50),
+			*getRandomBinaryEntry(20, 50),
+			*getRandomPathEntryWithBinaryValue(20, 50),
+			Entry{&EntryHeader{KeyFormat: DataFormat_UTF8, ValueFormat: DataFormat_UTF8, UpdateTime: 1483221600 * 1000000}, []byte{0xff, 0xfe}, []byte{}},
+		}
+
+		for _, testEntry := range testEntries {
+			serializedRecord, err := SerializeEntryToJsonRecord(&testEntry)
+			Expect(err).To(BeNil())
+			Expect(serializedRecord[len(serializedRecord)-1]).To(Equal(byte('\n')))
+
+			deserializedEntry, err := DeserializeJsonRecordToEntry(serializedRecord)
+			Expect(err).To(BeNil())
+
+			Expect(deserializedEntry.Key).To(Equal(testEntry.Key))
+			Expect(deserializedEntry.Value).To(Equal(testEntry.Value))
+			Expect(deserializedEntry.Header.KeyFormat).To(Equal(testEntry.Header.KeyFormat))
+			Expect(deserializedEntry.Header.ValueFormat).To(Equal(testEntry.Header.ValueFormat))
+			Expect(deserializedEntry.Header.UpdateTime).To(Equal(testEntry.Header.UpdateTime))
+			Expect(deserializedEntry.Header.CommitTime).To(Equal(testEntry.Header.CommitTime))
+		}
+	})
+
+	It("Encodes binary payloads as base64 and textual payloads as plain strings", func() {
+		record := CreateJsonRecordFromEntry(&Entry{
+			Header: &EntryHeader{KeyFormat: DataFormat_JSON, ValueFormat: DataFormat_Binary},
+			Key:    []byte(`"Key1"`),
+			Value:  []byte{1, 2, 3},
+		})
+
+		Expect(record.Key).To(Equal(`"Key1"`))
+		Expect(record.KeyEncoding).To(Equal(""))
+		Expect(record.Value).To(Equal("AQID"))
+		Expect(record.ValueEncoding).To(Equal(JsonRecordEncoding_Base64))
+	})
+
+	It("Converts an entry stream to JSON records and back", func() {
+		testEntries := GenerateRandomEntries(50, 20, 100, "randomPathEntryWithBinaryValue")
+		entryStream := SerializeEntries(testEntries)
+
+		output := NewMemoryWriter()
+		err := WriteEntryStreamAsJsonRecords(output, bytes.NewReader(entryStream), 0, int64(len(entryStream)))
+		Expect(err).To(BeNil())
+
+		deserializedEntries, err := DeserializeJsonRecordStream(bytes.NewReader(output.WrittenData()))
+		Expect(err).To(BeNil())
+
+		SerializeEntries(deserializedEntries)
+		ExpectEntryArraysToBeEquivalent(deserializedEntries, testEntries)
+	})
+
+	It("Rejects invalid JSON records", func() {
+		_, err := DeserializeJsonRecordStream(bytes.NewReader([]byte("{\"key\":\"a\"}\n\n{invalid\n")))
+		Expect(err).NotTo(BeNil())
+		Expect(err.Error()).To(ContainSubstring("line 3"))
+	})
+
+	It("Exports and imports a datastore file", func() {
+		testEntries := GenerateRandomEntries(30, 20, 100, "randomJSONEntry")
+		for _, entry := range testEntries {
+			entry.Header.Flags = 0
+		}
+
+		sourceFilePath := "./tests_temp/" + RandomWordString(12)
+		targetFilePath := "./tests_temp/" + RandomWordString(12)
+
+		defer os.Remove(sourceFilePath)
+		defer os.Remove(targetFilePath)
+
+		entryStream := SerializeEntries(testEntries)
+		Expect(ValidateAndPrepareTransaction(entryStream, MonoUnixTimeMicro(), 0)).To(BeNil())
+		Expect(CreateOrRewriteFileSafe(sourceFilePath, CreateNewDatastoreReaderFromBytes(entryStream, 0))).To(BeNil())
+
+		exported := NewMemoryWriter()
+		Expect(ExportDatastoreToJsonRecords(sourceFilePath, exported, 0)).To(BeNil())
+
+		entryCount, err := ImportDatastoreFromJsonRecords(bytes.NewReader(exported.WrittenData()), targetFilePath)
+		Expect(err).To(BeNil())
+		Expect(entryCount).To(Equal(len(testEntries)))
+
+		targetFileContent, err := ReadEntireFile(targetFilePath)
+		Expect(err).To(BeNil())
+		Expect(targetFileContent[HeadEntrySize:]).To(Equal(entryStream))
+	})
+
+	It("Indexes the transactions of an imported datastore file", func() {
+		options := DefaultDatastoreGeneratorOptions()
+		options.EntryCount = 30
+		options.TransactionCount = 6
+		options.EntryType = "randomJSONEntry"
+
+		startTimestamp := MonoUnixTimeMicro() - 3600000000
+		entryStream, err := GenerateRandomTransactionHistoryBytes(options, startTimestamp)
+		Expect(err).To(BeNil())
+
+		sourceFilePath := "./tests_temp/" + RandomWordString(12)
+		targetFilePath := "./tests_temp/" + RandomWordString(12)
+
+		defer os.Remove(sourceFilePath)
+		defer os.Remove(targetFilePath)
+
+		Expect(CreateOrRewriteFileSafe(sourceFilePath, CreateNewDatastoreReaderFromBytes(entryStream, startTimestamp))).To(BeNil())
+
+		exported := NewMemoryWriter()
+		Expect(ExportDatastoreToJsonRecords(sourceFilePath, exported, 0)).To(BeNil())
+
+		_, err = ImportDatastoreFromJsonRecords(bytes.NewReader(exported.WrittenData()), targetFilePath)
+		Expect(err).To(BeNil())
+
+		// Read the entries committed after the third transaction
+		entries, err := DeserializeEntryStreamBytes(entryStream)
+		Expect(err).To(BeNil())
+
+		partialExport := NewMemoryWriter()
+		Expect(ExportDatastoreToJsonRecords(targetFilePath, partialExport, entries[14].Header.CommitTime)).To(BeNil())
+
+		partialEntries, err := DeserializeJsonRecordStream(bytes.NewReader(partialExport.WrittenData()))
+		Expect(err).To(BeNil())
+		Expect(partialEntries).To(HaveLen(15))
+		Expect(partialEntries[0].Header.CommitTime).To(Equal(entries[15].Header.CommitTime))
+	})
+
+	It("Rejects importing records with out of order or future commit timestamps", func() {
+		targetFilePath := "./tests_temp/" + RandomWordString(12)
+		defer os.Remove(targetFilePath)
+
+		now := MonoUnixTimeMicro()
+
+		outOfOrderRecords := fmt.Sprintf("{\"key\":\"a\",\"value\":\"1\",\"commitTime\":%d,\"updateTime\":%d}\n{\"key\":\"b\",\"value\":\"2\",\"commitTime\":%d,\"updateTime\":%d}\n", now-1000, now-1000, now-2000, now-2000)
+		_, err := ImportDatastoreFromJsonRecords(bytes.NewReader([]byte(outOfOrderRecords)), targetFilePath)
+		Expect(err).NotTo(BeNil())
+		Expect(err.Error()).To(ContainSubstring("Record 2"))
+
+		futureRecords := fmt.Sprintf("{\"key\":\"a\",\"value\":\"1\",\"commitTime\":%d,\"updateTime\":%d}\n", now+60000000, now)
+		_, err = ImportDatastoreFromJsonRecords(bytes.NewReader([]byte(futureRecords)), targetFilePath)
+		Expect(err).NotTo(BeNil())
+		Expect(err.Error()).To(ContainSubstring("Record 1"))
+
+		Expect(FileExists(targetFilePath)).To(BeFalse())
+	})
+})
@@ -3,8 +3,11 @@ package main
 import (
 	"bytes"
 	"encoding/binary"
+	"encoding/json"
 	"fmt"
 	"io"
+	"strings"
+	"unicode/utf8"
 )
 
 const HeaderSize = 40
@@ -308,6 +311,23 @@ func ValidateAndPrepareTransaction(entryStream []byte, newCommitTimestamp int64,
 			return ErrEntryRejected{"Encountered an entry header containing an update time greater than 30 seconds past the server's clock."}
 		}
 
+		// Ensure unencrypted keys and values are well-formed according to their declared formats
+		if iteratorResult.Header.EncryptionMethod == 0 {
+			key, value, err := iteratorResult.ReadKeyAndValue()
+			if err != nil {
+				return err
+			}
+
+			if !isWellFormedForDataFormat(key, iteratorResult.Header.KeyFormat) {
+				return ErrEntryRejected{fmt.Sprintf("Encountered an entry key '%s' that is not well-formed according to its declared format (%d).", string(key), iteratorResult.Header.KeyFormat)}
+			}
+
+			// An empty value denotes a deletion, and is thus permitted for any format
+			if len(value) > 0 && !isWellFormedForDataFormat(value, iteratorResult.Header.ValueFormat) {
+				return ErrEntryRejected{fmt.Sprintf("The value for key '%s' is not well-formed according to its declared format (%d).", string(key), iteratorResult.Header.ValueFormat)}
+			}
+		}
+
 		// Set the commit timestamp, if needed
 		if newCommitTimestamp > 0 {
 			iteratorResult.Header.CommitTime = newCommitTimestamp
@@ -326,3 +346,158 @@ func ValidateAndPrepareTransaction(entryStream []byte, newCommitTimestamp int64,
 		AddChecksumsToSerializedEntry(entryStream[iteratorResult.Offset:iteratorResult.EndOffset()])
 	}
 }
+
+// Ensures every entry key in the given transaction starts with at least one of the given prefixes. Keys
+// having the JSON format are decoded to strings before being compared. A nil prefix list permits any key.
+func ValidateTransactionKeyPrefixes(entryStream []byte, allowedKeyPrefixes []string) error {
+	// If no restriction applies, return without error
+	if allowedKeyPrefixes == nil {
+		return nil
+	}
+
+	// Create an iterator to the given entry stream
+	next := NewEntryStreamIterator(bytes.NewReader(entryStream), 0, int64(len(entryStream)))
+
+	// Repeat
+	for {
+		// Iterate to next result
+		iteratorResult, err := next()
+
+		// If an error occurred when iterating
+		if err != nil {
+			// Return the error
+			return err
+		}
+
+		// If the iterator result is empty
+		if iteratorResult == nil {
+			// Return without error
+			return nil
+		}
+
+		// Encrypted keys cannot be inspected, and are thus never permitted when a restriction applies
+		if iteratorResult.Header.EncryptionMethod != 0 {
+			return ErrEntryKeyNotPermitted{"Encrypted entry keys are not permitted when writes are restricted to particular key prefixes."}
+		}
+
+		// Read the key
+		key, err := readDecodedEntryKey(iteratorResult)
+		if err != nil {
+			return err
+		}
+
+		// Check the key against the allowed prefixes
+		permitted := false
+
+		for _, prefix := range allowedKeyPrefixes {
+			if strings.HasPrefix(key, prefix) {
+				permitted = true
+				break
+			}
+		}
+
+		if !permitted {
+			return ErrEntryKeyNotPermitted{fmt.Sprintf("The key '%s' is outside the key prefixes permitted for writing.", key)}
+		}
+	}
+}
+
+// Ensures the values of every JSON formatted entry in the given transaction conform to the schema matching
+// its key, if any. A nil schema permits any value.
+func ValidateTransactionValues(entryStream []byte, schema *DatastoreSchema) error {
+	// If no schema is defined, return without error
+	if schema == nil {
+		return nil
+	}
+
+	// Create an iterator to the given entry stream
+	next := NewEntryStreamIterator(bytes.NewReader(entryStream), 0, int64(len(entryStream)))
+
+	// Repeat
+	for {
+		// Iterate to next result
+		iteratorResult, err := next()
+
+		// If an error occurred when iterating
+		if err != nil {
+			// Return the error
+			return err
+		}
+
+		// If the iterator result is empty
+		if iteratorResult == nil {
+			// Return without error
+			return nil
+		}
+
+		// Skip encrypted entries, deletions and entries not having JSON values
+		if iteratorResult.Header.EncryptionMethod != 0 ||
+			iteratorResult.Header.ValueFormat != DataFormat_JSON ||
+			iteratorResult.ValueSize() == 0 {
+			continue
+		}
+
+		// Read the key
+		key, err := readDecodedEntryKey(iteratorResult)
+		if err != nil {
+			return err
+		}
+
+		// Find the schema matching the key, and skip the entry if there isn't one
+		valueSchema := schema.FindValueSchema(key)
+
+		if valueSchema == nil {
+			continue
+		}
+
+		// Read and decode the value
+		valueBytes, err := iteratorResult.ReadValue()
+		if err != nil {
+			return err
+		}
+
+		var value interface{}
+
+		err = json.Unmarshal(valueBytes, &value)
+		if err != nil {
+			return ErrEntryRejected{fmt.Sprintf("The value for key '%s' is not valid JSON.", key)}
+		}
+
+		// Validate the value
+		err = valueSchema.Validate(value)
+		if err != nil {
+			return ErrEntryRejected{fmt.Sprintf("The value for key '%s' doesn't conform to the datastore's schema: %s.", key, err.Error())}
+		}
+	}
+}
+
+// Reads the key of the given entry as a string. Keys having the JSON format are decoded to their string values.
+func readDecodedEntryKey(iteratorResult *EntryStreamIteratorResult) (string, error) {
+	keyBytes, err := iteratorResult.ReadKey()
+	if err != nil {
+		return "", err
+	}
+
+	if iteratorResult.Header.KeyFormat == DataFormat_JSON {
+		var decodedKey string
+
+		if json.Unmarshal(keyBytes, &decodedKey) == nil {
+			return decodedKey, nil
+		}
+	}
+
+	return string(keyBytes), nil
+}
+
+// Checks if the given bytes are well-formed according to the given data format. Binary data, as well as
+// formats not verified by the server, are always considered well-formed.
+func isWellFormedForDataFormat(data []byte, format uint8) bool {
+	switch format {
+	case DataFormat_UTF8:
+		return utf8.Valid(data)
+	case DataFormat_JSON:
+		return json.Valid(data)
+	default:
+		return true
+	}
+}
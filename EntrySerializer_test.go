@@ -67,4 +67,71 @@ var _ = Describe("EntrySerializer", func() {
 
 		Expect(CompactEntries(entries)).To(Equal([]Entry{entries[1], entries[2], entries[4], entries[5]}))
 	})
+
+	It("Validates transaction keys against allowed prefixes", func() {
+		transaction := SerializeEntries([]Entry{
+			Entry{&EntryHeader{KeyFormat: DataFormat_JSON, ValueFormat: DataFormat_JSON}, []byte(`"['users']['alice']['name']"`), []byte(`"Alice"`)},
+			Entry{&EntryHeader{KeyFormat: DataFormat_UTF8, ValueFormat: DataFormat_JSON}, []byte(`['public']['motd']`), []byte(`"Hi"`)},
+		})
+
+		Expect(ValidateTransactionKeyPrefixes(transaction, nil)).To(BeNil())
+		Expect(ValidateTransactionKeyPrefixes(transaction, []string{"['users']['alice']", "['public']"})).To(BeNil())
+
+		err := ValidateTransactionKeyPrefixes(transaction, []string{"['users']['alice']"})
+		Expect(err).To(BeAssignableToTypeOf(ErrEntryKeyNotPermitted{}))
+		Expect(err.Error()).To(ContainSubstring("['public']['motd']"))
+
+		Expect(ValidateTransactionKeyPrefixes(transaction, []string{})).NotTo(BeNil())
+	})
+
+	It("Rejects transaction entries that are not well-formed according to their declared formats", func() {
+		createTransaction := func(keyFormat uint8, key string, valueFormat uint8, value string) []byte {
+			return SerializeEntries([]Entry{
+				Entry{&EntryHeader{KeyFormat: keyFormat, ValueFormat: valueFormat, UpdateTime: MonoUnixTimeMicro()}, []byte(key), []byte(value)},
+			})
+		}
+
+		Expect(ValidateAndPrepareTransaction(createTransaction(DataFormat_JSON, `"Key1"`, DataFormat_JSON, `{"a": [1, 2]}`), -1, 0)).To(BeNil())
+		Expect(ValidateAndPrepareTransaction(createTransaction(DataFormat_JSON, `"Key1"`, DataFormat_JSON, ``), -1, 0)).To(BeNil())
+		Expect(ValidateAndPrepareTransaction(createTransaction(DataFormat_Binary, "\xff", DataFormat_Binary, "\xfe"), -1, 0)).To(BeNil())
+
+		err := ValidateAndPrepareTransaction(createTransaction(DataFormat_JSON, `"Key1"`, DataFormat_JSON, `{"a": `), -1, 0)
+		Expect(err).To(BeAssignableToTypeOf(ErrEntryRejected{}))
+		Expect(err.Error()).To(ContainSubstring("Key1"))
+
+		err = ValidateAndPrepareTransaction(createTransaction(DataFormat_JSON, `Key1`, DataFormat_JSON, `1`), -1, 0)
+		Expect(err).To(BeAssignableToTypeOf(ErrEntryRejected{}))
+
+		err = ValidateAndPrepareTransaction(createTransaction(DataFormat_UTF8, "Key1", DataFormat_UTF8, "\xff\xfe"), -1, 0)
+		Expect(err).To(BeAssignableToTypeOf(ErrEntryRejected{}))
+		Expect(err.Error()).To(ContainSubstring("Key1"))
+	})
+
+	It("Validates transaction values against a datastore schema", func() {
+		config := NewDatastoreConfigSnapshot(nil, NewVarMap(map[string]interface{}{
+			"['datastore']['schema']['age']['keyPattern']": "['users']['*']['age']",
+			"['datastore']['schema']['age']['definition']": map[string]interface{}{"type": "integer", "minimum": 0.0},
+		}))
+
+		schema, err := GetConfiguredDatastoreSchema(config)
+		Expect(err).To(BeNil())
+
+		createTransaction := func(key string, value string) []byte {
+			return SerializeEntries([]Entry{
+				Entry{&EntryHeader{KeyFormat: DataFormat_JSON, ValueFormat: DataFormat_JSON}, []byte(`"` + key + `"`), []byte(value)},
+			})
+		}
+
+		Expect(ValidateTransactionValues(createTransaction("['users']['alice']['age']", `30`), schema)).To(BeNil())
+		Expect(ValidateTransactionValues(createTransaction("['users']['alice']['name']", `"Alice"`), schema)).To(BeNil())
+		Expect(ValidateTransactionValues(createTransaction("['users']['alice']['age']", ``), schema)).To(BeNil())
+		Expect(ValidateTransactionValues(createTransaction("['users']['alice']['age']", `"thirty"`), nil)).To(BeNil())
+
+		err = ValidateTransactionValues(createTransaction("['users']['alice']['age']", `-1`), schema)
+		Expect(err).To(BeAssignableToTypeOf(ErrEntryRejected{}))
+		Expect(err.Error()).To(ContainSubstring("['users']['alice']['age']"))
+
+		// The wildcard doesn't match across key identifiers
+		Expect(ValidateTransactionValues(createTransaction("['users']['alice']['x']['age']", `-1`), schema)).To(BeNil())
+	})
 })
@@ -68,3 +68,11 @@ func (this *FileDescriptorCounterMap) Decrement(file *os.File) (err error) {
 
 	return
 }
+
+// Gets the number of file descriptors currently open
+func (this *FileDescriptorCounterMap) Count() int {
+	this.Lock()
+	defer this.Unlock()
+
+	return len(this.counterMap)
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"sort"
+	"sync"
+)
+
+// The minimal interval, in milliseconds, between successive evictions of expired bans and failure records
+const ipBanListEvictionInterval int64 = 10000
+
+// IP ban list definition. Tracks failures (e.g. rejected authentication attempts) per IP and
+// temporarily bans IPs having too many failures within a time window. Safe for concurrent use.
+type IPBanList struct {
+	// A lookup table taking an IP and giving the time its ban expires
+	bans map[string]int64
+
+	// A lookup table taking an IP and giving its failure record for the current window
+	failures map[string]*IPFailureRecord
+
+	// The last time expired entries were evicted
+	lastEvictionTime int64
+
+	// Make this lockable
+	sync.Mutex
+}
+
+// Failure record definition
+type IPFailureRecord struct {
+	// Time the current window has started
+	StartTime int64
+	// Length of the window, in milliseconds
+	Window int64
+	// Number of failures counted
+	Count int64
+}
+
+// An IP ban, as returned to the client
+type IPBan struct {
+	IP        string `json:"ip"`
+	ExpiresAt int64  `json:"expiresAt"`
+}
+
+// Create a new IP ban list object
+func NewIPBanList() *IPBanList {
+	return &IPBanList{
+		bans:     map[string]int64{},
+		failures: map[string]*IPFailureRecord{},
+	}
+}
+
+// Checks if the given IP is currently banned
+func (this *IPBanList) IsBanned(ip string) bool {
+	// Lock this object
+	this.Lock()
+
+	// Unlock when the function returns
+	defer this.Unlock()
+
+	currentTime := MonoUnixTimeMilli()
+	this.evictExpiredIfNeeded(currentTime)
+
+	expiryTime, found := this.bans[ip]
+
+	return found && currentTime < expiryTime
+}
+
+// Records a failure for the given IP. If the IP had 'maxFailures' failures or more within the given
+// time window (milliseconds), it is banned for the given duration (milliseconds) and true is returned.
+func (this *IPBanList) RecordFailure(ip string, window int64, maxFailures int64, banDuration int64) (banned bool) {
+	// Lock this object
+	this.Lock()
+
+	// Unlock when the function returns
+	defer this.Unlock()
+
+	// Record the current time
+	currentTime := MonoUnixTimeMilli()
+	this.evictExpiredIfNeeded(currentTime)
+
+	// Look for an existing record for the given IP
+	record, found := this.failures[ip]
+
+	if !found || currentTime > record.StartTime+window { // If there isn't a record for the current window
+		// Create a new record with current time
+		record = &IPFailureRecord{
+			StartTime: currentTime,
+			Window:    window,
+			Count:     0,
+		}
+
+		this.failures[ip] = record
+	}
+
+	// Increment the count
+	record.Count += 1
+
+	// If the count has reached the limit, ban the IP and reset its record
+	if record.Count >= maxFailures {
+		this.bans[ip] = currentTime + banDuration
+		delete(this.failures, ip)
+
+		return true
+	}
+
+	return false
+}
+
+// Bans the given IP for the given duration (milliseconds)
+func (this *IPBanList) Ban(ip string, banDuration int64) {
+	this.Lock()
+	defer this.Unlock()
+
+	this.bans[ip] = MonoUnixTimeMilli() + banDuration
+}
+
+// Lifts the ban for the given IP. Returns false if the IP wasn't banned.
+func (this *IPBanList) Lift(ip string) bool {
+	this.Lock()
+	defer this.Unlock()
+
+	expiryTime, found := this.bans[ip]
+
+	delete(this.bans, ip)
+	delete(this.failures, ip)
+
+	return found && MonoUnixTimeMilli() < expiryTime
+}
+
+// Lists the currently active bans, ordered by IP
+func (this *IPBanList) List() []IPBan {
+	this.Lock()
+	defer this.Unlock()
+
+	currentTime := MonoUnixTimeMilli()
+	results := []IPBan{}
+
+	for ip, expiryTime := range this.bans {
+		if currentTime < expiryTime {
+			results = append(results, IPBan{IP: ip, ExpiresAt: expiryTime})
+		}
+	}
+
+	sort.Slice(results, func(i, j int) bool { return results[i].IP < results[j].IP })
+
+	return results
+}
+
+// Removes expired bans and failure records, if enough time has passed since the last eviction. Assumes
+// the object is already locked.
+func (this *IPBanList) evictExpiredIfNeeded(currentTime int64) {
+	if currentTime < this.lastEvictionTime+ipBanListEvictionInterval {
+		return
+	}
+
+	for ip, expiryTime := range this.bans {
+		if currentTime >= expiryTime {
+			delete(this.bans, ip)
+		}
+	}
+
+	for ip, record := range this.failures {
+		if currentTime > record.StartTime+record.Window {
+			delete(this.failures, ip)
+		}
+	}
+
+	this.lastEvictionTime = currentTime
+}
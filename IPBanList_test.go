@@ -0,0 +1,44 @@
+package main
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("IPBanList", func() {
+	It("Bans an IP after repeated failures within a window", func() {
+		banList := NewIPBanList()
+
+		Expect(banList.RecordFailure("10.0.0.1", 1000, 3, 1000)).To(BeFalse())
+		Expect(banList.RecordFailure("10.0.0.1", 1000, 3, 1000)).To(BeFalse())
+		Expect(banList.RecordFailure("10.0.0.2", 1000, 3, 1000)).To(BeFalse())
+		Expect(banList.IsBanned("10.0.0.1")).To(BeFalse())
+
+		Expect(banList.RecordFailure("10.0.0.1", 1000, 3, 1000)).To(BeTrue())
+		Expect(banList.IsBanned("10.0.0.1")).To(BeTrue())
+		Expect(banList.IsBanned("10.0.0.2")).To(BeFalse())
+
+		bans := banList.List()
+		Expect(bans).To(HaveLen(1))
+		Expect(bans[0].IP).To(Equal("10.0.0.1"))
+	})
+
+	It("Expires bans after their duration passes", func() {
+		banList := NewIPBanList()
+
+		banList.Ban("10.0.0.1", 50)
+		Expect(banList.IsBanned("10.0.0.1")).To(BeTrue())
+
+		Eventually(func() bool { return banList.IsBanned("10.0.0.1") }).Should(BeFalse())
+		Expect(banList.List()).To(BeEmpty())
+	})
+
+	It("Lifts bans", func() {
+		banList := NewIPBanList()
+
+		banList.Ban("10.0.0.1", 10000)
+		Expect(banList.Lift("10.0.0.1")).To(BeTrue())
+		Expect(banList.IsBanned("10.0.0.1")).To(BeFalse())
+		Expect(banList.Lift("10.0.0.1")).To(BeFalse())
+	})
+})
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// A parsed JSON schema. Only a subset of JSON Schema is supported: 'type', 'enum', 'minimum', 'maximum',
+// 'minLength', 'maxLength', 'pattern', 'properties', 'required', 'additionalProperties' (boolean only),
+// 'items', 'minItems' and 'maxItems'. Any other keyword is ignored.
+type JsonSchema struct {
+	Types                []string
+	Enum                 []interface{}
+	Minimum              *float64
+	Maximum              *float64
+	MinLength            *int
+	MaxLength            *int
+	Pattern              *regexp.Regexp
+	Properties           map[string]*JsonSchema
+	Required             []string
+	AdditionalProperties *bool
+	Items                *JsonSchema
+	MinItems             *int
+	MaxItems             *int
+}
+
+// The raw form of a schema, as decoded from JSON
+type jsonSchemaDefinition struct {
+	Type                 interface{}                      `json:"type"`
+	Enum                 []interface{}                    `json:"enum"`
+	Minimum              *float64                         `json:"minimum"`
+	Maximum              *float64                         `json:"maximum"`
+	MinLength            *int                             `json:"minLength"`
+	MaxLength            *int                             `json:"maxLength"`
+	Pattern              *string                          `json:"pattern"`
+	Properties           map[string]*jsonSchemaDefinition `json:"properties"`
+	Required             []string                         `json:"required"`
+	AdditionalProperties *bool                            `json:"additionalProperties"`
+	Items                *jsonSchemaDefinition            `json:"items"`
+	MinItems             *int                             `json:"minItems"`
+	MaxItems             *int                             `json:"maxItems"`
+}
+
+// Parses a JSON schema from its JSON representation
+func ParseJsonSchema(schemaBytes []byte) (*JsonSchema, error) {
+	var definition jsonSchemaDefinition
+
+	err := json.Unmarshal(schemaBytes, &definition)
+	if err != nil {
+		return nil, errors.New("Invalid JSON schema: " + err.Error())
+	}
+
+	return compileJsonSchemaDefinition(&definition)
+}
+
+// Compiles a decoded schema definition
+func compileJsonSchemaDefinition(definition *jsonSchemaDefinition) (schema *JsonSchema, err error) {
+	schema = &JsonSchema{
+		Enum:                 definition.Enum,
+		Minimum:              definition.Minimum,
+		Maximum:              definition.Maximum,
+		MinLength:            definition.MinLength,
+		MaxLength:            definition.MaxLength,
+		Required:             definition.Required,
+		AdditionalProperties: definition.AdditionalProperties,
+		MinItems:             definition.MinItems,
+		MaxItems:             definition.MaxItems,
+	}
+
+	// Parse the type, given either as a single string or an array of strings
+	switch typeValue := definition.Type.(type) {
+	case nil:
+	case string:
+		schema.Types = []string{typeValue}
+	case []interface{}:
+		for _, element := range typeValue {
+			typeName, ok := element.(string)
+			if !ok {
+				return nil, errors.New("Invalid JSON schema: 'type' must be a string or an array of strings.")
+			}
+
+			schema.Types = append(schema.Types, typeName)
+		}
+	default:
+		return nil, errors.New("Invalid JSON schema: 'type' must be a string or an array of strings.")
+	}
+
+	for _, typeName := range schema.Types {
+		switch typeName {
+		case "null", "boolean", "number", "integer", "string", "array", "object":
+		default:
+			return nil, errors.New("Invalid JSON schema: unsupported type '" + typeName + "'.")
+		}
+	}
+
+	// Compile the pattern
+	if definition.Pattern != nil {
+		schema.Pattern, err = regexp.Compile(*definition.Pattern)
+		if err != nil {
+			return nil, errors.New("Invalid JSON schema: invalid pattern '" + *definition.Pattern + "'.")
+		}
+	}
+
+	// Compile property schemas
+	if definition.Properties != nil {
+		schema.Properties = map[string]*JsonSchema{}
+
+		for propertyName, propertyDefinition := range definition.Properties {
+			if propertyDefinition == nil {
+				continue
+			}
+
+			schema.Properties[propertyName], err = compileJsonSchemaDefinition(propertyDefinition)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// Compile the item schema
+	if definition.Items != nil {
+		schema.Items, err = compileJsonSchemaDefinition(definition.Items)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return
+}
+
+// Validates a decoded JSON value against the schema. The returned error describes the location of the
+// first violation found.
+func (this *JsonSchema) Validate(value interface{}) error {
+	return this.validateAt(value, "")
+}
+
+// Validates a value located at the given path within the root value
+func (this *JsonSchema) validateAt(value interface{}, location string) error {
+	fail := func(format string, args ...interface{}) error {
+		description := "the value"
+
+		if location != "" {
+			description = "'" + location + "'"
+		}
+
+		return errors.New(description + " " + fmt.Sprintf(format, args...))
+	}
+
+	// Check the type
+	if len(this.Types) > 0 {
+		matched := false
+
+		for _, typeName := range this.Types {
+			if jsonValueHasType(value, typeName) {
+				matched = true
+				break
+			}
+		}
+
+		if !matched {
+			return fail("should be of type '%s'", strings.Join(this.Types, "' or '"))
+		}
+	}
+
+	// Check enumerated values
+	if this.Enum != nil {
+		matched := false
+		serializedValue, _ := json.Marshal(value)
+
+		for _, enumValue := range this.Enum {
+			serializedEnumValue, _ := json.Marshal(enumValue)
+
+			if string(serializedValue) == string(serializedEnumValue) {
+				matched = true
+				break
+			}
+		}
+
+		if !matched {
+			return fail("should be one of the enumerated values")
+		}
+	}
+
+	switch typedValue := value.(type) {
+	case float64:
+		if this.Minimum != nil && typedValue < *this.Minimum {
+			return fail("should be greater than or equal to %v", *this.Minimum)
+		}
+
+		if this.Maximum != nil && typedValue > *this.Maximum {
+			return fail("should be lesser than or equal to %v", *this.Maximum)
+		}
+
+	case string:
+		length := utf8.RuneCountInString(typedValue)
+
+		if this.MinLength != nil && length < *this.MinLength {
+			return fail("should have a length of at least %d", *this.MinLength)
+		}
+
+		if this.MaxLength != nil && length > *this.MaxLength {
+			return fail("should have a length of at most %d", *this.MaxLength)
+		}
+
+		if this.Pattern != nil && !this.Pattern.MatchString(typedValue) {
+			return fail("should match the pattern '%s'", this.Pattern.String())
+		}
+
+	case []interface{}:
+		if this.MinItems != nil && len(typedValue) < *this.MinItems {
+			return fail("should have at least %d items", *this.MinItems)
+		}
+
+		if this.MaxItems != nil && len(typedValue) > *this.MaxItems {
+			return fail("should have at most %d items", *this.MaxItems)
+		}
+
+		if this.Items != nil {
+			for index, item := range typedValue {
+				err := this.Items.validateAt(item, fmt.Sprintf("%s[%d]", location, index))
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+	case map[string]interface{}:
+		for _, requiredProperty := range this.Required {
+			if _, exists := typedValue[requiredProperty]; !exists {
+				return fail("should have the property '%s'", requiredProperty)
+			}
+		}
+
+		// Validate properties in a deterministic order
+		propertyNames := []string{}
+		for propertyName := range typedValue {
+			propertyNames = append(propertyNames, propertyName)
+		}
+		sort.Strings(propertyNames)
+
+		for _, propertyName := range propertyNames {
+			propertySchema, defined := this.Properties[propertyName]
+
+			if !defined {
+				if this.AdditionalProperties != nil && !*this.AdditionalProperties {
+					return fail("should not have the property '%s'", propertyName)
+				}
+
+				continue
+			}
+
+			err := propertySchema.validateAt(typedValue[propertyName], location+"."+propertyName)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Checks if a decoded JSON value has the given JSON schema type
+func jsonValueHasType(value interface{}, typeName string) bool {
+	switch typedValue := value.(type) {
+	case nil:
+		return typeName == "null"
+	case bool:
+		return typeName == "boolean"
+	case float64:
+		return typeName == "number" || (typeName == "integer" && typedValue == math.Trunc(typedValue))
+	case string:
+		return typeName == "string"
+	case []interface{}:
+		return typeName == "array"
+	case map[string]interface{}:
+		return typeName == "object"
+	default:
+		return false
+	}
+}
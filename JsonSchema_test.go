@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("JsonSchema", func() {
+	validate := func(schemaString string, valueString string) error {
+		schema, err := ParseJsonSchema([]byte(schemaString))
+		Expect(err).To(BeNil())
+
+		var value interface{}
+		Expect(json.Unmarshal([]byte(valueString), &value)).To(BeNil())
+
+		return schema.Validate(value)
+	}
+
+	It("Validates primitive types and constraints", func() {
+		Expect(validate(`{"type": "integer", "minimum": 1, "maximum": 10}`, `5`)).To(BeNil())
+		Expect(validate(`{"type": "integer"}`, `5.5`)).NotTo(BeNil())
+		Expect(validate(`{"type": "integer", "minimum": 1}`, `0`)).NotTo(BeNil())
+		Expect(validate(`{"type": "integer", "maximum": 10}`, `11`)).NotTo(BeNil())
+		Expect(validate(`{"type": ["string", "null"]}`, `null`)).To(BeNil())
+		Expect(validate(`{"type": "string", "minLength": 2, "maxLength": 4, "pattern": "^[a-z]+$"}`, `"abc"`)).To(BeNil())
+		Expect(validate(`{"type": "string", "maxLength": 2}`, `"abc"`)).NotTo(BeNil())
+		Expect(validate(`{"type": "string", "pattern": "^[a-z]+$"}`, `"ABC"`)).NotTo(BeNil())
+		Expect(validate(`{"enum": ["red", "green", 1]}`, `1`)).To(BeNil())
+		Expect(validate(`{"enum": ["red", "green", 1]}`, `"blue"`)).NotTo(BeNil())
+	})
+
+	It("Validates objects and arrays", func() {
+		schema := `{
+			"type": "object",
+			"required": ["name"],
+			"additionalProperties": false,
+			"properties": {
+				"name": {"type": "string"},
+				"tags": {"type": "array", "maxItems": 2, "items": {"type": "string"}}
+			}
+		}`
+
+		Expect(validate(schema, `{"name": "Alice", "tags": ["a", "b"]}`)).To(BeNil())
+		Expect(validate(schema, `{"tags": []}`)).NotTo(BeNil())
+		Expect(validate(schema, `{"name": "Alice", "age": 30}`)).NotTo(BeNil())
+		Expect(validate(schema, `{"name": "Alice", "tags": ["a", "b", "c"]}`)).NotTo(BeNil())
+
+		err := validate(schema, `{"name": "Alice", "tags": ["a", 2]}`)
+		Expect(err).NotTo(BeNil())
+		Expect(err.Error()).To(ContainSubstring(".tags[1]"))
+	})
+
+	It("Rejects invalid schemas", func() {
+		_, err := ParseJsonSchema([]byte(`{"type": "date"}`))
+		Expect(err).NotTo(BeNil())
+
+		_, err = ParseJsonSchema([]byte(`{"type": "string", "pattern": "("}`))
+		Expect(err).NotTo(BeNil())
+
+		_, err = ParseJsonSchema([]byte(`[]`))
+		Expect(err).NotTo(BeNil())
+	})
+})
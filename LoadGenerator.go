@@ -0,0 +1,543 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Load generator operation names
+const (
+	LoadGeneratorOperation_Post     = "POST"
+	LoadGeneratorOperation_Get      = "GET"
+	LoadGeneratorOperation_LongPoll = "LongPoll"
+)
+
+// The options of a load generator run
+type LoadGeneratorOptions struct {
+	// The URL of the server, e.g. 'http://localhost:1337'
+	HostURL string
+	// The access key to send requests with. Should allow creating and deleting datastores.
+	AccessKey string
+	// The duration to send requests for
+	Duration time.Duration
+	// The number of concurrent request senders
+	Concurrency int
+	// The number of datastores requests are distributed across
+	DatastoreCount int
+	// The number of WebSocket subscribers connected to each datastore
+	SubscribersPerDatastore int
+	// The relative weights of POST, GET and long-poll GET requests in the mix of requests sent
+	PostWeight     int
+	GetWeight      int
+	LongPollWeight int
+	// The number of entries included in each POST request, and their maximum key and value sizes
+	EntriesPerPost int
+	KeySize        int
+	ValueSize      int
+	// The type of entries generated, as accepted by 'GenerateRandomEntry'
+	EntryType string
+	// Don't delete the datastores once the run has completed
+	KeepDatastores bool
+}
+
+func DefaultLoadGeneratorOptions() *LoadGeneratorOptions {
+	return &LoadGeneratorOptions{
+		HostURL:                 "",
+		AccessKey:               "",
+		Duration:                10 * time.Second,
+		Concurrency:             8,
+		DatastoreCount:          4,
+		SubscribersPerDatastore: 2,
+		PostWeight:              5,
+		GetWeight:               4,
+		LongPollWeight:          1,
+		EntriesPerPost:          10,
+		KeySize:                 20,
+		ValueSize:               100,
+		EntryType:               "randomPathEntry",
+		KeepDatastores:          false,
+	}
+}
+
+// Load generator object. Drives a running server with a mix of requests and WebSocket subscribers, and
+// measures throughput, latency and propagation delay.
+type LoadGenerator struct {
+	options *LoadGeneratorOptions
+
+	// A client for each datastore
+	clients []*Client
+
+	// The last commit timestamp known for each datastore. Accessed atomically.
+	lastCommitTimestamps []int64
+
+	// A pool of entry batches sent in POST requests. These are generated in advance, since the random
+	// generator used isn't safe for concurrent use, and to exclude their generation from the results.
+	postBatches [][]Entry
+
+	// Latencies and error counts of each operation
+	latencies   map[string]*LatencyRecorder
+	errorCounts map[string]*int64
+
+	// Delays from sending a POST request to the delivery of its transaction to a WebSocket subscriber
+	propagationTracker *PropagationTracker
+}
+
+// Load generator object constructor function
+func NewLoadGenerator(options *LoadGeneratorOptions) *LoadGenerator {
+	this := &LoadGenerator{
+		options:            options,
+		latencies:          map[string]*LatencyRecorder{},
+		errorCounts:        map[string]*int64{},
+		propagationTracker: NewPropagationTracker(),
+	}
+
+	for _, operation := range []string{LoadGeneratorOperation_Post, LoadGeneratorOperation_Get, LoadGeneratorOperation_LongPoll} {
+		this.latencies[operation] = NewLatencyRecorder()
+		this.errorCounts[operation] = new(int64)
+	}
+
+	return this
+}
+
+// The results of a load generator run
+type LoadGeneratorReport struct {
+	Duration          time.Duration
+	Operations        map[string]*LoadGeneratorOperationReport
+	Propagation       *LatencySummary
+	WebSocketMessages int64
+}
+
+// The results of a single operation in a load generator run
+type LoadGeneratorOperationReport struct {
+	Latency    *LatencySummary
+	Errors     int64
+	Throughput float64
+}
+
+// Runs the load generator: creates the datastores, connects the WebSocket subscribers, sends requests
+// for the configured duration, and finally deletes the datastores, unless they should be kept.
+func (this *LoadGenerator) Run() (report *LoadGeneratorReport, err error) {
+	options := this.options
+
+	if options.DatastoreCount < 1 || options.Concurrency < 1 || options.EntriesPerPost < 1 {
+		return nil, errors.New("The datastore count, concurrency and entries per POST request must be at least 1.")
+	}
+
+	if options.PostWeight < 0 || options.GetWeight < 0 || options.LongPollWeight < 0 || options.PostWeight+options.GetWeight+options.LongPollWeight == 0 {
+		return nil, errors.New("The request weights must be non-negative, and at least one of them must be positive.")
+	}
+
+	// Create a context that is cancelled once the run ends, to abort pending requests
+	runContext, cancelRun := context.WithCancel(context.Background())
+	defer cancelRun()
+
+	// Share connections across the clients, and allow them to remain open between requests
+	httpClient := &http.Client{
+		Transport: &http.Transport{
+			Proxy:               http.ProxyFromEnvironment,
+			MaxIdleConnsPerHost: options.Concurrency,
+		},
+	}
+	defer httpClient.CloseIdleConnections()
+
+	// Generate the entry batches sent in POST requests
+	for i := 0; i < 256; i++ {
+		this.postBatches = append(this.postBatches, GenerateRandomEntries(options.EntriesPerPost, options.KeySize, options.ValueSize, options.EntryType))
+	}
+
+	// Create the datastores. Names are randomized to avoid conflicting with existing datastores.
+	namePrefix := "Bench_" + RandomWordString(8) + "_"
+
+	for i := 0; i < options.DatastoreCount; i++ {
+		client := NewClient(options.HostURL, fmt.Sprintf("%s%d", namePrefix, i), options.AccessKey)
+		client.httpClient = httpClient
+		client.requestContext = runContext
+
+		commitTimestamp, err := client.Put([]Entry{})
+		if err != nil {
+			return nil, errors.New("Failed creating datastore '" + client.datastoreName + "': " + err.Error())
+		}
+
+		this.clients = append(this.clients, client)
+		this.lastCommitTimestamps = append(this.lastCommitTimestamps, commitTimestamp)
+	}
+
+	// Delete the datastores once the run ends, unless they should be kept
+	if !options.KeepDatastores {
+		defer func() {
+			for _, client := range this.clients {
+				client.requestContext = nil
+				client.Delete()
+			}
+		}()
+	}
+
+	// Connect the WebSocket subscribers
+	subscribers := []*loadGeneratorSubscriber{}
+
+	defer func() {
+		for _, subscriber := range subscribers {
+			subscriber.conn.Close()
+		}
+	}()
+
+	for datastoreIndex, client := range this.clients {
+		for i := 0; i < options.SubscribersPerDatastore; i++ {
+			conn, err := client.OpenWebSocketConnection(this.lastCommitTimestamps[datastoreIndex])
+			if err != nil {
+				return nil, errors.New("Failed opening a WebSocket connection to datastore '" + client.datastoreName + "': " + err.Error())
+			}
+
+			subscriber := &loadGeneratorSubscriber{
+				parent:              this,
+				datastoreIndex:      datastoreIndex,
+				conn:                conn,
+				lastCommitTimestamp: this.lastCommitTimestamps[datastoreIndex],
+			}
+
+			subscribers = append(subscribers, subscriber)
+
+			go subscriber.receive()
+		}
+	}
+
+	// Start the request senders, and wait for them to finish
+	startTime := time.Now()
+	deadline := startTime.Add(options.Duration)
+
+	senderWaitGroup := &sync.WaitGroup{}
+
+	for i := 0; i < options.Concurrency; i++ {
+		senderWaitGroup.Add(1)
+
+		go func() {
+			defer senderWaitGroup.Done()
+
+			for time.Now().Before(deadline) {
+				this.sendRandomRequest(runContext)
+			}
+		}()
+	}
+
+	// Cancel pending long-poll requests once the deadline is reached
+	time.AfterFunc(time.Until(deadline), cancelRun)
+
+	senderWaitGroup.Wait()
+	elapsedTime := time.Since(startTime)
+
+	// Give the subscribers a limited amount of time to receive the last transactions
+	for waitStartTime := time.Now(); time.Since(waitStartTime) < 2*time.Second; time.Sleep(10 * time.Millisecond) {
+		caughtUp := true
+
+		for _, subscriber := range subscribers {
+			if atomic.LoadInt64(&subscriber.lastCommitTimestamp) < atomic.LoadInt64(&this.lastCommitTimestamps[subscriber.datastoreIndex]) {
+				caughtUp = false
+				break
+			}
+		}
+
+		if caughtUp {
+			break
+		}
+	}
+
+	// Create the report
+	report = &LoadGeneratorReport{
+		Duration:    elapsedTime,
+		Operations:  map[string]*LoadGeneratorOperationReport{},
+		Propagation: this.propagationTracker.Delays.Summary(),
+	}
+
+	for _, subscriber := range subscribers {
+		report.WebSocketMessages += atomic.LoadInt64(&subscriber.messageCount)
+	}
+
+	for operation, latencies := range this.latencies {
+		latencySummary := latencies.Summary()
+
+		report.Operations[operation] = &LoadGeneratorOperationReport{
+			Latency:    latencySummary,
+			Errors:     atomic.LoadInt64(this.errorCounts[operation]),
+			Throughput: float64(latencySummary.Count) / elapsedTime.Seconds(),
+		}
+	}
+
+	return report, nil
+}
+
+// Sends a single request, of an operation chosen randomly according to the configured weights, to a
+// random datastore
+func (this *LoadGenerator) sendRandomRequest(runContext context.Context) {
+	options := this.options
+
+	datastoreIndex := rand.Intn(len(this.clients))
+	client := this.clients[datastoreIndex]
+
+	// Choose the operation
+	var operation string
+
+	switch choice := rand.Intn(options.PostWeight + options.GetWeight + options.LongPollWeight); {
+	case choice < options.PostWeight:
+		operation = LoadGeneratorOperation_Post
+	case choice < options.PostWeight+options.GetWeight:
+		operation = LoadGeneratorOperation_Get
+	default:
+		operation = LoadGeneratorOperation_LongPoll
+	}
+
+	startTime := time.Now()
+	var err error
+
+	switch operation {
+	case LoadGeneratorOperation_Post:
+		var commitTimestamp int64
+
+		commitTimestamp, err = client.Post(this.postBatches[rand.Intn(len(this.postBatches))])
+		if err == nil {
+			this.propagationTracker.RecordSent(datastoreIndex, commitTimestamp, startTime)
+			atomicMaxInt64(&this.lastCommitTimestamps[datastoreIndex], commitTimestamp)
+		}
+	case LoadGeneratorOperation_Get:
+		_, err = client.Get(0)
+	case LoadGeneratorOperation_LongPoll:
+		_, err = client.GetWhenNonEmpty(atomic.LoadInt64(&this.lastCommitTimestamps[datastoreIndex]))
+	}
+
+	// Requests aborted due to the end of the run aren't counted
+	if runContext.Err() != nil {
+		return
+	}
+
+	if err != nil {
+		atomic.AddInt64(this.errorCounts[operation], 1)
+		return
+	}
+
+	this.latencies[operation].Record(time.Since(startTime))
+}
+
+// A WebSocket subscriber connected by the load generator
+type loadGeneratorSubscriber struct {
+	parent         *LoadGenerator
+	datastoreIndex int
+	conn           *websocket.Conn
+
+	// The last commit timestamp received, and the number of messages received. Accessed atomically.
+	lastCommitTimestamp int64
+	messageCount        int64
+}
+
+// Receives messages until the connection is closed, recording the time each transaction was delivered
+func (this *loadGeneratorSubscriber) receive() {
+	for {
+		messageType, reader, err := this.conn.NextReader()
+		if err != nil {
+			return
+		}
+
+		if messageType != websocket.BinaryMessage {
+			continue
+		}
+
+		entryStreamBytes, err := ReadEntireStream(reader)
+		if err != nil {
+			return
+		}
+
+		deliveryTime := time.Now()
+
+		entries, err := DeserializeEntryStreamBytes(entryStreamBytes)
+		if err != nil {
+			return
+		}
+
+		atomic.AddInt64(&this.messageCount, 1)
+
+		// Record the delivery of each transaction included in the message
+		lastCommitTimestamp := int64(-1)
+
+		for _, entry := range entries {
+			if entry.Header.CommitTime != lastCommitTimestamp {
+				lastCommitTimestamp = entry.Header.CommitTime
+				this.parent.propagationTracker.RecordDelivered(this.datastoreIndex, lastCommitTimestamp, deliveryTime)
+			}
+		}
+
+		if lastCommitTimestamp >= 0 {
+			atomicMaxInt64(&this.lastCommitTimestamp, lastCommitTimestamp)
+		}
+	}
+}
+
+// Atomically sets the value at the given address to the given value, if it is greater
+func atomicMaxInt64(address *int64, value int64) {
+	for {
+		currentValue := atomic.LoadInt64(address)
+
+		if value <= currentValue || atomic.CompareAndSwapInt64(address, currentValue, value) {
+			return
+		}
+	}
+}
+
+// Propagation tracker object. Matches the time transactions were sent with the times they were delivered
+// to subscribers, which may be recorded in either order. Safe for concurrent use.
+type PropagationTracker struct {
+	// The recorded delays
+	Delays *LatencyRecorder
+
+	// Lookup tables taking a datastore index and commit timestamp, and giving the time the transaction
+	// was sent, or the times it was delivered before its send time was known
+	sendTimes         map[propagationTrackerKey]time.Time
+	pendingDeliveries map[propagationTrackerKey][]time.Time
+
+	sync.Mutex
+}
+
+type propagationTrackerKey struct {
+	datastoreIndex  int
+	commitTimestamp int64
+}
+
+// Create a new propagation tracker object
+func NewPropagationTracker() *PropagationTracker {
+	return &PropagationTracker{
+		Delays:            NewLatencyRecorder(),
+		sendTimes:         map[propagationTrackerKey]time.Time{},
+		pendingDeliveries: map[propagationTrackerKey][]time.Time{},
+	}
+}
+
+// Records the time the transaction with the given commit timestamp was sent
+func (this *PropagationTracker) RecordSent(datastoreIndex int, commitTimestamp int64, sendTime time.Time) {
+	this.Lock()
+	defer this.Unlock()
+
+	key := propagationTrackerKey{datastoreIndex, commitTimestamp}
+	this.sendTimes[key] = sendTime
+
+	// Record the delays of deliveries that occurred before the send time was known
+	for _, deliveryTime := range this.pendingDeliveries[key] {
+		this.Delays.Record(deliveryTime.Sub(sendTime))
+	}
+
+	delete(this.pendingDeliveries, key)
+}
+
+// Records the time the transaction with the given commit timestamp was delivered to a subscriber
+func (this *PropagationTracker) RecordDelivered(datastoreIndex int, commitTimestamp int64, deliveryTime time.Time) {
+	this.Lock()
+	defer this.Unlock()
+
+	key := propagationTrackerKey{datastoreIndex, commitTimestamp}
+
+	if sendTime, found := this.sendTimes[key]; found {
+		this.Delays.Record(deliveryTime.Sub(sendTime))
+	} else {
+		this.pendingDeliveries[key] = append(this.pendingDeliveries[key], deliveryTime)
+	}
+}
+
+// Latency recorder object. Collects latency samples. Safe for concurrent use.
+type LatencyRecorder struct {
+	samples []time.Duration
+
+	sync.Mutex
+}
+
+// A summary of latency samples
+type LatencySummary struct {
+	Count int64
+	Mean  time.Duration
+	P50   time.Duration
+	P90   time.Duration
+	P99   time.Duration
+	Max   time.Duration
+}
+
+// Create a new latency recorder object
+func NewLatencyRecorder() *LatencyRecorder {
+	return &LatencyRecorder{
+		samples: []time.Duration{},
+	}
+}
+
+// Records a latency sample
+func (this *LatencyRecorder) Record(latency time.Duration) {
+	this.Lock()
+	this.samples = append(this.samples, latency)
+	this.Unlock()
+}
+
+// Summarizes the recorded samples
+func (this *LatencyRecorder) Summary() *LatencySummary {
+	this.Lock()
+	samples := append([]time.Duration{}, this.samples...)
+	this.Unlock()
+
+	summary := &LatencySummary{
+		Count: int64(len(samples)),
+	}
+
+	if len(samples) == 0 {
+		return summary
+	}
+
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+
+	var total time.Duration
+
+	for _, sample := range samples {
+		total += sample
+	}
+
+	// Gets the sample at the given percentile, using the nearest-rank method
+	percentile := func(p float64) time.Duration {
+		rank := int(math.Ceil(p / 100 * float64(len(samples))))
+
+		if rank < 1 {
+			rank = 1
+		}
+
+		return samples[rank-1]
+	}
+
+	summary.Mean = total / time.Duration(len(samples))
+	summary.P50 = percentile(50)
+	summary.P90 = percentile(90)
+	summary.P99 = percentile(99)
+	summary.Max = samples[len(samples)-1]
+
+	return summary
+}
+
+// Writes the report as human readable text
+func (this *LoadGeneratorReport) WriteText(target io.Writer) {
+	formatLatency := func(latency time.Duration) string {
+		return fmt.Sprintf("%.2fms", float64(latency)/float64(time.Millisecond))
+	}
+
+	fmt.Fprintf(target, "Duration: %.2fs\n\n", this.Duration.Seconds())
+	fmt.Fprintf(target, "%-10s %10s %8s %12s %10s %10s %10s %10s %10s\n", "Operation", "Requests", "Errors", "Requests/s", "Mean", "p50", "p90", "p99", "Max")
+
+	for _, operation := range []string{LoadGeneratorOperation_Post, LoadGeneratorOperation_Get, LoadGeneratorOperation_LongPoll} {
+		operationReport := this.Operations[operation]
+		latency := operationReport.Latency
+
+		fmt.Fprintf(target, "%-10s %10d %8d %12.1f %10s %10s %10s %10s %10s\n", operation, latency.Count, operationReport.Errors, operationReport.Throughput, formatLatency(latency.Mean), formatLatency(latency.P50), formatLatency(latency.P90), formatLatency(latency.P99), formatLatency(latency.Max))
+	}
+
+	fmt.Fprintf(target, "\nWebSocket messages received: %d\n", this.WebSocketMessages)
+	fmt.Fprintf(target, "Propagation delay (POST sent to WebSocket delivery), %d samples: mean %s, p50 %s, p90 %s, p99 %s, max %s\n", this.Propagation.Count, formatLatency(this.Propagation.Mean), formatLatency(this.Propagation.P50), formatLatency(this.Propagation.P90), formatLatency(this.Propagation.P99), formatLatency(this.Propagation.Max))
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("LatencyRecorder", func() {
+	It("Summarizes latency samples", func() {
+		recorder := NewLatencyRecorder()
+
+		for i := 100; i >= 1; i-- {
+			recorder.Record(time.Duration(i) * time.Millisecond)
+		}
+
+		summary := recorder.Summary()
+		Expect(summary.Count).To(Equal(int64(100)))
+		Expect(summary.P50).To(Equal(50 * time.Millisecond))
+		Expect(summary.P90).To(Equal(90 * time.Millisecond))
+		Expect(summary.P99).To(Equal(99 * time.Millisecond))
+		Expect(summary.Max).To(Equal(100 * time.Millisecond))
+		Expect(summary.Mean).To(Equal(50500 * time.Microsecond))
+
+		Expect(NewLatencyRecorder().Summary().Count).To(BeZero())
+	})
+})
+
+var _ = Describe("LoadGenerator", func() {
+	var context *ServerTestContext
+
+	BeforeEach(func() {
+		context = NewServerTestContext()
+		context.Start()
+	})
+
+	AfterEach(func() {
+		context.Stop()
+	})
+
+	It("Drives a running server and reports its performance", func() {
+		options := DefaultLoadGeneratorOptions()
+		options.HostURL = context.hostURL
+		options.Duration = 500 * time.Millisecond
+		options.Concurrency = 4
+		options.DatastoreCount = 2
+
+		report, err := NewLoadGenerator(options).Run()
+		Expect(err).To(BeNil())
+
+		for _, operation := range []string{LoadGeneratorOperation_Post, LoadGeneratorOperation_Get} {
+			Expect(report.Operations[operation].Errors).To(BeZero())
+			Expect(report.Operations[operation].Latency.Count).To(BeNumerically(">", 0))
+			Expect(report.Operations[operation].Throughput).To(BeNumerically(">", 0))
+		}
+
+		// Ensure POST requests were delivered to the WebSocket subscribers
+		Expect(report.WebSocketMessages).To(BeNumerically(">", 0))
+		Expect(report.Propagation.Count).To(BeNumerically(">", 0))
+
+		var output bytes.Buffer
+		report.WriteText(&output)
+		Expect(output.String()).To(ContainSubstring("Propagation delay"))
+	})
+})
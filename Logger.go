@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Supported log formats
+const (
+	LogFormat_Text = "text"
+	LogFormat_JSON = "json"
+)
+
+// Additional fields attached to a log message, e.g. the datastore name or the response status
+type LogFields map[string]interface{}
+
+// A leveled logger writing either plain text lines, or JSON lines including any additional fields given.
+// Safe for concurrent use.
+type Logger struct {
+	output io.Writer
+	format string
+
+	// Make this lockable
+	sync.Mutex
+}
+
+// Logger object constructor function. An unknown format is treated as plain text.
+func NewLogger(output io.Writer, format string) *Logger {
+	if format != LogFormat_JSON {
+		format = LogFormat_Text
+	}
+
+	return &Logger{
+		output: output,
+		format: format,
+	}
+}
+
+// Writes a message with the given level and fields. Checking whether the level is enabled is the
+// responsibility of the caller.
+func (this *Logger) Write(level int, message string, fields LogFields) {
+	var line []byte
+
+	if this.format == LogFormat_JSON {
+		line = formatJsonLogLine(time.Now(), level, message, fields)
+	} else {
+		line = formatTextLogLine(time.Now(), message, fields)
+	}
+
+	this.Lock()
+	this.output.Write(line)
+	this.Unlock()
+}
+
+// Formats a plain text log line, similar to the output of the standard 'log' package. Fields, if any,
+// are appended as 'name=value' pairs, ordered by name.
+func formatTextLogLine(timestamp time.Time, message string, fields LogFields) []byte {
+	var line bytes.Buffer
+
+	line.WriteString(timestamp.Format("2006/01/02 15:04:05 "))
+	line.WriteString(strings.TrimSuffix(message, "\n"))
+
+	for _, name := range sortedLogFieldNames(fields) {
+		line.WriteString(fmt.Sprintf(" %s=%v", name, fields[name]))
+	}
+
+	line.WriteByte('\n')
+
+	return line.Bytes()
+}
+
+// Formats a JSON log line. The 'time', 'level' and 'message' fields are always included, and take
+// precedence over additional fields having the same names.
+func formatJsonLogLine(timestamp time.Time, level int, message string, fields LogFields) []byte {
+	record := make(map[string]interface{}, len(fields)+3)
+
+	for name, value := range fields {
+		record[name] = value
+	}
+
+	record["time"] = timestamp.UTC().Format(time.RFC3339Nano)
+	record["level"] = level
+	record["message"] = strings.TrimSuffix(message, "\n")
+
+	// Map keys are serialized in sorted order, so the output is stable
+	serializedRecord, err := json.Marshal(record)
+	if err != nil {
+		serializedRecord, _ = json.Marshal(map[string]interface{}{
+			"time":    record["time"],
+			"level":   level,
+			"message": record["message"],
+			"error":   "Failed serializing log fields: " + err.Error(),
+		})
+	}
+
+	return append(serializedRecord, '\n')
+}
+
+// Gets the names of the given fields, in sorted order
+func sortedLogFieldNames(fields LogFields) []string {
+	names := make([]string, 0, len(fields))
+
+	for name := range fields {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Logger", func() {
+	It("Writes JSON lines including additional fields", func() {
+		var output bytes.Buffer
+		logger := NewLogger(&output, LogFormat_JSON)
+
+		logger.Write(1, "Handled request\n", LogFields{"datastore": "MyDatastore", "status": 200, "message": "Ignored"})
+		logger.Write(0, "Started", nil)
+
+		lines := bytes.Split(bytes.TrimSuffix(output.Bytes(), []byte("\n")), []byte("\n"))
+		Expect(lines).To(HaveLen(2))
+
+		var record map[string]interface{}
+		Expect(json.Unmarshal(lines[0], &record)).To(BeNil())
+		Expect(record["message"]).To(Equal("Handled request"))
+		Expect(record["level"]).To(Equal(1.0))
+		Expect(record["datastore"]).To(Equal("MyDatastore"))
+		Expect(record["status"]).To(Equal(200.0))
+
+		_, err := time.Parse(time.RFC3339Nano, record["time"].(string))
+		Expect(err).To(BeNil())
+
+		record = nil
+		Expect(json.Unmarshal(lines[1], &record)).To(BeNil())
+		Expect(record).To(HaveLen(3))
+		Expect(record["message"]).To(Equal("Started"))
+	})
+
+	It("Writes plain text lines with fields appended in sorted order", func() {
+		timestamp := time.Date(2017, 10, 10, 13, 55, 36, 0, time.Local)
+
+		Expect(string(formatTextLogLine(timestamp, "Handled request", LogFields{"status": 200, "datastore": "MyDatastore"}))).To(
+			Equal("2017/10/10 13:55:36 Handled request datastore=MyDatastore status=200\n"))
+
+		Expect(string(formatTextLogLine(timestamp, "Started", nil))).To(Equal("2017/10/10 13:55:36 Started\n"))
+	})
+})
@@ -1,11 +1,13 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"os"
 	"os/signal"
 	"path"
+	"strings"
 	"syscall"
 
 	"github.com/pkg/profile"
@@ -15,6 +17,10 @@ var profilerSession interface {
 	Stop()
 }
 
+// The server started by the 'start' command, if any. It is gracefully shut down when a termination
+// signal is received.
+var runningServer *Server
+
 const versionString = "0.4.0"
 
 func main() {
@@ -34,6 +40,14 @@ func main() {
 		parseStartCommand(commandArgs)
 	case "generate":
 		parseGenerateCommand(commandArgs)
+	case "bench":
+		parseBenchCommand(commandArgs)
+	case "export":
+		parseExportCommand(commandArgs)
+	case "import":
+		parseImportCommand(commandArgs)
+	case "config":
+		parseConfigCommand(commandArgs)
 	case "version":
 		fmt.Println(versionString)
 	default:
@@ -43,6 +57,14 @@ func main() {
 		fmt.Println("  \tStart a new server instance.")
 		fmt.Println("  zincserver generate")
 		fmt.Println("  \tGenerate a test datastore (for testing purposes).")
+		fmt.Println("  zincserver bench")
+		fmt.Println("  \tDrive a running server with generated load, and report its performance.")
+		fmt.Println("  zincserver export")
+		fmt.Println("  \tExport a datastore file as newline delimited JSON.")
+		fmt.Println("  zincserver import")
+		fmt.Println("  \tCreate a datastore file from newline delimited JSON.")
+		fmt.Println("  zincserver config")
+		fmt.Println("  \tView or modify configuration datastores while the server isn't running.")
 		fmt.Println("  zincserver version")
 		fmt.Println("  \tPrint version info.")
 	}
@@ -59,14 +81,39 @@ func parseStartCommand(args []string) {
 	commandFlagSet.IntVar(&commandOptions.InsecurePort, "insecurePort", commandOptions.InsecurePort, "Port to use for insecure connections.")
 	commandFlagSet.BoolVar(&commandOptions.InsecureListenerLoopbackOnly, "insecureListenerLoopbackOnly", commandOptions.InsecureListenerLoopbackOnly, "Only accept loopback connections on the insecure listener.")
 
+	commandFlagSet.Var((*serverListenerOptionsFlag)(&commandOptions.Listeners), "listen", "An additional listener, given as '<protocol>://<address>[?<options>]', where the protocol is 'http', 'https', 'http+unix' or 'https+unix' (e.g. 'http://127.0.0.1:8000' or 'http+unix:///var/run/zincserver.sock?mode=0660'). Supported options are 'loopbackOnly' (TCP only), 'mode' (Unix sockets only) and 'proxyProtocol' (expect a PROXY protocol header on each connection, from one of the peers given in 'trustedProxies'). Can be given multiple times.")
+
 	commandFlagSet.IntVar(&commandOptions.SecurePort, "securePort", commandOptions.SecurePort, "Port to use for secure connections. Requires valid 'certFile' and 'keyFile' arguments to be provided as well.")
 	commandFlagSet.BoolVar(&commandOptions.SecureListenerLoopbackOnly, "secureListenerLoopbackOnly", commandOptions.SecureListenerLoopbackOnly, "Only accept loopback connections on the secure listener.")
 	commandFlagSet.StringVar(&commandOptions.CertFile, "certFile", commandOptions.CertFile, "Path to a certificate file (X.509) to use with secure connections.")
 	commandFlagSet.StringVar(&commandOptions.KeyFile, "keyFile", commandOptions.KeyFile, "Path to a private key file (X.509) to use with secure connections.")
+	commandFlagSet.Var((*tlsCertificateFilesFlag)(&commandOptions.AdditionalCertificates), "additionalCertificate", "Additional certificate and private key files, given as '<certFile>,<keyFile>', selected when matching the server name requested by the client (SNI). Can be given multiple times.")
+	commandFlagSet.StringVar(&commandOptions.MinTLSVersion, "minTLSVersion", commandOptions.MinTLSVersion, "Minimum TLS version accepted by the secure listener. Either '1.0', '1.1', '1.2' or '1.3'.")
+	commandFlagSet.StringVar(&commandOptions.TLSCipherSuites, "tlsCipherSuites", commandOptions.TLSCipherSuites, "Comma separated list of cipher suites enabled for TLS 1.0-1.2 connections (e.g. 'TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256'). If empty, a secure default list is used.")
+	commandFlagSet.StringVar(&commandOptions.ClientCAFile, "clientCAFile", commandOptions.ClientCAFile, "Path to a bundle of CA certificates (PEM) to verify client certificates against. If given, clients connecting to the secure listener are requested to send a certificate, which can be mapped to an access profile through the ['datastore']['clientCertificate'][<identity>] setting.")
+	commandFlagSet.BoolVar(&commandOptions.RequireClientCertificate, "requireClientCertificate", commandOptions.RequireClientCertificate, "Reject connections to the secure listener that don't include a valid client certificate. Only relevant when 'clientCAFile' is given.")
 	commandFlagSet.BoolVar(&commandOptions.EnableHTTP2, "enableHTTP2", commandOptions.EnableHTTP2, "Enable HTTP2 support. Only relevant when secure connections are enabled.")
 
-	commandFlagSet.IntVar(&commandOptions.LogLevel, "logLevel", commandOptions.LogLevel, "Logging level.")
+	commandFlagSet.IntVar(&commandOptions.LogLevel, "logLevel", commandOptions.LogLevel, "Logging level. Overridden by the [\"server\",\"log\",\"level\"] global configuration setting, if set.")
+	commandFlagSet.StringVar(&commandOptions.LogFormat, "logFormat", commandOptions.LogFormat, "Log format. Either 'text' or 'json' (one JSON object per line).")
+	commandFlagSet.StringVar(&commandOptions.AccessLogFile, "accessLogFile", commandOptions.AccessLogFile, "Path to a file to write an access log to. If empty, no access log is written.")
+	commandFlagSet.StringVar(&commandOptions.AccessLogFormat, "accessLogFormat", commandOptions.AccessLogFormat, "Access log format. Either 'common' (Common Log Format) or 'json' (one JSON object per line).")
+	commandFlagSet.Int64Var(&commandOptions.AccessLogMaxSize, "accessLogMaxSize", commandOptions.AccessLogMaxSize, "Maximum size (bytes) of the access log file before it is rotated. 0 for no limit.")
+	commandFlagSet.DurationVar(&commandOptions.AccessLogMaxAge, "accessLogMaxAge", commandOptions.AccessLogMaxAge, "Maximum time the access log file is written to before it is rotated (e.g. '24h'). 0 for no limit.")
+	commandFlagSet.IntVar(&commandOptions.AccessLogMaxBackups, "accessLogMaxBackups", commandOptions.AccessLogMaxBackups, "Maximum number of rotated access log files to keep. 0 to keep all of them.")
+	commandFlagSet.DurationVar(&commandOptions.ShutdownTimeout, "shutdownTimeout", commandOptions.ShutdownTimeout, "Maximum time to wait for in-flight requests and datastore writes to complete when shutting down (e.g. '30s'). The server is stopped regardless once it elapses.")
+	commandFlagSet.DurationVar(&commandOptions.ReadHeaderTimeout, "readHeaderTimeout", commandOptions.ReadHeaderTimeout, "Maximum time to read the headers of a request. 0 for no limit.")
+	commandFlagSet.DurationVar(&commandOptions.ReadTimeout, "readTimeout", commandOptions.ReadTimeout, "Maximum time to read an entire request, including its body. 0 for no limit.")
+	commandFlagSet.DurationVar(&commandOptions.WriteTimeout, "writeTimeout", commandOptions.WriteTimeout, "Maximum time from the end of reading a request's headers to the end of writing its response. 0 for no limit.")
+	commandFlagSet.DurationVar(&commandOptions.IdleTimeout, "idleTimeout", commandOptions.IdleTimeout, "Maximum time to wait for the next request on a keep-alive connection. 0 to use 'readTimeout'.")
+	commandFlagSet.DurationVar(&commandOptions.LongPollTimeout, "longPollTimeout", commandOptions.LongPollTimeout, "Maximum time a GET request with 'waitUntilNonempty=true' waits for an update before responding with the currently available entries. Extends 'writeTimeout' for these requests. 0 to wait indefinitely.")
+	commandFlagSet.DurationVar(&commandOptions.WebSocketWriteTimeout, "webSocketWriteTimeout", commandOptions.WebSocketWriteTimeout, "Maximum time to complete a WebSocket handshake, or to send a single WebSocket message. 0 for no limit.")
+	commandFlagSet.IntVar(&commandOptions.MaxHeaderBytes, "maxHeaderBytes", commandOptions.MaxHeaderBytes, "Maximum size (bytes) of the headers of a request.")
+	commandFlagSet.Int64Var(&commandOptions.MaxConnectionsPerIP, "maxConnectionsPerIP", commandOptions.MaxConnectionsPerIP, "Maximum number of connections concurrently open from a single IP, on TCP listeners. Trusted proxies are exempt. 0 for no limit.")
+	commandFlagSet.StringVar(&commandOptions.TrustedProxies, "trustedProxies", commandOptions.TrustedProxies, "Comma separated list of IPs or CIDR ranges of trusted reverse proxies (e.g. '10.0.0.0/8,::1'), or 'unix' to trust peers connecting through a Unix socket. The client address of requests sent by a trusted proxy is taken from their 'Forwarded' or 'X-Forwarded-For' header.")
 	commandFlagSet.BoolVar(&commandOptions.NoAutoMasterKey, "noAutoMasterKey", commandOptions.NoAutoMasterKey, "Suppress generation of a random master key when a default configuration is created. Leave it empty instead (highly insecure, should only be used for testing).")
+	commandFlagSet.IntVar(&commandOptions.BlockProfileRate, "blockProfileRate", commandOptions.BlockProfileRate, "Record blocking events for the block profile served at /admin/debug/pprof/block, sampling one event per the given number of nanoseconds spent blocked. 0 to disable.")
+	commandFlagSet.IntVar(&commandOptions.MutexProfileFraction, "mutexProfileFraction", commandOptions.MutexProfileFraction, "Record mutex contention events for the mutex profile served at /admin/debug/pprof/mutex, sampling one per the given number of events. 0 to disable.")
 	commandFlagSet.BoolVar(&commandOptions.Profile, "profile", commandOptions.Profile, "Profile CPU usage (a report would be generated when the program exists).")
 
 	commandFlagSet.BoolVar(&helpRequested, "help", helpRequested, "Show this help message.")
@@ -94,9 +141,59 @@ func parseStartCommand(args []string) {
 	// Normalize storage path to remove unnecessary slashes and dots
 	commandOptions.StoragePath = path.Clean(commandOptions.StoragePath)
 
-	if commandOptions.InsecurePort == 0 && commandOptions.SecurePort == 0 {
+	if commandOptions.InsecurePort == 0 && commandOptions.SecurePort == 0 && len(commandOptions.Listeners) == 0 {
+		fmt.Println("")
+		fmt.Println("Error: no port specified. At least one of 'insecurePort', 'securePort' or 'listen' arguments must be provided.")
+		fmt.Println("")
+
+		printHelp()
+		return
+	}
+
+	if _, err := ParseTLSVersion(commandOptions.MinTLSVersion); err != nil {
+		fmt.Println("")
+		fmt.Println("Error: " + err.Error())
+		fmt.Println("")
+
+		printHelp()
+		return
+	}
+
+	if _, err := ParseTLSCipherSuites(commandOptions.TLSCipherSuites); err != nil {
+		fmt.Println("")
+		fmt.Println("Error: " + err.Error())
+		fmt.Println("")
+
+		printHelp()
+		return
+	}
+
+	trustedProxies, err := ParseTrustedProxyList(commandOptions.TrustedProxies)
+	if err == nil {
+		err = ValidateProxyProtocolListeners(commandOptions.Listeners, trustedProxies)
+	}
+
+	if err != nil {
+		fmt.Println("")
+		fmt.Println("Error: " + err.Error())
+		fmt.Println("")
+
+		printHelp()
+		return
+	}
+
+	if commandOptions.LogFormat != LogFormat_Text && commandOptions.LogFormat != LogFormat_JSON {
+		fmt.Println("")
+		fmt.Println("Error: invalid log format '" + commandOptions.LogFormat + "'. Should be either 'text' or 'json'.")
+		fmt.Println("")
+
+		printHelp()
+		return
+	}
+
+	if commandOptions.AccessLogFormat != AccessLogFormat_Common && commandOptions.AccessLogFormat != AccessLogFormat_JSON {
 		fmt.Println("")
-		fmt.Println("Error: no port specified. At least one of 'insecurePort' or 'securePort' arguments must be provided.")
+		fmt.Println("Error: invalid access log format '" + commandOptions.AccessLogFormat + "'. Should be either 'common' or 'json'.")
 		fmt.Println("")
 
 		printHelp()
@@ -121,24 +218,39 @@ func parseStartCommand(args []string) {
 	}
 
 	server.Start()
+	runningServer = server
+
 	server.runningStateWaitGroup.Wait()
+
+	// If the listeners were closed due to a graceful shutdown, wait for it to complete before exiting
+	if server.ShuttingDown() {
+		<-server.ShutdownCompleted()
+
+		if profilerSession != nil {
+			profilerSession.Stop()
+		}
+	}
 }
 
 func parseGenerateCommand(args []string) {
 	path := ""
-	entryCount := 100
-	keySize := 20
-	valueSize := 100
-	entryType := "randomPathEntry"
+	commandOptions := DefaultDatastoreGeneratorOptions()
 	showHelp := false
 
 	commandFlagSet := flag.NewFlagSet("generate", flag.PanicOnError)
 
 	commandFlagSet.StringVar(&path, "path", path, "Path of datastore file to generate. (required)")
-	commandFlagSet.IntVar(&entryCount, "entryCount", entryCount, "Number of entries to generate.")
-	commandFlagSet.IntVar(&keySize, "keySize", keySize, "Key size.")
-	commandFlagSet.IntVar(&valueSize, "valueSize", valueSize, "Value size.")
-	commandFlagSet.StringVar(&entryType, "entryType", entryType, `Entry type. Can be either one of "randomPathEntry", "randomPathEntryWithBinaryValue", "randomUTF8Entry", "randomBinaryEntry", "randomAlphanumericEntry" or "randomJSONEntry".`)
+	commandFlagSet.IntVar(&commandOptions.EntryCount, "entryCount", commandOptions.EntryCount, "Number of entries to generate.")
+	commandFlagSet.IntVar(&commandOptions.KeySize, "keySize", commandOptions.KeySize, "Key size.")
+	commandFlagSet.IntVar(&commandOptions.ValueSize, "valueSize", commandOptions.ValueSize, "Value size.")
+	commandFlagSet.StringVar(&commandOptions.EntryType, "entryType", commandOptions.EntryType, `Entry type. Can be either one of "randomPathEntry", "randomPathEntryWithBinaryValue", "randomUTF8Entry", "randomBinaryEntry", "randomAlphanumericEntry" or "randomJSONEntry".`)
+	commandFlagSet.IntVar(&commandOptions.TransactionCount, "transactionCount", commandOptions.TransactionCount, "Number of transactions the entries are divided into.")
+	commandFlagSet.DurationVar(&commandOptions.HistoryDuration, "historyDuration", commandOptions.HistoryDuration, "Time span the commit timestamps of the transactions are spread across, ending at the current time (e.g. '720h').")
+	commandFlagSet.Float64Var(&commandOptions.OverwriteRatio, "overwriteRatio", commandOptions.OverwriteRatio, "Fraction of entries (0..1) overwriting a previously written key.")
+	commandFlagSet.Float64Var(&commandOptions.DeletionRatio, "deletionRatio", commandOptions.DeletionRatio, "Fraction of entries (0..1) deleting a previously written key.")
+	commandFlagSet.StringVar(&commandOptions.KeyDistribution, "keyDistribution", commandOptions.KeyDistribution, "Distribution previously written keys are selected from for overwrites and deletions. Either 'uniform' or 'zipf'.")
+	commandFlagSet.Int64Var(&commandOptions.TargetSize, "targetSize", commandOptions.TargetSize, "If given, entries are generated until the datastore reaches this size (bytes), and 'entryCount' only sets the number of entries per transaction (as 'entryCount / transactionCount').")
+	commandFlagSet.BoolVar(&commandOptions.CorruptTail, "corruptTail", commandOptions.CorruptTail, "Append an incomplete transaction to the end of the datastore, as if a write was interrupted.")
 	commandFlagSet.BoolVar(&showHelp, "help", showHelp, "Show this help message.")
 	commandFlagSet.Parse(args)
 
@@ -150,8 +262,293 @@ func parseGenerateCommand(args []string) {
 		fmt.Println("")
 		commandFlagSet.PrintDefaults()
 	} else {
-		GenerateRandomDatastore(path, entryCount, keySize, valueSize, entryType)
+		GenerateDatastore(path, commandOptions)
+	}
+}
+
+func parseBenchCommand(args []string) {
+	options := DefaultLoadGeneratorOptions()
+	showHelp := false
+
+	commandFlagSet := flag.NewFlagSet("bench", flag.PanicOnError)
+
+	commandFlagSet.StringVar(&options.HostURL, "host", options.HostURL, "URL of a running server, e.g. 'http://localhost:1337'. (required)")
+	commandFlagSet.StringVar(&options.AccessKey, "accessKey", options.AccessKey, "Access key to send requests with. Should allow creating, writing, reading and deleting datastores (e.g. the master key).")
+	commandFlagSet.DurationVar(&options.Duration, "duration", options.Duration, "Duration to send requests for (e.g. '30s').")
+	commandFlagSet.IntVar(&options.Concurrency, "concurrency", options.Concurrency, "Number of requests sent concurrently.")
+	commandFlagSet.IntVar(&options.DatastoreCount, "datastores", options.DatastoreCount, "Number of datastores to create and distribute requests across.")
+	commandFlagSet.IntVar(&options.SubscribersPerDatastore, "subscribers", options.SubscribersPerDatastore, "Number of WebSocket subscribers connected to each datastore.")
+	commandFlagSet.IntVar(&options.PostWeight, "postWeight", options.PostWeight, "Relative weight of POST requests in the mix of requests sent.")
+	commandFlagSet.IntVar(&options.GetWeight, "getWeight", options.GetWeight, "Relative weight of GET requests (of the entire datastore) in the mix of requests sent.")
+	commandFlagSet.IntVar(&options.LongPollWeight, "longPollWeight", options.LongPollWeight, "Relative weight of long-poll GET requests (waiting for the next update) in the mix of requests sent.")
+	commandFlagSet.IntVar(&options.EntriesPerPost, "entriesPerPost", options.EntriesPerPost, "Number of entries included in each POST request.")
+	commandFlagSet.IntVar(&options.KeySize, "keySize", options.KeySize, "Maximum key size.")
+	commandFlagSet.IntVar(&options.ValueSize, "valueSize", options.ValueSize, "Maximum value size.")
+	commandFlagSet.StringVar(&options.EntryType, "entryType", options.EntryType, `Entry type. Can be either one of "randomPathEntry", "randomPathEntryWithBinaryValue", "randomUTF8Entry", "randomBinaryEntry", "randomAlphanumericEntry" or "randomJSONEntry".`)
+	commandFlagSet.BoolVar(&options.KeepDatastores, "keepDatastores", options.KeepDatastores, "Don't delete the created datastores once done.")
+	commandFlagSet.BoolVar(&showHelp, "help", showHelp, "Show this help message.")
+	commandFlagSet.Parse(args)
+
+	if showHelp {
+		commandFlagSet.PrintDefaults()
+		return
+	} else if options.HostURL == "" {
+		fmt.Println("")
+		fmt.Println("Error: no server URL specified. Please specify the URL of a running server using '-host <URL>'.")
+		fmt.Println("")
+		commandFlagSet.PrintDefaults()
+		return
+	}
+
+	options.HostURL = strings.TrimSuffix(options.HostURL, "/")
+
+	fmt.Printf("Sending requests to %s for %s...\n\n", options.HostURL, options.Duration.String())
+
+	report, err := NewLoadGenerator(options).Run()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+		os.Exit(1)
+	}
+
+	report.WriteText(os.Stdout)
+}
+
+func parseExportCommand(args []string) {
+	path := ""
+	outputPath := ""
+	var updatedAfter int64 = 0
+	showHelp := false
+
+	commandFlagSet := flag.NewFlagSet("export", flag.PanicOnError)
+
+	commandFlagSet.StringVar(&path, "path", path, "Path of datastore file to export. (required)")
+	commandFlagSet.StringVar(&outputPath, "output", outputPath, "Path of the output file. If not specified, the records would be written to the standard output.")
+	commandFlagSet.Int64Var(&updatedAfter, "updatedAfter", updatedAfter, "Only include entries committed after the given time (UNIX epoch, microseconds).")
+	commandFlagSet.BoolVar(&showHelp, "help", showHelp, "Show this help message.")
+	commandFlagSet.Parse(args)
+
+	if showHelp {
+		commandFlagSet.PrintDefaults()
+		return
+	} else if path == "" {
+		fmt.Println("")
+		fmt.Println("Error: no datastore path specified. Please specify the datastore file to export using '-path <datastoreFilePath>'.")
+		fmt.Println("")
+		commandFlagSet.PrintDefaults()
+		return
+	}
+
+	output := os.Stdout
+
+	if outputPath != "" {
+		outputFile, err := os.Create(outputPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: failed creating output file '%s': %s\n", outputPath, err.Error())
+			os.Exit(1)
+		}
+
+		defer outputFile.Close()
+		output = outputFile
+	}
+
+	err := ExportDatastoreToJsonRecords(path, output, updatedAfter)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: failed exporting datastore '%s': %s\n", path, err.Error())
+		os.Exit(1)
+	}
+}
+
+func parseImportCommand(args []string) {
+	path := ""
+	inputPath := ""
+	showHelp := false
+
+	commandFlagSet := flag.NewFlagSet("import", flag.PanicOnError)
+
+	commandFlagSet.StringVar(&path, "path", path, "Path of datastore file to create. An existing file would be overwritten. (required)")
+	commandFlagSet.StringVar(&inputPath, "input", inputPath, "Path of a newline delimited JSON file to import. If not specified, the records would be read from the standard input.")
+	commandFlagSet.BoolVar(&showHelp, "help", showHelp, "Show this help message.")
+	commandFlagSet.Parse(args)
+
+	if showHelp {
+		commandFlagSet.PrintDefaults()
+		return
+	} else if path == "" {
+		fmt.Println("")
+		fmt.Println("Error: no target path specified. Please specify a path for the imported datastore using '-path <targetFilePath>'.")
+		fmt.Println("")
+		commandFlagSet.PrintDefaults()
+		return
+	}
+
+	input := os.Stdin
+
+	if inputPath != "" {
+		inputFile, err := os.Open(inputPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: failed opening input file '%s': %s\n", inputPath, err.Error())
+			os.Exit(1)
+		}
+
+		defer inputFile.Close()
+		input = inputFile
 	}
+
+	entryCount, err := ImportDatastoreFromJsonRecords(input, path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: failed importing to datastore '%s': %s\n", path, err.Error())
+		os.Exit(1)
+	}
+
+	fmt.Fprintf(os.Stderr, "Imported %d entries to datastore '%s'.\n", entryCount, path)
+}
+
+func parseConfigCommand(args []string) {
+	action := ""
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		action = args[0]
+		args = args[1:]
+	}
+
+	storagePath := ""
+	datastoreName := ""
+	key := ""
+	value := ""
+	profileName := ""
+	accessKeyHash := ""
+	showHelp := false
+
+	commandFlagSet := flag.NewFlagSet("config", flag.PanicOnError)
+
+	commandFlagSet.StringVar(&storagePath, "storagePath", storagePath, "Root datastore storage directory path. (required)")
+	commandFlagSet.StringVar(&datastoreName, "datastore", datastoreName, "Name of the datastore whose dedicated configuration should be used. If not specified, the global configuration datastore would be used.")
+	commandFlagSet.StringVar(&key, "key", key, `Configuration key, either of the form "['datastore']['flush']['enabled']" or '["datastore","flush","enabled"]'.`)
+	commandFlagSet.StringVar(&value, "value", value, `JSON encoded value (e.g. 'true', '1000', '"Reader"' or '{"type":"integer"}').`)
+	commandFlagSet.StringVar(&profileName, "profile", profileName, "Access profile name.")
+	commandFlagSet.StringVar(&accessKeyHash, "accessKeyHash", accessKeyHash, "Access key hash.")
+	commandFlagSet.BoolVar(&showHelp, "help", showHelp, "Show this help message.")
+	commandFlagSet.Parse(args)
+
+	printHelp := func() {
+		fmt.Println("Usage: zincserver config <action> -storagePath <directory path> [-datastore <name>] [arguments]")
+		fmt.Println("")
+		fmt.Println("Supported actions:")
+		fmt.Println("")
+		fmt.Println("  list")
+		fmt.Println("  \tPrint all configuration keys and values.")
+		fmt.Println("  get -key <key>")
+		fmt.Println("  \tPrint the value of a configuration key.")
+		fmt.Println("  set -key <key> -value <value>")
+		fmt.Println("  \tSet the value of a configuration key.")
+		fmt.Println("  delete -key <key>")
+		fmt.Println("  \tDelete a configuration key.")
+		fmt.Println("  addAccessKey -profile <name>")
+		fmt.Println("  \tGenerate a new access key, associated with the given access profile.")
+		fmt.Println("  setAccessKeyProfile -accessKeyHash <hash> -profile <name>")
+		fmt.Println("  \tAssociate an existing access key hash with the given access profile.")
+		fmt.Println("  rotateMasterKey")
+		fmt.Println("  \tGenerate a new master key, replacing the existing one (global configuration only).")
+		fmt.Println("  migrateKeyHashes")
+		fmt.Println("  \tRewrite all legacy SHA1 access key hashes in every configuration datastore to the keyed HMAC-SHA256 scheme.")
+		fmt.Println("")
+		fmt.Println("Supported arguments:")
+		fmt.Println("")
+		commandFlagSet.PrintDefaults()
+	}
+
+	if showHelp || action == "" {
+		printHelp()
+		return
+	}
+
+	if storagePath == "" {
+		fmt.Println("")
+		fmt.Println("Error: no storage path specified. Please use '-storagePath <directory path>' to specify the root datastore storage directry.")
+		fmt.Println("")
+		printHelp()
+		return
+	}
+
+	switch action {
+	case "list", "get", "set", "delete", "addAccessKey", "setAccessKeyProfile", "rotateMasterKey", "migrateKeyHashes":
+	default:
+		fmt.Println("")
+		fmt.Println("Error: unsupported action '" + action + "'.")
+		fmt.Println("")
+		printHelp()
+		return
+	}
+
+	editor, err := NewConfigEditor(path.Clean(storagePath), datastoreName)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error: "+err.Error())
+		os.Exit(1)
+	}
+
+	// Run the action. The editor is closed before exiting with an error, since exiting would skip any
+	// deferred calls.
+	err = runConfigAction(editor, action, storagePath, key, value, profileName, accessKeyHash)
+	editor.Close()
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error: "+err.Error())
+		os.Exit(1)
+	}
+}
+
+// Runs a 'config' command action using the given editor
+func runConfigAction(editor *ConfigEditor, action string, storagePath string, key string, value string, profileName string, accessKeyHash string) error {
+	switch action {
+	case "list":
+		keys, values, err := editor.List()
+		if err != nil {
+			return err
+		}
+
+		for _, key := range keys {
+			fmt.Printf("%s = %s\n", key, values[key])
+		}
+	case "get":
+		value, err := editor.Get(key)
+		if err != nil {
+			return err
+		}
+
+		fmt.Println(value)
+	case "set":
+		return editor.Set(key, value)
+	case "delete":
+		return editor.Delete(key)
+	case "addAccessKey":
+		newAccessKey, newAccessKeyHash, err := editor.AddAccessKey(profileName)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Added access key '%s' with profile '%s' to '%s'.\n", newAccessKey, profileName, editor.DatastoreName())
+		fmt.Printf("Access key hash: %s\n", newAccessKeyHash)
+	case "setAccessKeyProfile":
+		return editor.SetAccessKeyProfile(accessKeyHash, profileName)
+	case "rotateMasterKey":
+		newMasterKey, newMasterKeyHash, err := editor.RotateMasterKey()
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("New master key: %s\n", newMasterKey)
+		fmt.Printf("Master key hash: %s\n", newMasterKeyHash)
+	case "migrateKeyHashes":
+		migratedCount, err := MigrateAllAccessKeyHashes(path.Clean(storagePath))
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Migrated %d access key hashes.\n", migratedCount)
+	default:
+		return errors.New("Unsupported action '" + action + "'.")
+	}
+
+	return nil
 }
 
 func handleOsSignals() {
@@ -161,10 +558,90 @@ func handleOsSignals() {
 		<-c
 		//fmt.Println("SIGTERM")
 
+		// If a server is running, gracefully shut it down. The main goroutine would exit once completed.
+		// A second signal terminates the process immediately.
+		if runningServer != nil {
+			go func() {
+				<-c
+				os.Exit(1)
+			}()
+
+			runningServer.Shutdown(runningServer.startupOptions.ShutdownTimeout)
+			return
+		}
+
 		if profilerSession != nil {
 			profilerSession.Stop()
 		}
 
 		os.Exit(1)
 	}()
+
+	// Reload the TLS certificates of a running server when SIGHUP is received
+	hangupSignals := make(chan os.Signal, 1)
+	signal.Notify(hangupSignals, syscall.SIGHUP)
+	go func() {
+		for range hangupSignals {
+			if runningServer != nil {
+				runningServer.ReloadCertificates()
+			}
+		}
+	}()
+}
+
+// A flag value holding a list of certificate and key file pairs, each given as '<certFile>,<keyFile>'
+type tlsCertificateFilesFlag []TLSCertificateFiles
+
+func (this *tlsCertificateFilesFlag) String() string {
+	if this == nil {
+		return ""
+	}
+
+	pairs := []string{}
+
+	for _, files := range *this {
+		pairs = append(pairs, files.CertFile+","+files.KeyFile)
+	}
+
+	return strings.Join(pairs, " ")
+}
+
+func (this *tlsCertificateFilesFlag) Set(value string) error {
+	pair := strings.Split(value, ",")
+
+	if len(pair) != 2 || pair[0] == "" || pair[1] == "" {
+		return errors.New("expected '<certFile>,<keyFile>'")
+	}
+
+	*this = append(*this, TLSCertificateFiles{pair[0], pair[1]})
+
+	return nil
+}
+
+// A flag value holding a list of listener options, each given as a listener specification
+type serverListenerOptionsFlag []*ServerListenerOptions
+
+func (this *serverListenerOptionsFlag) String() string {
+	if this == nil {
+		return ""
+	}
+
+	descriptions := []string{}
+
+	for _, listenerOptions := range *this {
+		descriptions = append(descriptions, listenerOptions.String())
+	}
+
+	return strings.Join(descriptions, ", ")
+}
+
+func (this *serverListenerOptionsFlag) Set(value string) error {
+	listenerOptions, err := ParseServerListenerOptions(value)
+	if err != nil {
+		return err
+	}
+
+	*this = append(*this, listenerOptions)
+
+	return nil
 }
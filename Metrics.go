@@ -0,0 +1,311 @@
+package main
+
+import (
+	"bytes"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// A metric that can be exposed in the Prometheus text exposition format
+type Metric interface {
+	WritePrometheusText(target *bytes.Buffer)
+}
+
+///////////////////////////////////////////////////////////////////////////////////////////////////
+/// Counters
+///////////////////////////////////////////////////////////////////////////////////////////////////
+
+// A monotonically increasing counter, optionally partitioned by a set of labels. Safe for concurrent use.
+type MetricsCounter struct {
+	name       string
+	help       string
+	labelNames []string
+
+	// A lookup table taking a serialized set of label values and giving the counter's value for it
+	values map[string]float64
+
+	// Make this lockable
+	sync.Mutex
+}
+
+// Metrics counter object constructor function
+func NewMetricsCounter(name string, help string, labelNames ...string) *MetricsCounter {
+	return &MetricsCounter{
+		name:       name,
+		help:       help,
+		labelNames: labelNames,
+		values:     map[string]float64{},
+	}
+}
+
+// Increments the counter for the given label values by 1
+func (this *MetricsCounter) Inc(labelValues ...string) {
+	this.Add(1, labelValues...)
+}
+
+// Increments the counter for the given label values by the given amount. Negative amounts are ignored.
+func (this *MetricsCounter) Add(amount float64, labelValues ...string) {
+	if amount < 0 {
+		return
+	}
+
+	labels := serializeMetricLabels(this.labelNames, labelValues)
+
+	this.Lock()
+	this.values[labels] += amount
+	this.Unlock()
+}
+
+// Gets the value of the counter for the given label values
+func (this *MetricsCounter) Value(labelValues ...string) float64 {
+	labels := serializeMetricLabels(this.labelNames, labelValues)
+
+	this.Lock()
+	defer this.Unlock()
+
+	return this.values[labels]
+}
+
+// Writes the counter in the Prometheus text format
+func (this *MetricsCounter) WritePrometheusText(target *bytes.Buffer) {
+	this.Lock()
+	defer this.Unlock()
+
+	writeMetricHeader(target, this.name, this.help, "counter")
+
+	// A counter without labels is always reported, even if it was never incremented
+	if len(this.labelNames) == 0 {
+		writeMetricSample(target, this.name, "", this.values[""])
+		return
+	}
+
+	// Otherwise, report each set of label values seen, in a stable order
+	labelSets := make([]string, 0, len(this.values))
+
+	for labels := range this.values {
+		labelSets = append(labelSets, labels)
+	}
+
+	sort.Strings(labelSets)
+
+	for _, labels := range labelSets {
+		writeMetricSample(target, this.name, labels, this.values[labels])
+	}
+}
+
+///////////////////////////////////////////////////////////////////////////////////////////////////
+/// Gauges
+///////////////////////////////////////////////////////////////////////////////////////////////////
+
+// A value that can go up and down. Safe for concurrent use.
+type MetricsGauge struct {
+	name  string
+	help  string
+	value float64
+
+	// Make this lockable
+	sync.Mutex
+}
+
+// Metrics gauge object constructor function
+func NewMetricsGauge(name string, help string) *MetricsGauge {
+	return &MetricsGauge{
+		name: name,
+		help: help,
+	}
+}
+
+// Sets the gauge to the given value
+func (this *MetricsGauge) Set(value float64) {
+	this.Lock()
+	this.value = value
+	this.Unlock()
+}
+
+// Adds the given amount, which may be negative, to the gauge
+func (this *MetricsGauge) Add(amount float64) {
+	this.Lock()
+	this.value += amount
+	this.Unlock()
+}
+
+// Gets the current value of the gauge
+func (this *MetricsGauge) Value() float64 {
+	this.Lock()
+	defer this.Unlock()
+
+	return this.value
+}
+
+// Writes the gauge in the Prometheus text format
+func (this *MetricsGauge) WritePrometheusText(target *bytes.Buffer) {
+	this.Lock()
+	defer this.Unlock()
+
+	writeMetricHeader(target, this.name, this.help, "gauge")
+	writeMetricSample(target, this.name, "", this.value)
+}
+
+///////////////////////////////////////////////////////////////////////////////////////////////////
+/// Histograms
+///////////////////////////////////////////////////////////////////////////////////////////////////
+
+// A histogram counting observed values in cumulative buckets. Safe for concurrent use.
+type MetricsHistogram struct {
+	name string
+	help string
+
+	// The upper bounds of the buckets, in increasing order (the implicit '+Inf' bucket isn't included)
+	upperBounds []float64
+	// The number of observations falling in each bucket, not including the ones in preceding buckets
+	bucketCounts []uint64
+
+	sum   float64
+	count uint64
+
+	// Make this lockable
+	sync.Mutex
+}
+
+// Metrics histogram object constructor function. The given bucket upper bounds are sorted and duplicates removed.
+func NewMetricsHistogram(name string, help string, upperBounds []float64) *MetricsHistogram {
+	sortedUpperBounds := []float64{}
+
+	for _, upperBound := range upperBounds {
+		if !math.IsInf(upperBound, 1) {
+			sortedUpperBounds = append(sortedUpperBounds, upperBound)
+		}
+	}
+
+	sort.Float64s(sortedUpperBounds)
+	sortedUpperBounds = uniqueSortedFloat64s(sortedUpperBounds)
+
+	return &MetricsHistogram{
+		name:         name,
+		help:         help,
+		upperBounds:  sortedUpperBounds,
+		bucketCounts: make([]uint64, len(sortedUpperBounds)),
+	}
+}
+
+// Records an observed value
+func (this *MetricsHistogram) Observe(value float64) {
+	// Find the first bucket whose upper bound is greater than or equal to the value
+	bucketIndex := sort.SearchFloat64s(this.upperBounds, value)
+
+	this.Lock()
+	defer this.Unlock()
+
+	if bucketIndex < len(this.bucketCounts) {
+		this.bucketCounts[bucketIndex]++
+	}
+
+	this.sum += value
+	this.count++
+}
+
+// Gets the number of observed values
+func (this *MetricsHistogram) Count() uint64 {
+	this.Lock()
+	defer this.Unlock()
+
+	return this.count
+}
+
+// Writes the histogram in the Prometheus text format
+func (this *MetricsHistogram) WritePrometheusText(target *bytes.Buffer) {
+	this.Lock()
+	defer this.Unlock()
+
+	writeMetricHeader(target, this.name, this.help, "histogram")
+
+	// Write the cumulative count of each bucket
+	var cumulativeCount uint64
+
+	for i, upperBound := range this.upperBounds {
+		cumulativeCount += this.bucketCounts[i]
+		writeMetricSample(target, this.name+"_bucket", `le="`+formatMetricValue(upperBound)+`"`, float64(cumulativeCount))
+	}
+
+	writeMetricSample(target, this.name+"_bucket", `le="+Inf"`, float64(this.count))
+	writeMetricSample(target, this.name+"_sum", "", this.sum)
+	writeMetricSample(target, this.name+"_count", "", float64(this.count))
+}
+
+///////////////////////////////////////////////////////////////////////////////////////////////////
+/// Text format utilities
+///////////////////////////////////////////////////////////////////////////////////////////////////
+
+var metricLabelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// Serializes the given label names and values to the form 'name1="value1",name2="value2"'. Missing
+// values are treated as empty strings.
+func serializeMetricLabels(labelNames []string, labelValues []string) string {
+	var result bytes.Buffer
+
+	for i, labelName := range labelNames {
+		labelValue := ""
+
+		if i < len(labelValues) {
+			labelValue = labelValues[i]
+		}
+
+		if i > 0 {
+			result.WriteByte(',')
+		}
+
+		result.WriteString(labelName)
+		result.WriteString(`="`)
+		result.WriteString(metricLabelValueEscaper.Replace(labelValue))
+		result.WriteByte('"')
+	}
+
+	return result.String()
+}
+
+// Writes the 'HELP' and 'TYPE' lines of a metric
+func writeMetricHeader(target *bytes.Buffer, name string, help string, metricType string) {
+	target.WriteString("# HELP " + name + " " + strings.Replace(help, "\n", " ", -1) + "\n")
+	target.WriteString("# TYPE " + name + " " + metricType + "\n")
+}
+
+// Writes a single sample line, with the given serialized labels
+func writeMetricSample(target *bytes.Buffer, name string, labels string, value float64) {
+	target.WriteString(name)
+
+	if labels != "" {
+		target.WriteString("{" + labels + "}")
+	}
+
+	target.WriteString(" " + formatMetricValue(value) + "\n")
+}
+
+// Formats a sample value
+func formatMetricValue(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	default:
+		return strconv.FormatFloat(value, 'g', -1, 64)
+	}
+}
+
+// Removes consecutive duplicates from a sorted slice
+func uniqueSortedFloat64s(values []float64) []float64 {
+	result := values[:0]
+
+	for i, value := range values {
+		if i == 0 || value != values[i-1] {
+			result = append(result, value)
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Metrics", func() {
+	It("Writes labeled counters in the Prometheus text format", func() {
+		counter := NewMetricsCounter("test_requests_total", "Number of test requests.", "method", "status")
+
+		counter.Inc("POST", "200")
+		counter.Inc("GET", "200")
+		counter.Add(2, "GET", "200")
+		counter.Add(-1, "GET", "200")
+		counter.Inc("GET", `"quoted"`)
+
+		Expect(counter.Value("GET", "200")).To(Equal(3.0))
+
+		var output bytes.Buffer
+		counter.WritePrometheusText(&output)
+
+		Expect(output.String()).To(Equal(`# HELP test_requests_total Number of test requests.
+# TYPE test_requests_total counter
+test_requests_total{method="GET",status="200"} 3
+test_requests_total{method="GET",status="\"quoted\""} 1
+test_requests_total{method="POST",status="200"} 1
+`))
+	})
+
+	It("Writes gauges and unlabeled counters even when they were never updated", func() {
+		counter := NewMetricsCounter("test_total", "A counter.")
+		gauge := NewMetricsGauge("test_gauge", "A gauge.")
+
+		var output bytes.Buffer
+		counter.WritePrometheusText(&output)
+		gauge.WritePrometheusText(&output)
+
+		Expect(output.String()).To(Equal(`# HELP test_total A counter.
+# TYPE test_total counter
+test_total 0
+# HELP test_gauge A gauge.
+# TYPE test_gauge gauge
+test_gauge 0
+`))
+
+		gauge.Add(2.5)
+		gauge.Add(-1)
+		Expect(gauge.Value()).To(Equal(1.5))
+	})
+
+	It("Counts histogram observations in cumulative buckets", func() {
+		histogram := NewMetricsHistogram("test_duration_seconds", "Test durations.", []float64{1, 0.5, 1})
+
+		histogram.Observe(0.25)
+		histogram.Observe(0.5)
+		histogram.Observe(0.75)
+		histogram.Observe(3)
+
+		Expect(histogram.Count()).To(Equal(uint64(4)))
+
+		var output bytes.Buffer
+		histogram.WritePrometheusText(&output)
+
+		Expect(output.String()).To(Equal(`# HELP test_duration_seconds Test durations.
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{le="0.5"} 2
+test_duration_seconds_bucket{le="1"} 3
+test_duration_seconds_bucket{le="+Inf"} 4
+test_duration_seconds_sum 4.5
+test_duration_seconds_count 4
+`))
+	})
+})
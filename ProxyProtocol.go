@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// The signature starting a PROXY protocol version 2 header
+var proxyProtocolV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// The maximum length of a PROXY protocol version 1 header line, including the terminating CRLF
+const proxyProtocolV1MaxHeaderLength = 107
+
+// Reads a PROXY protocol header (version 1 or 2) sent by a proxy at the start of a connection, and gets
+// the address of the client it was received from. Returns a nil address if the header doesn't carry
+// one (e.g. a version 1 'UNKNOWN' header or a version 2 'LOCAL' command), in which case the address of
+// the connection itself should be used.
+func ReadProxyProtocolHeader(reader *bufio.Reader) (net.Addr, error) {
+	// Peek the first bytes to determine the header version
+	prefix, err := reader.Peek(len(proxyProtocolV2Signature))
+
+	if bytes.Equal(prefix, proxyProtocolV2Signature) {
+		return readProxyProtocolV2Header(reader)
+	}
+
+	if len(prefix) >= 6 && string(prefix[:6]) == "PROXY " {
+		return readProxyProtocolV1Header(reader)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, errors.New("The connection didn't start with a PROXY protocol header.")
+}
+
+// Reads a PROXY protocol version 1 header, of the form 'PROXY TCP4 <src> <dst> <srcport> <dstport>\r\n'
+func readProxyProtocolV1Header(reader *bufio.Reader) (net.Addr, error) {
+	line := []byte{}
+
+	// Read the header line, byte by byte, ensuring it doesn't exceed the maximum length
+	for !bytes.HasSuffix(line, []byte("\r\n")) {
+		if len(line) >= proxyProtocolV1MaxHeaderLength {
+			return nil, errors.New("The PROXY protocol header exceeds the maximum length.")
+		}
+
+		b, err := reader.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+
+		line = append(line, b)
+	}
+
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, errors.New("Invalid PROXY protocol header '" + string(line[:len(line)-2]) + "'.")
+	}
+
+	sourceIP := net.ParseIP(fields[2])
+	sourcePort, err := strconv.ParseUint(fields[4], 10, 16)
+
+	if sourceIP == nil || err != nil {
+		return nil, errors.New("Invalid source address in PROXY protocol header '" + string(line[:len(line)-2]) + "'.")
+	}
+
+	return &net.TCPAddr{IP: sourceIP, Port: int(sourcePort)}, nil
+}
+
+// Reads a PROXY protocol version 2 (binary) header
+func readProxyProtocolV2Header(reader *bufio.Reader) (net.Addr, error) {
+	// Read the fixed part of the header: the signature, the version and command byte, the address
+	// family and protocol byte, and the length of the remaining part
+	fixedPart := make([]byte, 16)
+
+	_, err := io.ReadFull(reader, fixedPart)
+	if err != nil {
+		return nil, err
+	}
+
+	versionAndCommand := fixedPart[12]
+	familyAndProtocol := fixedPart[13]
+	remainingLength := binary.BigEndian.Uint16(fixedPart[14:16])
+
+	if versionAndCommand>>4 != 2 {
+		return nil, errors.New("Unsupported PROXY protocol version.")
+	}
+
+	// Read the remaining part, containing the addresses and any additional fields
+	remainingPart := make([]byte, remainingLength)
+
+	_, err = io.ReadFull(reader, remainingPart)
+	if err != nil {
+		return nil, err
+	}
+
+	switch versionAndCommand & 0x0f {
+	// The 'LOCAL' command, sent for connections established by the proxy itself (e.g. health checks)
+	case 0x0:
+		return nil, nil
+	// The 'PROXY' command
+	case 0x1:
+	default:
+		return nil, errors.New("Unsupported PROXY protocol command.")
+	}
+
+	switch familyAndProtocol >> 4 {
+	// IPv4: source address, destination address, source port and destination port
+	case 0x1:
+		if len(remainingPart) < 12 {
+			return nil, errors.New("Invalid PROXY protocol IPv4 address block.")
+		}
+
+		return &net.TCPAddr{IP: net.IP(remainingPart[0:4]), Port: int(binary.BigEndian.Uint16(remainingPart[8:10]))}, nil
+	// IPv6: source address, destination address, source port and destination port
+	case 0x2:
+		if len(remainingPart) < 36 {
+			return nil, errors.New("Invalid PROXY protocol IPv6 address block.")
+		}
+
+		return &net.TCPAddr{IP: net.IP(remainingPart[0:16]), Port: int(binary.BigEndian.Uint16(remainingPart[32:34]))}, nil
+	// Unspecified or Unix socket addresses
+	default:
+		return nil, nil
+	}
+}
+
+// A connection received through a proxy using the PROXY protocol. Data buffered while reading the
+// header is read first, and the address given in the header is reported as the remote address.
+type proxyProtocolConnection struct {
+	net.Conn
+	reader     *bufio.Reader
+	remoteAddr net.Addr
+}
+
+// Reads the PROXY protocol header from the given connection and wraps it
+func newProxyProtocolConnection(conn net.Conn) (*proxyProtocolConnection, error) {
+	reader := bufio.NewReader(conn)
+
+	remoteAddr, err := ReadProxyProtocolHeader(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	if remoteAddr == nil {
+		remoteAddr = conn.RemoteAddr()
+	}
+
+	return &proxyProtocolConnection{
+		Conn:       conn,
+		reader:     reader,
+		remoteAddr: remoteAddr,
+	}, nil
+}
+
+func (this *proxyProtocolConnection) Read(data []byte) (int, error) {
+	return this.reader.Read(data)
+}
+
+func (this *proxyProtocolConnection) RemoteAddr() net.Addr {
+	return this.remoteAddr
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// Builds a PROXY protocol version 2 header for a TCP over IPv4 connection from the given source address
+func buildProxyProtocolV2Header(sourceIP string, sourcePort uint16) []byte {
+	header := &bytes.Buffer{}
+	header.Write(proxyProtocolV2Signature)
+	header.Write([]byte{0x21, 0x11})
+	binary.Write(header, binary.BigEndian, uint16(12))
+	header.Write(net.ParseIP(sourceIP).To4())
+	header.Write(net.ParseIP("10.0.0.1").To4())
+	binary.Write(header, binary.BigEndian, sourcePort)
+	binary.Write(header, binary.BigEndian, uint16(8000))
+
+	return header.Bytes()
+}
+
+var _ = Describe("ProxyProtocol", func() {
+	It("Reads version 1 headers", func() {
+		reader := bufio.NewReader(strings.NewReader("PROXY TCP4 203.0.113.7 10.0.0.1 56324 8000\r\nGET / HTTP/1.1\r\n"))
+
+		address, err := ReadProxyProtocolHeader(reader)
+		Expect(err).To(BeNil())
+		Expect(address.String()).To(Equal("203.0.113.7:56324"))
+
+		// Ensure the data following the header is retained
+		remainder, _ := reader.ReadString('\n')
+		Expect(remainder).To(Equal("GET / HTTP/1.1\r\n"))
+
+		address, err = ReadProxyProtocolHeader(bufio.NewReader(strings.NewReader("PROXY TCP6 2001:db8::17 ::1 4711 8000\r\n")))
+		Expect(err).To(BeNil())
+		Expect(address.String()).To(Equal("[2001:db8::17]:4711"))
+
+		address, err = ReadProxyProtocolHeader(bufio.NewReader(strings.NewReader("PROXY UNKNOWN\r\n")))
+		Expect(err).To(BeNil())
+		Expect(address).To(BeNil())
+
+		for _, invalidHeader := range []string{
+			"GET / HTTP/1.1\r\n",
+			"PROXY TCP4 203.0.113.7 10.0.0.1 56324\r\n",
+			"PROXY TCP4 invalid 10.0.0.1 56324 8000\r\n",
+			"PROXY TCP4 " + strings.Repeat("1", 200) + "\r\n",
+		} {
+			_, err = ReadProxyProtocolHeader(bufio.NewReader(strings.NewReader(invalidHeader)))
+			Expect(err).NotTo(BeNil(), invalidHeader)
+		}
+	})
+
+	It("Reads version 2 headers", func() {
+		reader := bufio.NewReader(bytes.NewReader(append(buildProxyProtocolV2Header("203.0.113.7", 56324), []byte("GET")...)))
+
+		address, err := ReadProxyProtocolHeader(reader)
+		Expect(err).To(BeNil())
+		Expect(address.String()).To(Equal("203.0.113.7:56324"))
+
+		remainder, _ := reader.ReadString('T')
+		Expect(remainder).To(Equal("GET"))
+
+		// Ensure a 'LOCAL' command doesn't give an address
+		localHeader := append(append([]byte{}, proxyProtocolV2Signature...), 0x20, 0x00, 0x00, 0x00)
+
+		address, err = ReadProxyProtocolHeader(bufio.NewReader(bytes.NewReader(localHeader)))
+		Expect(err).To(BeNil())
+		Expect(address).To(BeNil())
+	})
+})
+
+var _ = Describe("Server", func() {
+	var context *ServerTestContext
+
+	BeforeEach(func() {
+		context = NewServerTestContext()
+		context.startupOptions.TrustedProxies = "127.0.0.1"
+		context.startupOptions.Listeners = []*ServerListenerOptions{
+			&ServerListenerOptions{Protocol: "http", Network: "tcp", Address: "127.0.0.1:12347", LoopbackOnly: true, ProxyProtocol: true},
+		}
+		context.Start()
+	})
+
+	AfterEach(func() {
+		context.Stop()
+	})
+
+	It("Identifies clients by the address given in PROXY protocol headers", func() {
+		sendRequest := func(header []byte) string {
+			conn, err := net.Dial("tcp", "127.0.0.1:12347")
+			Expect(err).To(BeNil())
+			defer conn.Close()
+
+			_, err = conn.Write(append(header, []byte("GET /datastore/"+RandomWordString(12)+" HTTP/1.1\r\nHost: localhost\r\n\r\n")...))
+			Expect(err).To(BeNil())
+
+			conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			statusLine, _ := bufio.NewReader(conn).ReadString('\n')
+
+			return strings.TrimSpace(statusLine)
+		}
+
+		// Ensure the loopback restriction applies to the client's address
+		Expect(sendRequest([]byte("PROXY TCP4 203.0.113.7 127.0.0.1 56324 12347\r\n"))).To(Equal("HTTP/1.1 403 Forbidden"))
+		Expect(sendRequest(buildProxyProtocolV2Header("203.0.113.7", 56324))).To(Equal("HTTP/1.1 403 Forbidden"))
+		Expect(sendRequest([]byte("PROXY TCP4 127.0.0.2 127.0.0.1 56324 12347\r\n"))).To(Equal("HTTP/1.1 404 Not Found"))
+
+		// Ensure connections without a header are closed
+		Expect(sendRequest(nil)).To(Equal(""))
+
+		// Ensure the regular listener is unaffected
+		response, err := http.Get("http://127.0.0.1:12345/datastore/" + RandomWordString(12))
+		Expect(err).To(BeNil())
+		response.Body.Close()
+		Expect(response.StatusCode).To(Equal(http.StatusNotFound))
+	})
+})
+
+var _ = Describe("Server", func() {
+	var context *ServerTestContext
+
+	BeforeEach(func() {
+		context = NewServerTestContext()
+		context.startupOptions.Listeners = []*ServerListenerOptions{
+			&ServerListenerOptions{Protocol: "http", Network: "tcp", Address: "127.0.0.1:12347", ProxyProtocol: true},
+		}
+	})
+
+	It("Refuses to start a PROXY protocol listener without trusted proxies", func() {
+		Expect(context.Start).To(Panic())
+	})
+
+	It("Closes PROXY protocol connections from peers other than trusted proxies", func() {
+		context.startupOptions.TrustedProxies = "10.0.0.0/8"
+		context.Start()
+		defer context.Stop()
+
+		conn, err := net.Dial("tcp", "127.0.0.1:12347")
+		Expect(err).To(BeNil())
+		defer conn.Close()
+
+		_, err = conn.Write([]byte("PROXY TCP4 127.0.0.1 127.0.0.1 56324 12347\r\nGET /datastore/" + RandomWordString(12) + " HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+		Expect(err).To(BeNil())
+
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		statusLine, _ := bufio.NewReader(conn).ReadString('\n')
+		Expect(statusLine).To(Equal(""))
+	})
+})
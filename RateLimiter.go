@@ -1,35 +1,65 @@
 package main
 
 import (
+	"math"
 	"sync"
 )
 
-// Rate limiter definition
+// Rate limiter definition. Uses a token bucket for each key: a bucket holds up to 'limit' tokens, and is
+// refilled continuously at a rate of 'limit' tokens per 'timeInterval' milliseconds.
 type RateLimiter struct {
 	// A lookup table taking a client id and giving a rate limiter entry
 	idLookup map[string]*RateLimiterEntry
 
+	// The minimal interval, in milliseconds, between successive evictions of idle entries
+	evictionInterval int64
+	// The last time idle entries were evicted
+	lastEvictionTime int64
+
 	// Make this lockable
 	sync.Mutex
 }
 
 // Rate limiter lookup entry definition
 type RateLimiterEntry struct {
-	// Time the last measurement has starter
-	StartTime int64
-	// Number of events counted
-	Count int64
+	// Number of tokens available in the bucket. May be negative if a cost greater than the available
+	// tokens was consumed.
+	Tokens float64
+	// Time the tokens were last updated
+	UpdateTime int64
+	// The bucket's capacity
+	Limit int64
+	// The time interval (milliseconds) it takes to refill an empty bucket
+	TimeInterval int64
+}
+
+// The result of processing an event
+type RateLimiterResult struct {
+	// True if the event was allowed
+	Allowed bool
+	// Number of whole tokens remaining in the bucket
+	Remaining int64
+	// Time (milliseconds) until enough tokens would be available for the event, if it wasn't allowed
+	RetryAfter int64
 }
 
 // Create a new rate limiter object
 func NewRateLimiter() *RateLimiter {
 	return &RateLimiter{
-		idLookup: map[string]*RateLimiterEntry{},
+		idLookup:         map[string]*RateLimiterEntry{},
+		evictionInterval: 10000,
 	}
 }
 
 // Process an event
 func (this *RateLimiter) ProcessEvent(datastoreName string, clientID string, operation string, timeInterval int64, limit int64) bool {
+	return this.ProcessWeightedEvent(datastoreName, clientID, operation, timeInterval, limit, 1).Allowed
+}
+
+// Process an event consuming the given number of tokens. An event is allowed if the bucket holds at least
+// as many tokens as its cost, or is full, in case the cost is greater than the bucket's capacity. Consuming
+// more tokens than available leaves the bucket in debt, which is repaid before further events are allowed.
+func (this *RateLimiter) ProcessWeightedEvent(datastoreName string, clientID string, operation string, timeInterval int64, limit int64, cost int64) RateLimiterResult {
 	// Lock this object
 	this.Lock()
 
@@ -42,33 +72,100 @@ func (this *RateLimiter) ProcessEvent(datastoreName string, clientID string, ope
 	// Record the current time
 	currentTime := MonoUnixTimeMilli()
 
+	// Evict idle entries, if needed
+	this.evictIdleEntriesIfNeeded(currentTime)
+
 	// Look for an existing entry for the given key
 	entry, found := this.idLookup[key]
 
-	if !found { // If an existing entry wasn't found
-		// Create a new entry with current time
+	if !found || entry.Limit != limit || entry.TimeInterval != timeInterval { // If an existing entry wasn't found, or its settings changed
+		// Create a new entry with a full bucket
 		entry = &RateLimiterEntry{
-			StartTime: currentTime,
-			Count:     0,
+			Tokens:       float64(limit),
+			UpdateTime:   currentTime,
+			Limit:        limit,
+			TimeInterval: timeInterval,
 		}
 
 		// Add the new entry
 		this.idLookup[key] = entry
+	} else {
+		// Otherwise, refill the bucket for the time passed since it was last updated
+		entry.refill(currentTime)
+	}
+
+	// A bucket with no capacity never allows any event
+	if limit <= 0 {
+		return RateLimiterResult{Allowed: false, Remaining: 0, RetryAfter: timeInterval}
+	}
+
+	// Calculate the number of tokens required for the event to be allowed
+	requiredTokens := float64(cost)
+
+	if requiredTokens > float64(limit) {
+		requiredTokens = float64(limit)
+	}
+
+	// If there aren't enough tokens
+	if entry.Tokens < requiredTokens {
+		// Calculate the time until there would be enough of them
+		retryAfter := int64(math.Ceil((requiredTokens - entry.Tokens) * float64(timeInterval) / float64(limit)))
+
+		return RateLimiterResult{Allowed: false, Remaining: entry.remaining(), RetryAfter: retryAfter}
+	}
+
+	// Otherwise, consume the tokens
+	entry.Tokens -= float64(cost)
+
+	return RateLimiterResult{Allowed: true, Remaining: entry.remaining()}
+}
 
-		// Otherwise if the start time of the entry has expired relative to the given interval
-	} else if currentTime > entry.StartTime+timeInterval {
-		// Reset the entry
-		entry.Count = 0
-		entry.StartTime = currentTime
+// Refill the entry's bucket for the time passed since it was last updated
+func (this *RateLimiterEntry) refill(currentTime int64) {
+	if this.TimeInterval <= 0 {
+		this.Tokens = float64(this.Limit)
+	} else if currentTime > this.UpdateTime {
+		this.Tokens += float64(currentTime-this.UpdateTime) * float64(this.Limit) / float64(this.TimeInterval)
 	}
 
-	// If the event count has reached or passed the limit
-	if entry.Count >= limit {
-		// Return false
-		return false
-	} else { // Otherwise
-		// Increment the count
-		entry.Count += 1
-		return true
+	if this.Tokens > float64(this.Limit) {
+		this.Tokens = float64(this.Limit)
 	}
+
+	this.UpdateTime = currentTime
+}
+
+// Get the number of whole tokens remaining in the entry's bucket
+func (this *RateLimiterEntry) remaining() int64 {
+	if this.Tokens < 0 {
+		return 0
+	}
+
+	return int64(this.Tokens)
+}
+
+// Removes entries whose buckets would have been refilled by now, if enough time has passed since the
+// last eviction. These are equivalent to newly created entries. Assumes the object is already locked.
+func (this *RateLimiter) evictIdleEntriesIfNeeded(currentTime int64) {
+	if currentTime < this.lastEvictionTime+this.evictionInterval {
+		return
+	}
+
+	for key, entry := range this.idLookup {
+		entry.refill(currentTime)
+
+		if entry.Tokens >= float64(entry.Limit) {
+			delete(this.idLookup, key)
+		}
+	}
+
+	this.lastEvictionTime = currentTime
+}
+
+// Get the number of entries currently tracked
+func (this *RateLimiter) EntryCount() int {
+	this.Lock()
+	defer this.Unlock()
+
+	return len(this.idLookup)
 }
@@ -28,4 +28,41 @@ var _ = Describe("RateLimiter", func() {
 
 		Eventually(func() bool { return rateLimiter.ProcessEvent("SomeDatastore", "SomeUser", "Open Door", 50, 2) }).Should(BeTrue())
 	})
+
+	It("Refills tokens gradually rather than at window edges", func() {
+		rateLimiter := NewRateLimiter()
+
+		Expect(rateLimiter.ProcessWeightedEvent("SomeDatastore", "SomeUser", "Open Door", 100000, 2, 1)).To(Equal(RateLimiterResult{Allowed: true, Remaining: 1}))
+		Expect(rateLimiter.ProcessWeightedEvent("SomeDatastore", "SomeUser", "Open Door", 100000, 2, 1)).To(Equal(RateLimiterResult{Allowed: true, Remaining: 0}))
+
+		result := rateLimiter.ProcessWeightedEvent("SomeDatastore", "SomeUser", "Open Door", 100000, 2, 1)
+		Expect(result.Allowed).To(BeFalse())
+		Expect(result.RetryAfter).To(BeNumerically(">", 40000))
+		Expect(result.RetryAfter).To(BeNumerically("<=", 50000))
+	})
+
+	It("Allows events costing more than the limit when the bucket is full, leaving it in debt", func() {
+		rateLimiter := NewRateLimiter()
+
+		Expect(rateLimiter.ProcessWeightedEvent("SomeDatastore", "SomeUser", "Read", 100000, 100, 250).Allowed).To(BeTrue())
+
+		result := rateLimiter.ProcessWeightedEvent("SomeDatastore", "SomeUser", "Read", 100000, 100, 1)
+		Expect(result.Allowed).To(BeFalse())
+		Expect(result.Remaining).To(Equal(int64(0)))
+		Expect(result.RetryAfter).To(BeNumerically(">", 100000))
+	})
+
+	It("Evicts idle entries", func() {
+		rateLimiter := NewRateLimiter()
+		rateLimiter.evictionInterval = 0
+
+		Expect(rateLimiter.ProcessEvent("SomeDatastore", "SomeUser", "Open Door", 20, 2)).To(BeTrue())
+		Expect(rateLimiter.ProcessEvent("SomeDatastore", "SomeOtherUser", "Open Door", 100000, 2)).To(BeTrue())
+		Expect(rateLimiter.EntryCount()).To(Equal(2))
+
+		Eventually(func() int {
+			rateLimiter.ProcessEvent("SomeDatastore", "SomeOtherUser", "Open Door", 100000, 2)
+			return rateLimiter.EntryCount()
+		}).Should(Equal(1))
+	})
 })
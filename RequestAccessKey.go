@@ -0,0 +1,106 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gorilla/websocket"
+)
+
+// Sources an access key can be included in
+const (
+	AccessKeySource_None                = ""
+	AccessKeySource_Query               = "query"
+	AccessKeySource_AuthorizationHeader = "authorizationHeader"
+	AccessKeySource_WebSocketProtocol   = "webSocketProtocol"
+)
+
+// The prefix of a WebSocket subprotocol token carrying an access key, e.g. "accessKey.912ec803b2ce49e4a541068d495ab570".
+// Browsers cannot set custom headers for WebSocket requests, so this provides an alternative to the query string.
+const AccessKeyWebSocketProtocolPrefix = "accessKey."
+
+// Gets the access key included in the request, along with the source it was found in. A key may be given
+// either in the 'accessKey' query parameter, an 'Authorization: Bearer <key>' header, or, for WebSocket
+// requests, a WebSocket subprotocol token. Including a key in more than one source is an error.
+func GetRequestAccessKey(r *http.Request, parsedQuery url.Values) (accessKey string, source string, err error) {
+	// Look for a key in the query string
+	if queryAccessKey := parsedQuery.Get("accessKey"); queryAccessKey != "" {
+		accessKey = queryAccessKey
+		source = AccessKeySource_Query
+	}
+
+	// Look for a key in the 'Authorization' header
+	if authorizationHeader := r.Header.Get("Authorization"); authorizationHeader != "" {
+		if len(authorizationHeader) < 7 || !strings.EqualFold(authorizationHeader[:7], "Bearer ") {
+			return "", AccessKeySource_None, errors.New("Unsupported authorization scheme. The 'Authorization' header should be of the form 'Bearer <accessKey>'.")
+		}
+
+		if source != AccessKeySource_None {
+			return "", AccessKeySource_None, errors.New("An access key may only be included once in a request.")
+		}
+
+		accessKey = strings.TrimSpace(authorizationHeader[7:])
+		source = AccessKeySource_AuthorizationHeader
+	}
+
+	// Look for a key in the requested WebSocket subprotocols
+	if protocolAccessKey, found := getWebSocketProtocolAccessKey(r); found {
+		if source != AccessKeySource_None {
+			return "", AccessKeySource_None, errors.New("An access key may only be included once in a request.")
+		}
+
+		accessKey = protocolAccessKey
+		source = AccessKeySource_WebSocketProtocol
+	}
+
+	return
+}
+
+// Checks if the configuration allows access keys from the given source. Keys in the query string can be
+// rejected by setting "['server']['accessKey']['rejectInQuery']" to true in the global configuration.
+func CheckAccessKeySourceAllowed(config *DatastoreConfigSnapshot, source string) error {
+	if source != AccessKeySource_Query {
+		return nil
+	}
+
+	rejectInQuery, _ := config.GetBool_GlobalOnly("['server']['accessKey']['rejectInQuery']")
+
+	if rejectInQuery {
+		return errors.New("Access keys are not accepted in the query string. Please use an 'Authorization: Bearer <accessKey>' header instead.")
+	}
+
+	return nil
+}
+
+// Gets the WebSocket subprotocol token carrying an access key, if the request included one
+func getWebSocketAccessKeyProtocol(r *http.Request) (protocol string, found bool) {
+	if strings.ToLower(r.Header.Get("Upgrade")) != "websocket" {
+		return "", false
+	}
+
+	for _, protocol := range websocket.Subprotocols(r) {
+		if strings.HasPrefix(protocol, AccessKeyWebSocketProtocolPrefix) {
+			return protocol, true
+		}
+	}
+
+	return "", false
+}
+
+// Gets the access key included in the WebSocket subprotocol tokens, if the request included one
+func getWebSocketProtocolAccessKey(r *http.Request) (accessKey string, found bool) {
+	protocol, found := getWebSocketAccessKeyProtocol(r)
+	if !found {
+		return "", false
+	}
+
+	return protocol[len(AccessKeyWebSocketProtocolPrefix):], true
+}
+
+// Checks if the given request header may include an access key, and thus shouldn't be logged
+func isAccessKeyBearingHeader(headerName string) bool {
+	canonicalName := http.CanonicalHeaderKey(headerName)
+	return canonicalName == "Authorization" || canonicalName == "Sec-Websocket-Protocol"
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The format of the timestamp suffix given to rotated files
+const rotatedFileTimestampFormat = "20060102T150405.000000000"
+
+// The default time to wait before retrying a failed rotation
+const defaultRotationRetryInterval = 10 * time.Second
+
+var rotatedFileNameRegexp *regexp.Regexp
+
+func init() {
+	rotatedFileNameRegexp = regexp.MustCompile(`^\.[0-9]{8}T[0-9]{6}\.[0-9]{9}$`)
+}
+
+// A writer appending to a file, which is rotated once it reaches a maximum size or age. Rotated files are
+// renamed to '<path>.<timestamp>', and the oldest ones are removed once there are more than a given number
+// of them. Safe for concurrent use.
+type RotatingFileWriter struct {
+	filePath string
+
+	// Maximum size (bytes) of the file before it is rotated, or 0 for no limit
+	maxSize int64
+	// Maximum time the file is written to before it is rotated, or 0 for no limit
+	maxAge time.Duration
+	// Maximum number of rotated files to keep, or 0 to keep all of them
+	maxBackups int
+
+	file     *os.File
+	size     int64
+	openTime time.Time
+	closed   bool
+
+	// Time to wait before retrying a failed rotation, and the earliest time the next rotation may be
+	// attempted
+	rotationRetryInterval   time.Duration
+	nextRotationAttemptTime time.Time
+
+	// The function used to rename the file when it is rotated
+	rename func(oldPath string, newPath string) error
+
+	// Make this lockable
+	sync.Mutex
+}
+
+// Rotating file writer object constructor function. Opens the file for appending, creating it if needed.
+func NewRotatingFileWriter(filePath string, maxSize int64, maxAge time.Duration, maxBackups int) (*RotatingFileWriter, error) {
+	writer := &RotatingFileWriter{
+		filePath:   filePath,
+		maxSize:    maxSize,
+		maxAge:     maxAge,
+		maxBackups: maxBackups,
+
+		rotationRetryInterval: defaultRotationRetryInterval,
+		rename:                os.Rename,
+	}
+
+	err := writer.open()
+	if err != nil {
+		return nil, err
+	}
+
+	return writer, nil
+}
+
+// Writes the given data to the file, rotating it first if needed. Data is never split between files.
+// If the rotation fails, the data is appended to the current file, and the rotation is retried once
+// the retry interval has passed.
+func (this *RotatingFileWriter) Write(data []byte) (int, error) {
+	this.Lock()
+	defer this.Unlock()
+
+	if this.closed {
+		return 0, os.ErrClosed
+	}
+
+	// If a previous rotation failed to open the file, try opening it again
+	if this.file == nil {
+		err := this.open()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	// Rotate the file if writing the data would exceed the maximum size, or the file is too old. An empty
+	// file is never rotated due to its size, to ensure data larger than the maximum size can be written.
+	sizeExceeded := this.maxSize > 0 && this.size > 0 && this.size+int64(len(data)) > this.maxSize
+	ageExceeded := this.maxAge > 0 && time.Since(this.openTime) >= this.maxAge
+
+	if (sizeExceeded || ageExceeded) && !time.Now().Before(this.nextRotationAttemptTime) {
+		err := this.rotate()
+
+		if err != nil {
+			this.nextRotationAttemptTime = time.Now().Add(this.rotationRetryInterval)
+
+			// If no file could be opened, the data can't be written
+			if this.file == nil {
+				return 0, err
+			}
+		}
+	}
+
+	written, err := this.file.Write(data)
+	this.size += int64(written)
+
+	return written, err
+}
+
+// Closes the file
+func (this *RotatingFileWriter) Close() error {
+	this.Lock()
+	defer this.Unlock()
+
+	this.closed = true
+
+	if this.file == nil {
+		return nil
+	}
+
+	err := this.file.Close()
+	this.file = nil
+
+	return err
+}
+
+// Opens the file for appending. Assumes the object is already locked.
+func (this *RotatingFileWriter) open() error {
+	file, err := os.OpenFile(this.filePath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+
+	fileInfo, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	this.file = file
+	this.size = fileInfo.Size()
+	this.openTime = time.Now()
+
+	return nil
+}
+
+// Renames the current file, opens a new one in its place, and removes excess rotated files. If the
+// file couldn't be renamed, it is reopened, such that writes would continue to append to it. Assumes
+// the object is already locked.
+func (this *RotatingFileWriter) rotate() error {
+	err := this.file.Close()
+	this.file = nil
+
+	// Rename the current file, using a timestamp that sorts chronologically
+	if err == nil {
+		rotatedFilePath := this.filePath + "." + time.Now().UTC().Format(rotatedFileTimestampFormat)
+		err = this.rename(this.filePath, rotatedFilePath)
+	}
+
+	if err != nil {
+		// Reopen the original file, retaining its open time, so the file's age is still measured from
+		// the time it was first opened
+		openTime := this.openTime
+
+		if this.open() == nil {
+			this.openTime = openTime
+		}
+
+		return err
+	}
+
+	err = this.open()
+	if err != nil {
+		return err
+	}
+
+	return this.removeExcessBackups()
+}
+
+// Removes the oldest rotated files, such that at most 'maxBackups' of them remain. Assumes the object
+// is already locked.
+func (this *RotatingFileWriter) removeExcessBackups() error {
+	if this.maxBackups <= 0 {
+		return nil
+	}
+
+	backupFilePaths, err := this.backupFilePaths()
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < len(backupFilePaths)-this.maxBackups; i++ {
+		err = os.Remove(backupFilePaths[i])
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Gets the paths of the rotated files, from oldest to newest
+func (this *RotatingFileWriter) backupFilePaths() ([]string, error) {
+	directoryPath, fileName := filepath.Split(this.filePath)
+
+	if directoryPath == "" {
+		directoryPath = "."
+	}
+
+	directoryEntries, err := ioutil.ReadDir(directoryPath)
+	if err != nil {
+		return nil, err
+	}
+
+	results := []string{}
+
+	for _, directoryEntry := range directoryEntries {
+		name := directoryEntry.Name()
+
+		if !directoryEntry.IsDir() && strings.HasPrefix(name, fileName) && rotatedFileNameRegexp.MatchString(name[len(fileName):]) {
+			results = append(results, filepath.Join(directoryPath, name))
+		}
+	}
+
+	sort.Strings(results)
+
+	return results, nil
+}
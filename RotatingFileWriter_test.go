@@ -0,0 +1,124 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("RotatingFileWriter", func() {
+	var directoryPath string
+
+	BeforeEach(func() {
+		var err error
+		directoryPath, err = ioutil.TempDir("", "RotatingFileWriter")
+		Expect(err).To(BeNil())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(directoryPath)
+	})
+
+	It("Rotates the file once it reaches the maximum size, and keeps the given number of rotated files", func() {
+		filePath := filepath.Join(directoryPath, "access.log")
+
+		writer, err := NewRotatingFileWriter(filePath, 10, 0, 2)
+		Expect(err).To(BeNil())
+		defer writer.Close()
+
+		for _, line := range []string{"aaaa\n", "bbbb\n", "cccc\n", "dddddddddddddddd\n", "eeee\n", "ffff\n"} {
+			_, err = writer.Write([]byte(line))
+			Expect(err).To(BeNil())
+		}
+
+		backupFilePaths, err := writer.backupFilePaths()
+		Expect(err).To(BeNil())
+		Expect(backupFilePaths).To(HaveLen(2))
+
+		// The first rotated file, containing "aaaa\nbbbb\n", should have been removed. A line larger than the
+		// maximum size is written to a file of its own.
+		Expect(ioutil.ReadFile(backupFilePaths[0])).To(Equal([]byte("cccc\n")))
+		Expect(ioutil.ReadFile(backupFilePaths[1])).To(Equal([]byte("dddddddddddddddd\n")))
+		Expect(ioutil.ReadFile(filePath)).To(Equal([]byte("eeee\nffff\n")))
+	})
+
+	It("Rotates the file once it reaches the maximum age", func() {
+		filePath := filepath.Join(directoryPath, "access.log")
+
+		writer, err := NewRotatingFileWriter(filePath, 0, 20*time.Millisecond, 0)
+		Expect(err).To(BeNil())
+		defer writer.Close()
+
+		_, err = writer.Write([]byte("first\n"))
+		Expect(err).To(BeNil())
+
+		time.Sleep(30 * time.Millisecond)
+
+		_, err = writer.Write([]byte("second\n"))
+		Expect(err).To(BeNil())
+
+		backupFilePaths, err := writer.backupFilePaths()
+		Expect(err).To(BeNil())
+		Expect(backupFilePaths).To(HaveLen(1))
+
+		Expect(ioutil.ReadFile(backupFilePaths[0])).To(Equal([]byte("first\n")))
+		Expect(ioutil.ReadFile(filePath)).To(Equal([]byte("second\n")))
+	})
+
+	It("Keeps appending to the file when it can't be renamed, and retries the rotation later", func() {
+		filePath := filepath.Join(directoryPath, "access.log")
+
+		writer, err := NewRotatingFileWriter(filePath, 10, 0, 0)
+		Expect(err).To(BeNil())
+		defer writer.Close()
+
+		writer.rotationRetryInterval = 20 * time.Millisecond
+		writer.rename = func(oldPath string, newPath string) error {
+			return errors.New("Rename failed.")
+		}
+
+		for _, line := range []string{"aaaa\n", "bbbb\n", "cccc\n", "dddd\n"} {
+			_, err = writer.Write([]byte(line))
+			Expect(err).To(BeNil())
+		}
+
+		Expect(writer.backupFilePaths()).To(BeEmpty())
+		Expect(ioutil.ReadFile(filePath)).To(Equal([]byte("aaaa\nbbbb\ncccc\ndddd\n")))
+
+		// Once renaming succeeds again, the file should be rotated after the retry interval has passed
+		writer.rename = os.Rename
+		time.Sleep(30 * time.Millisecond)
+
+		_, err = writer.Write([]byte("eeee\n"))
+		Expect(err).To(BeNil())
+
+		backupFilePaths, err := writer.backupFilePaths()
+		Expect(err).To(BeNil())
+		Expect(backupFilePaths).To(HaveLen(1))
+
+		Expect(ioutil.ReadFile(backupFilePaths[0])).To(Equal([]byte("aaaa\nbbbb\ncccc\ndddd\n")))
+		Expect(ioutil.ReadFile(filePath)).To(Equal([]byte("eeee\n")))
+	})
+
+	It("Appends to an existing file", func() {
+		filePath := filepath.Join(directoryPath, "access.log")
+		Expect(ioutil.WriteFile(filePath, []byte("existing\n"), 0644)).To(BeNil())
+
+		writer, err := NewRotatingFileWriter(filePath, 100, 0, 0)
+		Expect(err).To(BeNil())
+
+		_, err = writer.Write([]byte("appended\n"))
+		Expect(err).To(BeNil())
+		Expect(writer.Close()).To(BeNil())
+
+		Expect(ioutil.ReadFile(filePath)).To(Equal([]byte("existing\nappended\n")))
+
+		_, err = writer.Write([]byte("closed\n"))
+		Expect(err).To(Equal(os.ErrClosed))
+	})
+})
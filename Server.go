@@ -1,17 +1,15 @@
 package main
 
 import (
-	"crypto/rand"
 	"crypto/tls"
-	"encoding/hex"
 	"errors"
 	"fmt"
-	"log"
-	"net"
 	"net/http"
 	"os"
+	"runtime"
 	"strings"
 	"sync"
+	"time"
 )
 
 type ServerStartupOptions struct {
@@ -19,11 +17,35 @@ type ServerStartupOptions struct {
 	InsecureListenerLoopbackOnly bool
 	SecurePort                   int
 	SecureListenerLoopbackOnly   bool
+	Listeners                    []*ServerListenerOptions
 	CertFile                     string
 	KeyFile                      string
+	AdditionalCertificates       []TLSCertificateFiles
+	MinTLSVersion                string
+	TLSCipherSuites              string
+	ClientCAFile                 string
+	RequireClientCertificate     bool
 	EnableHTTP2                  bool
 	StoragePath                  string
 	LogLevel                     int
+	LogFormat                    string
+	AccessLogFile                string
+	AccessLogFormat              string
+	AccessLogMaxSize             int64
+	AccessLogMaxAge              time.Duration
+	AccessLogMaxBackups          int
+	ShutdownTimeout              time.Duration
+	ReadHeaderTimeout            time.Duration
+	ReadTimeout                  time.Duration
+	WriteTimeout                 time.Duration
+	IdleTimeout                  time.Duration
+	LongPollTimeout              time.Duration
+	WebSocketWriteTimeout        time.Duration
+	MaxHeaderBytes               int
+	MaxConnectionsPerIP          int64
+	TrustedProxies               string
+	BlockProfileRate             int
+	MutexProfileFraction         int
 	NoAutoMasterKey              bool
 	Profile                      bool
 }
@@ -34,11 +56,35 @@ func DefaultServerStartupOptions() *ServerStartupOptions {
 		InsecureListenerLoopbackOnly: false,
 		SecurePort:                   0,
 		SecureListenerLoopbackOnly:   false,
+		Listeners:                    []*ServerListenerOptions{},
 		CertFile:                     "cert.pem",
 		KeyFile:                      "key.pem",
+		AdditionalCertificates:       []TLSCertificateFiles{},
+		MinTLSVersion:                "1.2",
+		TLSCipherSuites:              "",
+		ClientCAFile:                 "",
+		RequireClientCertificate:     false,
 		EnableHTTP2:                  false,
 		StoragePath:                  "",
 		LogLevel:                     1,
+		LogFormat:                    LogFormat_Text,
+		AccessLogFile:                "",
+		AccessLogFormat:              AccessLogFormat_Common,
+		AccessLogMaxSize:             100000000,
+		AccessLogMaxAge:              24 * time.Hour,
+		AccessLogMaxBackups:          10,
+		ShutdownTimeout:              30 * time.Second,
+		ReadHeaderTimeout:            10 * time.Second,
+		ReadTimeout:                  2 * time.Minute,
+		WriteTimeout:                 2 * time.Minute,
+		IdleTimeout:                  2 * time.Minute,
+		LongPollTimeout:              5 * time.Minute,
+		WebSocketWriteTimeout:        1 * time.Minute,
+		MaxHeaderBytes:               64 * 1024,
+		MaxConnectionsPerIP:          256,
+		TrustedProxies:               "",
+		BlockProfileRate:             0,
+		MutexProfileFraction:         0,
 		NoAutoMasterKey:              false,
 		Profile:                      false,
 	}
@@ -50,26 +96,78 @@ type Server struct {
 	datastores       map[string]*DatastoreOperations
 	datastoreMapLock *sync.Mutex
 
-	insecureListener *ServerListener
-	secureListener   *ServerListener
+	// The running listeners and their HTTP servers. Requests may already be served on earlier listeners
+	// while later ones are started, so both are guarded by a lock.
+	listeners     []*ServerListener
+	httpServers   []*http.Server
+	listenersLock *sync.Mutex
 
-	runningStateWaitGroup *sync.WaitGroup
-	bannedIPs             map[string]bool
-	rateLimiter           *RateLimiter
+	certificateStore *TLSCertificateStore
+
+	runningStateWaitGroup      *sync.WaitGroup
+	webSocketConnections       *WebSocketConnectionSet
+	ipBanList                  *IPBanList
+	rateLimiter                *RateLimiter
+	webSocketConnectionLimiter *ConnectionLimiter
+	ipConnectionLimiter        *ConnectionLimiter
+	trustedProxies             *TrustedProxyList
+	metrics                    *ServerMetrics
+
+	logger    *Logger
+	accessLog *AccessLog
+
+	// The time the server was started
+	startTime time.Time
+
+	// Set to 1 once a graceful shutdown has been initiated. Accessed atomically.
+	shuttingDown      int32
+	shutdownInitiated chan struct{}
+	shutdownCompleted chan struct{}
+
+	// The last time audit records were checked for exceeding their maximum age. Only accessed within
+	// the audit datastore's writer queue.
+	lastAuditRetentionCheckTime int64
+
+	accessKeyHashSecret     []byte
+	accessKeyHashSecretLock *sync.Mutex
+
+	accessTokenKeySetCache *AccessTokenKeySetCache
+
+	// A lock for each principal having a storage quota, serializing its quota checks and writes
+	storageQuotaLocks     map[string]*sync.Mutex
+	storageQuotaLocksLock *sync.Mutex
 }
 
 func NewServer(startupOptions *ServerStartupOptions) *Server {
 	return &Server{
-		startupOptions:        startupOptions,
-		datastores:            make(map[string]*DatastoreOperations),
-		datastoreMapLock:      &sync.Mutex{},
-		runningStateWaitGroup: &sync.WaitGroup{},
-		bannedIPs:             make(map[string]bool),
-		rateLimiter:           NewRateLimiter(),
+		startupOptions:             startupOptions,
+		datastores:                 make(map[string]*DatastoreOperations),
+		datastoreMapLock:           &sync.Mutex{},
+		listenersLock:              &sync.Mutex{},
+		runningStateWaitGroup:      &sync.WaitGroup{},
+		webSocketConnections:       NewWebSocketConnectionSet(),
+		ipBanList:                  NewIPBanList(),
+		rateLimiter:                NewRateLimiter(),
+		webSocketConnectionLimiter: NewConnectionLimiter(),
+		ipConnectionLimiter:        NewConnectionLimiter(),
+		metrics:                    NewServerMetrics(),
+
+		logger:            NewLogger(os.Stderr, startupOptions.LogFormat),
+		shutdownInitiated: make(chan struct{}),
+		shutdownCompleted: make(chan struct{}),
+
+		accessKeyHashSecretLock: &sync.Mutex{},
+
+		accessTokenKeySetCache: NewAccessTokenKeySetCache(),
+
+		storageQuotaLocks:     make(map[string]*sync.Mutex),
+		storageQuotaLocksLock: &sync.Mutex{},
 	}
 }
 
 func (this *Server) Start() {
+	this.startTime = time.Now()
+
 	if this.startupOptions.StoragePath == "" {
 		panic("No storage path specified.")
 	}
@@ -81,6 +179,12 @@ func (this *Server) Start() {
 		panic("The specified storage path '" + this.startupOptions.StoragePath + "' does not exist.")
 	}
 
+	// Load or create the access key hash secret
+	accessKeyHashSecret, err := this.getAccessKeyHashSecret()
+	if err != nil {
+		panic(err)
+	}
+
 	// Load global configuration datastore
 	globalConfigDatastore := this.GetDatastoreOperations(".config")
 	_, err = globalConfigDatastore.LoadIfNeeded(false)
@@ -91,17 +195,21 @@ func (this *Server) Start() {
 			newMasterKey := ""
 
 			if !this.startupOptions.NoAutoMasterKey {
-				newMasterKeyBytes := make([]byte, 16)
-				rand.Read(newMasterKeyBytes)
-				newMasterKey = hex.EncodeToString(newMasterKeyBytes)
+				newMasterKey = GenerateRandomAccessKey()
 			}
 
 			this.Log(0, "No configuration datastore found.")
 			this.Log(0, "Creating default one with master key '"+newMasterKey+"'.")
 			this.Log(0, "")
 
+			// Hash the master key using the default scheme
+			accessKeyHasher, err := NewAccessKeyHasher(accessKeyHashSecret, AccessKeyHashScheme_HMACSHA256)
+			if err != nil {
+				panic(err)
+			}
+
 			// Initialize default configuration datastore
-			defaultConfigBytes := DefaultServerConfig(newMasterKey)
+			defaultConfigBytes := DefaultServerConfig(accessKeyHasher.Hash(newMasterKey))
 
 			timestamp := MonoUnixTimeMicro()
 			err = ValidateAndPrepareTransaction(defaultConfigBytes, timestamp, 0)
@@ -123,87 +231,194 @@ func (this *Server) Start() {
 		panic(errors.New("Failed loading or creating global configuration datastore"))
 	}
 
-	if this.startupOptions.SecurePort > 0 {
-		cer, err := tls.LoadX509KeyPair(this.startupOptions.CertFile, this.startupOptions.KeyFile)
+	// Open the access log file, if one was specified
+	if this.startupOptions.AccessLogFile != "" {
+		accessLogWriter, err := NewRotatingFileWriter(this.startupOptions.AccessLogFile, this.startupOptions.AccessLogMaxSize, this.startupOptions.AccessLogMaxAge, this.startupOptions.AccessLogMaxBackups)
 		if err != nil {
 			panic(err)
 		}
 
-		tlsConfig := tls.Config{
-			Certificates: []tls.Certificate{cer},
-		}
+		this.accessLog = NewAccessLog(accessLogWriter, this.startupOptions.AccessLogFormat)
+	}
+
+	// Enable the collection of block and mutex profiles, if requested. These are served by the
+	// profiling endpoints.
+	if this.startupOptions.BlockProfileRate > 0 {
+		runtime.SetBlockProfileRate(this.startupOptions.BlockProfileRate)
+	}
+
+	if this.startupOptions.MutexProfileFraction > 0 {
+		runtime.SetMutexProfileFraction(this.startupOptions.MutexProfileFraction)
+	}
+
+	// Parse the trusted proxy list
+	this.trustedProxies, err = ParseTrustedProxyList(this.startupOptions.TrustedProxies)
+	if err != nil {
+		panic(err)
+	}
+
+	// Ensure PROXY protocol listeners have trusted proxies, otherwise any client could spoof its address
+	err = ValidateProxyProtocolListeners(this.allListenerOptions(), this.trustedProxies)
+	if err != nil {
+		panic(err)
+	}
 
-		if this.startupOptions.EnableHTTP2 {
-			tlsConfig.NextProtos = []string{"h2"}
+	// Start the listeners. The TLS configuration is only created if there is a secure listener.
+	var tlsConfig *tls.Config
+
+	for _, listenerOptions := range this.allListenerOptions() {
+		if listenerOptions.Protocol == "https" && tlsConfig == nil {
+			tlsConfig, err = this.createTLSConfig()
+			if err != nil {
+				panic(err)
+			}
 		}
 
-		listenerAddress := fmt.Sprintf(":%d", this.startupOptions.SecurePort)
-		tlsListener, err := tls.Listen("tcp", listenerAddress, &tlsConfig)
+		err = this.startListener(listenerOptions, tlsConfig)
 		if err != nil {
 			panic(err)
 		}
+	}
+}
 
-		this.secureListener = NewServerListener(this, tlsListener, "https", this.startupOptions.SecureListenerLoopbackOnly)
-
-		this.runningStateWaitGroup.Add(1)
-		go func() {
-			http.Serve(this.secureListener, NewServerHandler(this))
-			this.runningStateWaitGroup.Done()
-			this.Logf(0, "Secure listener at port %d has been closed", this.startupOptions.SecurePort)
-		}()
+// Gets the options of all listeners to start: a secure and an insecure listener bound to all interfaces,
+// if their ports were given, followed by any additional listeners
+func (this *Server) allListenerOptions() []*ServerListenerOptions {
+	results := []*ServerListenerOptions{}
 
-		this.Logf(0, "Started secure listener at port %d", this.startupOptions.SecurePort)
+	if this.startupOptions.SecurePort > 0 {
+		results = append(results, &ServerListenerOptions{
+			Protocol:     "https",
+			Network:      "tcp",
+			Address:      fmt.Sprintf(":%d", this.startupOptions.SecurePort),
+			LoopbackOnly: this.startupOptions.SecureListenerLoopbackOnly,
+		})
 	}
 
 	if this.startupOptions.InsecurePort > 0 {
-		// Start HTTP Listener
-		listenerAddress := fmt.Sprintf(":%d", this.startupOptions.InsecurePort)
-		tcpListener, err := net.Listen("tcp", listenerAddress)
-		if err != nil {
-			panic(err)
-		}
-		this.insecureListener = NewServerListener(this, tcpListener, "http", this.startupOptions.InsecureListenerLoopbackOnly)
+		results = append(results, &ServerListenerOptions{
+			Protocol:     "http",
+			Network:      "tcp",
+			Address:      fmt.Sprintf(":%d", this.startupOptions.InsecurePort),
+			LoopbackOnly: this.startupOptions.InsecureListenerLoopbackOnly,
+		})
+	}
+
+	return append(results, this.startupOptions.Listeners...)
+}
 
-		this.runningStateWaitGroup.Add(1)
-		go func() {
-			http.Serve(this.insecureListener, NewServerHandler(this))
-			this.runningStateWaitGroup.Done()
-			Logf("Insecure listener at port %d has been closed", this.startupOptions.InsecurePort)
-		}()
+// Starts a listener and serves requests on it, until the server is stopped
+func (this *Server) startListener(listenerOptions *ServerListenerOptions, tlsConfig *tls.Config) error {
+	wrappedListener, err := listenerOptions.Listen()
+	if err != nil {
+		return err
+	}
 
-		this.Logf(0, "Started insecure listener at port %d", this.startupOptions.InsecurePort)
+	if listenerOptions.Protocol != "https" {
+		tlsConfig = nil
 	}
+
+	listener := NewServerListener(this, wrappedListener, tlsConfig, listenerOptions)
+
+	// Long-poll GET requests extend their write deadline while waiting for updates, and WebSocket
+	// connections set their own deadlines once upgraded
+	httpServer := &http.Server{
+		Handler:           NewServerHandler(this, listenerOptions.LoopbackOnly),
+		ReadHeaderTimeout: this.startupOptions.ReadHeaderTimeout,
+		ReadTimeout:       this.startupOptions.ReadTimeout,
+		WriteTimeout:      this.startupOptions.WriteTimeout,
+		IdleTimeout:       this.startupOptions.IdleTimeout,
+		MaxHeaderBytes:    this.startupOptions.MaxHeaderBytes,
+	}
+
+	this.listenersLock.Lock()
+	this.listeners = append(this.listeners, listener)
+	this.httpServers = append(this.httpServers, httpServer)
+	this.listenersLock.Unlock()
+
+	this.runningStateWaitGroup.Add(1)
+	go func() {
+		httpServer.Serve(listener)
+		this.runningStateWaitGroup.Done()
+		this.Logf(0, "The %s has been closed", listenerOptions.String())
+	}()
+
+	this.Logf(0, "Started %s", listenerOptions.String())
+
+	return nil
+}
+
+// Gets the HTTP servers of the running listeners
+func (this *Server) runningHTTPServers() []*http.Server {
+	this.listenersLock.Lock()
+	defer this.listenersLock.Unlock()
+
+	return this.httpServers
+}
+
+// Gets the number of running listeners
+func (this *Server) runningListenerCount() int {
+	this.listenersLock.Lock()
+	defer this.listenersLock.Unlock()
+
+	return len(this.listeners)
 }
 
 func (this *Server) Stop() {
-	if this.insecureListener != nil {
-		this.insecureListener.Close()
-		this.insecureListener = nil
+	this.listenersLock.Lock()
+	httpServers := this.httpServers
+	this.httpServers = nil
+	this.listeners = nil
+	this.listenersLock.Unlock()
+
+	for _, httpServer := range httpServers {
+		httpServer.Close()
 	}
 
-	if this.secureListener != nil {
-		this.secureListener.Close()
-		this.secureListener = nil
-	}
 	this.runningStateWaitGroup.Wait()
 
 	for _, datastore := range this.datastores {
 		datastore.Close()
 	}
+
+	if this.accessLog != nil {
+		this.accessLog.Close()
+		this.accessLog = nil
+	}
 }
 
 func (this *Server) Log(logLevel int, values ...interface{}) {
-	if this.startupOptions.LogLevel >= logLevel {
-		log.Println(values...)
+	if this.LogLevel() >= logLevel {
+		this.logger.Write(logLevel, strings.TrimSuffix(fmt.Sprintln(values...), "\n"), nil)
 	}
 }
 
 func (this *Server) Logf(logLevel int, format string, values ...interface{}) {
-	if this.startupOptions.LogLevel >= logLevel {
-		log.Printf(format, values...)
+	if this.LogLevel() >= logLevel {
+		this.logger.Write(logLevel, fmt.Sprintf(format, values...), nil)
 	}
 }
 
+// Logs a message along with the given fields. In the JSON log format, the fields are included as
+// additional properties of the logged object.
+func (this *Server) LogFields(logLevel int, message string, fields LogFields) {
+	if this.LogLevel() >= logLevel {
+		this.logger.Write(logLevel, message, fields)
+	}
+}
+
+// Gets the current log level. The level set in the global configuration, if any, takes precedence over
+// the one given at startup, such that it can be changed while the server is running.
+func (this *Server) LogLevel() int {
+	if globalConfigState := this.GetDatastoreOperations(".config").State; globalConfigState != nil && globalConfigState.DataCache != nil {
+		if configuredLogLevel, err := globalConfigState.DataCache.GetInt64("['server']['log']['level']"); err == nil {
+			return int(configuredLogLevel)
+		}
+	}
+
+	return this.startupOptions.LogLevel
+}
+
 func (this *Server) GetDatastoreOperations(datastoreName string) (datastoreOperations *DatastoreOperations) {
 	// Get the map entry for the datastore
 	datastoreOperations = this.datastores[datastoreName]
@@ -218,7 +433,8 @@ func (this *Server) GetDatastoreOperations(datastoreName string) (datastoreOpera
 
 		// If an operations object was found this time
 		if datastoreOperations != nil {
-			// Return it
+			// Unlock and return it
+			this.datastoreMapLock.Unlock()
 			return
 		}
 
@@ -287,17 +503,228 @@ func (this *Server) GetConfigSnapshot(datastoreName string) (*DatastoreConfigSna
 	}
 }
 
-func DefaultServerConfig(masterKey string) []byte {
-	var masterKeyHashHex string
+// Gets the current content of a configuration datastore. If the datastore doesn't exist, an empty map is returned.
+func (this *Server) GetConfigDatastoreContent(datastoreName string) (*VarMap, error) {
+	state, err := this.GetDatastoreOperations(datastoreName).LoadIfNeeded(false)
 
-	if len(masterKey) > 0 {
-		masterKeyHashHex = SHA1ToHex([]byte(masterKey))
-	} else {
-		masterKeyHashHex = ""
+	if err != nil {
+		switch err.(type) {
+		// If the datastore wasn't found, return an empty map
+		case *os.PathError:
+			return NewEmptyVarMap(), nil
+		default:
+			return nil, err
+		}
+	}
+
+	return state.DataCache.Clone(), nil
+}
+
+// Writes the given entries to a configuration datastore as a single transaction, creating the datastore
+// if it doesn't exist. An entry with an empty value deletes its key. The datastore file is flushed
+// immediately after the write.
+func (this *Server) UpdateConfigDatastore(datastoreName string, entries []JsonEntry) (commitTimestamp int64, err error) {
+	if !IsConfigDatastoreName(datastoreName) {
+		return 0, errors.New("The datastore '" + datastoreName + "' is not a configuration datastore.")
 	}
 
+	if len(entries) == 0 {
+		return 0, ErrEmptyTransaction
+	}
+
+	// Get the operations object for the datastore
+	operations := this.GetDatastoreOperations(datastoreName)
+
+	// Wait to enter the writer queue, and leave it when the function exits
+	writerQueueToken := operations.WriterQueue.Enter()
+	defer operations.WriterQueue.Leave(writerQueueToken)
+
+	return this.appendJsonEntries(operations, entries, 0)
+}
+
+// Appends the given entries to a datastore as a single transaction, creating the datastore if it doesn't
+// exist. The datastore file is flushed within the given maximum delay after the write. Should only be called
+// within the datastore's writer queue.
+func (this *Server) appendJsonEntries(operations *DatastoreOperations, entries []JsonEntry, maxFlushDelay int64) (commitTimestamp int64, err error) {
+	// Serialize the entries
+	transactionBytes := SerializeJsonEntries(entries)
+
+	// Load the datastore if needed
+	state, err := operations.LoadIfNeeded(false)
+
+	if err != nil {
+		// If the datastore doesn't exist
+		if _, ok := err.(*os.PathError); ok {
+			// Create it with the given entries as its content
+			commitTimestamp = operations.GetCollisionFreeTimestamp(nil)
+
+			err = ValidateAndPrepareTransaction(transactionBytes, commitTimestamp, 0)
+			if err != nil {
+				return
+			}
+
+			err = operations.Rewrite(transactionBytes, commitTimestamp)
+		}
+
+		return
+	}
+
+	// Otherwise, append the entries to the existing datastore
+	commitTimestamp = operations.GetCollisionFreeTimestamp(state)
+
+	err = ValidateAndPrepareTransaction(transactionBytes, commitTimestamp, 0)
+	if err != nil {
+		return
+	}
+
+	err = operations.Append(transactionBytes, state, commitTimestamp, true, maxFlushDelay)
+
+	return
+}
+
+// Checks if the given access profile is defined in either the given configuration datastore or the global one
+func (this *Server) AccessProfileExists(configDatastoreName string, profileName string) (bool, error) {
+	profilePrefix := "['accessProfile']['" + profileName + "']"
+
+	for _, datastoreName := range []string{configDatastoreName, ".config"} {
+		content, err := this.GetConfigDatastoreContent(datastoreName)
+		if err != nil {
+			return false, err
+		}
+
+		for _, key := range content.Keys() {
+			if strings.HasPrefix(key, profilePrefix) {
+				return true, nil
+			}
+		}
+	}
+
+	return false, nil
+}
+
+///////////////////////////////////////////////////////////////////////////////////////////////////
+/// Access key hashing
+///////////////////////////////////////////////////////////////////////////////////////////////////
+
+// Gets an access key hasher for the scheme set in the given configuration snapshot. If no scheme is set,
+// the legacy SHA1 scheme is used.
+func (this *Server) GetAccessKeyHasher(config *DatastoreConfigSnapshot) (*AccessKeyHasher, error) {
+	secret, err := this.getAccessKeyHashSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	scheme, err := config.GetString_GlobalOnly("['server']['accessKeyHashScheme']")
+	if err != nil || scheme == "" {
+		scheme = AccessKeyHashScheme_SHA1
+	}
+
+	return NewAccessKeyHasher(secret, scheme)
+}
+
+// Calculates the hash of the given access key, using the scheme set in the global configuration datastore
+func (this *Server) HashAccessKey(accessKey string) (string, error) {
+	// Ensure the global configuration datastore is loaded, if it exists
+	_, err := this.GetDatastoreOperations(".config").LoadIfNeeded(false)
+	if _, ok := err.(*os.PathError); err != nil && !ok {
+		return "", err
+	}
+
+	config, err := this.GetConfigSnapshot(".config")
+	if err != nil {
+		return "", err
+	}
+
+	accessKeyHasher, err := this.GetAccessKeyHasher(config)
+	if err != nil {
+		return "", err
+	}
+
+	return accessKeyHasher.Hash(accessKey), nil
+}
+
+// Gets the access key hash secret, loading or creating it if needed
+func (this *Server) getAccessKeyHashSecret() ([]byte, error) {
+	this.accessKeyHashSecretLock.Lock()
+	defer this.accessKeyHashSecretLock.Unlock()
+
+	if this.accessKeyHashSecret == nil {
+		secret, err := LoadOrCreateAccessKeyHashSecret(this.startupOptions.StoragePath)
+		if err != nil {
+			return nil, err
+		}
+
+		this.accessKeyHashSecret = secret
+	}
+
+	return this.accessKeyHashSecret, nil
+}
+
+///////////////////////////////////////////////////////////////////////////////////////////////////
+/// Access tokens
+///////////////////////////////////////////////////////////////////////////////////////////////////
+
+// Verifies a signed access token using the keys configured in the given configuration snapshot, and
+// returns its claims
+func (this *Server) VerifyAccessToken(config *DatastoreConfigSnapshot, token string) (*AccessTokenClaims, error) {
+	getKey := func(keyID string) (*AccessTokenKey, error) {
+		return GetConfiguredAccessTokenKey(config, this.accessTokenKeySetCache, keyID)
+	}
+
+	return ParseAndVerifyAccessToken(token, getKey, time.Now().Unix())
+}
+
+// Records a failed request (e.g. a rejected authentication attempt) from the given client IP. If IP
+// banning is enabled in the global configuration and the IP had too many failures within the configured
+// window, it is temporarily banned.
+func (this *Server) RecordClientFailure(ip string) {
+	// Get the global configuration snapshot
+	config, err := this.GetConfigSnapshot(".config")
+	if err != nil {
+		return
+	}
+
+	// Return if IP banning isn't enabled
+	enabled, _ := config.GetBool_GlobalOnly("['server']['ipBan']['enabled']")
+	if !enabled {
+		return
+	}
+
+	// Get the ban settings
+	maxFailures, err := config.GetInt64_GlobalOnly("['server']['ipBan']['maxFailures']")
+	if err != nil || maxFailures <= 0 {
+		maxFailures = 10
+	}
+
+	window, err := config.GetInt64_GlobalOnly("['server']['ipBan']['window']")
+	if err != nil || window <= 0 {
+		window = 60000
+	}
+
+	banDuration, err := config.GetInt64_GlobalOnly("['server']['ipBan']['duration']")
+	if err != nil || banDuration <= 0 {
+		banDuration = 600000
+	}
+
+	// Record the failure and log a message if the IP was banned as a result
+	if this.ipBanList.RecordFailure(ip, window, maxFailures, banDuration) {
+		this.Logf(1, "Banned IP '%s' for %dms after %d failed requests", ip, banDuration, maxFailures)
+	}
+}
+
+func DefaultServerConfig(masterKeyHash string) []byte {
 	defaultConfigStringEntries := []JsonEntry{
-		JsonEntry{`"['server']['masterKeyHash']"`, `"` + masterKeyHashHex + `"`},
+		JsonEntry{`"['server']['masterKeyHash']"`, `"` + masterKeyHash + `"`},
+		JsonEntry{`"['server']['accessKeyHashScheme']"`, `"` + AccessKeyHashScheme_HMACSHA256 + `"`},
+
+		JsonEntry{`"['server']['ipBan']['enabled']"`, `false`},
+		JsonEntry{`"['server']['ipBan']['maxFailures']"`, `10`},
+		JsonEntry{`"['server']['ipBan']['window']"`, `60000`},
+		JsonEntry{`"['server']['ipBan']['duration']"`, `600000`},
+
+		JsonEntry{`"['server']['audit']['enabled']"`, `false`},
+		JsonEntry{`"['server']['audit']['maxAge']"`, `2592000000`},
+		JsonEntry{`"['server']['audit']['maxKeys']"`, `1000`},
 
 		JsonEntry{`"['datastore']['compaction']['enabled']"`, `true`},
 		JsonEntry{`"['datastore']['compaction']['minSize']"`, `4096`},
@@ -314,6 +741,7 @@ func DefaultServerConfig(masterKey string) []byte {
 		JsonEntry{`"['accessProfile']['Reader']['method']['GET']['allowed']"`, `true`},
 		JsonEntry{`"['accessProfile']['Reader']['method']['GET']['param']['updatedAfter']['allowed']"`, `true`},
 		JsonEntry{`"['accessProfile']['Reader']['method']['GET']['param']['waitUntilNonempty']['allowed']"`, `true`},
+		JsonEntry{`"['accessProfile']['Reader']['method']['GET']['param']['format']['allowed']"`, `true`},
 		JsonEntry{`"['accessProfile']['Reader']['method']['GET']['limit']['requests']['interval']"`, `2000`},
 		JsonEntry{`"['accessProfile']['Reader']['method']['GET']['limit']['requests']['count']"`, `10`},
 		JsonEntry{`"['accessProfile']['Reader']['method']['WebSocket']['allowed']"`, `true`},
@@ -323,6 +751,7 @@ func DefaultServerConfig(masterKey string) []byte {
 		JsonEntry{`"['accessProfile']['ReaderWriter']['method']['GET']['allowed']"`, `true`},
 		JsonEntry{`"['accessProfile']['ReaderWriter']['method']['GET']['param']['updatedAfter']['allowed']"`, `true`},
 		JsonEntry{`"['accessProfile']['ReaderWriter']['method']['GET']['param']['waitUntilNonempty']['allowed']"`, `true`},
+		JsonEntry{`"['accessProfile']['ReaderWriter']['method']['GET']['param']['format']['allowed']"`, `true`},
 		JsonEntry{`"['accessProfile']['ReaderWriter']['method']['GET']['limit']['requests']['interval']"`, `2000`},
 		JsonEntry{`"['accessProfile']['ReaderWriter']['method']['GET']['limit']['requests']['count']"`, `10`},
 		JsonEntry{`"['accessProfile']['ReaderWriter']['method']['WebSocket']['allowed']"`, `true`},
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Declare the access key management handler object type
+type ServerAccessKeyHandler struct {
+	parentServer *Server
+}
+
+// Access key management handler object constructor function
+func NewServerAccessKeyHandler(parentServer *Server) *ServerAccessKeyHandler {
+	return &ServerAccessKeyHandler{
+		parentServer: parentServer,
+	}
+}
+
+// An access key binding, as returned to the client
+type AccessKeyBinding struct {
+	AccessKey     string `json:"accessKey,omitempty"`
+	AccessKeyHash string `json:"accessKeyHash"`
+	Profile       string `json:"profile"`
+	Datastore     string `json:"datastore"`
+}
+
+var accessKeyPathRegexp *regexp.Regexp
+var accessKeyHashConfigKeyRegexp *regexp.Regexp
+
+func init() {
+	accessKeyPathRegexp = regexp.MustCompile(`^/admin/accessKeys(?:/((?:[0-9a-z\-]+:)?[0-9a-f]+))?$`)
+	accessKeyHashConfigKeyRegexp = regexp.MustCompile(`^\['datastore'\]\['accessKeyHash'\]\['([^'\[\]]*)'\]$`)
+}
+
+// The main handler for access key management requests. The master key has already been verified at this point.
+func (this *ServerAccessKeyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// Parse the path to get the access key hash, if included
+	requestPathSubmatches := accessKeyPathRegexp.FindStringSubmatch(r.URL.Path)
+
+	if len(requestPathSubmatches) == 0 {
+		endRequestWithError(w, r, http.StatusBadRequest, errors.New("Invalid request path, should be of the form '/admin/accessKeys' or '/admin/accessKeys/[accessKeyHash]'."))
+		return
+	}
+
+	accessKeyHash := requestPathSubmatches[1]
+
+	// Parse the query
+	query := r.URL.Query()
+
+	// Resolve the configuration datastore holding the bindings. If no datastore name was given,
+	// the bindings would be global
+	datastoreName := query.Get("datastore")
+	configDatastoreName := ".config"
+
+	if datastoreName != "" {
+		if IsConfigDatastoreName(datastoreName) || !datastorePathRegexp.MatchString("/datastore/"+datastoreName) || len(datastoreName) > 128 {
+			endRequestWithError(w, r, http.StatusBadRequest, errors.New("Invalid datastore name '"+datastoreName+"'."))
+			return
+		}
+
+		configDatastoreName = datastoreName + ".config"
+	}
+
+	var err error
+
+	switch {
+	case r.Method == "GET" && accessKeyHash == "":
+		err = this.handleListRequest(w, r, datastoreName, configDatastoreName)
+	case r.Method == "POST" && accessKeyHash == "":
+		err = this.handleCreateRequest(w, r, datastoreName, configDatastoreName, query)
+	case r.Method == "DELETE" && accessKeyHash != "":
+		err = this.handleRevokeRequest(w, r, datastoreName, configDatastoreName, accessKeyHash)
+	default:
+		endRequestWithError(w, r, http.StatusMethodNotAllowed, nil)
+	}
+
+	// If an error occured, and wasn't properly handled to end the request, end the request
+	// with an 'Internal Server Error' response
+	if err != nil {
+		this.parentServer.Log(1, err)
+		endRequestWithError(w, r, http.StatusInternalServerError, err)
+	}
+}
+
+// Lists the access key hashes bound in the configuration datastore, along with their profiles
+func (this *ServerAccessKeyHandler) handleListRequest(w http.ResponseWriter, r *http.Request, datastoreName string, configDatastoreName string) (err error) {
+	content, err := this.parentServer.GetConfigDatastoreContent(configDatastoreName)
+	if err != nil {
+		return
+	}
+
+	bindings := []AccessKeyBinding{}
+
+	for _, key := range content.Keys() {
+		keySubmatches := accessKeyHashConfigKeyRegexp.FindStringSubmatch(key)
+
+		if len(keySubmatches) == 0 {
+			continue
+		}
+
+		profileName, _ := content.GetString(key)
+
+		bindings = append(bindings, AccessKeyBinding{
+			AccessKeyHash: keySubmatches[1],
+			Profile:       profileName,
+			Datastore:     datastoreName,
+		})
+	}
+
+	sort.Slice(bindings, func(i, j int) bool { return bindings[i].AccessKeyHash < bindings[j].AccessKeyHash })
+
+	return endRequestWithJson(w, r, bindings)
+}
+
+// Creates a new random access key and binds its hash to the given profile
+func (this *ServerAccessKeyHandler) handleCreateRequest(w http.ResponseWriter, r *http.Request, datastoreName string, configDatastoreName string, query url.Values) (err error) {
+	profileName := query.Get("profile")
+
+	if profileName == "" || strings.ContainsAny(profileName, "'[]") {
+		endRequestWithError(w, r, http.StatusBadRequest, errors.New("A valid 'profile' parameter must be given."))
+		return
+	}
+
+	// Ensure the profile is defined
+	profileExists, err := this.parentServer.AccessProfileExists(configDatastoreName, profileName)
+	if err != nil {
+		return
+	}
+
+	if !profileExists {
+		endRequestWithError(w, r, http.StatusBadRequest, errors.New(fmt.Sprintf("The access profile '%s' isn't defined.", profileName)))
+		return
+	}
+
+	// Generate a new key and hash it using the configured scheme
+	accessKey := GenerateRandomAccessKey()
+
+	accessKeyHash, err := this.parentServer.HashAccessKey(accessKey)
+	if err != nil {
+		return
+	}
+
+	// Write the binding to the configuration datastore
+	serializedProfileName, _ := json.Marshal(profileName)
+
+	_, err = this.parentServer.UpdateConfigDatastore(configDatastoreName, []JsonEntry{
+		JsonEntry{`"['datastore']['accessKeyHash']['` + accessKeyHash + `']"`, string(serializedProfileName)},
+	})
+
+	if err != nil {
+		return
+	}
+
+	this.parentServer.Logf(1, "Created access key with hash '%s' and profile '%s' in '%s'", accessKeyHash, profileName, configDatastoreName)
+
+	// Respond with the new key. This is the only time the key itself is ever sent.
+	return endRequestWithJson(w, r, AccessKeyBinding{
+		AccessKey:     accessKey,
+		AccessKeyHash: accessKeyHash,
+		Profile:       profileName,
+		Datastore:     datastoreName,
+	})
+}
+
+// Revokes the access key having the given hash
+func (this *ServerAccessKeyHandler) handleRevokeRequest(w http.ResponseWriter, r *http.Request, datastoreName string, configDatastoreName string, accessKeyHash string) (err error) {
+	configKey := "['datastore']['accessKeyHash']['" + accessKeyHash + "']"
+
+	content, err := this.parentServer.GetConfigDatastoreContent(configDatastoreName)
+	if err != nil {
+		return
+	}
+
+	// If the key isn't bound in the configuration datastore, end with a 404 Not Found status
+	if !content.Has(configKey) {
+		endRequestWithError(w, r, http.StatusNotFound, nil)
+		return
+	}
+
+	// Delete the binding
+	_, err = this.parentServer.UpdateConfigDatastore(configDatastoreName, []JsonEntry{
+		JsonEntry{`"` + configKey + `"`, ""},
+	})
+
+	if err != nil {
+		return
+	}
+
+	this.parentServer.Logf(1, "Revoked access key with hash '%s' in '%s'", accessKeyHash, configDatastoreName)
+
+	// Set the response content type to plain text
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	// Write the header
+	w.WriteHeader(http.StatusOK)
+
+	return
+}
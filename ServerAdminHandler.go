@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// Declare the administration handler object type. All administration requests require the master key,
+// except profiling requests received by a listener accepting only loopback connections.
+type ServerAdminHandler struct {
+	parentServer     *Server
+	loopbackOnly     bool
+	accessKeyHandler *ServerAccessKeyHandler
+	banHandler       *ServerBanHandler
+	profilingHandler *ServerProfilingHandler
+}
+
+// Administration handler object constructor function. 'loopbackOnly' should be set if the handler serves
+// a listener accepting only loopback connections.
+func NewServerAdminHandler(parentServer *Server, loopbackOnly bool) *ServerAdminHandler {
+	return &ServerAdminHandler{
+		parentServer:     parentServer,
+		loopbackOnly:     loopbackOnly,
+		accessKeyHandler: NewServerAccessKeyHandler(parentServer),
+		banHandler:       NewServerBanHandler(parentServer),
+		profilingHandler: NewServerProfilingHandler(parentServer),
+	}
+}
+
+// The main handler for all administration requests
+func (this *ServerAdminHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	isProfilingRequest := r.URL.Path+"/" == profilingPathPrefix || strings.HasPrefix(r.URL.Path, profilingPathPrefix)
+
+	// Profiling requests received by a loopback only listener don't require the master key. The remote
+	// address isn't used for this, since a local reverse proxy would forward any client's request from a
+	// loopback address.
+	isLoopbackProfilingRequest := isProfilingRequest && this.loopbackOnly
+
+	// Ensure the request was sent with the master key
+	if !isLoopbackProfilingRequest && !this.authorizeMasterKeyRequest(w, r) {
+		return
+	}
+
+	// Dispatch the appropriate handler for the requested path
+	switch {
+	case r.URL.Path == "/admin/accessKeys" || strings.HasPrefix(r.URL.Path, "/admin/accessKeys/"):
+		this.accessKeyHandler.ServeHTTP(w, r)
+	case r.URL.Path == "/admin/bans" || strings.HasPrefix(r.URL.Path, "/admin/bans/"):
+		this.banHandler.ServeHTTP(w, r)
+	case r.URL.Path == "/admin/usage":
+		this.handleUsageRequest(w, r)
+	case isProfilingRequest:
+		// Redirect to the index of the profiles, such that its relative links would be resolved correctly
+		if r.URL.Path+"/" == profilingPathPrefix {
+			redirectURL := *r.URL
+			redirectURL.Path = profilingPathPrefix
+
+			http.Redirect(w, r, redirectURL.String(), http.StatusMovedPermanently)
+			return
+		}
+
+		this.profilingHandler.ServeHTTP(w, r)
+	default:
+		endRequestWithError(w, r, http.StatusNotFound, errors.New("Invalid administration request path."))
+	}
+}
+
+// Reports the storage used by each principal across the datastores it owns. If a 'principal' query
+// parameter is given, only the usage of that principal is reported.
+func (this *ServerAdminHandler) handleUsageRequest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		endRequestWithError(w, r, http.StatusMethodNotAllowed, nil)
+		return
+	}
+
+	var result interface{}
+	var err error
+
+	if principal := r.URL.Query().Get("principal"); principal != "" {
+		result, err = this.parentServer.GetStorageUsage(principal)
+	} else {
+		result, err = this.parentServer.GetAllStorageUsage()
+	}
+
+	if err == nil {
+		err = endRequestWithJson(w, r, result)
+	}
+
+	// If an error occured, end the request with an 'Internal Server Error' response
+	if err != nil {
+		this.parentServer.Log(1, err)
+		endRequestWithError(w, r, http.StatusInternalServerError, err)
+	}
+}
+
+// Ensures the request was sent with the master key. If it wasn't, ends the request with an error and returns false.
+func (this *ServerAdminHandler) authorizeMasterKeyRequest(w http.ResponseWriter, r *http.Request) bool {
+	// Get the access key included in the request
+	accessKey, accessKeySource, err := GetRequestAccessKey(r, r.URL.Query())
+	if err != nil {
+		endRequestWithError(w, r, http.StatusBadRequest, err)
+		return false
+	}
+
+	// Verify the access key has a valid length and character set
+	if len(accessKey) > 0 && (len(accessKey) != 32 || !accessKeyRegexp.MatchString(accessKey)) {
+		endRequestWithError(w, r, http.StatusBadRequest, errors.New("A non-empty access key must contain exactly 32 lowercase hexedecimal digits."))
+		return false
+	}
+
+	// Get the global configuration snapshot
+	config, err := this.parentServer.GetConfigSnapshot(".config")
+	if err != nil {
+		endRequestWithError(w, r, http.StatusInternalServerError, err)
+		return false
+	}
+
+	// Verify the access key was included in a source allowed by the configuration
+	err = CheckAccessKeySourceAllowed(config, accessKeySource)
+	if err != nil {
+		endRequestWithError(w, r, http.StatusUnauthorized, err)
+		return false
+	}
+
+	// Get an access key hasher for the configured scheme
+	accessKeyHasher, err := this.parentServer.GetAccessKeyHasher(config)
+	if err != nil {
+		endRequestWithError(w, r, http.StatusInternalServerError, err)
+		return false
+	}
+
+	// Get master key hash
+	masterKeyHash, _ := config.GetString_GlobalOnly("['server']['masterKeyHash']")
+
+	if !accessKeyHasher.Matches(accessKey, masterKeyHash) {
+		endRequestWithError(w, r, http.StatusUnauthorized, errors.New("Administration requests can only be sent with the master key."))
+		return false
+	}
+
+	return true
+}
+
+// End the given request with a 200 OK status and the given object serialized as JSON
+func endRequestWithJson(w http.ResponseWriter, r *http.Request, result interface{}) (err error) {
+	serializedResult, err := json.Marshal(result)
+	if err != nil {
+		return
+	}
+
+	// Set the response content type to JSON
+	w.Header().Set("Content-Type", "application/json")
+	// Write the header with a 200 OK status
+	w.WriteHeader(http.StatusOK)
+	// Write the serialized object
+	_, err = w.Write(serializedResult)
+
+	return
+}
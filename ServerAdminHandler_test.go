@@ -0,0 +1,299 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Server", func() {
+	var context *ServerTestContext
+
+	BeforeEach(func() {
+		context = NewServerTestContext()
+		context.Start()
+	})
+
+	AfterEach(func() {
+		context.Stop()
+	})
+
+	sendAdminRequest := func(method string, path string) (statusCode int, body []byte) {
+		request, err := http.NewRequest(method, context.hostURL+path, nil)
+		Expect(err).To(BeNil())
+
+		response, err := http.DefaultClient.Do(request)
+		Expect(err).To(BeNil())
+		defer response.Body.Close()
+
+		body, err = ioutil.ReadAll(response.Body)
+		Expect(err).To(BeNil())
+
+		return response.StatusCode, body
+	}
+
+	//////////////////////////////////////////////////////////////////////////////////////////////////////
+	/// Access key management tests
+	//////////////////////////////////////////////////////////////////////////////////////////////////////
+	It("Creates, lists and revokes datastore access keys", func() {
+		datastoreName := RandomWordString(12)
+		testEntries := context.GetTestEntries()
+
+		// Create a new access key bound to the built-in 'ReaderWriter' profile
+		statusCode, body := sendAdminRequest("POST", "/admin/accessKeys?profile=ReaderWriter&datastore="+datastoreName)
+		Expect(statusCode).To(Equal(http.StatusOK))
+
+		var createdBinding AccessKeyBinding
+		Expect(json.Unmarshal(body, &createdBinding)).To(BeNil())
+		Expect(createdBinding.AccessKey).To(HaveLen(32))
+		Expect(createdBinding.AccessKeyHash).To(HavePrefix(AccessKeyHashScheme_HMACSHA256 + ":"))
+		Expect(context.server.HashAccessKey(createdBinding.AccessKey)).To(Equal(createdBinding.AccessKeyHash))
+		Expect(createdBinding.Profile).To(Equal("ReaderWriter"))
+		Expect(createdBinding.Datastore).To(Equal(datastoreName))
+
+		// Use the key to write to and read from the datastore
+		client := context.GetClient(datastoreName, createdBinding.AccessKey)
+		_, err := client.Put(testEntries)
+		Expect(err).To(BeNil())
+		_, err = client.Get(0)
+		Expect(err).To(BeNil())
+
+		// List the bindings and ensure the key itself isn't included
+		statusCode, body = sendAdminRequest("GET", "/admin/accessKeys?datastore="+datastoreName)
+		Expect(statusCode).To(Equal(http.StatusOK))
+
+		var listedBindings []AccessKeyBinding
+		Expect(json.Unmarshal(body, &listedBindings)).To(BeNil())
+		Expect(listedBindings).To(Equal([]AccessKeyBinding{
+			AccessKeyBinding{AccessKeyHash: createdBinding.AccessKeyHash, Profile: "ReaderWriter", Datastore: datastoreName},
+		}))
+
+		// Revoke the key
+		statusCode, _ = sendAdminRequest("DELETE", "/admin/accessKeys/"+createdBinding.AccessKeyHash+"?datastore="+datastoreName)
+		Expect(statusCode).To(Equal(http.StatusOK))
+
+		statusCode, _ = sendAdminRequest("DELETE", "/admin/accessKeys/"+createdBinding.AccessKeyHash+"?datastore="+datastoreName)
+		Expect(statusCode).To(Equal(http.StatusNotFound))
+
+		_, err = client.Get(0)
+		Expect(err).NotTo(BeNil())
+		Expect(err.Error()).To(ContainSubstring("401"))
+	})
+
+	It("Rejects access key creation for undefined profiles", func() {
+		statusCode, _ := sendAdminRequest("POST", "/admin/accessKeys?profile=NonexistingProfile")
+		Expect(statusCode).To(Equal(http.StatusBadRequest))
+
+		statusCode, _ = sendAdminRequest("POST", "/admin/accessKeys")
+		Expect(statusCode).To(Equal(http.StatusBadRequest))
+	})
+
+	It("Rejects administration requests without the master key", func() {
+		// Generate master key
+		masterKey, masterKeyHash := context.GetRandomAccessKey()
+
+		// Set the generated key as the new master key
+		context.PutGlobalSetting(`"['server']['masterKeyHash']"`, `"`+masterKeyHash+`"`, "")
+		defer context.PutGlobalSetting(`"['server']['masterKeyHash']"`, `""`, masterKey)
+
+		statusCode, _ := sendAdminRequest("GET", "/admin/accessKeys")
+		Expect(statusCode).To(Equal(http.StatusUnauthorized))
+
+		invalidMasterKey, _ := context.GetRandomAccessKey()
+		statusCode, _ = sendAdminRequest("GET", "/admin/accessKeys?accessKey="+invalidMasterKey)
+		Expect(statusCode).To(Equal(http.StatusUnauthorized))
+
+		statusCode, _ = sendAdminRequest("GET", "/admin/accessKeys?accessKey="+masterKey)
+		Expect(statusCode).To(Equal(http.StatusOK))
+	})
+
+	//////////////////////////////////////////////////////////////////////////////////////////////////////
+	/// IP ban tests
+	//////////////////////////////////////////////////////////////////////////////////////////////////////
+	It("Bans IPs after repeated authentication failures", func() {
+		settingErr := context.PutGlobalSettings("", map[string]string{
+			`"['server']['ipBan']['enabled']"`:     `true`,
+			`"['server']['ipBan']['maxFailures']"`: `3`,
+		}, "")
+		Expect(settingErr).To(BeNil())
+
+		invalidAccessKey, _ := context.GetRandomAccessKey()
+		client := context.GetClientForRandomDatastore(invalidAccessKey)
+
+		for i := 0; i < 3; i++ {
+			_, err := client.Get(0)
+			Expect(err).NotTo(BeNil())
+			Expect(err.Error()).To(ContainSubstring("401"))
+		}
+
+		// Further requests should be rejected, even when sent with the master key
+		Expect(context.server.ipBanList.IsBanned("127.0.0.1")).To(BeTrue())
+
+		statusCode, _ := sendAdminRequest("GET", "/admin/bans")
+		Expect(statusCode).NotTo(Equal(http.StatusOK))
+
+		// Lift the ban directly, since the admin endpoints can't be reached from the banned IP
+		Expect(context.server.ipBanList.Lift("127.0.0.1")).To(BeTrue())
+
+		statusCode, _ = sendAdminRequest("GET", "/admin/bans")
+		Expect(statusCode).To(Equal(http.StatusOK))
+	})
+
+	It("Lists and lifts IP bans", func() {
+		context.server.ipBanList.Ban("10.1.2.3", 60000)
+
+		statusCode, body := sendAdminRequest("GET", "/admin/bans")
+		Expect(statusCode).To(Equal(http.StatusOK))
+
+		var bans []IPBan
+		Expect(json.Unmarshal(body, &bans)).To(BeNil())
+		Expect(bans).To(HaveLen(1))
+		Expect(bans[0].IP).To(Equal("10.1.2.3"))
+
+		statusCode, _ = sendAdminRequest("DELETE", "/admin/bans/10.1.2.3")
+		Expect(statusCode).To(Equal(http.StatusOK))
+
+		statusCode, _ = sendAdminRequest("DELETE", "/admin/bans/10.1.2.3")
+		Expect(statusCode).To(Equal(http.StatusNotFound))
+
+		Expect(context.server.ipBanList.IsBanned("10.1.2.3")).To(BeFalse())
+	})
+
+	//////////////////////////////////////////////////////////////////////////////////////////////////////
+	/// Storage quota tests
+	//////////////////////////////////////////////////////////////////////////////////////////////////////
+	It("Enforces and reports storage quotas across the datastores a principal owns", func() {
+		accessKey, accessKeyHash := context.GetRandomAccessKey()
+
+		settingErr := context.PutGlobalSettings("", map[string]string{
+			`"['datastore']['accessKeyHash']['` + accessKeyHash + `']"`:               `"ReaderWriter"`,
+			`"['accessProfile']['ReaderWriter']['limit']['storage']['maxTotalSize']"`: `3500`,
+		}, "")
+		Expect(settingErr).To(BeNil())
+
+		datastoreNames := []string{RandomWordString(12), RandomWordString(12), RandomWordString(12)}
+
+		// Create two datastores, each containing a single 1060 byte entry
+		_, err := context.GetClient(datastoreNames[0], accessKey).Put([]Entry{*getRandomBinaryEntry(20, 1000)})
+		Expect(err).To(BeNil())
+		_, err = context.GetClient(datastoreNames[1], accessKey).PostOrCreate([]Entry{*getRandomBinaryEntry(20, 1000)})
+		Expect(err).To(BeNil())
+
+		// Creating a third one, or growing an existing one, would exceed the quota
+		_, err = context.GetClient(datastoreNames[2], accessKey).Put([]Entry{*getRandomBinaryEntry(20, 1000)})
+		Expect(err).NotTo(BeNil())
+		Expect(err.Error()).To(ContainSubstring("403"))
+
+		_, err = context.GetClient(datastoreNames[0], accessKey).Post([]Entry{*getRandomBinaryEntry(20, 1000)})
+		Expect(err).NotTo(BeNil())
+		Expect(err.Error()).To(ContainSubstring("403"))
+
+		// Replacing the content of an existing datastore is allowed as long as the total stays within the quota
+		_, err = context.GetClient(datastoreNames[0], accessKey).Put([]Entry{*getRandomBinaryEntry(20, 500)})
+		Expect(err).To(BeNil())
+
+		// Get the usage reported for the principal
+		statusCode, body := sendAdminRequest("GET", "/admin/usage?principal="+url.QueryEscape(accessKeyHash))
+		Expect(statusCode).To(Equal(http.StatusOK))
+
+		var usage StorageUsage
+		Expect(json.Unmarshal(body, &usage)).To(BeNil())
+		Expect(usage.Principal).To(Equal(accessKeyHash))
+		Expect(usage.Datastores).To(HaveLen(2))
+		Expect(usage.TotalSize).To(Equal(usage.Datastores[0].Size + usage.Datastores[1].Size))
+		Expect(usage.TotalSize).To(BeNumerically(">", 1560))
+		Expect(usage.TotalSize).To(BeNumerically("<=", 3500))
+
+		// Deleting a datastore releases its storage
+		Expect(context.GetClient(datastoreNames[1], "").Delete()).To(BeNil())
+
+		statusCode, body = sendAdminRequest("GET", "/admin/usage")
+		Expect(statusCode).To(Equal(http.StatusOK))
+
+		var allUsage []StorageUsage
+		Expect(json.Unmarshal(body, &allUsage)).To(BeNil())
+		Expect(allUsage).To(HaveLen(1))
+		Expect(allUsage[0].Datastores).To(HaveLen(1))
+		Expect(allUsage[0].Datastores[0].Name).To(Equal(datastoreNames[0]))
+	})
+
+	It("Enforces storage quotas for concurrent writes to different datastores", func() {
+		accessKey, accessKeyHash := context.GetRandomAccessKey()
+
+		settingErr := context.PutGlobalSettings("", map[string]string{
+			`"['datastore']['accessKeyHash']['` + accessKeyHash + `']"`:               `"ReaderWriter"`,
+			`"['accessProfile']['ReaderWriter']['limit']['storage']['maxTotalSize']"`: `3500`,
+		}, "")
+		Expect(settingErr).To(BeNil())
+
+		// Concurrently create eight datastores, each containing a single 1060 byte entry
+		results := make(chan error, 8)
+
+		for i := 0; i < 8; i++ {
+			go func() {
+				_, err := context.GetClient(RandomWordString(12), accessKey).Put([]Entry{*getRandomBinaryEntry(20, 1000)})
+				results <- err
+			}()
+		}
+
+		successCount := 0
+
+		for i := 0; i < 8; i++ {
+			err := <-results
+
+			if err == nil {
+				successCount++
+			} else {
+				Expect(err.Error()).To(ContainSubstring("403"))
+			}
+		}
+
+		// Ensure only two of them fit within the quota
+		Expect(successCount).To(Equal(2))
+
+		statusCode, body := sendAdminRequest("GET", "/admin/usage?principal="+url.QueryEscape(accessKeyHash))
+		Expect(statusCode).To(Equal(http.StatusOK))
+
+		var usage StorageUsage
+		Expect(json.Unmarshal(body, &usage)).To(BeNil())
+		Expect(usage.Datastores).To(HaveLen(2))
+		Expect(usage.TotalSize).To(BeNumerically("<=", 3500))
+	})
+
+	//////////////////////////////////////////////////////////////////////////////////////////////////////
+	/// Metrics tests
+	//////////////////////////////////////////////////////////////////////////////////////////////////////
+	It("Exposes request and datastore metrics", func() {
+		client := context.GetClientForRandomDatastore("")
+
+		_, err := client.Put(context.GetTestEntries())
+		Expect(err).To(BeNil())
+
+		_, err = client.Post(context.GetTestEntries())
+		Expect(err).To(BeNil())
+
+		_, err = client.Get(0)
+		Expect(err).To(BeNil())
+
+		statusCode, body := sendAdminRequest("GET", "/metrics")
+		Expect(statusCode).To(Equal(http.StatusOK))
+
+		metricsText := string(body)
+		Expect(metricsText).To(ContainSubstring("# TYPE zincserver_requests_total counter"))
+		Expect(metricsText).To(MatchRegexp(`(?m)^zincserver_requests_total\{method="PUT",status="200"\} [1-9]`))
+		Expect(metricsText).To(MatchRegexp(`(?m)^zincserver_requests_total\{method="GET",status="200"\} [1-9]`))
+		Expect(metricsText).To(MatchRegexp(`(?m)^zincserver_post_request_duration_seconds_count [1-9]`))
+		Expect(metricsText).To(MatchRegexp(`(?m)^zincserver_datastore_written_bytes_total [1-9]`))
+		Expect(metricsText).To(MatchRegexp(`(?m)^zincserver_datastore_read_bytes_total [1-9]`))
+		Expect(metricsText).To(MatchRegexp(`(?m)^zincserver_open_datastores [1-9]`))
+		Expect(metricsText).To(MatchRegexp(`(?m)^zincserver_open_file_descriptors [1-9]`))
+		Expect(metricsText).To(MatchRegexp(`(?m)^zincserver_websocket_subscribers 0$`))
+
+		statusCode, _ = sendAdminRequest("POST", "/metrics")
+		Expect(statusCode).To(Equal(http.StatusMethodNotAllowed))
+	})
+})
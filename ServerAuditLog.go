@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+)
+
+// The name of the datastore audit records are written to. It can only be read, and only with the master key.
+const AuditDatastoreName = ".audit"
+
+// The minimal interval, in milliseconds, between successive checks for audit records exceeding their maximum age
+const auditRetentionCheckInterval int64 = 60000
+
+// A record of a committed write, stored as a JSON value in the audit datastore
+type AuditRecord struct {
+	// The name of the datastore written to
+	Datastore string `json:"datastore"`
+	// The request method, either 'POST', 'PUT' or 'DELETE'
+	Method string `json:"method"`
+	// The commit timestamp of the transaction. For 'DELETE' requests, the time the datastore was deleted.
+	CommitTimestamp int64 `json:"commitTimestamp"`
+	// The hash of the access key the request was sent with, if any
+	AccessKeyHash string `json:"accessKeyHash,omitempty"`
+	// The subject of the signed token the request was sent with, if any
+	TokenSubject string `json:"tokenSubject,omitempty"`
+	// The identity of the client certificate the request was authenticated with, if any
+	ClientCertificate string `json:"clientCertificate,omitempty"`
+	// The IP the request was sent from
+	RemoteIP string `json:"remoteIP"`
+	// The number of entries in the transaction
+	EntryCount int64 `json:"entryCount"`
+	// The size of the transaction, in bytes
+	ByteSize int64 `json:"byteSize"`
+	// The keys written, for entries having textual, unencrypted keys
+	Keys []string `json:"keys"`
+	// The hex encoded keys written, for entries having binary or encrypted keys
+	BinaryKeys []string `json:"binaryKeys,omitempty"`
+	// True if some keys were omitted, due to the maximum number of keys recorded per transaction
+	KeysTruncated bool `json:"keysTruncated,omitempty"`
+}
+
+// The identity of the client sending a request, as recorded in the audit log
+type requestAuditIdentity struct {
+	accessKeyHash             string
+	tokenSubject              string
+	clientCertificateIdentity string
+	remoteIP                  string
+}
+
+// Creates an audit record for the given transaction. At most 'maxKeys' keys are recorded, where 0 or less
+// means there is no limit.
+func NewAuditRecord(identity *requestAuditIdentity, datastoreName string, method string, commitTimestamp int64, transactionBytes []byte, maxKeys int64) (*AuditRecord, error) {
+	record := &AuditRecord{
+		Datastore:         datastoreName,
+		Method:            method,
+		CommitTimestamp:   commitTimestamp,
+		AccessKeyHash:     identity.accessKeyHash,
+		TokenSubject:      identity.tokenSubject,
+		ClientCertificate: identity.clientCertificateIdentity,
+		RemoteIP:          identity.remoteIP,
+		ByteSize:          int64(len(transactionBytes)),
+		Keys:              []string{},
+	}
+
+	// Create an iterator to the transaction's entries
+	next := NewEntryStreamIterator(bytes.NewReader(transactionBytes), 0, int64(len(transactionBytes)))
+
+	for {
+		// Iterate to next result
+		iteratorResult, err := next()
+		if err != nil {
+			return nil, err
+		}
+
+		// If the iterator finished, return the record
+		if iteratorResult == nil {
+			return record, nil
+		}
+
+		record.EntryCount++
+
+		// If the maximum number of keys was already recorded, skip the key
+		if maxKeys > 0 && int64(len(record.Keys)+len(record.BinaryKeys)) >= maxKeys {
+			record.KeysTruncated = true
+			continue
+		}
+
+		// Record the key, hex encoding it if it isn't textual
+		if iteratorResult.Header.EncryptionMethod != 0 || iteratorResult.Header.KeyFormat == DataFormat_Binary {
+			keyBytes, err := iteratorResult.ReadKey()
+			if err != nil {
+				return nil, err
+			}
+
+			record.BinaryKeys = append(record.BinaryKeys, hex.EncodeToString(keyBytes))
+		} else {
+			key, err := readDecodedEntryKey(iteratorResult)
+			if err != nil {
+				return nil, err
+			}
+
+			record.Keys = append(record.Keys, key)
+		}
+	}
+}
+
+// Checks if audit records should be written for committed writes
+func (this *Server) AuditEnabled() bool {
+	config, err := this.GetConfigSnapshot(".config")
+	if err != nil {
+		return false
+	}
+
+	enabled, _ := config.GetBool_GlobalOnly("['server']['audit']['enabled']")
+
+	return enabled
+}
+
+// Writes an audit record for a committed transaction, if auditing is enabled
+func (this *Server) RecordAuditedWrite(identity *requestAuditIdentity, datastoreName string, method string, commitTimestamp int64, transactionBytes []byte) error {
+	if identity == nil || !this.AuditEnabled() {
+		return nil
+	}
+
+	config, err := this.GetConfigSnapshot(".config")
+	if err != nil {
+		return err
+	}
+
+	maxKeys, err := config.GetInt64_GlobalOnly("['server']['audit']['maxKeys']")
+	if err != nil {
+		maxKeys = 1000
+	}
+
+	record, err := NewAuditRecord(identity, datastoreName, method, commitTimestamp, transactionBytes, maxKeys)
+	if err != nil {
+		return err
+	}
+
+	return this.AppendAuditRecord(record)
+}
+
+// Appends a record to the audit datastore, creating it if needed, and discards records exceeding the
+// configured maximum age
+func (this *Server) AppendAuditRecord(record *AuditRecord) error {
+	config, err := this.GetConfigSnapshot(".config")
+	if err != nil {
+		return err
+	}
+
+	// Serialize the record. Its key combines the commit timestamp, datastore name and method, to ensure
+	// it is unique.
+	serializedKey, _ := json.Marshal(fmt.Sprintf("%d:%s:%s", record.CommitTimestamp, record.Datastore, record.Method))
+
+	serializedRecord, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	// Get the maximum flush delay configured for datastores
+	maxFlushDelay, err := config.GetInt64_GlobalOnly("['datastore']['flush']['maxDelay']")
+	if err != nil || maxFlushDelay < 0 {
+		maxFlushDelay = 0
+	}
+
+	// Get the operations object for the audit datastore
+	operations := this.GetDatastoreOperations(AuditDatastoreName)
+
+	// Wait to enter the writer queue, and leave it when the function exits
+	writerQueueToken := operations.WriterQueue.Enter()
+	defer operations.WriterQueue.Leave(writerQueueToken)
+
+	// Append the record
+	_, err = this.appendJsonEntries(operations, []JsonEntry{JsonEntry{string(serializedKey), string(serializedRecord)}}, maxFlushDelay)
+	if err != nil {
+		return err
+	}
+
+	// Discard records exceeding the maximum age, if one is set and enough time has passed since the last check
+	maxAge, _ := config.GetInt64_GlobalOnly("['server']['audit']['maxAge']")
+	currentTime := MonoUnixTimeMilli()
+
+	if maxAge > 0 && currentTime >= this.lastAuditRetentionCheckTime+auditRetentionCheckInterval {
+		this.lastAuditRetentionCheckTime = currentTime
+
+		discardedSize, err := operations.DiscardEntriesUpdatedUntil(operations.State, (currentTime-maxAge)*1000)
+		if err != nil {
+			return err
+		}
+
+		if discardedSize > 0 {
+			this.Logf(1, "Discarded %d bytes of audit records older than %dms", discardedSize, maxAge)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/json"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Server", func() {
+	var context *ServerTestContext
+
+	BeforeEach(func() {
+		context = NewServerTestContext()
+		context.Start()
+	})
+
+	AfterEach(func() {
+		context.Stop()
+	})
+
+	getAuditRecords := func() []AuditRecord {
+		entries, err := context.GetClient(AuditDatastoreName, "").Get(0)
+		Expect(err).To(BeNil())
+
+		records := []AuditRecord{}
+
+		// Skip the head entry
+		for _, entry := range entries[1:] {
+			var record AuditRecord
+			Expect(json.Unmarshal(entry.Value, &record)).To(BeNil())
+			records = append(records, record)
+		}
+
+		return records
+	}
+
+	It("Records committed writes in the audit datastore", func() {
+		err := context.PutGlobalSetting(`"['server']['audit']['enabled']"`, `true`, "")
+		Expect(err).To(BeNil())
+
+		accessKey, accessKeyHash := context.GetRandomAccessKey()
+		datastoreName := RandomWordString(12)
+
+		err = context.PutDatastoreSetting(datastoreName, `"['datastore']['accessKeyHash']['`+accessKeyHash+`']"`, `"ReaderWriter"`, "")
+		Expect(err).To(BeNil())
+
+		client := context.GetClient(datastoreName, accessKey)
+		testEntries := context.GetTestEntries()
+
+		putCommitTimestamp, err := client.Put(testEntries[0:2])
+		Expect(err).To(BeNil())
+
+		postCommitTimestamp, err := client.Post(testEntries[2:3])
+		Expect(err).To(BeNil())
+
+		Expect(context.GetClient(datastoreName, "").Delete()).To(BeNil())
+
+		// Find the records for the datastore (writes to its configuration datastore are recorded as well)
+		records := []AuditRecord{}
+
+		for _, record := range getAuditRecords() {
+			if record.Datastore == datastoreName {
+				records = append(records, record)
+			}
+		}
+
+		Expect(records).To(HaveLen(3))
+
+		Expect(records[0].Method).To(Equal("PUT"))
+		Expect(records[0].CommitTimestamp).To(Equal(putCommitTimestamp))
+		Expect(records[0].AccessKeyHash).To(Equal(accessKeyHash))
+		Expect(records[0].RemoteIP).To(Equal("127.0.0.1"))
+		Expect(records[0].EntryCount).To(Equal(int64(2)))
+		Expect(records[0].ByteSize).To(BeNumerically(">", 0))
+		Expect(records[0].Keys).To(Equal([]string{"Key1", "Key2"}))
+
+		Expect(records[1].Method).To(Equal("POST"))
+		Expect(records[1].CommitTimestamp).To(Equal(postCommitTimestamp))
+		Expect(records[1].Keys).To(Equal([]string{"Key3"}))
+
+		Expect(records[2].Method).To(Equal("DELETE"))
+		Expect(records[2].CommitTimestamp).To(BeNumerically(">", postCommitTimestamp))
+		Expect(records[2].EntryCount).To(Equal(int64(0)))
+	})
+
+	It("Doesn't record writes when auditing is disabled", func() {
+		_, err := context.GetClientForRandomDatastore("").Put(context.GetTestEntries())
+		Expect(err).To(BeNil())
+
+		_, err = context.GetClient(AuditDatastoreName, "").Get(0)
+		Expect(err).NotTo(BeNil())
+		Expect(err.Error()).To(ContainSubstring("404"))
+	})
+
+	It("Only allows reading the audit datastore with the master key", func() {
+		err := context.PutGlobalSetting(`"['server']['audit']['enabled']"`, `true`, "")
+		Expect(err).To(BeNil())
+
+		accessKey, accessKeyHash := context.GetRandomAccessKey()
+
+		err = context.PutGlobalSetting(`"['datastore']['accessKeyHash']['`+accessKeyHash+`']"`, `"ReaderWriter"`, "")
+		Expect(err).To(BeNil())
+
+		_, err = context.GetClient(AuditDatastoreName, accessKey).Get(0)
+		Expect(err).NotTo(BeNil())
+		Expect(err.Error()).To(ContainSubstring("401"))
+
+		_, err = context.GetClient(AuditDatastoreName, "").Post(context.GetTestEntries())
+		Expect(err).NotTo(BeNil())
+		Expect(err.Error()).To(ContainSubstring("405"))
+
+		Expect(context.GetClient(AuditDatastoreName, "").Delete()).NotTo(BeNil())
+
+		Expect(getAuditRecords()).NotTo(BeEmpty())
+	})
+
+	It("Discards entries committed up to a given time while preserving the remaining ones", func() {
+		client := context.GetClientForRandomDatastore("")
+		testEntries := context.GetTestEntries()
+
+		_, err := client.Put(testEntries[0:1])
+		Expect(err).To(BeNil())
+
+		secondCommitTimestamp, err := client.Post(testEntries[1:3])
+		Expect(err).To(BeNil())
+
+		thirdCommitTimestamp, err := client.Post(testEntries[3:5])
+		Expect(err).To(BeNil())
+
+		operations := context.server.GetDatastoreOperations(client.datastoreName)
+		writerQueueToken := operations.WriterQueue.Enter()
+		discardedSize, err := operations.DiscardEntriesUpdatedUntil(operations.State, secondCommitTimestamp)
+		operations.WriterQueue.Leave(writerQueueToken)
+
+		Expect(err).To(BeNil())
+		Expect(discardedSize).To(BeNumerically(">", 0))
+
+		entries, err := client.Get(0)
+		Expect(err).To(BeNil())
+		ExpectEntryArraysToBeEquivalent(entries[1:], testEntries[3:5])
+		Expect(entries[1].Header.CommitTime).To(Equal(thirdCommitTimestamp))
+
+		// Clients that already received the retained entries shouldn't receive them again
+		entries, err = client.Get(thirdCommitTimestamp)
+		Expect(err).To(BeNil())
+		Expect(entries).To(BeEmpty())
+	})
+})
+
+var _ = Describe("AuditRecord", func() {
+	It("Records textual and binary keys, up to the given maximum", func() {
+		transaction := SerializeEntries([]Entry{
+			Entry{&EntryHeader{KeyFormat: DataFormat_JSON, ValueFormat: DataFormat_JSON}, []byte(`"Key1"`), []byte(`1`)},
+			Entry{&EntryHeader{KeyFormat: DataFormat_Binary, ValueFormat: DataFormat_Binary}, []byte{1, 2, 255}, []byte{4}},
+			Entry{&EntryHeader{KeyFormat: DataFormat_UTF8, ValueFormat: DataFormat_UTF8, Flags: Flag_TransactionEnd}, []byte(`Key3`), []byte(`Value`)},
+		})
+
+		identity := &requestAuditIdentity{tokenSubject: "alice", remoteIP: "203.0.113.7"}
+
+		record, err := NewAuditRecord(identity, "MyDatastore", "POST", 1234, transaction, 0)
+		Expect(err).To(BeNil())
+		Expect(record.EntryCount).To(Equal(int64(3)))
+		Expect(record.ByteSize).To(Equal(int64(len(transaction))))
+		Expect(record.TokenSubject).To(Equal("alice"))
+		Expect(record.Keys).To(Equal([]string{"Key1", "Key3"}))
+		Expect(record.BinaryKeys).To(Equal([]string{"0102ff"}))
+		Expect(record.KeysTruncated).To(BeFalse())
+
+		record, err = NewAuditRecord(identity, "MyDatastore", "POST", 1234, transaction, 2)
+		Expect(err).To(BeNil())
+		Expect(record.EntryCount).To(Equal(int64(3)))
+		Expect(record.Keys).To(Equal([]string{"Key1"}))
+		Expect(record.BinaryKeys).To(Equal([]string{"0102ff"}))
+		Expect(record.KeysTruncated).To(BeTrue())
+	})
+})
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"regexp"
+)
+
+// Declare the IP ban management handler object type
+type ServerBanHandler struct {
+	parentServer *Server
+}
+
+// IP ban management handler object constructor function
+func NewServerBanHandler(parentServer *Server) *ServerBanHandler {
+	return &ServerBanHandler{
+		parentServer: parentServer,
+	}
+}
+
+var banPathRegexp *regexp.Regexp
+
+func init() {
+	banPathRegexp = regexp.MustCompile(`^/admin/bans(?:/([0-9a-fA-F\.:]+))?$`)
+}
+
+// The main handler for IP ban management requests. The master key has already been verified at this point.
+func (this *ServerBanHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// Parse the path to get the IP, if included
+	requestPathSubmatches := banPathRegexp.FindStringSubmatch(r.URL.Path)
+
+	if len(requestPathSubmatches) == 0 {
+		endRequestWithError(w, r, http.StatusBadRequest, errors.New("Invalid request path, should be of the form '/admin/bans' or '/admin/bans/[ip]'."))
+		return
+	}
+
+	ip := requestPathSubmatches[1]
+
+	var err error
+
+	switch {
+	case r.Method == "GET" && ip == "":
+		// List the active bans
+		err = endRequestWithJson(w, r, this.parentServer.ipBanList.List())
+	case r.Method == "DELETE" && ip != "":
+		err = this.handleLiftRequest(w, r, ip)
+	default:
+		endRequestWithError(w, r, http.StatusMethodNotAllowed, nil)
+	}
+
+	// If an error occured, and wasn't properly handled to end the request, end the request
+	// with an 'Internal Server Error' response
+	if err != nil {
+		this.parentServer.Log(1, err)
+		endRequestWithError(w, r, http.StatusInternalServerError, err)
+	}
+}
+
+// Lifts the ban for the given IP
+func (this *ServerBanHandler) handleLiftRequest(w http.ResponseWriter, r *http.Request, ip string) (err error) {
+	if net.ParseIP(ip) == nil {
+		endRequestWithError(w, r, http.StatusBadRequest, errors.New("Invalid IP '"+ip+"'."))
+		return
+	}
+
+	// If the IP isn't banned, end with a 404 Not Found status
+	if !this.parentServer.ipBanList.Lift(ip) {
+		endRequestWithError(w, r, http.StatusNotFound, nil)
+		return
+	}
+
+	this.parentServer.Logf(1, "Lifted ban for IP '%s'", ip)
+
+	// Set the response content type to plain text
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	// Write the header
+	w.WriteHeader(http.StatusOK)
+
+	return
+}
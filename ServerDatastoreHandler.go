@@ -11,6 +11,7 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gorilla/websocket"
 )
@@ -33,7 +34,7 @@ var accessKeyRegexp *regexp.Regexp
 
 func init() {
 	// Initialize helper regular expression objects
-	datastorePathRegexp = regexp.MustCompile(`^/datastore/([a-zA-Z0-9_]*(\.config)?)$`)
+	datastorePathRegexp = regexp.MustCompile(`^/datastore/([a-zA-Z0-9_]*(\.config)?|\.audit)$`)
 	accessKeyRegexp = regexp.MustCompile(`^[0-9a-f]*$`)
 }
 
@@ -44,9 +45,6 @@ func (this *ServerDatastoreHandler) ServeHTTP(w http.ResponseWriter, r *http.Req
 
 	// If no valid datastore name was found
 	if len(requestPathSubmatches) == 0 || len(requestPathSubmatches[1]) == 0 || len(requestPathSubmatches[1]) > 128 {
-		// Log a message
-		this.parentServer.Log(1, "["+r.RemoteAddr+"]: "+r.Method+" "+r.URL.Path+" <invalid path>")
-
 		// Ensure that cross-origin requests will also be able to receive the error
 		w.Header().Set("Access-Control-Allow-Origin", "*")
 
@@ -59,6 +57,10 @@ func (this *ServerDatastoreHandler) ServeHTTP(w http.ResponseWriter, r *http.Req
 	// Get target datastore name from the match results
 	datastoreName := requestPathSubmatches[1]
 
+	// Get the object holding the details included when the request is logged
+	logInfo := GetRequestLogInfo(r)
+	logInfo.Datastore = datastoreName
+
 	// Get a configuration snapshot for the datastore
 	config, configLoadErr := this.parentServer.GetConfigSnapshot(datastoreName)
 
@@ -105,35 +107,44 @@ func (this *ServerDatastoreHandler) ServeHTTP(w http.ResponseWriter, r *http.Req
 	parsedQuery := r.URL.Query()
 
 	// Get the access key included in the request
-	accessKey := parsedQuery.Get("accessKey")
+	accessKey, accessKeySource, accessKeyErr := GetRequestAccessKey(r, parsedQuery)
 
-	// Calculate the hex representation of the access key hash
-	var accessKeyHash string
+	if accessKeyErr != nil {
+		endRequestWithError(w, r, http.StatusBadRequest, accessKeyErr)
+		return
+	}
 
-	if len(accessKey) == 0 {
-		accessKeyHash = ""
-	} else {
-		accessKeyHash = SHA1ToHex([]byte(accessKey))
+	// Get an access key hasher for the configured scheme
+	accessKeyHasher, hasherErr := this.parentServer.GetAccessKeyHasher(config)
+
+	if hasherErr != nil {
+		endRequestWithError(w, r, http.StatusInternalServerError, hasherErr)
+		return
 	}
 
-	// Print a log message if needed
-	logLevel := this.parentServer.startupOptions.LogLevel
+	// Calculate the access key hash, using the configured scheme
+	accessKeyHash := accessKeyHasher.Hash(accessKey)
 
-	if logLevel > 0 {
-		secureURI := strings.Replace(r.RequestURI, "accessKey="+accessKey, "[accessKeyHash="+accessKeyHash+"]", 1)
+	// Log the request URI with the access key replaced by its hash. The request itself is logged once it
+	// has been handled, but its headers are logged here, if needed.
+	secureURI := strings.Replace(r.RequestURI, "accessKey="+accessKey, "[accessKeyHash="+accessKeyHash+"]", 1)
+	logInfo.URI = secureURI
 
-		if logLevel >= 2 {
-			message := "\n"
-			message += "[" + r.RemoteAddr + "]: " + method + " " + secureURI + "\n"
+	if this.parentServer.LogLevel() >= 2 {
+		message := "\n"
+		message += "[" + r.RemoteAddr + "]: " + method + " " + secureURI + "\n"
 
-			for k, v := range r.Header {
-				message += fmt.Sprintf("%s: %s\n", k, v)
+		for k, v := range r.Header {
+			// Omit the values of headers that may include the access key
+			if isAccessKeyBearingHeader(k) {
+				message += fmt.Sprintf("%s: [redacted]\n", k)
+				continue
 			}
 
-			this.parentServer.Log(2, message)
-		} else if logLevel == 1 {
-			this.parentServer.Log(1, "["+r.RemoteAddr+"]: "+method+" "+secureURI)
+			message += fmt.Sprintf("%s: %s\n", k, v)
 		}
+
+		this.parentServer.LogFields(2, message, LogFields{"datastore": datastoreName, "method": method})
 	}
 
 	// For the rest of this function, a 'HEAD' request is treated the same as 'GET'
@@ -141,31 +152,162 @@ func (this *ServerDatastoreHandler) ServeHTTP(w http.ResponseWriter, r *http.Req
 		method = "GET"
 	}
 
-	// Verify the access key has a valid length and character set
-	if len(accessKey) > 0 && (len(accessKey) != 32 || !accessKeyRegexp.MatchString(accessKey)) {
-		endRequestWithError(w, r, http.StatusBadRequest, errors.New("A non-empty access key must contain exactly 32 lowercase hexedecimal digits."))
+	// Verify the access key was included in a source allowed by the configuration
+	accessKeyErr = CheckAccessKeySourceAllowed(config, accessKeySource)
+
+	if accessKeyErr != nil {
+		endRequestWithError(w, r, http.StatusUnauthorized, accessKeyErr)
 		return
 	}
 
-	// Get master key hash
-	masterKeyHash, _ := config.GetString_GlobalOnly("['server']['masterKeyHash']")
+	// Resolve the access profile for the request, and the identifier used for rate limiting. Master key
+	// requests aren't associated with any profile.
+	isMasterKeyRequest := false
+	accessProfileName := ""
+	clientIdentifier := ""
 
-	// Check authorization and rate limits
-	if accessKeyHash != masterKeyHash {
+	// Sets of key prefixes the request is allowed to write to. Every key written must start with a
+	// prefix from each one of the sets.
+	var writeKeyPrefixRestrictions [][]string
+
+	// The subject of the signed token included with the request, if any
+	tokenSubject := ""
+
+	// Find the access profile mapped to the verified client certificate sent with the request, if any. It
+	// is only used if the request doesn't include an access key or a signed token.
+	clientCertificateIdentity := ""
+	clientCertificateProfileName := ""
+
+	if clientCertificate := GetVerifiedClientCertificate(r); clientCertificate != nil && accessKey == "" {
+		clientCertificateIdentity, clientCertificateProfileName, _ = FindClientCertificateProfile(config, clientCertificate)
+	}
+
+	if IsAccessToken(accessKey) {
+		// A signed token can never be used to access a configuration datastore or the audit datastore
 		if IsConfigDatastoreName(datastoreName) {
 			endRequestWithError(w, r, http.StatusUnauthorized, errors.New("A configuration datastore can only be accessed through the master key."))
 			return
 		}
 
-		// Find the access profile for the given access key hash
-		accessProfileName, err := config.GetString("['datastore']['accessKeyHash']['" + accessKeyHash + "']")
+		if datastoreName == AuditDatastoreName {
+			endRequestWithError(w, r, http.StatusUnauthorized, errors.New("The audit datastore can only be accessed through the master key."))
+			return
+		}
 
-		if err != nil {
-			// If a configuration entry wasn't found for the given key, end with an error
-			endRequestWithError(w, r, http.StatusUnauthorized, errors.New("Invalid access key."))
+		// Verify the token and get its claims
+		claims, tokenErr := this.parentServer.VerifyAccessToken(config, accessKey)
+
+		if tokenErr != nil {
+			endRequestWithError(w, r, http.StatusUnauthorized, tokenErr)
 			return
 		}
 
+		// Ensure the token grants access to the requested datastore
+		if !claims.AllowsDatastore(datastoreName) {
+			endRequestWithError(w, r, http.StatusForbidden, errors.New(fmt.Sprintf("The access token does not grant access to the datastore '%s'.", datastoreName)))
+			return
+		}
+
+		accessProfileName = claims.Profile
+		clientIdentifier = "token:" + claims.Subject
+		tokenSubject = claims.Subject
+
+		if claims.KeyPrefix != "" {
+			writeKeyPrefixRestrictions = append(writeKeyPrefixRestrictions, []string{claims.KeyPrefix})
+		}
+	} else if clientCertificateIdentity != "" {
+		// A client certificate can never be used to access a configuration datastore or the audit datastore
+		if IsConfigDatastoreName(datastoreName) {
+			endRequestWithError(w, r, http.StatusUnauthorized, errors.New("A configuration datastore can only be accessed through the master key."))
+			return
+		}
+
+		if datastoreName == AuditDatastoreName {
+			endRequestWithError(w, r, http.StatusUnauthorized, errors.New("The audit datastore can only be accessed through the master key."))
+			return
+		}
+
+		accessProfileName = clientCertificateProfileName
+		clientIdentifier = "cert:" + clientCertificateIdentity
+	} else {
+		// Verify the access key has a valid length and character set
+		if len(accessKey) > 0 && (len(accessKey) != 32 || !accessKeyRegexp.MatchString(accessKey)) {
+			endRequestWithError(w, r, http.StatusBadRequest, errors.New("A non-empty access key must contain exactly 32 lowercase hexedecimal digits."))
+			return
+		}
+
+		// Get master key hash
+		masterKeyHash, _ := config.GetString_GlobalOnly("['server']['masterKeyHash']")
+
+		if accessKeyHasher.Matches(accessKey, masterKeyHash) {
+			isMasterKeyRequest = true
+		} else {
+			if IsConfigDatastoreName(datastoreName) {
+				endRequestWithError(w, r, http.StatusUnauthorized, errors.New("A configuration datastore can only be accessed through the master key."))
+				return
+			}
+
+			if datastoreName == AuditDatastoreName {
+				endRequestWithError(w, r, http.StatusUnauthorized, errors.New("The audit datastore can only be accessed through the master key."))
+				return
+			}
+
+			// Find the access profile for the given access key hash. Hashes of all supported schemes are
+			// looked up, since keys stored with a previous scheme are still valid until migrated.
+			err := ErrNotFound
+
+			for _, candidateHash := range accessKeyHasher.CandidateHashes(accessKey) {
+				accessProfileName, err = config.GetString("['datastore']['accessKeyHash']['" + candidateHash + "']")
+
+				if err == nil {
+					accessKeyHash = candidateHash
+					break
+				}
+			}
+
+			if err != nil {
+				// If a configuration entry wasn't found for the given key, end with an error
+				endRequestWithError(w, r, http.StatusUnauthorized, errors.New("Invalid access key."))
+				return
+			}
+
+			clientIdentifier = accessKeyHash
+		}
+	}
+
+	logInfo.ClientID = clientIdentifier
+
+	// The audit datastore is only written to by the server itself
+	if datastoreName == AuditDatastoreName && method != "GET" && method != "WebSocket" {
+		endRequestWithError(w, r, http.StatusMethodNotAllowed, errors.New("The audit datastore is read-only."))
+		return
+	}
+
+	// Get the origin IP of the request
+	remoteHost, _, _ := net.SplitHostPort(r.RemoteAddr)
+
+	// The identity recorded in the audit log for writes sent by the request
+	auditIdentity := &requestAuditIdentity{
+		tokenSubject:              tokenSubject,
+		clientCertificateIdentity: clientCertificateIdentity,
+		remoteIP:                  remoteHost,
+	}
+
+	if !IsAccessToken(accessKey) && clientCertificateIdentity == "" {
+		auditIdentity.accessKeyHash = accessKeyHash
+	}
+
+	// A function releasing the WebSocket connection slot acquired for the request, if any
+	var releaseWebSocketConnection func()
+
+	// The bandwidth limit applying to the request, if any
+	var bandwidthLimit *requestBandwidthLimit
+
+	// The storage quota applying to the request, if any
+	var storageQuota *principalStorageQuota
+
+	// Check authorization and rate limits
+	if !isMasterKeyRequest {
 		// Check if the profile support the requested method
 		profileForMethodPrefix := "['accessProfile']['" + accessProfileName + "']['method']['" + method + "']"
 		methodAllowed, _ := config.GetBool(profileForMethodPrefix + "['allowed']")
@@ -176,9 +318,21 @@ func (this *ServerDatastoreHandler) ServeHTTP(w http.ResponseWriter, r *http.Req
 			return
 		}
 
+		// Add the key prefixes the profile is allowed to write to, if it defines any
+		profileWriteKeyPrefixes := getProfileWriteKeyPrefixes(config, accessProfileName, tokenSubject)
+
+		if profileWriteKeyPrefixes != nil {
+			writeKeyPrefixRestrictions = append(writeKeyPrefixRestrictions, profileWriteKeyPrefixes)
+		}
+
+		// Requests restricted to particular key prefixes cannot replace or destroy the entire datastore
+		if len(writeKeyPrefixRestrictions) > 0 && (method == "PUT" || method == "DELETE") {
+			endRequestWithError(w, r, http.StatusForbidden, errors.New(fmt.Sprintf("'%s' requests are not permitted when writes are restricted to particular key prefixes.", method)))
+			return
+		}
+
 		// Parse the host and port of the client's IP and combine them to a client ID string
-		remoteHost, _, _ := net.SplitHostPort(r.RemoteAddr)
-		clientID := accessKeyHash + "@" + remoteHost
+		clientID := clientIdentifier + "@" + remoteHost
 
 		// Check request rate limits
 		requestLimitInterval, _ := config.GetInt64(profileForMethodPrefix + "['limit']['requests']['interval']")
@@ -188,13 +342,65 @@ func (this *ServerDatastoreHandler) ServeHTTP(w http.ResponseWriter, r *http.Req
 			requestLimitCount, _ := config.GetInt64(profileForMethodPrefix + "['limit']['requests']['count']")
 
 			// Use the rate limiter object to decide if the allowed request rate has been exceeded
-			allowed := this.parentServer.rateLimiter.ProcessEvent(datastoreName, clientID, method, requestLimitInterval, requestLimitCount)
+			rateLimitResult := this.parentServer.rateLimiter.ProcessWeightedEvent(datastoreName, clientID, method, requestLimitInterval, requestLimitCount, 1)
 
 			// If the rate has been exceeded, end with an error
-			if !allowed {
+			if !rateLimitResult.Allowed {
+				this.parentServer.metrics.rateLimitRejections.Inc("requests")
+				setRetryAfterHeader(w, rateLimitResult.RetryAfter)
 				endRequestWithError(w, r, http.StatusTooManyRequests, errors.New(fmt.Sprintf("Maximum request rate exceeded. The client identifier '%s' is limited to %d %s requests per %dms.", clientID, requestLimitCount, method, requestLimitInterval)))
 				return
 			}
+
+			// Report the number of requests remaining
+			w.Header().Set("X-RateLimit-Remaining", strconv.FormatInt(rateLimitResult.Remaining, 10))
+		}
+
+		// Get the bandwidth limit, if set. For GET requests this applies to the size of the response body,
+		// and for POST and PUT requests to the size of the request body
+		byteLimitInterval, _ := config.GetInt64(profileForMethodPrefix + "['limit']['bytes']['interval']")
+
+		if byteLimitInterval > 0 {
+			byteLimitCount, _ := config.GetInt64(profileForMethodPrefix + "['limit']['bytes']['count']")
+
+			bandwidthLimit = &requestBandwidthLimit{
+				rateLimiter:   this.parentServer.rateLimiter,
+				metrics:       this.parentServer.metrics,
+				datastoreName: datastoreName,
+				clientID:      clientID,
+				operation:     method + ":bytes",
+				interval:      byteLimitInterval,
+				limit:         byteLimitCount,
+			}
+		}
+
+		// Check the limit on parallel WebSocket connections
+		if method == "WebSocket" {
+			maxParallelConnections, _ := config.GetInt64(profileForMethodPrefix + "['limit']['parallelConnections']['max']")
+
+			if maxParallelConnections > 0 {
+				var acquired bool
+				releaseWebSocketConnection, acquired = this.parentServer.webSocketConnectionLimiter.TryAcquire(clientID, maxParallelConnections)
+
+				// If the client already has the maximum number of connections open, end with an error
+				if !acquired {
+					this.parentServer.metrics.rateLimitRejections.Inc("parallelConnections")
+					endRequestWithError(w, r, http.StatusTooManyRequests, errors.New(fmt.Sprintf("Maximum parallel connections exceeded. The client identifier '%s' is limited to %d parallel WebSocket connections.", clientID, maxParallelConnections)))
+					return
+				}
+
+				// Ensure the slot is released when the request ends, if it wasn't released earlier
+				defer releaseWebSocketConnection()
+			}
+		}
+
+		// Get the storage quota for the principal sending the request. The principal is tracked even if
+		// no quota is set, such that datastores it creates would be recorded as owned by it.
+		maxTotalSize, _ := config.GetInt64("['accessProfile']['" + accessProfileName + "']['limit']['storage']['maxTotalSize']")
+
+		storageQuota = &principalStorageQuota{
+			principal:    clientIdentifier,
+			maxTotalSize: maxTotalSize,
 		}
 
 		// Check permissions for each individual request parameter in the query part of the request URI
@@ -222,14 +428,14 @@ func (this *ServerDatastoreHandler) ServeHTTP(w http.ResponseWriter, r *http.Req
 	// the particular method requested
 	switch method {
 	case "GET": // 'HEAD' is also included here as the 'method' variable would be changed to 'GET' in that case
-		err = this.handleGetOrHeadRequest(w, r, datastoreName, operations, parsedQuery)
+		err = this.handleGetOrHeadRequest(w, r, datastoreName, operations, parsedQuery, bandwidthLimit)
 	case "WebSocket": // This method string was converted from GET earlier, if the request had an upgrade to WebSocket
-		err = this.handleWebsocketRequest(w, r, datastoreName, operations, parsedQuery)
+		err = this.handleWebsocketRequest(w, r, datastoreName, operations, parsedQuery, releaseWebSocketConnection)
 		err = nil
 	case "POST", "PUT":
-		err = this.handlePostOrPutRequest(w, r, datastoreName, operations, parsedQuery, config)
+		err = this.handlePostOrPutRequest(w, r, datastoreName, operations, parsedQuery, config, writeKeyPrefixRestrictions, bandwidthLimit, storageQuota, auditIdentity)
 	case "DELETE":
-		err = this.handleDeleteRequest(w, r, datastoreName, operations, parsedQuery)
+		err = this.handleDeleteRequest(w, r, datastoreName, operations, parsedQuery, auditIdentity)
 	default:
 		endRequestWithError(w, r, http.StatusMethodNotAllowed, nil)
 	}
@@ -243,7 +449,7 @@ func (this *ServerDatastoreHandler) ServeHTTP(w http.ResponseWriter, r *http.Req
 }
 
 // Handles a GET or HEAD request
-func (this *ServerDatastoreHandler) handleGetOrHeadRequest(w http.ResponseWriter, r *http.Request, datastoreName string, operations *DatastoreOperations, query url.Values) (err error) {
+func (this *ServerDatastoreHandler) handleGetOrHeadRequest(w http.ResponseWriter, r *http.Request, datastoreName string, operations *DatastoreOperations, query url.Values, bandwidthLimit *requestBandwidthLimit) (err error) {
 	// Parse the "updatedAfter" query parameter (ParseInt returns 0 if string was empty or invalid).
 	updatedAfter, _ := strconv.ParseInt(query.Get("updatedAfter"), 10, 64)
 
@@ -254,6 +460,16 @@ func (this *ServerDatastoreHandler) handleGetOrHeadRequest(w http.ResponseWriter
 		return
 	}
 
+	// Get the requested response format
+	format := query.Get("format")
+
+	// If an unsupported format was given, error
+	if format != "" && format != "binary" && format != "ndjson" {
+		endRequestWithError(w, r, http.StatusBadRequest, errors.New("Unsupported response format '"+format+"'. Should be either 'binary' or 'ndjson'."))
+
+		return
+	}
+
 	// Load the datastore if needed
 	state, err := operations.LoadIfNeeded(true)
 
@@ -278,10 +494,27 @@ func (this *ServerDatastoreHandler) handleGetOrHeadRequest(w http.ResponseWriter
 	if query.Get("waitUntilNonempty") == "true" && updatedAfter >= lastModifiedTime {
 		state.Decrement()
 
-		waitGroup := operations.UpdateNotifier.CreateUpdateNotification(updatedAfter)
-		waitGroup.Wait()
+		longPollTimeout := this.parentServer.startupOptions.LongPollTimeout
+		writeTimeout := this.parentServer.startupOptions.WriteTimeout
+		responseController := http.NewResponseController(w)
 
-		err = this.handleGetOrHeadRequest(w, r, datastoreName, operations, query)
+		// Clear the write deadline set by the HTTP server while waiting (which may be indefinitely), and set
+		// a fresh one once the wait is over, such that the response is allowed the full write timeout
+		if writeTimeout > 0 {
+			responseController.SetWriteDeadline(time.Time{})
+		}
+
+		// If no update occurred before the long-poll timeout elapsed, or the server started shutting down,
+		// respond immediately with whatever is available (possibly no entries)
+		if !operations.UpdateNotifier.WaitForUpdate(updatedAfter, longPollTimeout, this.parentServer.ShutdownInitiated()) {
+			query.Del("waitUntilNonempty")
+		}
+
+		if writeTimeout > 0 {
+			responseController.SetWriteDeadline(time.Now().Add(writeTimeout))
+		}
+
+		err = this.handleGetOrHeadRequest(w, r, datastoreName, operations, query, bandwidthLimit)
 		return
 	}
 
@@ -296,6 +529,34 @@ func (this *ServerDatastoreHandler) handleGetOrHeadRequest(w http.ResponseWriter
 		return err
 	}
 
+	// If the request had a GET method, ensure sending the response wouldn't exceed the bandwidth limit.
+	// For newline delimited JSON responses, the size of the equivalent binary response is used.
+	if r.Method == "GET" && !bandwidthLimit.consume(w, r, readSize) {
+		return
+	}
+
+	// If newline delimited JSON was requested
+	if format == "ndjson" {
+		// Set headers for the response. The length of the converted output isn't known in advance, so a
+		// 'Content-Length' header isn't sent
+		w.Header().Set("Cache-Control", "max-age=0")
+		w.Header().Set("Content-Type", "application/x-ndjson")
+
+		// Write the header
+		w.WriteHeader(http.StatusOK)
+
+		// If the request had a GET method (HEAD would skip this), convert and send the matching entries
+		if r.Method == "GET" {
+			err = operations.WriteJsonRecords(w, state, updatedAfter)
+
+			if err == nil {
+				this.parentServer.metrics.bytesRead.Add(float64(readSize))
+			}
+		}
+
+		return
+	}
+
 	// Set headers for the response
 	w.Header().Set("Cache-Control", "max-age=0")
 	w.Header().Set("Content-Type", "application/octet-stream")
@@ -315,13 +576,17 @@ func (this *ServerDatastoreHandler) handleGetOrHeadRequest(w http.ResponseWriter
 			// Any error during the reading of the datastore would result in an internal server error
 			return
 		}
+
+		// Record the bytes read
+		this.parentServer.metrics.bytesRead.Add(float64(readSize))
 	}
 
 	return
 }
 
-// Handles WebSocket upgrade requests
-func (this *ServerDatastoreHandler) handleWebsocketRequest(w http.ResponseWriter, r *http.Request, datastoreName string, operations *DatastoreOperations, query url.Values) (err error) {
+// Handles WebSocket upgrade requests. If not nil, 'releaseConnection' is called as soon as the connection
+// is closed by the client, to release the connection slot acquired for it.
+func (this *ServerDatastoreHandler) handleWebsocketRequest(w http.ResponseWriter, r *http.Request, datastoreName string, operations *DatastoreOperations, query url.Values, releaseConnection func()) (err error) {
 	// Parse the "updatedAfter" query parameter (ParseInt returns 0 if string was empty or invalid).
 	updatedAfter, _ := strconv.ParseInt(query.Get("updatedAfter"), 10, 64)
 
@@ -347,25 +612,57 @@ func (this *ServerDatastoreHandler) handleWebsocketRequest(w http.ResponseWriter
 		return
 	}
 
-	// Create a WebSocket upgrader object
+	// If the server is shutting down, don't accept new subscribers
+	if this.parentServer.ShuttingDown() {
+		endRequestWithError(w, r, http.StatusServiceUnavailable, errors.New("The server is shutting down."))
+		return nil
+	}
+
+	// Create a WebSocket upgrader object. The deadlines set by the HTTP server are cleared once the
+	// connection is upgraded, so a write deadline is set for each message instead.
+	webSocketWriteTimeout := this.parentServer.startupOptions.WebSocketWriteTimeout
+
 	var websocketUpgrader = websocket.Upgrader{
-		CheckOrigin: func(r *http.Request) bool { return true },
+		CheckOrigin:      func(r *http.Request) bool { return true },
+		HandshakeTimeout: webSocketWriteTimeout,
+	}
+
+	// If the access key was sent as a WebSocket subprotocol token, it must be selected in the response,
+	// otherwise browsers would fail the connection
+	var responseHeader http.Header
+
+	if accessKeyProtocol, found := getWebSocketAccessKeyProtocol(r); found {
+		responseHeader = http.Header{"Sec-Websocket-Protocol": []string{accessKeyProtocol}}
 	}
 
 	// Upgrade the request to a WebSocket request
-	ws, err := websocketUpgrader.Upgrade(w, r, nil)
+	ws, err := websocketUpgrader.Upgrade(w, r, responseHeader)
 	if err != nil {
 		return
 	}
 
+	// Count the connection as an active subscriber, and track it so it can be closed when the server shuts down
+	this.parentServer.metrics.webSocketSubscribers.Add(1)
+	this.parentServer.webSocketConnections.Add(ws)
+
 	// Handle messages sent by the client
 	go func() {
+		// Release the connection slot once the connection has been closed. This is done here since
+		// the sending loop below may remain blocked waiting for an update after the client disconnected.
+		if releaseConnection != nil {
+			defer releaseConnection()
+		}
+
+		defer this.parentServer.metrics.webSocketSubscribers.Add(-1)
+		defer this.parentServer.webSocketConnections.Remove(ws)
+
 		for {
 			messageType, _, err := ws.NextReader()
 
 			// If an error occurred while reading the next message
 			if err != nil {
-				// return the error
+				// Ensure the connection is closed and return
+				ws.Close()
 				return
 			}
 
@@ -410,9 +707,10 @@ func (this *ServerDatastoreHandler) handleWebsocketRequest(w http.ResponseWriter
 
 		// Create a datastore reader
 		var resultReader io.Reader
+		var readSize int64
 		var messageWriter io.WriteCloser
 
-		resultReader, _, err = operations.CreateReader(state, updatedAfter)
+		resultReader, readSize, err = operations.CreateReader(state, updatedAfter)
 
 		// If an error ocurred creating the reader
 		if err != nil {
@@ -424,6 +722,11 @@ func (this *ServerDatastoreHandler) handleWebsocketRequest(w http.ResponseWriter
 			return err
 		}
 
+		// Ensure a client that doesn't read its messages wouldn't hold the connection indefinitely
+		if webSocketWriteTimeout > 0 {
+			ws.SetWriteDeadline(time.Now().Add(webSocketWriteTimeout))
+		}
+
 		// Create a writer for a binary WebSocket message
 		messageWriter, err = ws.NextWriter(websocket.BinaryMessage)
 
@@ -453,12 +756,15 @@ func (this *ServerDatastoreHandler) handleWebsocketRequest(w http.ResponseWriter
 		// Close the websocket message writer object
 		messageWriter.Close()
 
+		// Record the bytes read
+		this.parentServer.metrics.bytesRead.Add(float64(readSize))
+
 		// Set the update time threshold to the last modified time
 		updatedAfter = lastModifiedTime
 	}
 }
 
-func (this *ServerDatastoreHandler) handlePostOrPutRequest(w http.ResponseWriter, r *http.Request, datastoreName string, operations *DatastoreOperations, query url.Values, config *DatastoreConfigSnapshot) (err error) {
+func (this *ServerDatastoreHandler) handlePostOrPutRequest(w http.ResponseWriter, r *http.Request, datastoreName string, operations *DatastoreOperations, query url.Values, config *DatastoreConfigSnapshot, writeKeyPrefixRestrictions [][]string, bandwidthLimit *requestBandwidthLimit, storageQuota *principalStorageQuota, auditIdentity *requestAuditIdentity) (err error) {
 	// Read the entire request body to memory
 	transactionBytes, err := ReadEntireStream(r.Body)
 	if err != nil {
@@ -472,6 +778,17 @@ func (this *ServerDatastoreHandler) handlePostOrPutRequest(w http.ResponseWriter
 		return
 	}
 
+	// Ensure the transaction wouldn't exceed the bandwidth limit
+	if !bandwidthLimit.consume(w, r, int64(len(transactionBytes))) {
+		return
+	}
+
+	// Get the schema defined for the datastore's values, if any
+	schema, err := GetConfiguredDatastoreSchema(config)
+	if err != nil {
+		return
+	}
+
 	// Wait to enter the writer queue
 	writerQueueToken := operations.WriterQueue.Enter()
 
@@ -481,6 +798,9 @@ func (this *ServerDatastoreHandler) handlePostOrPutRequest(w http.ResponseWriter
 	// Load the datastore if needed
 	state, err := operations.LoadIfNeeded(false)
 
+	// Remember if the datastore existed before the request
+	datastoreExisted := err == nil
+
 	// If an error ocurred while loading the datastore and the method was POST
 	if err != nil && !rewrite {
 		// If the error was a 'file not found' error
@@ -531,6 +851,43 @@ func (this *ServerDatastoreHandler) handlePostOrPutRequest(w http.ResponseWriter
 		return
 	}
 
+	// If a storage quota applies, hold the principal's lock until the transaction has been committed and
+	// the ownership of a new datastore has been recorded, such that the quota checks of concurrent writes
+	// by the same principal would include it
+	storageQuotaLock := this.parentServer.GetStorageQuotaLock(storageQuota)
+
+	if storageQuotaLock != nil {
+		storageQuotaLock.Lock()
+
+		defer func() {
+			if storageQuotaLock != nil {
+				storageQuotaLock.Unlock()
+			}
+		}()
+	}
+
+	// Make sure the transaction wouldn't cause the principal sending it to exceed its storage quota
+	var existingState *DatastoreState
+
+	if datastoreExisted {
+		existingState = state
+	}
+
+	err = this.parentServer.CheckStorageQuota(storageQuota, datastoreName, existingState, rewrite, int64(len(transactionBytes)))
+
+	if err != nil {
+		// Leave the writer queue
+		operations.WriterQueue.Leave(writerQueueToken)
+
+		// End the request with a 'forbidden' error if the quota would be exceeded
+		if _, ok := err.(ErrStorageQuotaExceeded); ok {
+			endRequestWithError(w, r, http.StatusForbidden, err)
+			err = nil
+		}
+
+		return
+	}
+
 	// Get the entry size limit
 	datastoreEntrySizeLimit, _ := config.GetInt64("['datastore']['limit']['maxEntrySize']")
 
@@ -541,6 +898,20 @@ func (this *ServerDatastoreHandler) handlePostOrPutRequest(w http.ResponseWriter
 	// for last entry
 	err = ValidateAndPrepareTransaction(transactionBytes, commitTimestamp, datastoreEntrySizeLimit)
 
+	// Ensure all entry keys are within the prefixes the request is allowed to write to
+	for _, allowedKeyPrefixes := range writeKeyPrefixRestrictions {
+		if err != nil {
+			break
+		}
+
+		err = ValidateTransactionKeyPrefixes(transactionBytes, allowedKeyPrefixes)
+	}
+
+	// Ensure all JSON values conform to the datastore's schema
+	if err == nil {
+		err = ValidateTransactionValues(transactionBytes, schema)
+	}
+
 	// If an error occurred while preparing the transaction
 	if err != nil {
 		// Leave the writer queue
@@ -561,6 +932,11 @@ func (this *ServerDatastoreHandler) handlePostOrPutRequest(w http.ResponseWriter
 			case ErrDatastoreEntrySizeLimitExceeded:
 				endRequestWithError(w, r, http.StatusForbidden, err)
 				err = nil
+
+			// Check for entry keys outside the permitted prefixes and respond with a forbidden request status
+			case ErrEntryKeyNotPermitted:
+				endRequestWithError(w, r, http.StatusForbidden, err)
+				err = nil
 			}
 		}
 
@@ -626,6 +1002,27 @@ func (this *ServerDatastoreHandler) handlePostOrPutRequest(w http.ResponseWriter
 	// Leave the writer queue
 	operations.WriterQueue.Leave(writerQueueToken)
 
+	// If the datastore was created by the request, record the principal that created it as its owner
+	if !datastoreExisted && storageQuota != nil && storageQuota.principal != "" {
+		err = this.parentServer.SetDatastoreOwner(datastoreName, storageQuota.principal)
+		if err != nil {
+			return
+		}
+	}
+
+	// Release the principal's storage quota lock, if held
+	if storageQuotaLock != nil {
+		storageQuotaLock.Unlock()
+		storageQuotaLock = nil
+	}
+
+	// Record the write in the audit log, if enabled. The transaction has already been committed at this
+	// point, so a failure is only logged.
+	auditErr := this.parentServer.RecordAuditedWrite(auditIdentity, datastoreName, r.Method, commitTimestamp, transactionBytes)
+	if auditErr != nil {
+		this.parentServer.Logf(1, "Error writing audit record for datastore '%s': %s", datastoreName, auditErr.Error())
+	}
+
 	// Set the response content type to JSON
 	w.Header().Set("Content-Type", "application/json")
 	// Write the header with a 200 OK status
@@ -638,7 +1035,7 @@ func (this *ServerDatastoreHandler) handlePostOrPutRequest(w http.ResponseWriter
 }
 
 // Handles DELETE requests
-func (this *ServerDatastoreHandler) handleDeleteRequest(w http.ResponseWriter, r *http.Request, datastoreName string, operations *DatastoreOperations, query url.Values) (err error) {
+func (this *ServerDatastoreHandler) handleDeleteRequest(w http.ResponseWriter, r *http.Request, datastoreName string, operations *DatastoreOperations, query url.Values, auditIdentity *requestAuditIdentity) (err error) {
 	// If the target datastore is the global configuration datastore, reject the request
 	// with a "method not allowed" status
 	if datastoreName == ".config" {
@@ -668,6 +1065,20 @@ func (this *ServerDatastoreHandler) handleDeleteRequest(w http.ResponseWriter, r
 	// Leave the writer queue
 	operations.WriterQueue.Leave(writerQueueToken)
 
+	// Remove the datastore's ownership record, if it has one
+	if !IsConfigDatastoreName(datastoreName) {
+		err = this.parentServer.SetDatastoreOwner(datastoreName, "")
+		if err != nil {
+			return
+		}
+	}
+
+	// Record the deletion in the audit log, if enabled
+	auditErr := this.parentServer.RecordAuditedWrite(auditIdentity, datastoreName, r.Method, MonoUnixTimeMicro(), nil)
+	if auditErr != nil {
+		this.parentServer.Logf(1, "Error writing audit record for datastore '%s': %s", datastoreName, auditErr.Error())
+	}
+
 	// Set the response content type to plain text
 	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
 	// Write the header
@@ -677,6 +1088,75 @@ func (this *ServerDatastoreHandler) handleDeleteRequest(w http.ResponseWriter, r
 	return
 }
 
+// Gets the key prefixes the given access profile is allowed to write to, as defined by its
+// "['accessProfile'][<name>]['writeKeyPrefix'][<id>]" entries. Any '<tokenSubject>' placeholder in a prefix
+// is replaced with the subject of the request's signed token. Prefixes whose placeholder cannot be replaced
+// safely are omitted. Returns nil if the profile doesn't restrict writes.
+func getProfileWriteKeyPrefixes(config *DatastoreConfigSnapshot, accessProfileName string, tokenSubject string) []string {
+	prefixKeys := config.FindKeysStartingWith("['accessProfile']['" + accessProfileName + "']['writeKeyPrefix']")
+
+	if len(prefixKeys) == 0 {
+		return nil
+	}
+
+	writeKeyPrefixes := []string{}
+
+	for _, prefixKey := range prefixKeys {
+		prefix, err := config.GetString(prefixKey)
+		if err != nil || prefix == "" {
+			continue
+		}
+
+		if strings.Contains(prefix, "<tokenSubject>") {
+			// A subject containing path delimiters could otherwise be used to reach into another subject's keys
+			if tokenSubject == "" || strings.ContainsAny(tokenSubject, "'[]\"") {
+				continue
+			}
+
+			prefix = strings.Replace(prefix, "<tokenSubject>", tokenSubject, -1)
+		}
+
+		writeKeyPrefixes = append(writeKeyPrefixes, prefix)
+	}
+
+	return writeKeyPrefixes
+}
+
+// A limit on the number of bytes a client may transfer per time interval, for a particular request method
+type requestBandwidthLimit struct {
+	rateLimiter   *RateLimiter
+	metrics       *ServerMetrics
+	datastoreName string
+	clientID      string
+	operation     string
+	interval      int64
+	limit         int64
+}
+
+// Consumes the given number of bytes from the limit. If the limit would be exceeded, ends the request
+// with a 429 Too Many Requests status and returns false. A nil limit always allows the request.
+func (this *requestBandwidthLimit) consume(w http.ResponseWriter, r *http.Request, byteCount int64) bool {
+	if this == nil {
+		return true
+	}
+
+	rateLimitResult := this.rateLimiter.ProcessWeightedEvent(this.datastoreName, this.clientID, this.operation, this.interval, this.limit, byteCount)
+
+	if !rateLimitResult.Allowed {
+		this.metrics.rateLimitRejections.Inc("bytes")
+		setRetryAfterHeader(w, rateLimitResult.RetryAfter)
+		endRequestWithError(w, r, http.StatusTooManyRequests, errors.New(fmt.Sprintf("Maximum transfer rate exceeded. The client identifier '%s' is limited to %d bytes per %dms.", this.clientID, this.limit, this.interval)))
+		return false
+	}
+
+	return true
+}
+
+// Sets the 'Retry-After' header to the given time, in milliseconds, rounded up to whole seconds
+func setRetryAfterHeader(w http.ResponseWriter, retryAfter int64) {
+	w.Header().Set("Retry-After", strconv.FormatInt((retryAfter+999)/1000, 10))
+}
+
 // End the given request with the given error
 func endRequestWithError(w http.ResponseWriter, r *http.Request, statusCode int, err error) {
 	if err != nil {
@@ -1,9 +1,13 @@
 package main
 
 import (
+	"encoding/base64"
 	"encoding/hex"
+	"net/http"
 	"time"
 
+	"github.com/gorilla/websocket"
+
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 )
@@ -97,6 +101,282 @@ var _ = Describe("Server", func() {
 		Expect(err.Error()).To(ContainSubstring("401"))
 	})
 
+	It("Accepts access keys stored with the legacy SHA1 hash scheme", func() {
+		datastoreName := RandomWordString(12)
+		testEntries := context.GetTestEntries()
+
+		// Generate access key and hash it using the legacy scheme
+		accessKey, accessKeyHash := context.GetRandomAccessKey()
+		legacyAccessKeyHash := SHA1ToHex([]byte(accessKey))
+		Expect(legacyAccessKeyHash).NotTo(Equal(accessKeyHash))
+
+		settingErr := context.PutDatastoreSetting(datastoreName, `"['datastore']['accessKeyHash']['`+legacyAccessKeyHash+`']"`, `"ReaderWriter"`, "")
+		Expect(settingErr).To(BeNil())
+
+		client := NewClient(context.hostURL, datastoreName, accessKey)
+		_, err := client.Put(testEntries)
+		Expect(err).To(BeNil())
+		_, err = client.Get(0)
+		Expect(err).To(BeNil())
+	})
+
+	It("Accepts access keys in the query string, the Authorization header or a WebSocket subprotocol", func() {
+		datastoreName := RandomWordString(12)
+
+		accessKey, accessKeyHash := context.GetRandomAccessKey()
+		settingErr := context.PutDatastoreSetting(datastoreName, `"['datastore']['accessKeyHash']['`+accessKeyHash+`']"`, `"ReaderWriter"`, "")
+		Expect(settingErr).To(BeNil())
+
+		// The client sends the key in the Authorization header
+		client := context.GetClient(datastoreName, accessKey)
+		_, err := client.Put(context.GetTestEntries())
+		Expect(err).To(BeNil())
+
+		// Send the key in the query string
+		response, err := http.Get(context.hostURL + "/datastore/" + datastoreName + "?accessKey=" + accessKey)
+		Expect(err).To(BeNil())
+		response.Body.Close()
+		Expect(response.StatusCode).To(Equal(http.StatusOK))
+
+		// Send the key both in the query string and the Authorization header
+		request, _ := http.NewRequest("GET", context.hostURL+"/datastore/"+datastoreName+"?accessKey="+accessKey, nil)
+		request.Header.Set("Authorization", "Bearer "+accessKey)
+		response, err = http.DefaultClient.Do(request)
+		Expect(err).To(BeNil())
+		response.Body.Close()
+		Expect(response.StatusCode).To(Equal(http.StatusBadRequest))
+
+		// Send the key with an unsupported authorization scheme
+		request, _ = http.NewRequest("GET", context.hostURL+"/datastore/"+datastoreName, nil)
+		request.Header.Set("Authorization", "Basic "+accessKey)
+		response, err = http.DefaultClient.Do(request)
+		Expect(err).To(BeNil())
+		response.Body.Close()
+		Expect(response.StatusCode).To(Equal(http.StatusBadRequest))
+
+		// Send the key as a WebSocket subprotocol token, and ensure it is selected in the response
+		dialer := &websocket.Dialer{Subprotocols: []string{AccessKeyWebSocketProtocolPrefix + accessKey}}
+		conn, _, err := dialer.Dial("ws://"+context.hostURL[7:]+"/datastore/"+datastoreName, nil)
+		Expect(err).To(BeNil())
+		defer conn.Close()
+		Expect(conn.Subprotocol()).To(Equal(AccessKeyWebSocketProtocolPrefix + accessKey))
+	})
+
+	It("Limits the number of parallel WebSocket connections for a client", func() {
+		datastoreName := RandomWordString(12)
+
+		accessKey, accessKeyHash := context.GetRandomAccessKey()
+		settingErr := context.PutDatastoreSettings(datastoreName, map[string]string{
+			`"['datastore']['accessKeyHash']['` + accessKeyHash + `']"`:                                         `"ReaderWriter"`,
+			`"['accessProfile']['ReaderWriter']['method']['WebSocket']['limit']['parallelConnections']['max']"`: `2`,
+		}, "")
+		Expect(settingErr).To(BeNil())
+
+		_, err := context.GetClient(datastoreName, accessKey).Put(context.GetTestEntries())
+		Expect(err).To(BeNil())
+
+		dial := func() (*websocket.Conn, int) {
+			dialer := &websocket.Dialer{Subprotocols: []string{AccessKeyWebSocketProtocolPrefix + accessKey}}
+			conn, response, _ := dialer.Dial("ws://"+context.hostURL[7:]+"/datastore/"+datastoreName, nil)
+
+			if response == nil {
+				return conn, 0
+			}
+
+			return conn, response.StatusCode
+		}
+
+		conn1, statusCode := dial()
+		Expect(statusCode).To(Equal(http.StatusSwitchingProtocols))
+		conn2, statusCode := dial()
+		Expect(statusCode).To(Equal(http.StatusSwitchingProtocols))
+		defer conn2.Close()
+
+		_, statusCode = dial()
+		Expect(statusCode).To(Equal(http.StatusTooManyRequests))
+
+		// Close one of the connections and ensure its slot is released
+		conn1.Close()
+
+		Eventually(func() int {
+			conn, statusCode := dial()
+			if conn != nil {
+				defer conn.Close()
+			}
+
+			return statusCode
+		}).Should(Equal(http.StatusSwitchingProtocols))
+	})
+
+	It("Rejects access keys in the query string when configured to", func() {
+		datastoreName := RandomWordString(12)
+
+		accessKey, accessKeyHash := context.GetRandomAccessKey()
+		settingErr := context.PutDatastoreSetting(datastoreName, `"['datastore']['accessKeyHash']['`+accessKeyHash+`']"`, `"ReaderWriter"`, "")
+		Expect(settingErr).To(BeNil())
+
+		settingErr = context.PutGlobalSetting(`"['server']['accessKey']['rejectInQuery']"`, `true`, "")
+		Expect(settingErr).To(BeNil())
+		defer context.PutGlobalSetting(`"['server']['accessKey']['rejectInQuery']"`, `false`, "")
+
+		response, err := http.Get(context.hostURL + "/datastore/" + datastoreName + "?accessKey=" + accessKey)
+		Expect(err).To(BeNil())
+		response.Body.Close()
+		Expect(response.StatusCode).To(Equal(http.StatusUnauthorized))
+
+		client := context.GetClient(datastoreName, accessKey)
+		_, err = client.Put(context.GetTestEntries())
+		Expect(err).To(BeNil())
+	})
+
+	It("Accepts signed access tokens and applies their claims", func() {
+		datastoreName := "app_" + RandomWordString(12)
+		tokenSecret := RandomBytes(32)
+
+		settingErr := context.PutGlobalSettings("", map[string]string{
+			`"['server']['token']['key']['testKey']['algorithm']"`: `"HS256"`,
+			`"['server']['token']['key']['testKey']['secret']"`:    `"` + base64.StdEncoding.EncodeToString(tokenSecret) + `"`,
+		}, "")
+		Expect(settingErr).To(BeNil())
+
+		// Create the datastore
+		_, err := context.GetClient(datastoreName, "").Put([]Entry{})
+		Expect(err).To(BeNil())
+
+		tokenKey := &AccessTokenKey{Algorithm: AccessTokenAlgorithm_HS256, Secret: tokenSecret}
+		token, err := CreateAccessToken(&AccessTokenClaims{
+			Subject:    "user1",
+			Datastores: []string{"app_*"},
+			Profile:    "ReaderWriter",
+			KeyPrefix:  "['users']['user1']",
+			ExpiresAt:  time.Now().Unix() + 60,
+		}, "testKey", tokenKey)
+		Expect(err).To(BeNil())
+
+		client := context.GetClient(datastoreName, token)
+
+		// Write within the permitted prefix
+		_, err = client.Post([]Entry{
+			Entry{&EntryHeader{KeyFormat: DataFormat_JSON, ValueFormat: DataFormat_JSON}, []byte(`"['users']['user1']['name']"`), []byte(`"Alice"`)},
+		})
+		Expect(err).To(BeNil())
+
+		// Write outside the permitted prefix
+		_, err = client.Post([]Entry{
+			Entry{&EntryHeader{KeyFormat: DataFormat_JSON, ValueFormat: DataFormat_JSON}, []byte(`"['users']['user1']['name']"`), []byte(`"Alice"`)},
+			Entry{&EntryHeader{KeyFormat: DataFormat_JSON, ValueFormat: DataFormat_JSON}, []byte(`"['users']['user2']['name']"`), []byte(`"Bob"`)},
+		})
+		Expect(err).NotTo(BeNil())
+		Expect(err.Error()).To(ContainSubstring("403"))
+
+		// Rewriting the entire datastore isn't permitted when restricted to a prefix
+		_, err = client.Put([]Entry{})
+		Expect(err).NotTo(BeNil())
+		Expect(err.Error()).To(ContainSubstring("403"))
+
+		// Read the datastore
+		entries, err := client.Get(0)
+		Expect(err).To(BeNil())
+		Expect(entries[len(entries)-1].Value).To(Equal([]byte(`"Alice"`)))
+
+		// Access a datastore not matching the token's patterns
+		_, err = context.GetClientForRandomDatastore(token).Get(0)
+		Expect(err).NotTo(BeNil())
+		Expect(err.Error()).To(ContainSubstring("403"))
+
+		// Use a token signed with an unknown key
+		invalidToken, _ := CreateAccessToken(&AccessTokenClaims{Subject: "user1", Datastores: []string{"*"}, Profile: "ReaderWriter", ExpiresAt: time.Now().Unix() + 60}, "otherKey", tokenKey)
+		_, err = context.GetClient(datastoreName, invalidToken).Get(0)
+		Expect(err).NotTo(BeNil())
+		Expect(err.Error()).To(ContainSubstring("401"))
+
+		// Use an expired token
+		expiredToken, _ := CreateAccessToken(&AccessTokenClaims{Subject: "user1", Datastores: []string{"*"}, Profile: "ReaderWriter", ExpiresAt: time.Now().Unix() - 1}, "testKey", tokenKey)
+		_, err = context.GetClient(datastoreName, expiredToken).Get(0)
+		Expect(err).NotTo(BeNil())
+		Expect(err.Error()).To(ContainSubstring("401"))
+	})
+
+	It("Restricts writes to the key prefixes defined in an access profile", func() {
+		datastoreName := RandomWordString(12)
+		tokenSecret := RandomBytes(32)
+
+		settingErr := context.PutGlobalSettings("", map[string]string{
+			`"['server']['token']['key']['testKey']['algorithm']"`:             `"HS256"`,
+			`"['server']['token']['key']['testKey']['secret']"`:                `"` + base64.StdEncoding.EncodeToString(tokenSecret) + `"`,
+			`"['accessProfile']['PrefixWriter']['method']['GET']['allowed']"`:  `true`,
+			`"['accessProfile']['PrefixWriter']['method']['POST']['allowed']"`: `true`,
+			`"['accessProfile']['PrefixWriter']['method']['PUT']['allowed']"`:  `true`,
+			`"['accessProfile']['PrefixWriter']['writeKeyPrefix']['own']"`:     `"['users']['<tokenSubject>']"`,
+			`"['accessProfile']['PrefixWriter']['writeKeyPrefix']['public']"`:  `"['public']"`,
+		}, "")
+		Expect(settingErr).To(BeNil())
+
+		// Create the datastore
+		_, err := context.GetClient(datastoreName, "").Put([]Entry{})
+		Expect(err).To(BeNil())
+
+		createEntry := func(key string) Entry {
+			return Entry{&EntryHeader{KeyFormat: DataFormat_JSON, ValueFormat: DataFormat_JSON}, []byte(`"` + key + `"`), []byte(`1`)}
+		}
+
+		token, _ := CreateAccessToken(&AccessTokenClaims{Subject: "alice", Datastores: []string{datastoreName}, Profile: "PrefixWriter", ExpiresAt: time.Now().Unix() + 60}, "testKey", &AccessTokenKey{Algorithm: AccessTokenAlgorithm_HS256, Secret: tokenSecret})
+		tokenClient := context.GetClient(datastoreName, token)
+
+		_, err = tokenClient.Post([]Entry{createEntry("['users']['alice']['age']"), createEntry("['public']['count']")})
+		Expect(err).To(BeNil())
+
+		_, err = tokenClient.Post([]Entry{createEntry("['users']['bob']['age']")})
+		Expect(err).NotTo(BeNil())
+		Expect(err.Error()).To(ContainSubstring("403"))
+
+		_, err = tokenClient.Put([]Entry{createEntry("['users']['alice']['age']")})
+		Expect(err).NotTo(BeNil())
+		Expect(err.Error()).To(ContainSubstring("403"))
+
+		// A plain access key has no token subject, so only the prefixes without a placeholder apply to it
+		accessKey, accessKeyHash := context.GetRandomAccessKey()
+		settingErr = context.PutDatastoreSetting(datastoreName, `"['datastore']['accessKeyHash']['`+accessKeyHash+`']"`, `"PrefixWriter"`, "")
+		Expect(settingErr).To(BeNil())
+
+		keyClient := context.GetClient(datastoreName, accessKey)
+
+		_, err = keyClient.Post([]Entry{createEntry("['public']['count']")})
+		Expect(err).To(BeNil())
+
+		_, err = keyClient.Post([]Entry{createEntry("['users']['alice']['age']")})
+		Expect(err).NotTo(BeNil())
+		Expect(err.Error()).To(ContainSubstring("403"))
+	})
+
+	It("Rejects JSON values not conforming to the datastore's schema", func() {
+		datastoreName := RandomWordString(12)
+
+		settingErr := context.PutDatastoreSettings(datastoreName, map[string]string{
+			`"['datastore']['schema']['age']['keyPattern']"`: `"['users']['*']['age']"`,
+			`"['datastore']['schema']['age']['definition']"`: `{"type": "integer", "minimum": 0}`,
+		}, "")
+		Expect(settingErr).To(BeNil())
+
+		client := context.GetClient(datastoreName, "")
+
+		createEntry := func(key string, value string) Entry {
+			return Entry{&EntryHeader{KeyFormat: DataFormat_JSON, ValueFormat: DataFormat_JSON}, []byte(`"` + key + `"`), []byte(value)}
+		}
+
+		_, err := client.Put([]Entry{createEntry("['users']['alice']['age']", `30`), createEntry("['users']['alice']['name']", `"Alice"`)})
+		Expect(err).To(BeNil())
+
+		_, err = client.Post([]Entry{createEntry("['users']['bob']['age']", `"thirty"`)})
+		Expect(err).NotTo(BeNil())
+		Expect(err.Error()).To(ContainSubstring("400"))
+
+		// Malformed JSON values are rejected even for keys not matched by the schema
+		_, err = client.Post([]Entry{createEntry("['users']['bob']['name']", `"Bob`)})
+		Expect(err).NotTo(BeNil())
+		Expect(err.Error()).To(ContainSubstring("400"))
+	})
+
 	It("Rejects requests to a configuaration datastore, not using the master key", func() {
 		datastoreName := RandomWordString(12)
 		testEntries := context.GetTestEntries()
@@ -220,6 +500,72 @@ var _ = Describe("Server", func() {
 		Expect(err).To(BeNil())
 	})
 
+	It("Reports remaining requests and when to retry in rate limit headers", func() {
+		client := context.GetClientForRandomDatastore("")
+
+		accessKey, accessKeyHash := context.GetRandomAccessKey()
+
+		settingErr := context.PutDatastoreSettings(client.datastoreName, map[string]string{
+			`"['datastore']['accessKeyHash']['` + accessKeyHash + `']"`:                       `"Reader"`,
+			`"['accessProfile']['Reader']['method']['GET']['limit']['requests']['interval']"`: `60000`,
+			`"['accessProfile']['Reader']['method']['GET']['limit']['requests']['count']"`:    `2`,
+		}, "")
+		Expect(settingErr).To(BeNil())
+
+		_, err := client.Put(context.GetTestEntries())
+		Expect(err).To(BeNil())
+
+		clientForProfile := NewClient(context.hostURL, client.datastoreName, accessKey)
+
+		response, _, err := clientForProfile.Request("GET", nil, nil)
+		Expect(err).To(BeNil())
+		Expect(response.Header.Get("X-RateLimit-Remaining")).To(Equal("1"))
+
+		response, _, err = clientForProfile.Request("GET", nil, nil)
+		Expect(err).To(BeNil())
+		Expect(response.Header.Get("X-RateLimit-Remaining")).To(Equal("0"))
+
+		response, _, _ = clientForProfile.Request("GET", nil, nil)
+		Expect(response.StatusCode).To(Equal(http.StatusTooManyRequests))
+		Expect(response.Header.Get("Retry-After")).To(Equal("30"))
+	})
+
+	It("Enforces bandwidth limits for a particular profile", func() {
+		client := context.GetClientForRandomDatastore("")
+
+		accessKey, accessKeyHash := context.GetRandomAccessKey()
+
+		settingErr := context.PutDatastoreSettings(client.datastoreName, map[string]string{
+			`"['datastore']['accessKeyHash']['` + accessKeyHash + `']"`:                           `"ReaderWriter"`,
+			`"['accessProfile']['ReaderWriter']['method']['POST']['limit']['bytes']['interval']"`: `60000`,
+			`"['accessProfile']['ReaderWriter']['method']['POST']['limit']['bytes']['count']"`:    `1500`,
+			`"['accessProfile']['ReaderWriter']['method']['GET']['limit']['bytes']['interval']"`:  `60000`,
+			`"['accessProfile']['ReaderWriter']['method']['GET']['limit']['bytes']['count']"`:     `1500`,
+		}, "")
+		Expect(settingErr).To(BeNil())
+
+		_, err := client.Put([]Entry{})
+		Expect(err).To(BeNil())
+
+		clientForProfile := NewClient(context.hostURL, client.datastoreName, accessKey)
+
+		// The first transaction fits within the limit, and the second would exceed it
+		_, err = clientForProfile.Post([]Entry{*getRandomBinaryEntry(20, 1000)})
+		Expect(err).To(BeNil())
+
+		_, err = clientForProfile.Post([]Entry{*getRandomBinaryEntry(20, 1000)})
+		Expect(err).NotTo(BeNil())
+		Expect(err.Error()).To(ContainSubstring("429"))
+
+		// Reading the datastore once fits within the limit, and reading it again would exceed it
+		_, err = clientForProfile.Get(0)
+		Expect(err).To(BeNil())
+
+		_, err = clientForProfile.Get(0)
+		Expect(err).NotTo(BeNil())
+		Expect(err.Error()).To(ContainSubstring("429"))
+	})
+
 	It("Enforces maximum datastore size limits", func() {
 		client := context.GetClientForRandomDatastore("")
 
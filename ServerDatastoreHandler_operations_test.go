@@ -1,6 +1,11 @@
 package main
 
 import (
+	"bufio"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
 	"time"
 
 	. "github.com/onsi/ginkgo"
@@ -102,6 +107,34 @@ var _ = Describe("Server operations", func() {
 		ExpectEntryArraysToBeEquivalent(returnedEntries, testEntries[2:5])
 	})
 
+	It("Gets entries as newline delimited JSON records", func() {
+		client := context.GetClientForRandomDatastore("")
+		testEntries := context.GetTestEntries()
+
+		_, err := client.Put(testEntries[0:2])
+		Expect(err).To(BeNil())
+
+		commitTimestamp, err := client.Post(testEntries[2:5])
+		Expect(err).To(BeNil())
+
+		returnedEntries, err := client.GetJsonRecords(0)
+		Expect(err).To(BeNil())
+
+		SerializeEntries(returnedEntries)
+		ExpectEntryArraysToBeEquivalent(returnedEntries, testEntries)
+
+		returnedEntries, err = client.GetJsonRecords(commitTimestamp - 1)
+		Expect(err).To(BeNil())
+
+		SerializeEntries(returnedEntries)
+		ExpectEntryArraysToBeEquivalent(returnedEntries, testEntries[2:5])
+		ExpectEntriesToHaveCommitTimestamp(returnedEntries, commitTimestamp)
+
+		_, _, err = client.Request("GET", map[string]string{"format": "xml"}, nil)
+		Expect(err).NotTo(BeNil())
+		Expect(err.Error()).To(ContainSubstring("400"))
+	})
+
 	It("Repeatedly posts a random transaction and gets it", func() {
 		client := context.GetClientForRandomDatastore("")
 
@@ -375,6 +408,65 @@ var _ = Describe("Server operations", func() {
 		ExpectEntryArraysToBeEquivalent(result, testEntries)
 	})
 
+	It("Responds to a GET request with waitUntilNonempty enabled once the long-poll timeout elapses", func() {
+		context.startupOptions.LongPollTimeout = 200 * time.Millisecond
+
+		client := context.GetClientForRandomDatastore("")
+
+		commitTimestamp, err := client.Put([]Entry{})
+		Expect(err).To(BeNil())
+
+		startTime := time.Now()
+		result, err := client.GetWhenNonEmpty(commitTimestamp)
+		Expect(err).To(BeNil())
+		Expect(result).To(BeEmpty())
+		Expect(time.Since(startTime)).To(BeNumerically(">=", 200*time.Millisecond))
+	})
+
+	It("Responds to a GET request with waitUntilNonempty enabled after waiting longer than the write timeout", func() {
+		// Restart the server with a short write timeout, and no long-poll timeout
+		context.Stop()
+		context = NewServerTestContext()
+		context.startupOptions.WriteTimeout = 200 * time.Millisecond
+		context.startupOptions.LongPollTimeout = 0
+		context.Start()
+
+		client := context.GetClientForRandomDatastore("")
+		testEntries := context.GetTestEntries()
+
+		commitTimestamp, err := client.Put([]Entry{})
+		Expect(err).To(BeNil())
+
+		postResults := make(chan error, 1)
+		go func() {
+			time.Sleep(500 * time.Millisecond)
+			_, postErr := client.Post(testEntries)
+			postResults <- postErr
+		}()
+
+		// Send the request over a raw connection, since the HTTP client would silently retry a GET request
+		// whose response failed to be written
+		conn, err := net.Dial("tcp", "localhost:12345")
+		Expect(err).To(BeNil())
+		defer conn.Close()
+
+		request, _ := http.NewRequest("GET", client.BuildRequestURL(map[string]string{"updatedAfter": strconv.FormatInt(commitTimestamp, 10), "waitUntilNonempty": "true"}), nil)
+		Expect(request.Write(conn)).To(BeNil())
+
+		response, err := http.ReadResponse(bufio.NewReader(conn), request)
+		Expect(err).To(BeNil())
+		Expect(response.StatusCode).To(Equal(http.StatusOK))
+
+		responseBody, err := ioutil.ReadAll(response.Body)
+		Expect(err).To(BeNil())
+
+		result, err := DeserializeEntryStreamBytes(responseBody)
+		Expect(err).To(BeNil())
+		ExpectEntryArraysToBeEquivalent(result, testEntries)
+
+		Eventually(postResults, 5*time.Second).Should(Receive(BeNil()))
+	})
+
 	It("Serves a WebSocket connection", func() {
 		client := context.GetClientForRandomDatastore("")
 		testEntries := context.GetTestEntries()
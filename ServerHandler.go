@@ -1,34 +1,107 @@
 package main
 
 import (
+	"net"
 	"net/http"
 	"strings"
+	"time"
 )
 
 type ServerHandler struct {
 	parentServer     *Server
+	loopbackOnly     bool
 	datastoreHandler *ServerDatastoreHandler
+	adminHandler     *ServerAdminHandler
 	staticHandler    *ServerStaticHandler
+	metricsHandler   *ServerMetricsHandler
+	healthHandler    *ServerHealthHandler
 }
 
-func (this *ServerHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+func (this *ServerHandler) ServeHTTP(originalWriter http.ResponseWriter, r *http.Request) {
+	// If the request was forwarded by a trusted proxy, replace its remote address with the address of
+	// the client given in the forwarding headers. The client's port isn't known, so it is set to 0.
+	if clientIP := this.parentServer.trustedProxies.GetForwardedClientIP(r.RemoteAddr, r.Header); clientIP != "" {
+		r = r.WithContext(r.Context())
+		r.RemoteAddr = net.JoinHostPort(clientIP, "0")
+	}
+
+	remoteHost, _, _ := net.SplitHostPort(r.RemoteAddr)
+
+	// Reject requests forwarded on behalf of a non-loopback client, if the listener only accepts
+	// loopback connections
+	if this.loopbackOnly && !loopbackIPRegExp.MatchString(remoteHost) {
+		http.Error(originalWriter, "Incoming host IP is forbidden.", http.StatusForbidden)
+		return
+	}
+
+	// Reject requests from banned IPs. Banned IPs are also rejected by the listener, but connections
+	// accepted before the ban was applied, or requests forwarded by a proxy, may still be received.
+	// Requests received through a Unix socket have no remote IP, and are never banned, since all local
+	// clients of the socket would otherwise share a single ban.
+	if remoteHost != "" && this.parentServer.ipBanList.IsBanned(remoteHost) {
+		http.Error(originalWriter, "Incoming host IP has been temporarily banned.", http.StatusForbidden)
+		return
+	}
+
+	// Wrap the response writer to record the status code and number of bytes sent
+	w := NewServerResponseWriter(originalWriter)
+
+	// Attach an object to the request, for handlers to add details included when the request is logged
+	r, logInfo := WithRequestLogInfo(r)
+
+	// Record the time the request has started
+	startTime := time.Now()
+
+	// Log the request and record metrics, as well as authentication, authorization and rate limit
+	// failures, once the request has been handled
+	defer func() {
+		this.parentServer.LogRequest(r, w, logInfo, startTime)
+
+		// Record request metrics
+		this.parentServer.metrics.RecordRequest(r.Method, w.StatusCode, w.Hijacked)
+
+		if r.Method == "POST" && strings.HasPrefix(r.URL.Path, "/datastore/") {
+			this.parentServer.metrics.postLatency.Observe(time.Since(startTime).Seconds())
+		}
+
+		if remoteHost != "" {
+			switch w.StatusCode {
+			case http.StatusUnauthorized, http.StatusForbidden, http.StatusTooManyRequests:
+				this.parentServer.RecordClientFailure(remoteHost)
+			}
+		}
+	}()
+
 	if strings.HasPrefix(r.URL.Path, "/datastore/") {
 		this.datastoreHandler.ServeHTTP(w, r)
+	} else if strings.HasPrefix(r.URL.Path, "/admin/") {
+		this.adminHandler.ServeHTTP(w, r)
+	} else if r.URL.Path == "/metrics" {
+		this.metricsHandler.ServeHTTP(w, r)
+	} else if r.URL.Path == "/healthz" || r.URL.Path == "/readyz" || r.URL.Path == "/status" {
+		this.healthHandler.ServeHTTP(w, r)
 		/*
 			} else if strings.HasPrefix(r.URL.Path, "/static/") {
 				this.staticHandler.ServeHTTP(w, r)
 		*/
 	} else {
-		this.parentServer.Log(1, "["+r.RemoteAddr+"]: " + r.Method + " " + r.URL.Path + " <invalid path>")
 		w.Header().Set("Access-Control-Allow-Origin", "*")
 		http.Error(w, "Invalid request path.", http.StatusBadRequest)
 	}
 }
 
-func NewServerHandler(parentServer *Server) *ServerHandler {
+// Server handler object constructor function. If 'loopbackOnly' is set, requests are only accepted from
+// loopback clients, including ones forwarded by trusted proxies.
+func NewServerHandler(parentServer *Server, loopbackOnly bool) *ServerHandler {
+	adminHandler := NewServerAdminHandler(parentServer, loopbackOnly)
+
 	return &ServerHandler{
 		parentServer:     parentServer,
+		loopbackOnly:     loopbackOnly,
 		datastoreHandler: NewServerDatastoreHandler(parentServer),
+		adminHandler:     adminHandler,
 		staticHandler:    NewServerStaticHandler(parentServer),
+		metricsHandler:   NewServerMetricsHandler(parentServer, adminHandler, loopbackOnly),
+		healthHandler:    NewServerHealthHandler(parentServer, adminHandler),
 	}
 }
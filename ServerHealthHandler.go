@@ -0,0 +1,195 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Declare the health handler object type. Serves the '/healthz', '/readyz' and '/status' endpoints.
+type ServerHealthHandler struct {
+	parentServer *Server
+	adminHandler *ServerAdminHandler
+}
+
+// Health handler object constructor function. The administration handler is used to verify the master
+// key for status requests.
+func NewServerHealthHandler(parentServer *Server, adminHandler *ServerAdminHandler) *ServerHealthHandler {
+	return &ServerHealthHandler{
+		parentServer: parentServer,
+		adminHandler: adminHandler,
+	}
+}
+
+// The result of a readiness check, as returned to the client
+type ServerReadiness struct {
+	Ready bool `json:"ready"`
+	// A lookup table taking a check name and giving either 'ok' or the reason it failed
+	Checks map[string]string `json:"checks"`
+}
+
+// The status of the server, as returned to the client
+type ServerStatus struct {
+	Version        string   `json:"version"`
+	GoVersion      string   `json:"goVersion"`
+	StartTime      int64    `json:"startTime"`
+	Uptime         int64    `json:"uptime"`
+	ShuttingDown   bool     `json:"shuttingDown"`
+	OpenDatastores []string `json:"openDatastores"`
+	PendingFlushes int      `json:"pendingFlushes"`
+}
+
+// The main handler for health requests
+func (this *ServerHealthHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		endRequestWithError(w, r, http.StatusMethodNotAllowed, nil)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-cache")
+
+	// Dispatch the appropriate handler for the requested path
+	switch r.URL.Path {
+	case "/healthz":
+		// The process is alive if it is able to respond
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+
+		if r.Method == "GET" {
+			w.Write([]byte("OK"))
+		}
+	case "/readyz":
+		readiness := this.parentServer.GetReadiness()
+
+		statusCode := http.StatusOK
+		if !readiness.Ready {
+			statusCode = http.StatusServiceUnavailable
+		}
+
+		writeJsonResponse(w, r, statusCode, readiness)
+	case "/status":
+		// Status requests require the master key
+		if !this.adminHandler.authorizeMasterKeyRequest(w, r) {
+			return
+		}
+
+		writeJsonResponse(w, r, http.StatusOK, this.parentServer.GetStatus())
+	default:
+		endRequestWithError(w, r, http.StatusNotFound, errors.New("Invalid request path."))
+	}
+}
+
+// Writes a JSON response with the given status code. The body is omitted for HEAD requests.
+func writeJsonResponse(w http.ResponseWriter, r *http.Request, statusCode int, result interface{}) {
+	serializedResult, err := json.Marshal(result)
+	if err != nil {
+		endRequestWithError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(len(serializedResult)))
+	w.WriteHeader(statusCode)
+
+	if r.Method == "GET" {
+		w.Write(serializedResult)
+	}
+}
+
+// Checks whether the server is ready to serve requests: the global configuration datastore can be
+// loaded, the storage directory is writable, all listeners have been started, and the server isn't
+// shutting down
+func (this *Server) GetReadiness() *ServerReadiness {
+	readiness := &ServerReadiness{
+		Ready:  true,
+		Checks: map[string]string{},
+	}
+
+	setCheckResult := func(name string, err error) {
+		if err != nil {
+			readiness.Ready = false
+			readiness.Checks[name] = err.Error()
+		} else {
+			readiness.Checks[name] = "ok"
+		}
+	}
+
+	// Ensure the global configuration datastore is loaded, loading it if needed. A configuration snapshot
+	// can't be used for this, since one is returned for configuration datastores even if the global
+	// configuration isn't loaded.
+	_, err := this.GetDatastoreOperations(".config").LoadIfNeeded(false)
+	setCheckResult("configuration", err)
+
+	// Ensure the storage directory is writable, by creating and removing a temporary file in it
+	setCheckResult("storage", this.checkStorageWritable())
+
+	// Ensure all listeners have been started, and the server isn't shutting down
+	if this.ShuttingDown() {
+		setCheckResult("listeners", errors.New("The server is shutting down."))
+	} else if this.runningListenerCount() < len(this.allListenerOptions()) {
+		setCheckResult("listeners", errors.New("Not all listeners have been started."))
+	} else {
+		setCheckResult("listeners", nil)
+	}
+
+	return readiness
+}
+
+// Checks the storage directory is writable
+func (this *Server) checkStorageWritable() error {
+	file, err := ioutil.TempFile(this.startupOptions.StoragePath, ".readyz-")
+	if err != nil {
+		return err
+	}
+
+	file.Close()
+
+	return os.Remove(file.Name())
+}
+
+// Gets the current status of the server
+func (this *Server) GetStatus() *ServerStatus {
+	status := &ServerStatus{
+		Version:        "(unknown)",
+		GoVersion:      runtime.Version(),
+		StartTime:      this.startTime.UnixNano() / 1000000,
+		Uptime:         int64(time.Since(this.startTime) / time.Millisecond),
+		ShuttingDown:   this.ShuttingDown(),
+		OpenDatastores: []string{},
+	}
+
+	// Get the version of the main module, if the executable was built with module information
+	if buildInfo, ok := debug.ReadBuildInfo(); ok && buildInfo.Main.Version != "" {
+		status.Version = buildInfo.Main.Version
+	}
+
+	// List the open datastores, and count the ones having a flush scheduled
+	this.datastoreMapLock.Lock()
+
+	for name, operations := range this.datastores {
+		state := operations.State
+
+		if state == nil {
+			continue
+		}
+
+		status.OpenDatastores = append(status.OpenDatastores, name)
+
+		if state.FlushScheduler.FlushScheduled() {
+			status.PendingFlushes++
+		}
+	}
+
+	this.datastoreMapLock.Unlock()
+
+	sort.Strings(status.OpenDatastores)
+
+	return status
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync/atomic"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Server", func() {
+	var context *ServerTestContext
+
+	BeforeEach(func() {
+		context = NewServerTestContext()
+		context.Start()
+	})
+
+	AfterEach(func() {
+		context.Stop()
+	})
+
+	// Sends a GET request to the given path and gets the response status code and body
+	get := func(path string) (int, []byte) {
+		response, err := http.Get("http://localhost:12345" + path)
+		Expect(err).To(BeNil())
+		defer response.Body.Close()
+
+		body, err := ioutil.ReadAll(response.Body)
+		Expect(err).To(BeNil())
+
+		return response.StatusCode, body
+	}
+
+	It("Reports health and readiness", func() {
+		statusCode, body := get("/healthz")
+		Expect(statusCode).To(Equal(http.StatusOK))
+		Expect(string(body)).To(Equal("OK"))
+
+		statusCode, body = get("/readyz")
+		Expect(statusCode).To(Equal(http.StatusOK))
+
+		var readiness ServerReadiness
+		Expect(json.Unmarshal(body, &readiness)).To(BeNil())
+		Expect(readiness.Ready).To(BeTrue())
+		Expect(readiness.Checks).To(Equal(map[string]string{"configuration": "ok", "storage": "ok", "listeners": "ok"}))
+
+		// Ensure the server isn't reported as ready once it is shutting down
+		atomic.StoreInt32(&context.server.shuttingDown, 1)
+		statusCode, body = get("/readyz")
+		atomic.StoreInt32(&context.server.shuttingDown, 0)
+
+		Expect(statusCode).To(Equal(http.StatusServiceUnavailable))
+		Expect(json.Unmarshal(body, &readiness)).To(BeNil())
+		Expect(readiness.Ready).To(BeFalse())
+		Expect(readiness.Checks["listeners"]).To(Equal("The server is shutting down."))
+	})
+
+	It("Reports the server as not ready if the global configuration can't be loaded", func() {
+		globalConfigFilePath := context.startupOptions.StoragePath + "/.config"
+		globalConfigOperations := context.server.GetDatastoreOperations(".config")
+
+		// Close the global configuration datastore and move its file away
+		Expect(globalConfigOperations.Close()).To(BeNil())
+		Expect(os.Rename(globalConfigFilePath, globalConfigFilePath+".moved")).To(BeNil())
+
+		statusCode, body := get("/readyz")
+
+		Expect(os.Rename(globalConfigFilePath+".moved", globalConfigFilePath)).To(BeNil())
+
+		Expect(statusCode).To(Equal(http.StatusServiceUnavailable))
+
+		var readiness ServerReadiness
+		Expect(json.Unmarshal(body, &readiness)).To(BeNil())
+		Expect(readiness.Ready).To(BeFalse())
+		Expect(readiness.Checks["configuration"]).NotTo(Equal("ok"))
+		Expect(readiness.Checks["storage"]).To(Equal("ok"))
+
+		// Once the file is restored, and the cached load failure cleared, the server should be ready again
+		Expect(globalConfigOperations.Close()).To(BeNil())
+
+		statusCode, _ = get("/readyz")
+		Expect(statusCode).To(Equal(http.StatusOK))
+	})
+
+	It("Reports status to master key requests", func() {
+		client := context.GetClientForRandomDatastore("")
+		_, err := client.Put(context.GetTestEntries())
+		Expect(err).To(BeNil())
+
+		statusCode, body := get("/status")
+		Expect(statusCode).To(Equal(http.StatusOK))
+
+		var status ServerStatus
+		Expect(json.Unmarshal(body, &status)).To(BeNil())
+		Expect(status.Uptime).To(BeNumerically(">=", 0))
+		Expect(status.OpenDatastores).To(ContainElement(client.datastoreName))
+		Expect(status.OpenDatastores).To(ContainElement(".config"))
+
+		// Set a master key, and ensure requests without it are rejected
+		_, masterKeyHash := context.GetRandomAccessKey()
+		err = context.PutGlobalSetting(`"['server']['masterKeyHash']"`, `"`+masterKeyHash+`"`, "")
+		Expect(err).To(BeNil())
+
+		statusCode, _ = get("/status")
+		Expect(statusCode).To(Equal(http.StatusUnauthorized))
+
+		// Ensure health and readiness requests don't require an access key
+		statusCode, _ = get("/healthz")
+		Expect(statusCode).To(Equal(http.StatusOK))
+
+		statusCode, _ = get("/readyz")
+		Expect(statusCode).To(Equal(http.StatusOK))
+	})
+})
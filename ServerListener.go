@@ -1,8 +1,16 @@
 package main
 
 import (
+	"crypto/tls"
+	"errors"
 	"net"
+	"net/url"
+	"os"
 	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
 var loopbackIPRegExp *regexp.Regexp
@@ -14,47 +22,372 @@ func init() {
 type ServerListener struct {
 	parentServer    *Server
 	wrappedListener net.Listener
-	protocol        string
+	tlsConfig       *tls.Config
 	loopbackOnly    bool
+	proxyProtocol   bool
+
+	// Connections accepted and admitted in the background, when the PROXY protocol is enabled
+	admittedConnections chan net.Conn
+	acceptErrors        chan error
+	startAccepting      *sync.Once
+	closed              chan struct{}
+	closeOnce           *sync.Once
 }
 
-func NewServerListener(parentServer *Server, wrappedListener net.Listener, protocol string, loopbackOnly bool) *ServerListener {
+// Server listener object constructor function. If a TLS configuration is given, accepted connections are
+// served over TLS.
+func NewServerListener(parentServer *Server, wrappedListener net.Listener, tlsConfig *tls.Config, listenerOptions *ServerListenerOptions) *ServerListener {
 	return &ServerListener{
-		parentServer: parentServer,
+		parentServer:    parentServer,
 		wrappedListener: wrappedListener,
-		protocol: protocol,
-		loopbackOnly: loopbackOnly,
+		tlsConfig:       tlsConfig,
+		loopbackOnly:    listenerOptions.LoopbackOnly,
+		proxyProtocol:   listenerOptions.ProxyProtocol,
+
+		admittedConnections: make(chan net.Conn),
+		acceptErrors:        make(chan error),
+		startAccepting:      &sync.Once{},
+		closed:              make(chan struct{}),
+		closeOnce:           &sync.Once{},
 	}
 }
 
-func (this *ServerListener) Accept() (conn net.Conn, err error) {
+func (this *ServerListener) Accept() (net.Conn, error) {
+	// When the PROXY protocol is enabled, the client's address is only known once the header has been
+	// read, so connections are accepted in the background, and their headers read concurrently
+	if this.proxyProtocol {
+		this.startAccepting.Do(func() {
+			go this.acceptInBackground()
+		})
+
+		select {
+		case conn := <-this.admittedConnections:
+			return conn, nil
+		case err := <-this.acceptErrors:
+			return nil, err
+		case <-this.closed:
+			return nil, net.ErrClosed
+		}
+	}
+
 	for {
-		conn, err = this.wrappedListener.Accept()
+		conn, err := this.wrappedListener.Accept()
 
 		if err != nil {
-			return
+			return nil, err
+		}
+
+		if conn = this.admit(conn); conn != nil {
+			return conn, nil
 		}
+	}
+}
 
-		remoteHost, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+// Accepts connections from the wrapped listener and admits each of them in a separate goroutine, once
+// its PROXY protocol header has been read. Errors are passed to 'Accept'.
+func (this *ServerListener) acceptInBackground() {
+	for {
+		conn, err := this.wrappedListener.Accept()
+
+		if err != nil {
+			select {
+			case this.acceptErrors <- err:
+			case <-this.closed:
+				return
+			}
+
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
 
-		if this.loopbackOnly && !loopbackIPRegExp.MatchString(remoteHost) {
-			conn.Write([]byte("HTTP/1.1 403 Forbidden\r\nConnection: Close\r\nContent-Type: text/plain; charset=utf-8\r\nContent-Length: 30\r\n\r\nIncoming host IP is forbidden."))
-			conn.Close()
-			continue
-		} else if banned, _ := this.parentServer.bannedIPs[remoteHost]; banned == true {
-			conn.Write([]byte("HTTP/1.1 403 Forbidden\r\nConnection: Close\r\nContent-Type: text/plain; charset=utf-8\r\nContent-Length: 45\r\n\r\nIncoming host IP has been temporarily banned."))
-			conn.Close()
 			continue
-		} else {
-			return
 		}
+
+		go func() {
+			conn = this.readProxyProtocolHeader(conn)
+			if conn == nil {
+				return
+			}
+
+			if conn = this.admit(conn); conn == nil {
+				return
+			}
+
+			select {
+			case this.admittedConnections <- conn:
+			case <-this.closed:
+				conn.Close()
+			}
+		}()
 	}
 }
 
+// Reads the PROXY protocol header sent at the start of the given connection, and gets a connection
+// reporting the client's address given in it. Closes the connection and returns nil if it wasn't sent by
+// a trusted proxy, or the header couldn't be read in time.
+func (this *ServerListener) readProxyProtocolHeader(conn net.Conn) net.Conn {
+	if !this.parentServer.trustedProxies.ContainsAddress(conn.RemoteAddr().String()) {
+		conn.Close()
+		return nil
+	}
+
+	timeout := this.parentServer.startupOptions.ReadHeaderTimeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+
+	conn.SetReadDeadline(time.Now().Add(timeout))
+
+	proxiedConn, err := newProxyProtocolConnection(conn)
+	if err != nil {
+		this.parentServer.Logf(1, "Error reading PROXY protocol header from %s: %s", conn.RemoteAddr().String(), err.Error())
+		conn.Close()
+		return nil
+	}
+
+	conn.SetReadDeadline(time.Time{})
+
+	return proxiedConn
+}
+
+// Checks if a connection is allowed by the loopback restriction, the IP ban list and the per-IP
+// connection limit. Returns the connection, wrapped as needed, or nil if it was rejected.
+func (this *ServerListener) admit(conn net.Conn) net.Conn {
+	// Connections to a Unix socket are always local, and have no remote IP, unless one was given in
+	// a PROXY protocol header
+	remoteAddr, isTCP := conn.RemoteAddr().(*net.TCPAddr)
+
+	if !isTCP {
+		return this.wrapConnection(conn)
+	}
+
+	remoteHost := remoteAddr.IP.String()
+
+	if this.loopbackOnly && !loopbackIPRegExp.MatchString(remoteHost) {
+		go this.reject(conn, "403 Forbidden", "Incoming host IP is forbidden.")
+		return nil
+	} else if this.parentServer.ipBanList.IsBanned(remoteHost) {
+		go this.reject(conn, "403 Forbidden", "Incoming host IP has been temporarily banned.")
+		return nil
+	}
+
+	// Ensure the number of connections concurrently open by the remote IP doesn't exceed the limit.
+	// The connection slot is released once the connection is closed, including when it was hijacked
+	// (e.g. for a WebSocket). Trusted proxies are exempt, since they open connections on behalf of
+	// many clients.
+	maxConnectionsPerIP := this.parentServer.startupOptions.MaxConnectionsPerIP
+
+	if maxConnectionsPerIP > 0 && !this.parentServer.trustedProxies.ContainsAddress(conn.RemoteAddr().String()) {
+		release, acquired := this.parentServer.ipConnectionLimiter.TryAcquire(remoteHost, maxConnectionsPerIP)
+
+		if !acquired {
+			go this.reject(conn, "429 Too Many Requests", "Too many concurrent connections from incoming host IP.")
+			return nil
+		}
+
+		conn = &limitedConnection{Conn: conn, release: release}
+	}
+
+	return this.wrapConnection(conn)
+}
+
+// Wraps an accepted connection with TLS, if enabled for the listener. This is done after the connection
+// was checked, such that the checks count the underlying connection.
+func (this *ServerListener) wrapConnection(conn net.Conn) net.Conn {
+	if this.tlsConfig == nil {
+		return conn
+	}
+
+	return tls.Server(conn, this.tlsConfig)
+}
+
+// Sends a minimal HTTP error response to a rejected connection and closes it. Called in a separate
+// goroutine, so a slow client wouldn't block the accepting loop.
+func (this *ServerListener) reject(conn net.Conn, status string, message string) {
+	conn = this.wrapConnection(conn)
+
+	// Give up on clients that don't complete the TLS handshake or read the response in time
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	conn.Write([]byte("HTTP/1.1 " + status + "\r\nConnection: Close\r\nContent-Type: text/plain; charset=utf-8\r\nContent-Length: " + strconv.Itoa(len(message)) + "\r\n\r\n" + message))
+	conn.Close()
+}
+
 func (this *ServerListener) Close() error {
+	this.closeOnce.Do(func() {
+		close(this.closed)
+	})
+
 	return this.wrappedListener.Close()
 }
 
 func (this *ServerListener) Addr() net.Addr {
 	return this.wrappedListener.Addr()
 }
+
+// A connection holding a slot in the per-IP connection limiter, released when the connection is closed
+type limitedConnection struct {
+	net.Conn
+	release func()
+}
+
+func (this *limitedConnection) Close() error {
+	err := this.Conn.Close()
+	this.release()
+
+	return err
+}
+
+// The options of a single listener
+type ServerListenerOptions struct {
+	// Either 'http' or 'https'
+	Protocol string
+	// Either 'tcp' or 'unix'
+	Network string
+	// A TCP address of the form '<host>:<port>' (an empty host binds to all interfaces), or a Unix socket path
+	Address string
+	// Only accept connections from loopback IPs. Not applicable to Unix sockets.
+	LoopbackOnly bool
+	// Permissions given to the Unix socket file, or 0 to leave the default ones
+	SocketMode os.FileMode
+	// Expect each connection to start with a PROXY protocol (version 1 or 2) header, giving the address
+	// of the client connected to the proxy
+	ProxyProtocol bool
+}
+
+// Parses a listener specification of the form '<protocol>://<address>[?<options>]', where the protocol
+// is either 'http', 'https', 'http+unix' or 'https+unix', e.g.:
+//
+// 'http://127.0.0.1:8000', 'https://:8001?loopbackOnly=true' or 'http+unix:///var/run/zincserver.sock?mode=0660'
+//
+// Supported options are 'loopbackOnly' (boolean, TCP only), 'mode' (octal file permissions, Unix sockets only)
+// and 'proxyProtocol' (boolean).
+func ParseServerListenerOptions(specification string) (*ServerListenerOptions, error) {
+	separatorIndex := strings.Index(specification, "://")
+	if separatorIndex < 0 {
+		return nil, errors.New("Invalid listener '" + specification + "'. Should be of the form '<protocol>://<address>'.")
+	}
+
+	scheme := specification[:separatorIndex]
+	address := specification[separatorIndex+3:]
+	optionsString := ""
+
+	if queryIndex := strings.Index(address, "?"); queryIndex >= 0 {
+		optionsString = address[queryIndex+1:]
+		address = address[:queryIndex]
+	}
+
+	options := &ServerListenerOptions{
+		Address: address,
+	}
+
+	switch scheme {
+	case "http", "https":
+		options.Protocol = scheme
+		options.Network = "tcp"
+
+		_, port, err := net.SplitHostPort(address)
+		if err != nil || port == "" {
+			return nil, errors.New("Invalid listener address '" + address + "'. Should be of the form '<host>:<port>'.")
+		}
+	case "http+unix", "https+unix":
+		options.Protocol = strings.TrimSuffix(scheme, "+unix")
+		options.Network = "unix"
+
+		if address == "" {
+			return nil, errors.New("No Unix socket path was given for the listener '" + specification + "'.")
+		}
+	default:
+		return nil, errors.New("Invalid listener protocol '" + scheme + "'. Should be one of 'http', 'https', 'http+unix' or 'https+unix'.")
+	}
+
+	parsedOptions, err := url.ParseQuery(optionsString)
+	if err != nil {
+		return nil, err
+	}
+
+	for name, values := range parsedOptions {
+		value := values[len(values)-1]
+
+		switch {
+		case name == "loopbackOnly" && options.Network == "tcp":
+			options.LoopbackOnly, err = strconv.ParseBool(value)
+			if err != nil {
+				return nil, errors.New("Invalid 'loopbackOnly' option value '" + value + "'.")
+			}
+		case name == "mode" && options.Network == "unix":
+			mode, err := strconv.ParseUint(value, 8, 32)
+			if err != nil || mode > 0777 {
+				return nil, errors.New("Invalid 'mode' option value '" + value + "'. Should be an octal permission mask, e.g. '0660'.")
+			}
+
+			options.SocketMode = os.FileMode(mode)
+		case name == "proxyProtocol":
+			options.ProxyProtocol, err = strconv.ParseBool(value)
+			if err != nil {
+				return nil, errors.New("Invalid 'proxyProtocol' option value '" + value + "'.")
+			}
+		default:
+			return nil, errors.New("Unsupported listener option '" + name + "' for '" + specification + "'.")
+		}
+	}
+
+	return options, nil
+}
+
+// Gets a description of the listener, e.g. 'secure listener at 127.0.0.1:8001', used in log messages
+func (this *ServerListenerOptions) String() string {
+	description := "insecure listener"
+
+	if this.Protocol == "https" {
+		description = "secure listener"
+	}
+
+	if this.Network == "unix" {
+		return description + " at Unix socket " + this.Address
+	}
+
+	return description + " at " + this.Address
+}
+
+// Creates the listener described by the options. For Unix sockets, a stale socket file left at the path
+// is removed first, and the socket file's permissions are set if needed.
+func (this *ServerListenerOptions) Listen() (net.Listener, error) {
+	if this.Network != "unix" {
+		return net.Listen(this.Network, this.Address)
+	}
+
+	// Remove a socket file left by a previous instance, if it exists. Other kinds of files are never removed.
+	if fileInfo, err := os.Lstat(this.Address); err == nil && fileInfo.Mode()&os.ModeSocket != 0 {
+		err = os.Remove(this.Address)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	listener, err := net.Listen("unix", this.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	if this.SocketMode != 0 {
+		err = os.Chmod(this.Address, this.SocketMode)
+		if err != nil {
+			listener.Close()
+			return nil, err
+		}
+	}
+
+	return listener, nil
+}
+
+// Ensures a non-empty trusted proxy list is given if any of the given listeners expects PROXY protocol
+// headers, since headers sent by any other peer can't be trusted
+func ValidateProxyProtocolListeners(allListenerOptions []*ServerListenerOptions, trustedProxies *TrustedProxyList) error {
+	for _, listenerOptions := range allListenerOptions {
+		if listenerOptions.ProxyProtocol && trustedProxies.IsEmpty() {
+			return errors.New("The 'proxyProtocol' listener option requires a list of trusted proxies to be given with 'trustedProxies'.")
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bufio"
+	gocontext "context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ServerListenerOptions", func() {
+	It("Parses listener specifications", func() {
+		options, err := ParseServerListenerOptions("http://127.0.0.1:8000")
+		Expect(err).To(BeNil())
+		Expect(*options).To(Equal(ServerListenerOptions{Protocol: "http", Network: "tcp", Address: "127.0.0.1:8000"}))
+
+		options, err = ParseServerListenerOptions("https://[::1]:8001?loopbackOnly=true")
+		Expect(err).To(BeNil())
+		Expect(*options).To(Equal(ServerListenerOptions{Protocol: "https", Network: "tcp", Address: "[::1]:8001", LoopbackOnly: true}))
+
+		options, err = ParseServerListenerOptions("http+unix:///var/run/zincserver.sock?mode=0660")
+		Expect(err).To(BeNil())
+		Expect(*options).To(Equal(ServerListenerOptions{Protocol: "http", Network: "unix", Address: "/var/run/zincserver.sock", SocketMode: 0660}))
+
+		options, err = ParseServerListenerOptions("https+unix://./zincserver.sock")
+		Expect(err).To(BeNil())
+		Expect(*options).To(Equal(ServerListenerOptions{Protocol: "https", Network: "unix", Address: "./zincserver.sock"}))
+
+		for _, invalidSpecification := range []string{
+			"127.0.0.1:8000",
+			"ftp://127.0.0.1:8000",
+			"http://127.0.0.1",
+			"http+unix://",
+			"http://:8000?mode=0660",
+			"http+unix:///tmp/zincserver.sock?loopbackOnly=true",
+			"http+unix:///tmp/zincserver.sock?mode=999",
+		} {
+			_, err = ParseServerListenerOptions(invalidSpecification)
+			Expect(err).NotTo(BeNil(), invalidSpecification)
+		}
+	})
+})
+
+var _ = Describe("Server", func() {
+	var context *ServerTestContext
+	var testDirectory string
+	var socketPath string
+
+	BeforeEach(func() {
+		var err error
+		testDirectory, err = ioutil.TempDir("", "ServerListener")
+		Expect(err).To(BeNil())
+
+		socketPath = filepath.Join(testDirectory, "zincserver.sock")
+
+		context = NewServerTestContext()
+		context.startupOptions.Listeners = []*ServerListenerOptions{
+			&ServerListenerOptions{Protocol: "http", Network: "unix", Address: socketPath, SocketMode: 0600},
+			&ServerListenerOptions{Protocol: "http", Network: "tcp", Address: "127.0.0.1:12347", LoopbackOnly: true},
+		}
+
+		context.Start()
+	})
+
+	AfterEach(func() {
+		context.Stop()
+		os.RemoveAll(testDirectory)
+	})
+
+	It("Serves requests on additional listeners", func() {
+		client := context.GetClientForRandomDatastore("")
+		_, err := client.Put(context.GetTestEntries())
+		Expect(err).To(BeNil())
+
+		// Ensure the socket file was given the requested permissions
+		socketFileInfo, err := os.Stat(socketPath)
+		Expect(err).To(BeNil())
+		Expect(socketFileInfo.Mode() & os.ModePerm).To(Equal(os.FileMode(0600)))
+
+		// Send a request through the Unix socket
+		unixSocketClient := &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx gocontext.Context, network string, address string) (net.Conn, error) {
+					return (&net.Dialer{}).DialContext(ctx, "unix", socketPath)
+				},
+			},
+		}
+
+		response, err := unixSocketClient.Get("http://localhost/datastore/" + client.datastoreName)
+		Expect(err).To(BeNil())
+		response.Body.Close()
+		Expect(response.StatusCode).To(Equal(http.StatusOK))
+		unixSocketClient.CloseIdleConnections()
+
+		// Send a request through the TCP listener bound to the loopback interface
+		response, err = http.Get("http://127.0.0.1:12347/datastore/" + client.datastoreName)
+		Expect(err).To(BeNil())
+		response.Body.Close()
+		Expect(response.StatusCode).To(Equal(http.StatusOK))
+
+		// Ensure the socket file is removed once the server is stopped
+		context.server.Stop()
+
+		_, err = os.Stat(socketPath)
+		Expect(os.IsNotExist(err)).To(BeTrue())
+	})
+
+	It("Doesn't ban Unix socket clients after repeated authentication failures", func() {
+		settingErr := context.PutGlobalSettings("", map[string]string{
+			`"['server']['ipBan']['enabled']"`:     `true`,
+			`"['server']['ipBan']['maxFailures']"`: `3`,
+		}, "")
+		Expect(settingErr).To(BeNil())
+
+		client := context.GetClientForRandomDatastore("")
+		_, err := client.Put(context.GetTestEntries())
+		Expect(err).To(BeNil())
+
+		unixSocketClient := &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx gocontext.Context, network string, address string) (net.Conn, error) {
+					return (&net.Dialer{}).DialContext(ctx, "unix", socketPath)
+				},
+			},
+		}
+		defer unixSocketClient.CloseIdleConnections()
+
+		// Send requests with an invalid access key through the Unix socket
+		invalidAccessKey, _ := context.GetRandomAccessKey()
+
+		for i := 0; i < 5; i++ {
+			response, err := unixSocketClient.Get("http://localhost/datastore/" + client.datastoreName + "?accessKey=" + invalidAccessKey)
+			Expect(err).To(BeNil())
+			response.Body.Close()
+			Expect(response.StatusCode).To(Equal(http.StatusUnauthorized))
+		}
+
+		// Ensure the failures weren't recorded, and other clients of the socket are still served
+		Expect(context.server.ipBanList.List()).To(BeEmpty())
+
+		response, err := unixSocketClient.Get("http://localhost/datastore/" + client.datastoreName)
+		Expect(err).To(BeNil())
+		response.Body.Close()
+		Expect(response.StatusCode).To(Equal(http.StatusOK))
+	})
+})
+
+var _ = Describe("Server", func() {
+	var context *ServerTestContext
+
+	BeforeEach(func() {
+		context = NewServerTestContext()
+		context.startupOptions.MaxConnectionsPerIP = 2
+		context.startupOptions.ReadHeaderTimeout = 200 * time.Millisecond
+		context.startupOptions.MaxHeaderBytes = 1024
+		context.Start()
+	})
+
+	AfterEach(func() {
+		context.Stop()
+	})
+
+	// Opens a connection to the insecure listener and sends the given raw request, if not empty
+	connect := func(request string) net.Conn {
+		conn, err := net.Dial("tcp", "127.0.0.1:12345")
+		Expect(err).To(BeNil())
+
+		if request != "" {
+			_, err = conn.Write([]byte(request))
+			Expect(err).To(BeNil())
+		}
+
+		return conn
+	}
+
+	// Reads the status line of the response sent over the given connection
+	readStatusLine := func(conn net.Conn) string {
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		statusLine, _ := bufio.NewReader(conn).ReadString('\n')
+
+		return strings.TrimSpace(statusLine)
+	}
+
+	It("Limits the number of concurrent connections from a single IP", func() {
+		datastorePath := "/datastore/" + RandomWordString(12)
+
+		firstConn := connect("")
+		secondConn := connect("")
+		defer secondConn.Close()
+
+		// Ensure a third connection is rejected
+		thirdConn := connect("GET " + datastorePath + " HTTP/1.1\r\nHost: localhost\r\n\r\n")
+		Expect(readStatusLine(thirdConn)).To(Equal("HTTP/1.1 429 Too Many Requests"))
+		thirdConn.Close()
+
+		// Ensure a connection is accepted once one of the previous ones is closed
+		firstConn.Close()
+
+		Eventually(func() string {
+			conn := connect("GET " + datastorePath + " HTTP/1.1\r\nHost: localhost\r\n\r\n")
+			defer conn.Close()
+
+			return readStatusLine(conn)
+		}, "5s").Should(Equal("HTTP/1.1 404 Not Found"))
+	})
+
+	Context("Behind a trusted proxy", func() {
+		BeforeEach(func() {
+			context.Stop()
+
+			context = NewServerTestContext()
+			context.startupOptions.MaxConnectionsPerIP = 2
+			context.startupOptions.TrustedProxies = "127.0.0.1/32"
+			context.Start()
+		})
+
+		It("Doesn't limit the number of concurrent connections from a trusted proxy", func() {
+			firstConn := connect("")
+			defer firstConn.Close()
+			secondConn := connect("")
+			defer secondConn.Close()
+
+			// Ensure a third connection is accepted
+			thirdConn := connect("GET /datastore/" + RandomWordString(12) + " HTTP/1.1\r\nHost: localhost\r\n\r\n")
+			defer thirdConn.Close()
+
+			Expect(readStatusLine(thirdConn)).To(Equal("HTTP/1.1 404 Not Found"))
+		})
+	})
+
+	It("Closes connections that don't send their headers in time", func() {
+		conn := connect("GET / HTTP/1.1\r\n")
+		defer conn.Close()
+
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+		startTime := time.Now()
+		_, err := conn.Read(make([]byte, 1))
+		Expect(err).NotTo(BeNil())
+		Expect(time.Since(startTime)).To(BeNumerically("<", 5*time.Second))
+	})
+
+	It("Rejects requests with oversized headers", func() {
+		conn := connect("GET / HTTP/1.1\r\nHost: localhost\r\nX-Padding: " + strings.Repeat("a", 8192) + "\r\n\r\n")
+		defer conn.Close()
+
+		Expect(readStatusLine(conn)).To(Equal("HTTP/1.1 431 Request Header Fields Too Large"))
+	})
+})
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"strconv"
+)
+
+// Bucket upper bounds, in seconds, for request and flush latency histograms
+var latencyHistogramBuckets = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Bucket upper bounds, in seconds, for compaction duration histograms
+var compactionDurationHistogramBuckets = []float64{0.01, 0.05, 0.1, 0.5, 1, 5, 10, 30, 60, 300}
+
+// The metrics collected by the server
+type ServerMetrics struct {
+	requests                 *MetricsCounter
+	postLatency              *MetricsHistogram
+	bytesRead                *MetricsCounter
+	bytesWritten             *MetricsCounter
+	compactions              *MetricsCounter
+	compactionDuration       *MetricsHistogram
+	compactionReclaimedBytes *MetricsCounter
+	repairs                  *MetricsCounter
+	flushLatency             *MetricsHistogram
+	openDatastores           *MetricsGauge
+	openFileDescriptors      *MetricsGauge
+	webSocketSubscribers     *MetricsGauge
+	rateLimitRejections      *MetricsCounter
+}
+
+// Server metrics object constructor function
+func NewServerMetrics() *ServerMetrics {
+	return &ServerMetrics{
+		requests:                 NewMetricsCounter("zincserver_requests_total", "Number of HTTP requests handled, by method and response status.", "method", "status"),
+		postLatency:              NewMetricsHistogram("zincserver_post_request_duration_seconds", "Time taken to handle POST requests to datastores.", latencyHistogramBuckets),
+		bytesRead:                NewMetricsCounter("zincserver_datastore_read_bytes_total", "Number of datastore bytes sent to clients, in binary form."),
+		bytesWritten:             NewMetricsCounter("zincserver_datastore_written_bytes_total", "Number of transaction bytes written to datastore files."),
+		compactions:              NewMetricsCounter("zincserver_compactions_total", "Number of datastore compactions performed."),
+		compactionDuration:       NewMetricsHistogram("zincserver_compaction_duration_seconds", "Time taken to compact datastores.", compactionDurationHistogramBuckets),
+		compactionReclaimedBytes: NewMetricsCounter("zincserver_compaction_reclaimed_bytes_total", "Number of bytes reclaimed by datastore compactions."),
+		repairs:                  NewMetricsCounter("zincserver_repairs_total", "Number of corrupted or incomplete datastores repaired."),
+		flushLatency:             NewMetricsHistogram("zincserver_flush_latency_seconds", "Time from a datastore write to its flush to physical media.", latencyHistogramBuckets),
+		openDatastores:           NewMetricsGauge("zincserver_open_datastores", "Number of datastores currently loaded."),
+		openFileDescriptors:      NewMetricsGauge("zincserver_open_file_descriptors", "Number of datastore file descriptors currently open."),
+		webSocketSubscribers:     NewMetricsGauge("zincserver_websocket_subscribers", "Number of WebSocket connections currently open."),
+		rateLimitRejections:      NewMetricsCounter("zincserver_rate_limit_rejections_total", "Number of requests rejected due to a rate limit, by limit type.", "limit"),
+	}
+}
+
+// Records a handled request. WebSocket upgrades are recorded with the method 'WebSocket' and status 101.
+func (this *ServerMetrics) RecordRequest(method string, statusCode int, hijacked bool) {
+	if hijacked {
+		method = "WebSocket"
+		statusCode = http.StatusSwitchingProtocols
+	}
+
+	// Group unknown methods together to prevent clients from creating an arbitrary number of series
+	switch method {
+	case "GET", "HEAD", "POST", "PUT", "DELETE", "OPTIONS", "WebSocket":
+	default:
+		method = "other"
+	}
+
+	// A handler that didn't write anything implies a 200 OK status
+	if statusCode == 0 {
+		statusCode = http.StatusOK
+	}
+
+	this.requests.Inc(method, strconv.Itoa(statusCode))
+}
+
+// Writes all metrics in the Prometheus text format
+func (this *ServerMetrics) WritePrometheusText(target *bytes.Buffer) {
+	metrics := []Metric{
+		this.requests,
+		this.postLatency,
+		this.bytesRead,
+		this.bytesWritten,
+		this.compactions,
+		this.compactionDuration,
+		this.compactionReclaimedBytes,
+		this.repairs,
+		this.flushLatency,
+		this.openDatastores,
+		this.openFileDescriptors,
+		this.webSocketSubscribers,
+		this.rateLimitRejections,
+	}
+
+	for _, metric := range metrics {
+		metric.WritePrometheusText(target)
+	}
+}
+
+// Declare the metrics handler object type
+type ServerMetricsHandler struct {
+	parentServer *Server
+	adminHandler *ServerAdminHandler
+	loopbackOnly bool
+}
+
+// Metrics handler object constructor function. The administration handler is used to verify the master key.
+// 'loopbackOnly' should be set if the handler serves a listener only accepting loopback connections.
+func NewServerMetricsHandler(parentServer *Server, adminHandler *ServerAdminHandler, loopbackOnly bool) *ServerMetricsHandler {
+	return &ServerMetricsHandler{
+		parentServer: parentServer,
+		adminHandler: adminHandler,
+		loopbackOnly: loopbackOnly,
+	}
+}
+
+// The main handler for metrics requests. Requests received by a loopback only listener are always allowed,
+// others require the master key.
+func (this *ServerMetricsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		endRequestWithError(w, r, http.StatusMethodNotAllowed, nil)
+		return
+	}
+
+	// Ensure the request was received by a loopback only listener or sent with the master key. The remote
+	// address isn't used for this, since a local reverse proxy would forward any client's request from a
+	// loopback address.
+	if !this.loopbackOnly && !this.adminHandler.authorizeMasterKeyRequest(w, r) {
+		return
+	}
+
+	metrics := this.parentServer.metrics
+
+	// Update gauges whose values are sampled when requested
+	openDatastoreCount := 0
+
+	this.parentServer.datastoreMapLock.Lock()
+
+	for _, operations := range this.parentServer.datastores {
+		if operations.State != nil {
+			openDatastoreCount++
+		}
+	}
+
+	this.parentServer.datastoreMapLock.Unlock()
+
+	metrics.openDatastores.Set(float64(openDatastoreCount))
+	metrics.openFileDescriptors.Set(float64(FileDescriptors.Count()))
+
+	// Serialize the metrics
+	var body bytes.Buffer
+	metrics.WritePrometheusText(&body)
+
+	// Set headers for the response
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Header().Set("Content-Length", strconv.Itoa(body.Len()))
+
+	// Write the header
+	w.WriteHeader(http.StatusOK)
+
+	// If the request had a GET method (HEAD would skip this), send the metrics
+	if r.Method == "GET" {
+		w.Write(body.Bytes())
+	}
+}
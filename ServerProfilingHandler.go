@@ -0,0 +1,48 @@
+package main
+
+import (
+	"net/http"
+	"net/http/pprof"
+	"strings"
+)
+
+// The path profiling endpoints are mounted under
+const profilingPathPrefix = "/admin/debug/pprof/"
+
+// Declare the profiling handler object type. Serves runtime profiles in the format used by 'go tool pprof'.
+type ServerProfilingHandler struct {
+	parentServer *Server
+}
+
+// Profiling handler object constructor function
+func NewServerProfilingHandler(parentServer *Server) *ServerProfilingHandler {
+	return &ServerProfilingHandler{
+		parentServer: parentServer,
+	}
+}
+
+// The main handler for profiling requests. The prefix itself serves an index of the available profiles.
+// Paths relative to it are 'profile' (CPU profile, for the duration given by the 'seconds' parameter),
+// 'trace' (execution trace, for the duration given by the 'seconds' parameter), 'cmdline', 'symbol', and
+// named runtime profiles: 'heap', 'allocs', 'goroutine', 'block', 'mutex' and 'threadcreate'.
+func (this *ServerProfilingHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, profilingPathPrefix)
+
+	switch name {
+	case "":
+		pprof.Index(w, r)
+	case "profile":
+		this.parentServer.Logf(1, "Collecting a CPU profile requested by %s", r.RemoteAddr)
+		pprof.Profile(w, r)
+	case "trace":
+		this.parentServer.Logf(1, "Collecting an execution trace requested by %s", r.RemoteAddr)
+		pprof.Trace(w, r)
+	case "cmdline":
+		pprof.Cmdline(w, r)
+	case "symbol":
+		pprof.Symbol(w, r)
+	default:
+		// Responds with 404 Not Found for unknown profile names
+		pprof.Handler(name).ServeHTTP(w, r)
+	}
+}
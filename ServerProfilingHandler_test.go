@@ -0,0 +1,132 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Server", func() {
+	var context *ServerTestContext
+
+	BeforeEach(func() {
+		context = NewServerTestContext()
+		context.startupOptions.TrustedProxies = "127.0.0.1"
+	})
+
+	AfterEach(func() {
+		context.Stop()
+	})
+
+	// Sends a GET request to the given path, optionally on behalf of the given forwarded client IP, and
+	// gets the response status code and body
+	get := func(path string, forwardedFor string) (int, string) {
+		request, _ := http.NewRequest("GET", "http://127.0.0.1:12345"+path, nil)
+
+		if forwardedFor != "" {
+			request.Header.Set("X-Forwarded-For", forwardedFor)
+		}
+
+		response, err := http.DefaultClient.Do(request)
+		Expect(err).To(BeNil())
+		defer response.Body.Close()
+
+		body, err := ioutil.ReadAll(response.Body)
+		Expect(err).To(BeNil())
+
+		return response.StatusCode, string(body)
+	}
+
+	setMasterKey := func() string {
+		masterKey, masterKeyHash := context.GetRandomAccessKey()
+		err := context.PutGlobalSetting(`"['server']['masterKeyHash']"`, `"`+masterKeyHash+`"`, "")
+		Expect(err).To(BeNil())
+
+		return masterKey
+	}
+
+	It("Serves runtime profiles", func() {
+		context.Start()
+
+		statusCode, body := get("/admin/debug/pprof/", "")
+		Expect(statusCode).To(Equal(http.StatusOK))
+		Expect(body).To(ContainSubstring("goroutine"))
+
+		statusCode, body = get("/admin/debug/pprof/goroutine?debug=1", "")
+		Expect(statusCode).To(Equal(http.StatusOK))
+		Expect(strings.HasPrefix(body, "goroutine profile:")).To(BeTrue())
+
+		statusCode, body = get("/admin/debug/pprof/heap", "")
+		Expect(statusCode).To(Equal(http.StatusOK))
+		Expect(body).NotTo(BeEmpty())
+
+		statusCode, body = get("/admin/debug/pprof/profile?seconds=1", "")
+		Expect(statusCode).To(Equal(http.StatusOK))
+		Expect(body).NotTo(BeEmpty())
+
+		statusCode, _ = get("/admin/debug/pprof/nonexisting", "")
+		Expect(statusCode).To(Equal(http.StatusNotFound))
+	})
+
+	It("Doesn't require the master key for profiling requests received by a loopback only listener", func() {
+		context.startupOptions.InsecureListenerLoopbackOnly = true
+		context.Start()
+
+		setMasterKey()
+
+		statusCode, _ := get("/admin/debug/pprof/heap", "")
+		Expect(statusCode).To(Equal(http.StatusOK))
+
+		// Ensure other administration requests still require the master key
+		statusCode, _ = get("/admin/usage", "")
+		Expect(statusCode).To(Equal(http.StatusUnauthorized))
+	})
+
+	It("Requires the master key for profiling requests received by other listeners", func() {
+		context.Start()
+
+		masterKey := setMasterKey()
+
+		// Requests from a loopback address (e.g. sent through a local reverse proxy) require the master key
+		statusCode, _ := get("/admin/debug/pprof/heap", "")
+		Expect(statusCode).To(Equal(http.StatusUnauthorized))
+
+		statusCode, _ = get("/admin/debug/pprof/heap", "203.0.113.7")
+		Expect(statusCode).To(Equal(http.StatusUnauthorized))
+
+		statusCode, _ = get("/admin/debug/pprof/cmdline", "203.0.113.7")
+		Expect(statusCode).To(Equal(http.StatusUnauthorized))
+
+		statusCode, _ = get("/admin/debug/pprof/heap?accessKey="+masterKey, "203.0.113.7")
+		Expect(statusCode).To(Equal(http.StatusOK))
+	})
+
+	It("Doesn't require the master key for metrics requests received by a loopback only listener", func() {
+		context.startupOptions.InsecureListenerLoopbackOnly = true
+		context.Start()
+
+		setMasterKey()
+
+		statusCode, body := get("/metrics", "")
+		Expect(statusCode).To(Equal(http.StatusOK))
+		Expect(body).To(ContainSubstring("zincserver_requests_total"))
+	})
+
+	It("Requires the master key for metrics requests received by other listeners", func() {
+		context.Start()
+
+		masterKey := setMasterKey()
+
+		statusCode, _ := get("/metrics", "")
+		Expect(statusCode).To(Equal(http.StatusUnauthorized))
+
+		statusCode, _ = get("/metrics", "203.0.113.7")
+		Expect(statusCode).To(Equal(http.StatusUnauthorized))
+
+		statusCode, _ = get("/metrics?accessKey="+masterKey, "203.0.113.7")
+		Expect(statusCode).To(Equal(http.StatusOK))
+	})
+})
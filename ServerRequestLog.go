@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// Request details that are only known to the handler processing the request, and are included when the
+// request is logged once handled
+type RequestLogInfo struct {
+	// The requested URI, with any access key redacted. If empty, only the request path is logged.
+	URI string
+	// The name of the datastore targeted by the request
+	Datastore string
+	// The identifier of the client sending the request, e.g. its access key hash
+	ClientID string
+}
+
+type requestLogInfoContextKey struct{}
+
+// Attaches a new, empty, log info object to the given request
+func WithRequestLogInfo(r *http.Request) (*http.Request, *RequestLogInfo) {
+	logInfo := &RequestLogInfo{}
+
+	return r.WithContext(context.WithValue(r.Context(), requestLogInfoContextKey{}, logInfo)), logInfo
+}
+
+// Gets the log info object attached to the given request. If there isn't one, a detached object is returned,
+// so the result can always be written to.
+func GetRequestLogInfo(r *http.Request) *RequestLogInfo {
+	if logInfo, ok := r.Context().Value(requestLogInfoContextKey{}).(*RequestLogInfo); ok {
+		return logInfo
+	}
+
+	return &RequestLogInfo{}
+}
+
+// Logs a handled request to the server log, at level 1, and to the access log, if enabled
+func (this *Server) LogRequest(r *http.Request, w *ServerResponseWriter, logInfo *RequestLogInfo, startTime time.Time) {
+	duration := time.Since(startTime)
+
+	// Use the status code recorded by the response writer. WebSocket upgrades are recorded as 101
+	// Switching Protocols, and a handler that didn't write anything implies 200 OK.
+	statusCode := w.StatusCode
+
+	if w.Hijacked {
+		statusCode = http.StatusSwitchingProtocols
+	} else if statusCode == 0 {
+		statusCode = http.StatusOK
+	}
+
+	// Only include the path if the handler didn't provide a redacted URI, since the query string may
+	// include an access key
+	uri := logInfo.URI
+
+	if uri == "" {
+		uri = r.URL.Path
+	}
+
+	if this.LogLevel() >= 1 {
+		fields := LogFields{
+			"remoteAddr": r.RemoteAddr,
+			"method":     r.Method,
+			"status":     statusCode,
+			"duration":   durationToMilliseconds(duration),
+			"bytes":      w.BytesWritten,
+		}
+
+		if logInfo.Datastore != "" {
+			fields["datastore"] = logInfo.Datastore
+		}
+
+		if logInfo.ClientID != "" {
+			fields["clientID"] = logInfo.ClientID
+		}
+
+		this.logger.Write(1, "["+r.RemoteAddr+"]: "+r.Method+" "+uri, fields)
+	}
+
+	if this.accessLog != nil {
+		err := this.accessLog.Write(&AccessLogRecord{
+			Time:       startTime,
+			RemoteAddr: r.RemoteAddr,
+			Method:     r.Method,
+			URI:        uri,
+			Protocol:   r.Proto,
+			Status:     statusCode,
+			Bytes:      w.BytesWritten,
+			Duration:   duration,
+			Datastore:  logInfo.Datastore,
+			ClientID:   logInfo.ClientID,
+		})
+
+		if err != nil {
+			this.Logf(1, "Error writing to access log: %s", err.Error())
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Server", func() {
+	var context *ServerTestContext
+
+	BeforeEach(func() {
+		context = NewServerTestContext()
+		context.Start()
+	})
+
+	AfterEach(func() {
+		context.Stop()
+	})
+
+	It("Writes handled requests to the access log, with access keys redacted", func() {
+		directoryPath, err := ioutil.TempDir("", "AccessLog")
+		Expect(err).To(BeNil())
+		defer os.RemoveAll(directoryPath)
+
+		accessLogFilePath := filepath.Join(directoryPath, "access.log")
+		accessLogWriter, err := NewRotatingFileWriter(accessLogFilePath, 0, 0, 0)
+		Expect(err).To(BeNil())
+
+		context.server.accessLog = NewAccessLog(accessLogWriter, AccessLogFormat_JSON)
+
+		accessKey, accessKeyHash := context.GetRandomAccessKey()
+		datastoreName := RandomWordString(12)
+
+		err = context.PutDatastoreSetting(datastoreName, `"['datastore']['accessKeyHash']['`+accessKeyHash+`']"`, `"ReaderWriter"`, "")
+		Expect(err).To(BeNil())
+
+		_, err = context.GetClient(datastoreName, accessKey).Put(context.GetTestEntries())
+		Expect(err).To(BeNil())
+
+		_, err = context.GetClient(datastoreName, accessKey).Get(0)
+		Expect(err).To(BeNil())
+
+		// Send a request including the access key in the query string
+		response, err := http.Get(context.hostURL + "/datastore/" + datastoreName + "?accessKey=" + accessKey)
+		Expect(err).To(BeNil())
+		response.Body.Close()
+		Expect(response.StatusCode).To(Equal(http.StatusOK))
+
+		accessLogContent, err := ioutil.ReadFile(accessLogFilePath)
+		Expect(err).To(BeNil())
+		Expect(string(accessLogContent)).NotTo(ContainSubstring(accessKey))
+
+		lines := bytes.Split(bytes.TrimSuffix(accessLogContent, []byte("\n")), []byte("\n"))
+		Expect(len(lines)).To(BeNumerically(">=", 2))
+
+		var record map[string]interface{}
+		Expect(json.Unmarshal(lines[len(lines)-2], &record)).To(BeNil())
+		Expect(record["method"]).To(Equal("GET"))
+		Expect(record["status"]).To(Equal(200.0))
+		Expect(record["datastore"]).To(Equal(datastoreName))
+		Expect(record["clientID"]).To(Equal(accessKeyHash))
+		Expect(record["bytes"]).To(BeNumerically(">", 0))
+		Expect(record["uri"]).To(Equal("/datastore/" + datastoreName))
+
+		record = nil
+		Expect(json.Unmarshal(lines[len(lines)-1], &record)).To(BeNil())
+		Expect(record["uri"]).To(Equal("/datastore/" + datastoreName + "?[accessKeyHash=" + accessKeyHash + "]"))
+	})
+
+	It("Applies the log level set in the global configuration while running", func() {
+		Expect(context.server.LogLevel()).To(Equal(context.startupOptions.LogLevel))
+
+		err := context.PutGlobalSetting(`"['server']['log']['level']"`, `2`, "")
+		Expect(err).To(BeNil())
+		Expect(context.server.LogLevel()).To(Equal(2))
+
+		err = context.PutGlobalSetting(`"['server']['log']['level']"`, `0`, "")
+		Expect(err).To(BeNil())
+		Expect(context.server.LogLevel()).To(Equal(0))
+	})
+})
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+)
+
+// A response writer wrapper that records the status code and number of body bytes sent to the client
+type ServerResponseWriter struct {
+	http.ResponseWriter
+
+	// The status code sent, or 0 if a header wasn't written yet
+	StatusCode int
+	// The number of body bytes written
+	BytesWritten int64
+	// True if the connection was hijacked (e.g. upgraded to a WebSocket)
+	Hijacked bool
+}
+
+// Server response writer object constructor function
+func NewServerResponseWriter(w http.ResponseWriter) *ServerResponseWriter {
+	return &ServerResponseWriter{
+		ResponseWriter: w,
+	}
+}
+
+// Writes the header with the given status code
+func (this *ServerResponseWriter) WriteHeader(statusCode int) {
+	if this.StatusCode == 0 {
+		this.StatusCode = statusCode
+	}
+
+	this.ResponseWriter.WriteHeader(statusCode)
+}
+
+// Writes to the response body. Writing without first writing the header implies a 200 OK status.
+func (this *ServerResponseWriter) Write(data []byte) (int, error) {
+	if this.StatusCode == 0 {
+		this.StatusCode = http.StatusOK
+	}
+
+	written, err := this.ResponseWriter.Write(data)
+	this.BytesWritten += int64(written)
+
+	return written, err
+}
+
+// Flushes buffered data to the client, if supported by the wrapped writer
+func (this *ServerResponseWriter) Flush() {
+	if flusher, ok := this.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Gets the wrapped response writer. This allows http.ResponseController to access its features (e.g.
+// setting deadlines).
+func (this *ServerResponseWriter) Unwrap() http.ResponseWriter {
+	return this.ResponseWriter
+}
+
+// Hijacks the underlying connection, if supported by the wrapped writer. This is required for WebSocket upgrades.
+func (this *ServerResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := this.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("The response writer doesn't support hijacking.")
+	}
+
+	conn, readWriter, err := hijacker.Hijack()
+	if err == nil {
+		this.Hijacked = true
+	}
+
+	return conn, readWriter, err
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// The time allowed for writing a close frame to a WebSocket connection during shutdown
+const webSocketCloseFrameWriteTimeout = 1 * time.Second
+
+// A set of the open WebSocket connections, such that they can be closed when the server shuts down
+type WebSocketConnectionSet struct {
+	connections map[*websocket.Conn]struct{}
+
+	// Set once all connections have been closed, after which added connections are closed immediately
+	closed      bool
+	closeReason string
+
+	// Make this lockable
+	sync.Mutex
+}
+
+// WebSocket connection set object constructor function
+func NewWebSocketConnectionSet() *WebSocketConnectionSet {
+	return &WebSocketConnectionSet{
+		connections: make(map[*websocket.Conn]struct{}),
+	}
+}
+
+// Adds a connection to the set. If the set was already closed, the connection is closed instead.
+func (this *WebSocketConnectionSet) Add(connection *websocket.Conn) {
+	this.Lock()
+
+	if this.closed {
+		this.Unlock()
+		closeWebSocketConnection(connection, this.closeReason)
+		return
+	}
+
+	this.connections[connection] = struct{}{}
+	this.Unlock()
+}
+
+// Removes a connection from the set
+func (this *WebSocketConnectionSet) Remove(connection *websocket.Conn) {
+	this.Lock()
+	delete(this.connections, connection)
+	this.Unlock()
+}
+
+// Gets the number of connections in the set
+func (this *WebSocketConnectionSet) Count() int {
+	this.Lock()
+	defer this.Unlock()
+
+	return len(this.connections)
+}
+
+// Sends a 'going away' close frame to every connection in the set and closes them. Connections added
+// afterwards are closed immediately.
+func (this *WebSocketConnectionSet) CloseAll(reason string) {
+	this.Lock()
+	connections := this.connections
+	this.connections = make(map[*websocket.Conn]struct{})
+	this.closed = true
+	this.closeReason = reason
+	this.Unlock()
+
+	for connection := range connections {
+		closeWebSocketConnection(connection, reason)
+	}
+}
+
+// Sends a 'going away' close frame to the given connection and closes it
+func closeWebSocketConnection(connection *websocket.Conn, reason string) {
+	closeMessage := websocket.FormatCloseMessage(websocket.CloseGoingAway, reason)
+
+	// Errors are ignored here, since the connection is closed regardless
+	connection.WriteControl(websocket.CloseMessage, closeMessage, time.Now().Add(webSocketCloseFrameWriteTimeout))
+	connection.Close()
+}
+
+// Checks whether a graceful shutdown has been initiated
+func (this *Server) ShuttingDown() bool {
+	return atomic.LoadInt32(&this.shuttingDown) != 0
+}
+
+// Gets a channel that is closed once a graceful shutdown has been initiated
+func (this *Server) ShutdownInitiated() <-chan struct{} {
+	return this.shutdownInitiated
+}
+
+// Gets a channel that is closed once a graceful shutdown has completed
+func (this *Server) ShutdownCompleted() <-chan struct{} {
+	return this.shutdownCompleted
+}
+
+// Gracefully shuts down the server. The listeners stop accepting connections, open WebSocket connections
+// are sent close frames, pending long-poll requests are responded to with the entries currently available,
+// and in-flight requests are allowed to complete. Once the writer queues of all datastores have drained,
+// every open datastore is flushed to disk, and the server is stopped.
+//
+// The given timeout applies separately to waiting for in-flight requests, and to flushing the datastores,
+// which is attempted even if in-flight requests didn't complete in time. If either times out, the server
+// is stopped anyway, and an error is returned.
+func (this *Server) Shutdown(timeout time.Duration) (err error) {
+	// Ensure shutdown is only initiated once
+	if !atomic.CompareAndSwapInt32(&this.shuttingDown, 0, 1) {
+		return errors.New("The server is already shutting down.")
+	}
+
+	defer close(this.shutdownCompleted)
+
+	// Release pending long-poll requests
+	close(this.shutdownInitiated)
+
+	this.Logf(0, "Shutting down (timeout: %s)..", timeout)
+
+	shutdownContext, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	// Stop accepting new connections, and wait for in-flight requests to complete. WebSocket connections
+	// aren't tracked by the HTTP servers, so they are closed separately.
+	httpServers := this.runningHTTPServers()
+	httpServersWaitGroup := &sync.WaitGroup{}
+	httpServerErrors := make(chan error, len(httpServers))
+
+	for _, httpServer := range httpServers {
+		httpServersWaitGroup.Add(1)
+		go func(httpServer *http.Server) {
+			httpServerErrors <- httpServer.Shutdown(shutdownContext)
+			httpServersWaitGroup.Done()
+		}(httpServer)
+	}
+
+	this.webSocketConnections.CloseAll("Server is shutting down")
+
+	httpServersWaitGroup.Wait()
+	close(httpServerErrors)
+
+	for httpServerError := range httpServerErrors {
+		if httpServerError != nil && err == nil {
+			err = errors.New("Timed out waiting for in-flight requests to complete.")
+		}
+	}
+
+	// Wait for the writer queues to drain, and flush the datastores. This uses a separate deadline, since
+	// the shared one may have already been used up waiting for in-flight requests.
+	flushContext, cancelFlush := context.WithTimeout(context.Background(), timeout)
+	defer cancelFlush()
+
+	if flushErr := this.flushAllDatastores(flushContext); flushErr != nil {
+		if err == nil {
+			err = flushErr
+		} else {
+			this.Logf(0, "Error shutting down: %s", flushErr.Error())
+		}
+	}
+
+	if err != nil {
+		this.Logf(0, "Error shutting down: %s Stopping the server anyway.", err.Error())
+	}
+
+	// Close all datastores and listeners
+	this.Stop()
+
+	if err == nil {
+		this.Log(0, "Server has been shut down.")
+	}
+
+	return
+}
+
+// Waits for the writer queue of every datastore to drain, and synchronously flushes the open ones. Returns
+// an error if the given context is done before all datastores were flushed.
+func (this *Server) flushAllDatastores(ctx context.Context) error {
+	flushWaitGroup := &sync.WaitGroup{}
+
+	// Take a snapshot of the datastore map, since requests still being handled may open new datastores
+	this.datastoreMapLock.Lock()
+
+	datastores := make([]*DatastoreOperations, 0, len(this.datastores))
+
+	for _, operations := range this.datastores {
+		datastores = append(datastores, operations)
+	}
+
+	this.datastoreMapLock.Unlock()
+
+	for _, operations := range datastores {
+		flushWaitGroup.Add(1)
+		go func(operations *DatastoreOperations) {
+			defer flushWaitGroup.Done()
+
+			// Wait to enter the writer queue, and leave it once flushed
+			writerQueueToken := operations.WriterQueue.Enter()
+			defer operations.WriterQueue.Leave(writerQueueToken)
+
+			err := operations.Flush()
+			if err != nil {
+				this.Logf(0, "Error flushing datastore '%s': %s", operations.Name, err.Error())
+			}
+		}(operations)
+	}
+
+	flushesCompleted := make(chan struct{})
+
+	go func() {
+		flushWaitGroup.Wait()
+		close(flushesCompleted)
+	}()
+
+	select {
+	case <-flushesCompleted:
+		return nil
+	case <-ctx.Done():
+		return errors.New("Timed out waiting for datastore writes to complete.")
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Server", func() {
+	var context *ServerTestContext
+
+	BeforeEach(func() {
+		context = NewServerTestContext()
+		context.Start()
+	})
+
+	AfterEach(func() {
+		context.Stop()
+	})
+
+	It("Completes in-flight writes and flushes datastores when shutting down", func() {
+		// Set a long flush delay, such that flushes would still be pending when shutting down
+		err := context.PutGlobalSetting(`"['datastore']['flush']['maxDelay']"`, `60000`, "")
+		Expect(err).To(BeNil())
+
+		client := context.GetClientForRandomDatastore("")
+		testEntries := context.GetTestEntries()
+
+		_, err = client.Put(testEntries[0:2])
+		Expect(err).To(BeNil())
+
+		// Start a POST request, with a body that isn't fully sent yet
+		bodyReader, bodyWriter := io.Pipe()
+		request, _ := http.NewRequest("POST", client.BuildRequestURL(map[string]string{}), bodyReader)
+
+		responses := make(chan *http.Response, 1)
+		go func() {
+			defer GinkgoRecover()
+
+			response, err := http.DefaultClient.Do(request)
+			Expect(err).To(BeNil())
+			response.Body.Close()
+			responses <- response
+		}()
+
+		serializedEntries := SerializeEntries(testEntries[2:5])
+		_, err = bodyWriter.Write(serializedEntries[0:10])
+		Expect(err).To(BeNil())
+
+		// Allow the server to start handling the request
+		time.Sleep(100 * time.Millisecond)
+
+		// Initiate the shutdown while the request is in-flight
+		shutdownResults := make(chan error, 1)
+		go func() {
+			shutdownResults <- context.server.Shutdown(10 * time.Second)
+		}()
+
+		Eventually(context.server.ShuttingDown).Should(BeTrue())
+		Consistently(shutdownResults, 200*time.Millisecond).ShouldNot(Receive())
+
+		// Complete the request
+		_, err = bodyWriter.Write(serializedEntries[10:])
+		Expect(err).To(BeNil())
+		Expect(bodyWriter.Close()).To(BeNil())
+
+		var response *http.Response
+		Eventually(responses, 5*time.Second).Should(Receive(&response))
+		Expect(response.StatusCode).To(Equal(http.StatusOK))
+
+		// Ensure the shutdown completed well before the scheduled flush would have happened
+		Eventually(shutdownResults, 5*time.Second).Should(Receive(BeNil()))
+		Eventually(context.server.ShutdownCompleted()).Should(BeClosed())
+
+		// Ensure new connections are refused
+		_, err = client.Get(0)
+		Expect(err).NotTo(BeNil())
+
+		// Ensure all entries were persisted to the datastore file
+		var exportedRecords bytes.Buffer
+		err = ExportDatastoreToJsonRecords(context.server.GetDatastoreOperations(client.datastoreName).FilePath, &exportedRecords, 0)
+		Expect(err).To(BeNil())
+
+		for _, key := range []string{"Key1", "Key2", "Key3", "Key4", "Key5"} {
+			Expect(exportedRecords.String()).To(ContainSubstring(key))
+		}
+
+		// Ensure a second shutdown is rejected
+		Expect(context.server.Shutdown(time.Second)).NotTo(BeNil())
+	})
+
+	It("Responds to pending long-poll requests and flushes datastores when shutting down", func() {
+		// Set a long flush delay, such that flushes would still be pending when shutting down
+		err := context.PutGlobalSetting(`"['datastore']['flush']['maxDelay']"`, `60000`, "")
+		Expect(err).To(BeNil())
+
+		client := context.GetClientForRandomDatastore("")
+		testEntries := context.GetTestEntries()
+
+		commitTimestamp, err := client.Put(testEntries)
+		Expect(err).To(BeNil())
+
+		// Start a long-poll request, waiting for entries newer than the ones written
+		type longPollResult struct {
+			results []Entry
+			err     error
+		}
+
+		longPollResults := make(chan longPollResult, 1)
+		go func() {
+			results, err := client.GetWhenNonEmpty(commitTimestamp)
+			longPollResults <- longPollResult{results, err}
+		}()
+
+		// Allow the server to start handling the request
+		time.Sleep(100 * time.Millisecond)
+
+		// Ensure the shutdown completes well before the long-poll timeout would have elapsed
+		startTime := time.Now()
+		Expect(context.server.Shutdown(10 * time.Second)).To(BeNil())
+		Expect(time.Since(startTime)).To(BeNumerically("<", 5*time.Second))
+
+		var result longPollResult
+		Eventually(longPollResults, 5*time.Second).Should(Receive(&result))
+		Expect(result.err).To(BeNil())
+		Expect(result.results).To(BeEmpty())
+
+		// Ensure all entries were persisted to the datastore file
+		var exportedRecords bytes.Buffer
+		err = ExportDatastoreToJsonRecords(context.server.GetDatastoreOperations(client.datastoreName).FilePath, &exportedRecords, 0)
+		Expect(err).To(BeNil())
+
+		for _, key := range []string{"Key1", "Key2", "Key3", "Key4", "Key5"} {
+			Expect(exportedRecords.String()).To(ContainSubstring(key))
+		}
+	})
+
+	It("Sends close frames to WebSocket subscribers when shutting down", func() {
+		client := context.GetClientForRandomDatastore("")
+
+		_, err := client.Put(context.GetTestEntries())
+		Expect(err).To(BeNil())
+
+		dialer := &websocket.Dialer{}
+		conn, _, err := dialer.Dial("ws://"+context.hostURL[7:]+"/datastore/"+client.datastoreName, nil)
+		Expect(err).To(BeNil())
+		defer conn.Close()
+
+		// Read the initial message, containing the existing entries
+		messageType, _, err := conn.ReadMessage()
+		Expect(err).To(BeNil())
+		Expect(messageType).To(Equal(websocket.BinaryMessage))
+
+		Eventually(context.server.webSocketConnections.Count).Should(Equal(1))
+
+		Expect(context.server.Shutdown(5 * time.Second)).To(BeNil())
+
+		// Ensure the connection was closed with a 'going away' close frame
+		_, _, err = conn.ReadMessage()
+		Expect(websocket.IsCloseError(err, websocket.CloseGoingAway)).To(BeTrue())
+		Expect(context.server.webSocketConnections.Count()).To(Equal(0))
+	})
+})
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"strings"
+)
+
+// Supported minimum TLS version option values
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// Parses a TLS version string, e.g. '1.2'
+func ParseTLSVersion(version string) (uint16, error) {
+	parsedVersion, found := tlsVersions[version]
+	if !found {
+		return 0, errors.New("Invalid TLS version '" + version + "'. Should be one of '1.0', '1.1', '1.2' or '1.3'.")
+	}
+
+	return parsedVersion, nil
+}
+
+// Parses a comma separated list of cipher suite names, e.g. 'TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256'. An
+// empty string results in a nil list, meaning the default cipher suites are used. Insecure cipher suites
+// are rejected.
+func ParseTLSCipherSuites(cipherSuiteNames string) ([]uint16, error) {
+	if strings.TrimSpace(cipherSuiteNames) == "" {
+		return nil, nil
+	}
+
+	// Map the names of the supported cipher suites to their identifiers
+	supportedCipherSuites := make(map[string]uint16)
+
+	for _, cipherSuite := range tls.CipherSuites() {
+		supportedCipherSuites[cipherSuite.Name] = cipherSuite.ID
+	}
+
+	cipherSuites := []uint16{}
+
+	for _, name := range strings.Split(cipherSuiteNames, ",") {
+		name = strings.TrimSpace(name)
+
+		id, found := supportedCipherSuites[name]
+		if !found {
+			return nil, errors.New("Unsupported or insecure cipher suite '" + name + "'.")
+		}
+
+		cipherSuites = append(cipherSuites, id)
+	}
+
+	return cipherSuites, nil
+}
+
+// Creates the TLS configuration for the secure listener, loading the certificates given in the startup
+// options
+func (this *Server) createTLSConfig() (*tls.Config, error) {
+	minVersion, err := ParseTLSVersion(this.startupOptions.MinTLSVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	cipherSuites, err := ParseTLSCipherSuites(this.startupOptions.TLSCipherSuites)
+	if err != nil {
+		return nil, err
+	}
+
+	// Load the certificates. The one given by 'CertFile' and 'KeyFile' is used when no other certificate
+	// matches the server name requested by the client.
+	certificateFiles := []TLSCertificateFiles{TLSCertificateFiles{this.startupOptions.CertFile, this.startupOptions.KeyFile}}
+	certificateFiles = append(certificateFiles, this.startupOptions.AdditionalCertificates...)
+
+	this.certificateStore, err = NewTLSCertificateStore(certificateFiles)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		GetCertificate: this.getCertificate,
+		MinVersion:     minVersion,
+		CipherSuites:   cipherSuites,
+	}
+
+	// If a client CA bundle was given, request client certificates and verify them against it. Clients
+	// that don't send a certificate can still authenticate with an access key, unless a certificate is
+	// required.
+	if this.startupOptions.ClientCAFile != "" {
+		tlsConfig.ClientCAs, err = LoadClientCACertificatePool(this.startupOptions.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+
+		if this.startupOptions.RequireClientCertificate {
+			tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		} else {
+			tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		}
+	}
+
+	if this.startupOptions.EnableHTTP2 {
+		tlsConfig.NextProtos = []string{"h2"}
+	}
+
+	return tlsConfig, nil
+}
+
+// Gets the certificate for a TLS handshake, first reloading the certificates if their files were modified
+func (this *Server) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	reloaded, err := this.certificateStore.ReloadIfModified()
+
+	if err != nil {
+		this.Logf(0, "Error reloading modified TLS certificates: %s. Previously loaded certificates are still used.", err.Error())
+	} else if reloaded {
+		this.Log(0, "Reloaded modified TLS certificates.")
+	}
+
+	return this.certificateStore.GetCertificate(hello)
+}
+
+// Reloads the TLS certificates used by the secure listener, if it is enabled. If loading any of them
+// fails, the previously loaded certificates are still used.
+func (this *Server) ReloadCertificates() error {
+	if this.certificateStore == nil {
+		return nil
+	}
+
+	err := this.certificateStore.Reload()
+	if err != nil {
+		this.Logf(0, "Error reloading TLS certificates: %s. Previously loaded certificates are still used.", err.Error())
+		return err
+	}
+
+	this.Log(0, "Reloaded TLS certificates.")
+
+	return nil
+}
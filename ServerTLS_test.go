@@ -0,0 +1,99 @@
+package main
+
+import (
+	"crypto/tls"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Server", func() {
+	var context *ServerTestContext
+	var testDirectory string
+
+	BeforeEach(func() {
+		var err error
+		testDirectory, err = ioutil.TempDir("", "ServerTLS")
+		Expect(err).To(BeNil())
+
+		context = NewServerTestContext()
+		context.startupOptions.SecurePort = 12346
+		context.startupOptions.CertFile = filepath.Join(testDirectory, "default.cert.pem")
+		context.startupOptions.KeyFile = filepath.Join(testDirectory, "default.key.pem")
+		context.startupOptions.AdditionalCertificates = []TLSCertificateFiles{
+			TLSCertificateFiles{filepath.Join(testDirectory, "other.cert.pem"), filepath.Join(testDirectory, "other.key.pem")},
+		}
+
+		writeTestCertificate(context.startupOptions.CertFile, context.startupOptions.KeyFile, []string{"default.example.com"})
+		writeTestCertificate(context.startupOptions.AdditionalCertificates[0].CertFile, context.startupOptions.AdditionalCertificates[0].KeyFile, []string{"other.example.com"})
+
+		context.Start()
+	})
+
+	AfterEach(func() {
+		context.Stop()
+		os.RemoveAll(testDirectory)
+	})
+
+	getServedCommonName := func(tlsConfig *tls.Config) (string, error) {
+		tlsConfig.InsecureSkipVerify = true
+
+		conn, err := tls.Dial("tcp", "localhost:12346", tlsConfig)
+		if err != nil {
+			return "", err
+		}
+
+		defer conn.Close()
+
+		return conn.ConnectionState().PeerCertificates[0].Subject.CommonName, nil
+	}
+
+	It("Selects certificates by server name, and reloads them on request", func() {
+		commonName, err := getServedCommonName(&tls.Config{ServerName: "other.example.com"})
+		Expect(err).To(BeNil())
+		Expect(commonName).To(Equal("other.example.com"))
+
+		commonName, err = getServedCommonName(&tls.Config{})
+		Expect(err).To(BeNil())
+		Expect(commonName).To(Equal("default.example.com"))
+
+		// Replace the default certificate and reload it
+		writeTestCertificate(context.startupOptions.CertFile, context.startupOptions.KeyFile, []string{"replaced.example.com"})
+		Expect(context.server.ReloadCertificates()).To(BeNil())
+
+		commonName, err = getServedCommonName(&tls.Config{})
+		Expect(err).To(BeNil())
+		Expect(commonName).To(Equal("replaced.example.com"))
+	})
+
+	It("Rejects connections using TLS versions older than the minimum version", func() {
+		_, err := getServedCommonName(&tls.Config{MaxVersion: tls.VersionTLS11})
+		Expect(err).NotTo(BeNil())
+
+		_, err = getServedCommonName(&tls.Config{MinVersion: tls.VersionTLS12})
+		Expect(err).To(BeNil())
+	})
+
+	It("Parses TLS versions and cipher suites", func() {
+		version, err := ParseTLSVersion("1.3")
+		Expect(err).To(BeNil())
+		Expect(version).To(Equal(uint16(tls.VersionTLS13)))
+
+		_, err = ParseTLSVersion("1.4")
+		Expect(err).NotTo(BeNil())
+
+		cipherSuites, err := ParseTLSCipherSuites("")
+		Expect(err).To(BeNil())
+		Expect(cipherSuites).To(BeNil())
+
+		cipherSuites, err = ParseTLSCipherSuites("TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384")
+		Expect(err).To(BeNil())
+		Expect(cipherSuites).To(Equal([]uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384}))
+
+		_, err = ParseTLSCipherSuites("TLS_RSA_WITH_RC4_128_SHA")
+		Expect(err).NotTo(BeNil())
+	})
+})
@@ -65,7 +65,7 @@ func (this *ServerTestContext) GetRandomEntries(maxCount int, maxKeySize int, ma
 
 func (this *ServerTestContext) GetRandomAccessKey() (key string, keyHash string) {
 	key = hex.EncodeToString(RandomBytes(16))
-	keyHash = SHA1ToHex([]byte(key))
+	keyHash, _ = this.server.HashAccessKey(key)
 
 	return
 }
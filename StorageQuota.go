@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"sync"
+)
+
+// The name of the internal datastore recording the principal that owns each datastore. A principal is
+// either an access key hash or, for signed tokens, "token:" followed by the token's subject. Since the
+// name doesn't match the datastore path pattern, it can't be accessed through the datastore API.
+const DatastoreOwnershipDatastoreName = ".owners.config"
+
+var datastoreOwnerConfigKeyRegexp *regexp.Regexp
+
+func init() {
+	datastoreOwnerConfigKeyRegexp = regexp.MustCompile(`^\['datastore'\]\['([a-zA-Z0-9_]+)'\]\['owner'\]$`)
+}
+
+// The storage used by a particular principal, as returned to the client
+type StorageUsage struct {
+	Principal  string                  `json:"principal"`
+	TotalSize  int64                   `json:"totalSize"`
+	Datastores []DatastoreStorageUsage `json:"datastores"`
+}
+
+// The storage used by a particular datastore
+type DatastoreStorageUsage struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+}
+
+// The storage quota applying to a request, along with the principal sending it
+type principalStorageQuota struct {
+	principal    string
+	maxTotalSize int64
+}
+
+// Gets the principal owning the given datastore, or an empty string if it isn't owned by any principal
+func (this *Server) GetDatastoreOwner(datastoreName string) (string, error) {
+	content, err := this.GetConfigDatastoreContent(DatastoreOwnershipDatastoreName)
+	if err != nil {
+		return "", err
+	}
+
+	owner, _ := content.GetString("['datastore']['" + datastoreName + "']['owner']")
+
+	return owner, nil
+}
+
+// Sets the principal owning the given datastore. An empty principal removes the ownership record.
+func (this *Server) SetDatastoreOwner(datastoreName string, principal string) (err error) {
+	ownerKey := "['datastore']['" + datastoreName + "']['owner']"
+
+	// Avoid writing anything if there's no change
+	content, err := this.GetConfigDatastoreContent(DatastoreOwnershipDatastoreName)
+	if err != nil {
+		return
+	}
+
+	currentOwner, _ := content.GetString(ownerKey)
+
+	if currentOwner == principal {
+		return
+	}
+
+	serializedPrincipal := ""
+
+	if principal != "" {
+		serializedPrincipalBytes, _ := json.Marshal(principal)
+		serializedPrincipal = string(serializedPrincipalBytes)
+	}
+
+	_, err = this.UpdateConfigDatastore(DatastoreOwnershipDatastoreName, []JsonEntry{
+		JsonEntry{`"` + ownerKey + `"`, serializedPrincipal},
+	})
+
+	return
+}
+
+// Gets the storage used by every principal owning at least one datastore, ordered by principal.
+// The size of a datastore is the current size of its file.
+func (this *Server) GetAllStorageUsage() ([]StorageUsage, error) {
+	content, err := this.GetConfigDatastoreContent(DatastoreOwnershipDatastoreName)
+	if err != nil {
+		return nil, err
+	}
+
+	usageLookup := map[string]*StorageUsage{}
+
+	for _, key := range content.Keys() {
+		keySubmatches := datastoreOwnerConfigKeyRegexp.FindStringSubmatch(key)
+
+		if len(keySubmatches) == 0 {
+			continue
+		}
+
+		principal, _ := content.GetString(key)
+
+		if principal == "" {
+			continue
+		}
+
+		// Get the size of the datastore file, and skip it if it doesn't exist anymore
+		datastoreName := keySubmatches[1]
+
+		fileInfo, err := os.Stat(this.GetDatastoreOperations(datastoreName).FilePath)
+		if err != nil {
+			continue
+		}
+
+		usage, found := usageLookup[principal]
+
+		if !found {
+			usage = &StorageUsage{Principal: principal, Datastores: []DatastoreStorageUsage{}}
+			usageLookup[principal] = usage
+		}
+
+		usage.TotalSize += fileInfo.Size()
+		usage.Datastores = append(usage.Datastores, DatastoreStorageUsage{Name: datastoreName, Size: fileInfo.Size()})
+	}
+
+	results := []StorageUsage{}
+
+	for _, usage := range usageLookup {
+		sort.Slice(usage.Datastores, func(i, j int) bool { return usage.Datastores[i].Name < usage.Datastores[j].Name })
+		results = append(results, *usage)
+	}
+
+	sort.Slice(results, func(i, j int) bool { return results[i].Principal < results[j].Principal })
+
+	return results, nil
+}
+
+// Gets the storage used by the given principal
+func (this *Server) GetStorageUsage(principal string) (StorageUsage, error) {
+	allUsage, err := this.GetAllStorageUsage()
+	if err != nil {
+		return StorageUsage{}, err
+	}
+
+	for _, usage := range allUsage {
+		if usage.Principal == principal {
+			return usage, nil
+		}
+	}
+
+	return StorageUsage{Principal: principal, Datastores: []DatastoreStorageUsage{}}, nil
+}
+
+// Gets the lock serializing the quota checks and writes of the principal the given quota applies to, such
+// that concurrent writes to different datastores couldn't each pass the check and together exceed the
+// quota. Returns nil if no quota applies.
+func (this *Server) GetStorageQuotaLock(quota *principalStorageQuota) *sync.Mutex {
+	if quota == nil || quota.maxTotalSize <= 0 {
+		return nil
+	}
+
+	this.storageQuotaLocksLock.Lock()
+	defer this.storageQuotaLocksLock.Unlock()
+
+	lock, found := this.storageQuotaLocks[quota.principal]
+
+	if !found {
+		lock = &sync.Mutex{}
+		this.storageQuotaLocks[quota.principal] = lock
+	}
+
+	return lock
+}
+
+// Checks if writing a transaction of the given size to the given datastore would cause the principal
+// sending it to exceed its quota. Only datastores owned by the principal count toward its quota, where
+// a datastore that doesn't exist yet would become owned by it. 'state' is nil if the datastore doesn't
+// exist, and 'rewrite' is true if the transaction would replace the datastore's current content.
+func (this *Server) CheckStorageQuota(quota *principalStorageQuota, datastoreName string, state *DatastoreState, rewrite bool, transactionSize int64) error {
+	// If no quota applies, return without error
+	if quota == nil || quota.maxTotalSize <= 0 {
+		return nil
+	}
+
+	// Get the current size of the datastore
+	var currentSize int64
+
+	if state != nil {
+		currentSize = state.Size()
+
+		// If the datastore exists but isn't owned by the principal, the write doesn't count toward its quota
+		owner, err := this.GetDatastoreOwner(datastoreName)
+		if err != nil {
+			return err
+		}
+
+		if owner != quota.principal {
+			return nil
+		}
+	}
+
+	// Get the storage currently used by the principal
+	usage, err := this.GetStorageUsage(quota.principal)
+	if err != nil {
+		return err
+	}
+
+	// Calculate the datastore's size after the write. A new or rewritten datastore would also include
+	// a head entry.
+	newSize := currentSize + transactionSize
+
+	if state == nil || rewrite {
+		newSize = HeadEntrySize + transactionSize
+	}
+
+	// The current usage already includes the datastore's current size, if it exists
+	if usage.TotalSize-currentSize+newSize > quota.maxTotalSize {
+		return ErrStorageQuotaExceeded{fmt.Sprintf("The transaction would exceed the storage quota of %d bytes for the principal '%s', which currently uses %d bytes.", quota.maxTotalSize, quota.principal, usage.TotalSize)}
+	}
+
+	return nil
+}
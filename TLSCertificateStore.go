@@ -0,0 +1,189 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"os"
+	"sync"
+	"time"
+)
+
+// The minimal interval between successive checks for modified certificate files
+const tlsCertificateModificationCheckInterval = 1 * time.Second
+
+// The paths of a certificate file and its matching private key file
+type TLSCertificateFiles struct {
+	CertFile string
+	KeyFile  string
+}
+
+// A certificate loaded from a pair of files, along with the modification times of the files at the time
+// it was loaded
+type loadedTLSCertificate struct {
+	files           TLSCertificateFiles
+	certificate     *tls.Certificate
+	certFileModTime time.Time
+	keyFileModTime  time.Time
+}
+
+// A store of TLS certificates, loaded from files. Certificates are selected by the server name sent by the
+// client (SNI), and are reloaded when their files are modified, or when explicitly requested. Safe for
+// concurrent use.
+type TLSCertificateStore struct {
+	certificateFiles []TLSCertificateFiles
+	certificates     []*loadedTLSCertificate
+
+	// The last time the certificate files were checked for modifications (monotonic, in nanoseconds)
+	lastModificationCheckTime int64
+
+	// Make this lockable
+	sync.RWMutex
+}
+
+// TLS certificate store object constructor function. Loads the certificates from the given file pairs,
+// where the first one would be used when no other certificate matches the server name requested by the
+// client.
+func NewTLSCertificateStore(certificateFiles []TLSCertificateFiles) (*TLSCertificateStore, error) {
+	if len(certificateFiles) == 0 {
+		return nil, errors.New("No certificate files were given.")
+	}
+
+	store := &TLSCertificateStore{
+		certificateFiles: certificateFiles,
+	}
+
+	err := store.Reload()
+	if err != nil {
+		return nil, err
+	}
+
+	return store, nil
+}
+
+// Reloads all certificates from their files. If any of them fails to load, the previously loaded
+// certificates are retained, and the error is returned.
+func (this *TLSCertificateStore) Reload() error {
+	certificates := make([]*loadedTLSCertificate, 0, len(this.certificateFiles))
+
+	for _, files := range this.certificateFiles {
+		loadedCertificate, err := loadTLSCertificate(files)
+		if err != nil {
+			return err
+		}
+
+		certificates = append(certificates, loadedCertificate)
+	}
+
+	this.Lock()
+	this.certificates = certificates
+	this.lastModificationCheckTime = MonoUnixTimeNano()
+	this.Unlock()
+
+	return nil
+}
+
+// Reloads all certificates if any of their files was modified since they were last loaded. At most a
+// single check is performed per second.
+func (this *TLSCertificateStore) ReloadIfModified() (bool, error) {
+	currentTime := MonoUnixTimeNano()
+
+	this.Lock()
+
+	// If a check was recently performed, return
+	if currentTime < this.lastModificationCheckTime+int64(tlsCertificateModificationCheckInterval) {
+		this.Unlock()
+		return false, nil
+	}
+
+	this.lastModificationCheckTime = currentTime
+	certificates := this.certificates
+
+	this.Unlock()
+
+	// Check if any of the files was modified
+	modified := false
+
+	for _, loadedCertificate := range certificates {
+		certFileModTime, keyFileModTime, err := getTLSCertificateFileModTimes(loadedCertificate.files)
+		if err != nil {
+			return false, err
+		}
+
+		if !certFileModTime.Equal(loadedCertificate.certFileModTime) || !keyFileModTime.Equal(loadedCertificate.keyFileModTime) {
+			modified = true
+			break
+		}
+	}
+
+	if !modified {
+		return false, nil
+	}
+
+	err := this.Reload()
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Gets the certificate matching the server name requested by the client, or the first certificate if
+// none match. Can be used as the 'GetCertificate' callback of a TLS configuration.
+func (this *TLSCertificateStore) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	this.RLock()
+	certificates := this.certificates
+	this.RUnlock()
+
+	if hello.ServerName != "" {
+		for _, loadedCertificate := range certificates {
+			if loadedCertificate.certificate.Leaf.VerifyHostname(hello.ServerName) == nil {
+				return loadedCertificate.certificate, nil
+			}
+		}
+	}
+
+	return certificates[0].certificate, nil
+}
+
+// Loads a certificate from the given files, and parses its leaf certificate
+func loadTLSCertificate(files TLSCertificateFiles) (*loadedTLSCertificate, error) {
+	// Get the modification times first, such that a modification occurring while the files are loaded
+	// would be detected on the next check
+	certFileModTime, keyFileModTime, err := getTLSCertificateFileModTimes(files)
+	if err != nil {
+		return nil, err
+	}
+
+	certificate, err := tls.LoadX509KeyPair(files.CertFile, files.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	certificate.Leaf, err = x509.ParseCertificate(certificate.Certificate[0])
+	if err != nil {
+		return nil, err
+	}
+
+	return &loadedTLSCertificate{
+		files:           files,
+		certificate:     &certificate,
+		certFileModTime: certFileModTime,
+		keyFileModTime:  keyFileModTime,
+	}, nil
+}
+
+// Gets the modification times of the given certificate and key files
+func getTLSCertificateFileModTimes(files TLSCertificateFiles) (certFileModTime time.Time, keyFileModTime time.Time, err error) {
+	certFileInfo, err := os.Stat(files.CertFile)
+	if err != nil {
+		return
+	}
+
+	keyFileInfo, err := os.Stat(files.KeyFile)
+	if err != nil {
+		return
+	}
+
+	return certFileInfo.ModTime(), keyFileInfo.ModTime(), nil
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// Writes a self-signed certificate for the given DNS names, and its private key, to the given files
+func writeTestCertificate(certFile string, keyFile string, dnsNames []string) {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).To(BeNil())
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: dnsNames[0]},
+		DNSNames:     dnsNames,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+
+	certificateBytes, err := x509.CreateCertificate(rand.Reader, template, template, &privateKey.PublicKey, privateKey)
+	Expect(err).To(BeNil())
+
+	privateKeyBytes, err := x509.MarshalECPrivateKey(privateKey)
+	Expect(err).To(BeNil())
+
+	err = ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificateBytes}), 0644)
+	Expect(err).To(BeNil())
+
+	err = ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: privateKeyBytes}), 0600)
+	Expect(err).To(BeNil())
+}
+
+var _ = Describe("TLSCertificateStore", func() {
+	var testDirectory string
+
+	BeforeEach(func() {
+		var err error
+		testDirectory, err = ioutil.TempDir("", "TLSCertificateStore")
+		Expect(err).To(BeNil())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(testDirectory)
+	})
+
+	testFiles := func(name string) TLSCertificateFiles {
+		return TLSCertificateFiles{filepath.Join(testDirectory, name+".cert.pem"), filepath.Join(testDirectory, name+".key.pem")}
+	}
+
+	getServedCommonName := func(store *TLSCertificateStore, serverName string) string {
+		certificate, err := store.GetCertificate(&tls.ClientHelloInfo{ServerName: serverName})
+		Expect(err).To(BeNil())
+
+		return certificate.Leaf.Subject.CommonName
+	}
+
+	It("Selects certificates by the requested server name", func() {
+		defaultFiles := testFiles("default")
+		writeTestCertificate(defaultFiles.CertFile, defaultFiles.KeyFile, []string{"default.example.com"})
+
+		otherFiles := testFiles("other")
+		writeTestCertificate(otherFiles.CertFile, otherFiles.KeyFile, []string{"other.example.com", "*.other.example.com"})
+
+		store, err := NewTLSCertificateStore([]TLSCertificateFiles{defaultFiles, otherFiles})
+		Expect(err).To(BeNil())
+
+		Expect(getServedCommonName(store, "default.example.com")).To(Equal("default.example.com"))
+		Expect(getServedCommonName(store, "other.example.com")).To(Equal("other.example.com"))
+		Expect(getServedCommonName(store, "sub.other.example.com")).To(Equal("other.example.com"))
+		Expect(getServedCommonName(store, "unknown.example.com")).To(Equal("default.example.com"))
+		Expect(getServedCommonName(store, "")).To(Equal("default.example.com"))
+	})
+
+	It("Reloads modified certificates, retaining the previous ones if loading fails", func() {
+		files := testFiles("reloaded")
+		writeTestCertificate(files.CertFile, files.KeyFile, []string{"first.example.com"})
+
+		store, err := NewTLSCertificateStore([]TLSCertificateFiles{files})
+		Expect(err).To(BeNil())
+
+		// Replace the files, and ensure the modification is detected once the check interval has passed
+		writeTestCertificate(files.CertFile, files.KeyFile, []string{"second.example.com"})
+		modificationTime := time.Now().Add(time.Minute)
+		Expect(os.Chtimes(files.CertFile, modificationTime, modificationTime)).To(BeNil())
+
+		reloaded, err := store.ReloadIfModified()
+		Expect(err).To(BeNil())
+		Expect(reloaded).To(BeFalse())
+
+		store.lastModificationCheckTime -= int64(tlsCertificateModificationCheckInterval)
+
+		reloaded, err = store.ReloadIfModified()
+		Expect(err).To(BeNil())
+		Expect(reloaded).To(BeTrue())
+		Expect(getServedCommonName(store, "")).To(Equal("second.example.com"))
+
+		// Corrupt the key file and ensure the previous certificate is retained
+		Expect(ioutil.WriteFile(files.KeyFile, []byte("Invalid key"), 0600)).To(BeNil())
+
+		Expect(store.Reload()).NotTo(BeNil())
+		Expect(getServedCommonName(store, "")).To(Equal("second.example.com"))
+	})
+})
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Trusted proxy list definition. Holds the networks of reverse proxies whose forwarding headers (e.g.
+// 'X-Forwarded-For') are trusted to identify the client a request originated from.
+type TrustedProxyList struct {
+	// The trusted networks
+	networks []*net.IPNet
+
+	// True if peers connecting through a Unix socket are trusted
+	trustUnixSockets bool
+}
+
+// Parses a comma separated list of trusted proxy IPs or CIDR ranges (e.g. '10.0.0.0/8,::1'). The special
+// value 'unix' trusts peers connecting through a Unix socket.
+func ParseTrustedProxyList(trustedProxies string) (*TrustedProxyList, error) {
+	result := &TrustedProxyList{
+		networks: []*net.IPNet{},
+	}
+
+	for _, entry := range strings.Split(trustedProxies, ",") {
+		entry = strings.TrimSpace(entry)
+
+		if entry == "" {
+			continue
+		}
+
+		if entry == "unix" {
+			result.trustUnixSockets = true
+			continue
+		}
+
+		// A plain IP is treated as a range containing only that IP
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, errors.New("Invalid trusted proxy IP '" + entry + "'.")
+			}
+
+			if ip.To4() != nil {
+				entry += "/32"
+			} else {
+				entry += "/128"
+			}
+		}
+
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, errors.New("Invalid trusted proxy range '" + entry + "'.")
+		}
+
+		result.networks = append(result.networks, network)
+	}
+
+	return result, nil
+}
+
+// Checks if the list is empty
+func (this *TrustedProxyList) IsEmpty() bool {
+	return len(this.networks) == 0 && !this.trustUnixSockets
+}
+
+// Checks if the given IP belongs to a trusted proxy
+func (this *TrustedProxyList) Contains(ip string) bool {
+	parsedIP := net.ParseIP(ip)
+	if parsedIP == nil {
+		return false
+	}
+
+	for _, network := range this.networks {
+		if network.Contains(parsedIP) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Checks if the peer at the given remote address (as given by 'http.Request.RemoteAddr' or
+// 'net.Conn.RemoteAddr') is a trusted proxy
+func (this *TrustedProxyList) ContainsAddress(remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+
+	// Addresses of peers connected through a Unix socket don't have a host and port
+	if err != nil || host == "" {
+		return this.trustUnixSockets && net.ParseIP(remoteAddr) == nil
+	}
+
+	return this.Contains(host)
+}
+
+// Gets the IP of the client a request originated from. If the request was sent by a trusted proxy, the
+// 'Forwarded' header, or, if not present, the 'X-Forwarded-For' header, is scanned from the nearest hop
+// backwards, and the first IP that doesn't belong to a trusted proxy is returned. Returns an empty string
+// if the request wasn't sent by a trusted proxy, or the headers don't include a valid IP.
+func (this *TrustedProxyList) GetForwardedClientIP(remoteAddr string, header http.Header) string {
+	if !this.ContainsAddress(remoteAddr) {
+		return ""
+	}
+
+	var forwardedAddresses []string
+
+	if forwardedHeaders := header.Values("Forwarded"); len(forwardedHeaders) > 0 {
+		forwardedAddresses = parseForwardedHeaderAddresses(forwardedHeaders)
+	} else {
+		for _, value := range header.Values("X-Forwarded-For") {
+			for _, address := range strings.Split(value, ",") {
+				forwardedAddresses = append(forwardedAddresses, strings.TrimSpace(address))
+			}
+		}
+	}
+
+	clientIP := ""
+
+	for i := len(forwardedAddresses) - 1; i >= 0; i-- {
+		ip := parseForwardedAddressIP(forwardedAddresses[i])
+
+		// Stop at an address that isn't an IP (e.g. 'unknown' or an obfuscated identifier), since
+		// hops before it can't be verified
+		if ip == "" {
+			break
+		}
+
+		clientIP = ip
+
+		if !this.Contains(ip) {
+			break
+		}
+	}
+
+	return clientIP
+}
+
+// Gets the 'for' parameter values of the elements of the given 'Forwarded' headers (RFC 7239), e.g.
+// 'for=192.0.2.60;proto=http, for="[2001:db8:cafe::17]:4711"'
+func parseForwardedHeaderAddresses(forwardedHeaders []string) []string {
+	addresses := []string{}
+
+	for _, value := range forwardedHeaders {
+		for _, element := range strings.Split(value, ",") {
+			forAddress := ""
+
+			for _, pair := range strings.Split(element, ";") {
+				separatorIndex := strings.Index(pair, "=")
+				if separatorIndex < 0 {
+					continue
+				}
+
+				if strings.EqualFold(strings.TrimSpace(pair[:separatorIndex]), "for") {
+					forAddress = strings.Trim(strings.TrimSpace(pair[separatorIndex+1:]), `"`)
+				}
+			}
+
+			addresses = append(addresses, forAddress)
+		}
+	}
+
+	return addresses
+}
+
+// Gets the IP included in a forwarded address, which may include a port and IPv6 brackets, or an empty
+// string if it doesn't include a valid IP
+func parseForwardedAddressIP(address string) string {
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		address = host
+	}
+
+	address = strings.TrimSuffix(strings.TrimPrefix(address, "["), "]")
+
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return ""
+	}
+
+	return ip.String()
+}
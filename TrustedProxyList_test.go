@@ -0,0 +1,86 @@
+package main
+
+import (
+	"net/http"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("TrustedProxyList", func() {
+	It("Parses IPs and CIDR ranges", func() {
+		trustedProxies, err := ParseTrustedProxyList("10.0.0.0/8, 192.168.1.1,::1")
+		Expect(err).To(BeNil())
+
+		Expect(trustedProxies.Contains("10.1.2.3")).To(BeTrue())
+		Expect(trustedProxies.Contains("192.168.1.1")).To(BeTrue())
+		Expect(trustedProxies.Contains("192.168.1.2")).To(BeFalse())
+		Expect(trustedProxies.Contains("::1")).To(BeTrue())
+		Expect(trustedProxies.ContainsAddress("[::1]:1234")).To(BeTrue())
+		Expect(trustedProxies.ContainsAddress("@")).To(BeFalse())
+
+		trustedProxies, err = ParseTrustedProxyList("unix")
+		Expect(err).To(BeNil())
+		Expect(trustedProxies.ContainsAddress("@")).To(BeTrue())
+
+		_, err = ParseTrustedProxyList("10.0.0.0/33")
+		Expect(err).NotTo(BeNil())
+
+		_, err = ParseTrustedProxyList("proxy.example.com")
+		Expect(err).NotTo(BeNil())
+	})
+
+	It("Gets the client IP from forwarding headers sent by trusted proxies", func() {
+		trustedProxies, err := ParseTrustedProxyList("10.0.0.0/8")
+		Expect(err).To(BeNil())
+
+		header := http.Header{}
+		header.Add("X-Forwarded-For", "198.51.100.1, 203.0.113.7")
+		header.Add("X-Forwarded-For", "10.0.0.2")
+
+		// Ensure the nearest untrusted hop is used
+		Expect(trustedProxies.GetForwardedClientIP("10.0.0.1:5000", header)).To(Equal("203.0.113.7"))
+
+		// Ensure the headers are ignored if the request wasn't sent by a trusted proxy
+		Expect(trustedProxies.GetForwardedClientIP("203.0.113.8:5000", header)).To(Equal(""))
+
+		// Ensure the 'Forwarded' header takes precedence
+		header.Set("Forwarded", `for=198.51.100.2;proto=https, for="[2001:db8::17]:4711"`)
+		Expect(trustedProxies.GetForwardedClientIP("10.0.0.1:5000", header)).To(Equal("2001:db8::17"))
+
+		// Ensure scanning stops at an obfuscated identifier
+		header.Set("Forwarded", `for=198.51.100.2, for=_hidden, for=10.0.0.3`)
+		Expect(trustedProxies.GetForwardedClientIP("10.0.0.1:5000", header)).To(Equal("10.0.0.3"))
+	})
+})
+
+var _ = Describe("Server", func() {
+	var context *ServerTestContext
+
+	BeforeEach(func() {
+		context = NewServerTestContext()
+		context.startupOptions.InsecureListenerLoopbackOnly = true
+		context.startupOptions.TrustedProxies = "127.0.0.1"
+		context.Start()
+	})
+
+	AfterEach(func() {
+		context.Stop()
+	})
+
+	It("Applies loopback restrictions to clients of trusted proxies", func() {
+		sendRequest := func(forwardedFor string) int {
+			request, _ := http.NewRequest("GET", "http://127.0.0.1:12345/datastore/"+RandomWordString(12), nil)
+			request.Header.Set("X-Forwarded-For", forwardedFor)
+
+			response, err := http.DefaultClient.Do(request)
+			Expect(err).To(BeNil())
+			response.Body.Close()
+
+			return response.StatusCode
+		}
+
+		Expect(sendRequest("203.0.113.7")).To(Equal(http.StatusForbidden))
+		Expect(sendRequest("127.0.0.1")).To(Equal(http.StatusNotFound))
+	})
+})
@@ -4,6 +4,7 @@ import (
 	"math/rand"
 	//"unsafe"
 	"bytes"
+	cryptoRand "crypto/rand"
 	"crypto/sha1"
 	"encoding/hex"
 	"hash/crc32"
@@ -100,6 +101,14 @@ func Sleep(durationMilliseconds float64) {
 	time.Sleep(time.Duration(int64(durationMilliseconds * float64(time.Millisecond))))
 }
 
+// Generates a new access key, consisting of 32 lowercase hexadecimal digits, using a cryptographically
+// secure random source
+func GenerateRandomAccessKey() string {
+	keyBytes := make([]byte, 16)
+	cryptoRand.Read(keyBytes)
+	return hex.EncodeToString(keyBytes)
+}
+
 func SHA1ToHex(data []byte) string {
 	hash := sha1.Sum(data)
 	return hex.EncodeToString(hash[:])
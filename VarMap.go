@@ -3,6 +3,7 @@ package main
 import (
 	"encoding/json"
 	"errors"
+	"strings"
 )
 
 type VarMap struct {
@@ -167,6 +168,7 @@ func (this *VarMap) GetArray(key string) ([]interface{}, error) {
 		return nil, ErrUnexpectedType
 	}
 }
+*/
 
 func (this *VarMap) FindKeysStartingWith(prefix string) (results []string) {
 	for key, _ := range this.entries {
@@ -177,7 +179,6 @@ func (this *VarMap) FindKeysStartingWith(prefix string) (results []string) {
 
 	return
 }
-*/
 
 func (this *VarMap) Has(key string) bool {
 	_, ok := this.entries[key]